@@ -16,6 +16,7 @@ require (
 	github.com/esimov/pigo v1.4.6
 	github.com/hashicorp/golang-lru/v2 v2.0.7
 	github.com/joho/godotenv v1.5.1
+	github.com/klauspost/compress v1.18.0
 	github.com/prometheus/client_golang v1.23.2
 	github.com/redis/go-redis/v9 v9.17.2
 	github.com/yalue/onnxruntime_go v1.25.0
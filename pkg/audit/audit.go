@@ -0,0 +1,102 @@
+// Package audit records privileged operations (purges, flushes, warmups,
+// watermark bypasses, original downloads) with the actor that performed them.
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Actions.
+const (
+	Purge           = "purge"
+	Warmup          = "warmup"
+	WatermarkBypass = "watermark_bypass"
+	CacheFlush      = "cache_flush"
+	RateLimitReset  = "ratelimit_reset"
+	OriginalAccess  = "original_access"
+)
+
+// Event is one audited operation. It is recorded before the operation runs,
+// so failed operations are audited too. Events never carry secrets: callers
+// strip signatures, tokens and credentials from Params and Targets.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	ClientIP string    `json:"client_ip"`
+	// Actor is how the request was authorized: "admin_token",
+	// "cidr:<network>", "signature", "token" or "anonymous"
+	Actor   string   `json:"actor"`
+	Key     string   `json:"key,omitempty"`
+	Params  string   `json:"params,omitempty"`
+	Targets []string `json:"targets,omitempty"`
+}
+
+// Log writes events to a structured logger and keeps the most recent ones in
+// a bounded ring.
+type Log struct {
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	entries []Event
+	next    int
+	full    bool
+}
+
+// New returns an audit log keeping size recent events and writing every
+// event to logger.
+func New(logger *slog.Logger, size int) *Log {
+	if size <= 0 {
+		size = 1
+	}
+	return &Log{logger: logger, entries: make([]Event, size)}
+}
+
+// Record adds an event. It is a no-op on a nil Log.
+func (l *Log) Record(e Event) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	l.mu.Lock()
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+	l.mu.Unlock()
+
+	attrs := []slog.Attr{
+		slog.String("action", e.Action),
+		slog.String("client_ip", e.ClientIP),
+		slog.String("actor", e.Actor),
+	}
+	if e.Key != "" {
+		attrs = append(attrs, slog.String("key", e.Key))
+	}
+	if e.Params != "" {
+		attrs = append(attrs, slog.String("params", e.Params))
+	}
+	if len(e.Targets) > 0 {
+		attrs = append(attrs, slog.Any("targets", e.Targets))
+	}
+	l.logger.LogAttrs(context.Background(), slog.LevelInfo, "Audit", slog.Attr{Key: "audit", Value: slog.GroupValue(attrs...)})
+}
+
+// Recent returns the recorded events, oldest first.
+func (l *Log) Recent() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.full {
+		return append([]Event(nil), l.entries[:l.next]...)
+	}
+	out := make([]Event, 0, len(l.entries))
+	out = append(out, l.entries[l.next:]...)
+	return append(out, l.entries[:l.next]...)
+}
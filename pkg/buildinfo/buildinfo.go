@@ -0,0 +1,47 @@
+// Package buildinfo holds the version of the running binary. Release builds
+// set the variables with the linker:
+//
+//	go build -ldflags "-X github.com/CodeTease/quirm/pkg/buildinfo.Version=1.2.0 \
+//	  -X github.com/CodeTease/quirm/pkg/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/CodeTease/quirm/pkg/buildinfo.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	// Version is the release of the binary
+	Version = "0.5.0"
+	// Commit is the VCS revision the binary was built from
+	Commit = ""
+	// BuildDate is when the binary was built, in RFC 3339
+	BuildDate = ""
+)
+
+func init() {
+	// Without ldflags, fall back to what the Go toolchain stamped from the
+	// VCS checkout (the commit time standing in for the build date)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && Commit == "":
+				Commit = s.Value
+			case s.Key == "vcs.time" && BuildDate == "":
+				BuildDate = s.Value
+			}
+		}
+	}
+	if Commit == "" {
+		Commit = "unknown"
+	}
+	if BuildDate == "" {
+		BuildDate = "unknown"
+	}
+}
+
+// GoVersion is the Go release the binary was built with.
+func GoVersion() string {
+	return runtime.Version()
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"os"
+	"sync"
+)
+
+const (
+	// The count-min sketch takes accessDepth*accessWidth*4 bytes (1 MiB)
+	// whatever the number of entries
+	accessDepth = 4
+	accessWidth = 1 << 16
+
+	// AccessStatsFile is the name of the file, in the cache directory, the
+	// access counts are saved to between restarts.
+	AccessStatsFile = "_access.stats"
+)
+
+var accessStatsMagic = []byte("QAS1")
+
+// AccessStats counts the accesses to disk cache entries approximately, in a
+// count-min sketch: estimates can be too high for colliding keys but never
+// too low, and memory stays bounded. Counts are halved by Age, so they
+// favour recent popularity.
+type AccessStats struct {
+	mu       sync.Mutex
+	counters [accessDepth][accessWidth]uint32
+	recorded bool
+}
+
+// NewAccessStats returns empty access statistics.
+func NewAccessStats() *AccessStats {
+	return &AccessStats{}
+}
+
+// Record counts an access to the cache entry key.
+func (a *AccessStats) Record(key string) {
+	if a == nil {
+		return
+	}
+	slots := accessSlots(key)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for row, slot := range slots {
+		if a.counters[row][slot] < ^uint32(0) {
+			a.counters[row][slot]++
+		}
+	}
+	a.recorded = true
+}
+
+// Estimate returns the approximate number of accesses to key.
+func (a *AccessStats) Estimate(key string) uint32 {
+	slots := accessSlots(key)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	estimate := ^uint32(0)
+	for row, slot := range slots {
+		estimate = min(estimate, a.counters[row][slot])
+	}
+	return estimate
+}
+
+// Empty reports whether no access was recorded or loaded yet, in which case
+// the counts carry no information.
+func (a *AccessStats) Empty() bool {
+	if a == nil {
+		return true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return !a.recorded
+}
+
+// Age halves every count, so entries that stopped being requested lose
+// their rank over time.
+func (a *AccessStats) Age() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for row := range a.counters {
+		for i := range a.counters[row] {
+			a.counters[row][i] >>= 1
+		}
+	}
+}
+
+// Save writes the counts to path.
+func (a *AccessStats) Save(path string) error {
+	var buf bytes.Buffer
+	buf.Grow(len(accessStatsMagic) + accessDepth*accessWidth*4)
+	buf.Write(accessStatsMagic)
+	a.mu.Lock()
+	err := binary.Write(&buf, binary.LittleEndian, &a.counters)
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// Load replaces the counts with those saved at path.
+func (a *AccessStats) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, accessStatsMagic) || len(data) != len(accessStatsMagic)+accessDepth*accessWidth*4 {
+		return errors.New("unrecognized access stats file")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := binary.Read(bytes.NewReader(data[len(accessStatsMagic):]), binary.LittleEndian, &a.counters); err != nil {
+		return err
+	}
+	a.recorded = true
+	return nil
+}
+
+// accessSlots returns the counter of key in each row of the sketch.
+func accessSlots(key string) [accessDepth]uint32 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	// Double hashing derives the rows' indexes from two halves of one hash
+	h1, h2 := uint32(sum), uint32(sum>>32)|1
+	var slots [accessDepth]uint32
+	for row := range slots {
+		slots[row] = (h1 + uint32(row)*h2) % accessWidth
+	}
+	return slots
+}
@@ -9,7 +9,10 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
 )
 
 type CacheProvider interface {
@@ -17,6 +20,25 @@ type CacheProvider interface {
 	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
 	Delete(ctx context.Context, key string) error
 	Health(ctx context.Context) error
+	// Flush drops every entry of this instance. Caches shared with other
+	// instances (Redis) are only flushed when shared is set.
+	Flush(ctx context.Context, shared bool) error
+}
+
+// Remover is implemented by caches that can delete an entry and report
+// whether it was there in one call, without reading its value.
+type Remover interface {
+	Remove(ctx context.Context, key string) (bool, error)
+}
+
+// ObserveLookup records a lookup in tier (memory, redis or disk) that
+// started at start.
+func ObserveLookup(tier string, start time.Time, found bool) {
+	result := "miss"
+	if found {
+		result = "hit"
+	}
+	metrics.CacheLookupDuration.WithLabelValues(tier, result).Observe(time.Since(start).Seconds())
 }
 
 func GenerateKeyOriginal(key, encoding string) string {
@@ -25,7 +47,12 @@ func GenerateKeyOriginal(key, encoding string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-func GenerateKeyProcessed(key string, params url.Values, format string) string {
+// GenerateKeyProcessed derives a cache key from the raw request parameters.
+// extras carry request properties that change the output without being
+// parameters (e.g. client hint buckets); without extras the key is unchanged.
+// Image variants are keyed by GenerateKeyOptions; these keys remain for
+// palettes, video cards and the legacy lookup of CACHE_KEY_LEGACY_FALLBACK.
+func GenerateKeyProcessed(key string, params url.Values, format string, extras ...string) string {
 	// Sort params for determinism
 	keys := make([]string, 0, len(params))
 	for k := range params {
@@ -43,6 +70,29 @@ func GenerateKeyProcessed(key string, params url.Values, format string) string {
 		h.Write([]byte(params.Get(k)))
 	}
 	h.Write([]byte(format))
+	for _, extra := range extras {
+		h.Write([]byte{0})
+		h.Write([]byte(extra))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// GenerateKeyOptions derives the cache key of a processed variant from the
+// canonical serialization of its effective options (see
+// processor.ImageOptions.Canonical), so requests spelling the same options
+// differently (parameter order, "w=0300", presets, unused parameters) share
+// one entry. format and extras are as in GenerateKeyProcessed.
+func GenerateKeyOptions(key, canonical, format string, extras ...string) string {
+	h := sha256.New()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(canonical))
+	h.Write([]byte{0})
+	h.Write([]byte(format))
+	for _, extra := range extras {
+		h.Write([]byte{0})
+		h.Write([]byte(extra))
+	}
 	return hex.EncodeToString(h.Sum(nil))
 }
 
@@ -53,12 +103,31 @@ func GetCachePath(dir, key string) string {
 	return filepath.Join(dir, key[0:2], key[2:4], key)
 }
 
-func StartCleaner(dir string, hardTTL, interval time.Duration, debug bool) {
-	ticker := time.NewTicker(interval)
+// CleanerOptions configures the disk cache cleaner.
+type CleanerOptions struct {
+	// HardTTL is the age at which entries are deleted
+	HardTTL  time.Duration
+	Interval time.Duration
+	// MaxSize is the disk budget in bytes (0 = unlimited). Past it, entries
+	// are evicted until the cache is back under 90% of the budget.
+	MaxSize int64
+	// Policy picks the entries evicted for size: EvictLRU or EvictLFU
+	Policy string
+	// Access holds the counts EvictLFU ranks entries by. It is saved to
+	// AccessStatsFile and aged on every run.
+	Access *AccessStats
+}
+
+func StartCleaner(dir string, opts CleanerOptions) {
+	hardTTL := opts.HardTTL
+	statsPath := filepath.Join(dir, AccessStatsFile)
+	ticker := time.NewTicker(opts.Interval)
 	defer ticker.Stop()
 	for range ticker.C {
 		slog.Debug("[CLEANUP] Starting cache cleanup...")
 		deletedCount := 0
+		blobDir := filepath.Join(dir, DedupDir)
+		var usage diskUsage
 		err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
 			if err != nil {
 				return nil // skip errors
@@ -70,11 +139,26 @@ func StartCleaner(dir string, hardTTL, interval time.Duration, debug bool) {
 			if err != nil {
 				return nil
 			}
-			if time.Since(info.ModTime()) > hardTTL {
+			// Deduplicated variants are hard links: removing one leaves the
+			// blob to the other variants, and blobs go once unreferenced
+			if strings.HasPrefix(path, blobDir+string(filepath.Separator)) {
+				if removableBlob(info, hardTTL) && os.Remove(path) == nil {
+					deletedCount++
+				} else {
+					usage.addBlob(path, info)
+				}
+				return nil
+			}
+			if path == statsPath {
+				return nil
+			}
+			if time.Since(EntryTime(path, info)) > hardTTL {
 				if err := os.Remove(path); err == nil {
 					deletedCount++
 				}
+				return nil
 			}
+			usage.add(path, info)
 			return nil
 		})
 
@@ -82,6 +166,17 @@ func StartCleaner(dir string, hardTTL, interval time.Duration, debug bool) {
 			slog.Error("[CLEANUP] Error walking dir", "error", err)
 		}
 
+		if opts.MaxSize > 0 {
+			deletedCount += usage.evict(opts.MaxSize, opts.Policy, opts.Access)
+		}
+		metrics.DiskCacheSizeBytes.Set(float64(usage.total))
+		if opts.Access != nil {
+			opts.Access.Age()
+			if err := opts.Access.Save(statsPath); err != nil {
+				slog.Warn("[CLEANUP] Failed to save access stats", "path", statsPath, "error", err)
+			}
+		}
+
 		// Clean empty directories (optional, but good for sharding)
 		var dirs []string
 		_ = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DedupDir is the directory below the cache directory that holds the
+// content-addressed blobs of deduplicated outputs.
+const DedupDir = "_cas"
+
+// dedupOrphanAge is how long an unreferenced blob is kept, so the cleaner
+// never removes a blob between its write and the first link to it.
+const dedupOrphanAge = time.Minute
+
+// WriteDeduplicated stores data at destPath as a hard link to a blob named
+// by its SHA-256, writing the blob only if no identical output is stored
+// yet. It reports whether an existing blob was reused. Purging a variant
+// removes only its link; the blob goes once no variant links to it.
+//
+// Links share the blob's timestamps, so the blob is never touched: each
+// variant keeps its own time on its sidecar (see Touch).
+func WriteDeduplicated(dir, destPath string, data []byte) (bool, error) {
+	sum := sha256.Sum256(data)
+	blobPath := GetCachePath(filepath.Join(dir, DedupDir), hex.EncodeToString(sum[:]))
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+		return false, err
+	}
+
+	reused := true
+	if blobInfo, err := os.Stat(blobPath); err != nil {
+		reused = false
+		if err := writeFileAtomic(blobPath, data); err != nil {
+			return false, err
+		}
+	} else if destInfo, err := os.Stat(destPath); err == nil && os.SameFile(blobInfo, destInfo) {
+		// Rebuilt with identical output: only mark it fresh again
+		return false, Touch(destPath)
+	}
+
+	// Link next to the destination and rename over it, so readers never
+	// see a missing file
+	tmp := destPath + ".link-" + randomSuffix()
+	err := os.Link(blobPath, tmp)
+	if err != nil {
+		if _, statErr := os.Stat(blobPath); errors.Is(statErr, fs.ErrNotExist) {
+			// The cleaner removed the blob as unreferenced since the stat
+			reused = false
+			if err = writeFileAtomic(blobPath, data); err == nil {
+				err = os.Link(blobPath, tmp)
+			}
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	err = os.Rename(tmp, destPath)
+	// Renaming onto a link of the same blob succeeds without removing tmp
+	os.Remove(tmp)
+	if err != nil {
+		return false, err
+	}
+	return reused, Touch(destPath)
+}
+
+// Touch marks the cache entry at path as written or served now. A
+// deduplicated variant shares its timestamps with every variant linked to
+// the same blob, so its time is set on its metadata sidecar instead, which
+// is created if missing.
+func Touch(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if !shared(info) {
+		return os.Chtimes(path, now, now)
+	}
+	err = os.Chtimes(MetaPath(path), now, now)
+	if errors.Is(err, fs.ErrNotExist) {
+		return WriteMeta(path, Meta{})
+	}
+	return err
+}
+
+// EntryTime returns when the cache entry at path, described by info, was
+// last written or served. Entries that are, or were, deduplicated variants
+// keep that time on their sidecar.
+func EntryTime(path string, info os.FileInfo) time.Time {
+	t := info.ModTime()
+	if meta, err := os.Stat(MetaPath(path)); err == nil && meta.ModTime().After(t) {
+		return meta.ModTime()
+	}
+	return t
+}
+
+// fileKey identifies a file independently of the links naming it.
+type fileKey struct {
+	dev, ino uint64
+}
+
+// shared reports whether the file described by info is linked to a blob.
+func shared(info os.FileInfo) bool {
+	links, ok := linkCount(info)
+	return ok && links > 1
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "quirm_tmp_*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func randomSuffix() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// removableBlob reports whether the cleaner may delete the blob at path:
+// once no variant links to it any more. Variants expire on their own time;
+// where link counts are not available, blobs go once past hardTTL.
+func removableBlob(info os.FileInfo, hardTTL time.Duration) bool {
+	age := time.Since(info.ModTime())
+	links, ok := linkCount(info)
+	if !ok {
+		return age > hardTTL
+	}
+	return links <= 1 && age > dedupOrphanAge
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package cache
+
+import "os"
+
+// linkCount is not available on this platform; unreferenced blobs are then
+// only removed once they expire.
+func linkCount(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// fileID is not available on this platform; evicting variants then frees
+// their blobs on a later cleanup.
+func fileID(info os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
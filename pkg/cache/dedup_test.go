@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dedupVariants writes one output under the given cache keys and returns
+// their paths. It skips where hard links cannot be counted.
+func dedupVariants(t *testing.T, dir string, data []byte, keys ...string) []string {
+	t.Helper()
+	var paths []string
+	for _, key := range keys {
+		path := GetCachePath(dir, key)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := WriteDeduplicated(dir, path, data); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	info, err := os.Stat(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := linkCount(info); !ok {
+		t.Skip("hard link counts are not available on this platform")
+	}
+	return paths
+}
+
+func entryAge(t *testing.T, path string) time.Duration {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return time.Since(EntryTime(path, info))
+}
+
+// TestDedupFreshnessPerVariant checks that variants sharing a blob age on
+// their own: touching, rebuilding or adding one leaves the others alone.
+func TestDedupFreshnessPerVariant(t *testing.T) {
+	dir := t.TempDir()
+	a, b := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	paths := dedupVariants(t, dir, []byte("output"), a, b)
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, path := range paths {
+		if err := os.Chtimes(MetaPath(path), old, old); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"touch", func() error { return Touch(paths[1]) }},
+		{"rebuild", func() error { _, err := WriteDeduplicated(dir, paths[1], []byte("output")); return err }},
+		{"new variant", func() error {
+			path := GetCachePath(dir, strings.Repeat("c", 64))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			_, err := WriteDeduplicated(dir, path, []byte("output"))
+			return err
+		}},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if age := entryAge(t, paths[0]); age < 47*time.Hour {
+			t.Errorf("%s: untouched variant is %v old, want its own 48h", step.name, age)
+		}
+	}
+	if age := entryAge(t, paths[1]); age > time.Minute {
+		t.Errorf("touched variant is %v old, want fresh", age)
+	}
+}
+
+// TestEvictDedupBlob checks that evicting the variants linked to a blob
+// removes the blob with the last of them, and credits its size.
+func TestEvictDedupBlob(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("x"), 4096)
+	paths := dedupVariants(t, dir, data, strings.Repeat("a", 64), strings.Repeat("b", 64))
+
+	var u diskUsage
+	blobDir := filepath.Join(dir, DedupDir)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(path, blobDir+string(filepath.Separator)) {
+			u.addBlob(path, info)
+		} else {
+			u.add(path, info)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.total < int64(len(data)) || u.total >= 2*int64(len(data)) {
+		t.Fatalf("total = %d, want the blob counted once", u.total)
+	}
+
+	if n := u.evict(1, EvictLRU, nil); n != len(paths) {
+		t.Errorf("evicted %d entries, want %d", n, len(paths))
+	}
+	if u.total >= int64(len(data)) {
+		t.Errorf("total after eviction = %d, want the blob credited", u.total)
+	}
+	sum := sha256.Sum256(data)
+	if _, err := os.Stat(GetCachePath(blobDir, hex.EncodeToString(sum[:]))); err == nil {
+		t.Error("blob left behind")
+	}
+}
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// DiskMonitor tracks whether the disk cache can be written. When a write
+// fails because the volume is full, read-only or not writable, the cache is
+// marked degraded: requests keep being served from memory and the origin
+// until a periodic probe finds the directory writable again.
+// A nil *DiskMonitor reports the disk as always healthy.
+type DiskMonitor struct {
+	dir      string
+	mu       sync.Mutex
+	degraded bool
+	lastErr  error
+}
+
+func NewDiskMonitor(dir string) *DiskMonitor {
+	return &DiskMonitor{dir: dir}
+}
+
+// IsDiskError reports whether err means the cache volume cannot be written
+// (as opposed to a problem with the data being written).
+func IsDiskError(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) ||
+		errors.Is(err, syscall.EDQUOT) ||
+		errors.Is(err, syscall.EROFS) ||
+		errors.Is(err, fs.ErrPermission)
+}
+
+// Degraded reports whether disk writes are currently skipped.
+func (m *DiskMonitor) Degraded() bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.degraded
+}
+
+// Status returns the degraded flag and the error that caused it.
+func (m *DiskMonitor) Status() (bool, error) {
+	if m == nil {
+		return false, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.degraded, m.lastErr
+}
+
+// Fail records a failed disk write. It returns true if err is a disk
+// condition, in which case the cache is now degraded and the caller should
+// carry on without the disk.
+func (m *DiskMonitor) Fail(err error) bool {
+	if m == nil || !IsDiskError(err) {
+		return false
+	}
+	m.set(true, err)
+	return true
+}
+
+// Probe checks whether the cache directory is writable and clears the
+// degraded flag if it is.
+func (m *DiskMonitor) Probe() {
+	if m == nil {
+		return
+	}
+	f, err := os.CreateTemp(m.dir, "quirm_probe_*")
+	if err == nil {
+		_, err = f.Write([]byte("ok"))
+		f.Close()
+		os.Remove(f.Name())
+	}
+	if err != nil {
+		if IsDiskError(err) {
+			m.set(true, err)
+		}
+		return
+	}
+	m.set(false, nil)
+}
+
+// Run probes the cache directory every interval while it is degraded.
+func (m *DiskMonitor) Run(interval time.Duration) {
+	if m == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if m.Degraded() {
+			m.Probe()
+		}
+	}
+}
+
+func (m *DiskMonitor) set(degraded bool, err error) {
+	m.mu.Lock()
+	changed := m.degraded != degraded
+	m.degraded = degraded
+	m.lastErr = err
+	m.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	// Logged once per state change rather than for every failed request
+	if degraded {
+		slog.Error("Disk cache is not writable, serving without it", "dir", m.dir, "error", err)
+		metrics.DiskCacheDegraded.Set(1)
+	} else {
+		slog.Info("Disk cache is writable again", "dir", m.dir)
+		metrics.DiskCacheDegraded.Set(0)
+	}
+}
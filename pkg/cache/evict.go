@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// Disk eviction policies (CACHE_EVICTION_POLICY).
+const (
+	// EvictLRU evicts the entries served least recently first
+	EvictLRU = "lru"
+	// EvictLFU evicts the entries served least often first, the least
+	// recently served among equals. It falls back to LRU until access
+	// counts are available.
+	EvictLFU = "lfu"
+)
+
+// evictTarget is the share of the budget the cache is brought back to, so
+// eviction does not run again on every cleanup.
+const evictTarget = 0.9
+
+// diskUsage accounts the disk cache found by a cleaner walk.
+type diskUsage struct {
+	total   int64
+	entries []diskEntry
+	// blobs holds the deduplicated blobs by inode, so evicting the last
+	// variant linked to one removes it and credits its size
+	blobs map[fileKey]*blobUsage
+}
+
+// blobUsage is a deduplicated blob and the links to it left on disk,
+// counting the blob itself.
+type blobUsage struct {
+	path  string
+	size  int64
+	links uint64
+}
+
+// diskEntry is a cache entry that may be evicted.
+type diskEntry struct {
+	path     string
+	key      string
+	lastUsed time.Time // serveFile touches entries when serving them
+	// size is what removing the entry frees: only its sidecar for a
+	// deduplicated variant, whose blob goes with its last link
+	size int64
+	blob *blobUsage
+}
+
+// add accounts the file at path. Cache entries are also recorded as
+// eviction candidates; temporary files and sidecars only count towards the
+// total.
+func (u *diskUsage) add(path string, info os.FileInfo) {
+	size := info.Size()
+	var blob *blobUsage
+	if shared(info) {
+		// Counted once, with the blob
+		size = 0
+		blob = u.blob(info)
+	}
+	u.total += size
+
+	key := filepath.Base(path)
+	if !isCacheKey(key) {
+		return
+	}
+	if meta, err := os.Stat(MetaPath(path)); err == nil {
+		size += meta.Size()
+	}
+	u.entries = append(u.entries, diskEntry{path: path, key: key, lastUsed: EntryTime(path, info), size: size, blob: blob})
+}
+
+// addBlob accounts the deduplicated blob at path.
+func (u *diskUsage) addBlob(path string, info os.FileInfo) {
+	u.total += info.Size()
+	if b := u.blob(info); b != nil {
+		b.path, b.size = path, info.Size()
+	}
+}
+
+// blob returns the usage of the blob the file described by info is linked
+// to, or nil where inodes cannot be told apart.
+func (u *diskUsage) blob(info os.FileInfo) *blobUsage {
+	id, ok := fileID(info)
+	if !ok {
+		return nil
+	}
+	if u.blobs == nil {
+		u.blobs = make(map[fileKey]*blobUsage)
+	}
+	b := u.blobs[id]
+	if b == nil {
+		links, _ := linkCount(info)
+		b = &blobUsage{links: links}
+		u.blobs[id] = b
+	}
+	return b
+}
+
+// evict removes entries chosen by policy until the total is back under
+// evictTarget of maxSize. It returns the number of entries removed.
+func (u *diskUsage) evict(maxSize int64, policy string, access *AccessStats) int {
+	if u.total <= maxSize {
+		return 0
+	}
+
+	reason := EvictLRU
+	if policy == EvictLFU && !access.Empty() {
+		reason = EvictLFU
+		counts := make(map[string]uint32, len(u.entries))
+		for _, e := range u.entries {
+			counts[e.key] = access.Estimate(e.key)
+		}
+		sort.Slice(u.entries, func(i, j int) bool {
+			a, b := u.entries[i], u.entries[j]
+			if counts[a.key] != counts[b.key] {
+				return counts[a.key] < counts[b.key]
+			}
+			return a.lastUsed.Before(b.lastUsed)
+		})
+	} else {
+		sort.Slice(u.entries, func(i, j int) bool { return u.entries[i].lastUsed.Before(u.entries[j].lastUsed) })
+	}
+
+	target := int64(float64(maxSize) * evictTarget)
+	evicted := 0
+	for _, e := range u.entries {
+		if u.total <= target {
+			break
+		}
+		if err := os.Remove(e.path); err != nil {
+			continue
+		}
+		_ = os.Remove(MetaPath(e.path))
+		u.total -= e.size
+		if b := e.blob; b != nil {
+			// Only the blob is left once its last variant goes
+			b.links--
+			if b.links <= 1 && b.path != "" && os.Remove(b.path) == nil {
+				u.total -= b.size
+			}
+		}
+		evicted++
+		metrics.DiskEvictionsTotal.WithLabelValues(reason).Inc()
+	}
+	return evicted
+}
+
+// isCacheKey reports whether name is a cache entry: a SHA-256 key,
+// possibly suffixed (e.g. "-lqip"), and not a metadata sidecar.
+func isCacheKey(name string) bool {
+	if len(name) < 64 || strings.HasSuffix(name, ".meta") {
+		return false
+	}
+	_, err := hex.DecodeString(name[:64])
+	return err == nil
+}
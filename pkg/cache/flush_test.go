@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis answers the commands RedisCache sends from a map, as a client
+// hook, so no server is needed.
+type fakeRedis struct {
+	mu   sync.Mutex
+	data map[string]string
+	gets int
+}
+
+func newFakeRedisCache() (*RedisCache, *fakeRedis) {
+	fake := &fakeRedis{data: map[string]string{}}
+	c := NewRedisCache([]string{"127.0.0.1:0"}, "", 0, time.Hour)
+	c.client.AddHook(fake)
+	return c, fake
+}
+
+func (f *fakeRedis) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (f *fakeRedis) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		f.process(cmd)
+		return cmd.Err()
+	}
+}
+
+func (f *fakeRedis) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			f.process(cmd)
+		}
+		return nil
+	}
+}
+
+func (f *fakeRedis) process(cmd redis.Cmder) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	args := cmd.Args()
+	arg := func(i int) string {
+		switch v := args[i].(type) {
+		case []byte:
+			return string(v)
+		case string:
+			return v
+		}
+		return ""
+	}
+	switch strings.ToLower(cmd.Name()) {
+	case "get":
+		f.gets++
+		if v, ok := f.data[arg(1)]; ok {
+			cmd.(*redis.StringCmd).SetVal(v)
+		} else {
+			cmd.SetErr(redis.Nil)
+		}
+	case "set":
+		f.data[arg(1)] = arg(2)
+		cmd.(*redis.StatusCmd).SetVal("OK")
+	case "del", "unlink":
+		var n int64
+		for i := 1; i < len(args); i++ {
+			if _, ok := f.data[arg(i)]; ok {
+				delete(f.data, arg(i))
+				n++
+			}
+		}
+		cmd.(*redis.IntCmd).SetVal(n)
+	case "scan":
+		// A single page holding every match
+		pattern := "*"
+		for i := 2; i+1 < len(args); i += 2 {
+			if strings.EqualFold(arg(i), "match") {
+				pattern = arg(i + 1)
+			}
+		}
+		var keys []string
+		for key := range f.data {
+			if ok, _ := path.Match(pattern, key); ok {
+				keys = append(keys, key)
+			}
+		}
+		cmd.(*redis.ScanCmd).SetVal(keys, 0)
+	case "ping":
+		cmd.(*redis.StatusCmd).SetVal("PONG")
+	default:
+		cmd.SetErr(redis.Nil)
+	}
+}
+
+func (f *fakeRedis) has(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.data[key]
+	return ok
+}
+
+// cacheKey returns a key shaped like the ones the handlers build.
+func cacheKey(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFlush(t *testing.T) {
+	ctx := context.Background()
+	entry, lqip := cacheKey("photos/a.jpg?w=300"), cacheKey("photos/a.jpg")+"_lqip"
+	// Keys that share the database with the cache and survive any flush
+	others := []string{"ratelimit:192.0.2.1", "lock:" + entry, "stats:hits"}
+
+	tests := []struct {
+		name       string
+		provider   string // flushed cache: memory, redis or tiered
+		shared     bool
+		wantMemory bool // entries left in memory
+		wantRedis  bool // entries left in Redis
+	}{
+		{name: "memory", provider: "memory", wantRedis: true},
+		{name: "memory, shared", provider: "memory", shared: true, wantRedis: true},
+		{name: "redis", provider: "redis", wantMemory: true, wantRedis: true},
+		{name: "redis, shared", provider: "redis", shared: true, wantMemory: true},
+		{name: "tiered", provider: "tiered", wantRedis: true},
+		{name: "tiered, shared", provider: "tiered", shared: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory := NewMemoryCache(100, 0, time.Hour)
+			redisCache, fake := newFakeRedisCache()
+			c := map[string]CacheProvider{
+				"memory": memory,
+				"redis":  redisCache,
+				"tiered": NewTieredCache(memory, redisCache),
+			}[tt.provider]
+			for _, key := range []string{entry, lqip} {
+				memory.Set(ctx, key, []byte("variant"), 0)
+				redisCache.Set(ctx, key, []byte("variant"), 0)
+			}
+			for _, key := range others {
+				fake.data[key] = "1"
+			}
+			memory.cache.Wait()
+
+			if err := c.Flush(ctx, tt.shared); err != nil {
+				t.Fatalf("Flush() = %v", err)
+			}
+			for _, key := range []string{entry, lqip} {
+				if _, found := memory.Get(ctx, key); found != tt.wantMemory {
+					t.Errorf("memory entry %s present %v, want %v", key, found, tt.wantMemory)
+				}
+				if found := fake.has(key); found != tt.wantRedis {
+					t.Errorf("Redis entry %s present %v, want %v", key, found, tt.wantRedis)
+				}
+			}
+			for _, key := range others {
+				if !fake.has(key) {
+					t.Errorf("flush deleted %s", key)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Locker hands out short-lived exclusive leases, used to make sure only one
+// instance refreshes a given stale cache entry at a time.
+type Locker interface {
+	// TryLock acquires the lease for key if nobody holds it. The lease is not
+	// released explicitly; it expires after ttl.
+	TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// Ensure RedisLocker implements Locker
+var _ Locker = (*RedisLocker)(nil)
+
+// RedisLocker implements Locker with SET NX, so the lease is shared by every
+// instance using the same Redis.
+type RedisLocker struct {
+	client redis.UniversalClient
+}
+
+func NewRedisLocker(addrs []string, password string, db int) *RedisLocker {
+	return &RedisLocker{
+		client: redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs:    addrs,
+			Password: password,
+			DB:       db,
+		}),
+	}
+}
+
+func (l *RedisLocker) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return l.client.SetNX(ctx, "quirm:lock:"+key, 1, ttl).Result()
+}
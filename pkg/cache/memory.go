@@ -2,56 +2,70 @@ package cache
 
 import (
 	"context"
+	"log/slog"
 	"time"
 
 	"github.com/dgraph-io/ristretto"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
 )
 
-// Ensure MemoryCache implements CacheProvider
-var _ CacheProvider = (*MemoryCache)(nil)
+// Ensure MemoryCache implements CacheProvider and Remover
+var (
+	_ CacheProvider = (*MemoryCache)(nil)
+	_ Remover       = (*MemoryCache)(nil)
+)
 
 type MemoryCache struct {
 	cache *ristretto.Cache
+	ttl   time.Duration
+}
+
+// DefaultAvgItemBytes is the average entry size assumed when sizing the
+// admission counters of a byte-limited memory cache.
+const DefaultAvgItemBytes = 10240
+
+// MemoryCacheOptions configures a MemoryCache.
+type MemoryCacheOptions struct {
+	// Size is the number of entries held when LimitBytes is 0
+	Size int
+	// LimitBytes bounds the cache by the size of its entries instead
+	LimitBytes int64
+	DefaultTTL time.Duration
+	// AvgItemBytes is the expected average entry size with LimitBytes, from
+	// which the number of admission counters is derived (10 per entry that
+	// fits). Too high and popular entries are rejected for lack of counters;
+	// too low only costs memory. 0 means DefaultAvgItemBytes.
+	AvgItemBytes int64
+	// Metrics enables ristretto's statistics, reported as the hit and
+	// admission ratio gauges
+	Metrics bool
 }
 
 func NewMemoryCache(size int, limitBytes int64, defaultTTL time.Duration) *MemoryCache {
-	var maxCost int64
-	var numCounters int64
-	
-	// Determine configuration
-	if limitBytes > 0 {
-		// Capacity-based limit
-		maxCost = limitBytes
-		// NumCounters should be approx 10x the number of items.
-		// Since we don't know the item count, we assume an average item size.
-		// Let's assume average 50KB image/data size as a heuristic? 
-		// Or just set a safe high number. Ristretto counters are small (4 bits).
-		// 100MB cache -> 2000 items (50KB each). 10x -> 20,000 counters.
-		// If limitBytes is small (10MB), 200 items.
-		// Let's estimate avg item size 10KB to be safe?
-		estimatedItems := limitBytes / 10240 
-		if estimatedItems < 100 {
-			estimatedItems = 100
-		}
-		numCounters = estimatedItems * 10
-	} else {
-		// Item count-based limit
-		maxCost = int64(size)
-		if maxCost <= 0 {
-			maxCost = 100 // Fallback
-		}
-		numCounters = maxCost * 10
-	}
+	return NewMemoryCacheWithOptions(MemoryCacheOptions{Size: size, LimitBytes: limitBytes, DefaultTTL: defaultTTL})
+}
 
+// NewMemoryCacheWithOptions returns a memory cache configured by opts.
+func NewMemoryCacheWithOptions(opts MemoryCacheOptions) *MemoryCache {
 	config := &ristretto.Config{
-		NumCounters: numCounters,
-		MaxCost:     maxCost,
 		BufferItems: 64, // Number of keys per Get buffer.
-		Metrics:     false,
+		Metrics:     opts.Metrics,
 	}
+	costUnit := "items"
 
-	// Cost function
-	if limitBytes > 0 {
+	if opts.LimitBytes > 0 {
+		// Capacity-based limit. Entries cost their size, plus the internal
+		// cost ristretto adds for its bookkeeping of each one.
+		avg := opts.AvgItemBytes
+		if avg <= 0 {
+			avg = DefaultAvgItemBytes
+		}
+		estimatedItems := max(opts.LimitBytes/avg, 100)
+		costUnit = "bytes"
+		config.MaxCost = opts.LimitBytes
+		config.NumCounters = estimatedItems * 10
+		config.IgnoreInternalCost = false
 		config.Cost = func(value interface{}) int64 {
 			if val, ok := value.([]byte); ok {
 				return int64(len(val))
@@ -59,39 +73,69 @@ func NewMemoryCache(size int, limitBytes int64, defaultTTL time.Duration) *Memor
 			return 1
 		}
 	} else {
-		// If using item count, cost is always 1
+		// Item count-based limit. Every entry costs 1, so the internal cost
+		// must be ignored: added to it, the cache would hold only a small
+		// fraction of Size entries.
+		config.MaxCost = int64(opts.Size)
+		if config.MaxCost <= 0 {
+			config.MaxCost = 100 // Fallback
+		}
+		config.NumCounters = config.MaxCost * 10
+		config.IgnoreInternalCost = true
 		config.Cost = func(value interface{}) int64 {
 			return 1
 		}
 	}
 
-	// We don't set IgnoreInternalCost: false, so overhead is not tracked automatically, which is fine.
-
 	cache, err := ristretto.NewCache(config)
 	if err != nil {
-		// Fallback or panic? A panic here means bad config usually.
-		// Given startup phase, panic is acceptable or return nil/log fatal.
-		// We'll panic to be noticed immediately.
+		// A bad configuration, to be noticed at startup
 		panic(err)
 	}
+	slog.Info("Memory cache sized", "num_counters", config.NumCounters, "max_cost", config.MaxCost, "cost_unit", costUnit, "metrics", opts.Metrics)
 
-	return &MemoryCache{
+	c := &MemoryCache{
 		cache: cache,
+		ttl:   opts.DefaultTTL,
 	}
+	if opts.Metrics {
+		go c.reportMetrics(15 * time.Second)
+	}
+	return c
 }
 
-func (c *MemoryCache) Get(ctx context.Context, key string) ([]byte, bool) {
-	val, found := c.cache.Get(key)
-	if !found {
-		return nil, false
+// reportMetrics copies ristretto's hit and admission ratios to their gauges
+// every interval.
+func (c *MemoryCache) reportMetrics(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m := c.cache.Metrics
+		metrics.MemoryCacheHitRatio.Set(m.Ratio())
+		// Sets that made it into the cache, out of all attempted: the rest
+		// were rejected by the admission policy or dropped under contention
+		if attempts := m.KeysAdded() + m.KeysUpdated() + m.SetsRejected() + m.SetsDropped(); attempts > 0 {
+			metrics.MemoryCacheAdmissionRatio.Set(float64(m.KeysAdded()+m.KeysUpdated()) / float64(attempts))
+		}
 	}
-	if data, ok := val.([]byte); ok {
-		return data, true
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string) (data []byte, found bool) {
+	defer func(start time.Time) { ObserveLookup("memory", start, found) }(time.Now())
+	val, ok := c.cache.Get(key)
+	if !ok {
+		return nil, false
 	}
-	return nil, false
+	data, found = val.([]byte)
+	return data, found
 }
 
+// Set stores value for ttl, or for the cache's default TTL when ttl is 0.
+// The default TTL also caps longer requested TTLs.
 func (c *MemoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if ttl <= 0 || (c.ttl > 0 && ttl > c.ttl) {
+		ttl = c.ttl
+	}
 	// Pass 0 as cost to let Ristretto calculate it using the configured Cost function.
 	c.cache.SetWithTTL(key, value, 0, ttl)
 	return nil
@@ -102,6 +146,20 @@ func (c *MemoryCache) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+// Remove deletes key and reports whether it was cached. Unlike Get, it is
+// not counted as a lookup.
+func (c *MemoryCache) Remove(ctx context.Context, key string) (bool, error) {
+	_, found := c.cache.Get(key)
+	c.cache.Del(key)
+	return found, nil
+}
+
+// Flush clears the cache. It is local to the instance, so shared is ignored.
+func (c *MemoryCache) Flush(ctx context.Context, shared bool) error {
+	c.cache.Clear()
+	return nil
+}
+
 func (c *MemoryCache) Health(ctx context.Context) error {
 	return nil
 }
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// cacheWorkload is a mix of entry sizes requested with a Zipf popularity,
+// as a few hot variants take most of the traffic.
+type cacheWorkload struct {
+	name  string
+	keys  int
+	limit int64 // memory cache size in bytes
+	// size returns the size of entry i
+	size func(i int) int
+}
+
+// avgSize is the mean entry size of w, what MEMORY_CACHE_AVG_ITEM_BYTES
+// should be set to for it.
+func (w cacheWorkload) avgSize() int64 {
+	var total int64
+	for i := range w.keys {
+		total += int64(w.size(i))
+	}
+	return total / int64(w.keys)
+}
+
+const (
+	avifBytes     = 200 << 10 // a processed AVIF
+	blurhashBytes = 30        // a blurhash string
+)
+
+var cacheWorkloads = []cacheWorkload{
+	{name: "avif", keys: 2000, limit: 16 << 20, size: func(i int) int { return avifBytes }},
+	{name: "mixed", keys: 5000, limit: 16 << 20, size: func(i int) int {
+		if i%10 < 7 {
+			return avifBytes
+		}
+		return blurhashBytes
+	}},
+	{name: "blurhash", keys: 200000, limit: 4 << 20, size: func(i int) int { return blurhashBytes }},
+}
+
+// BenchmarkMemoryCacheHitRate replays each workload against a byte-limited
+// memory cache sized with the default average entry size, as before
+// MEMORY_CACHE_AVG_ITEM_BYTES, and with the workload's own average. The
+// hit-rate metric is the one to compare.
+func BenchmarkMemoryCacheHitRate(b *testing.B) {
+	ctx := context.Background()
+	buf := make([]byte, avifBytes)
+	for _, w := range cacheWorkloads {
+		for _, avg := range []int64{DefaultAvgItemBytes, w.avgSize()} {
+			b.Run(fmt.Sprintf("%s/avg=%d", w.name, avg), func(b *testing.B) {
+				c := NewMemoryCacheWithOptions(MemoryCacheOptions{LimitBytes: w.limit, AvgItemBytes: avg})
+				defer c.cache.Close()
+				zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, uint64(w.keys-1))
+				hits := 0
+				b.ResetTimer()
+				for range b.N {
+					i := int(zipf.Uint64())
+					key := fmt.Sprintf("%064x", i)
+					if _, found := c.Get(ctx, key); found {
+						hits++
+						continue
+					}
+					c.Set(ctx, key, buf[:w.size(i)], 0)
+					c.cache.Wait()
+				}
+				b.ReportMetric(float64(hits)/float64(b.N), "hit-rate")
+			})
+		}
+	}
+}
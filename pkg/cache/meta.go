@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Meta is the sidecar metadata stored next to a cached file. It records the
+// origin version the file was built from, so a stale entry can be revalidated
+// against the origin instead of being rebuilt.
+type Meta struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified time.Time `json:"last_modified,omitempty"`
+	// Metadata is the origin's user-defined metadata, which may change the
+	// output through processing hints
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Revalidations counts refreshes that kept the file since it was built
+	Revalidations int `json:"revalidations,omitempty"`
+	// ContentType is the origin's Content-Type of a passthrough original, or
+	// the type of the format a processed variant was encoded in
+	ContentType string `json:"content_type,omitempty"`
+	// ObjectKey is the object the file was built from, so entries can be
+	// found by object key without the variant index (prefix purges)
+	ObjectKey string `json:"object_key,omitempty"`
+	// SourceFrames is the frame count of the source of a static variant, so
+	// X-Quirm-Static is only sent for stills of animations
+	SourceFrames int `json:"source_frames,omitempty"`
+	// Version is the cache version (content hash, VERSION_PARAMS) a
+	// passthrough original was stored under, so prefix purges can find its
+	// copies in other encodings
+	Version string `json:"version,omitempty"`
+}
+
+// MetaPath returns the sidecar path for the cached file at path.
+func MetaPath(path string) string {
+	return path + ".meta"
+}
+
+// ReadMeta loads the sidecar metadata of the cached file at path.
+func ReadMeta(path string) (Meta, error) {
+	var m Meta
+	data, err := os.ReadFile(MetaPath(path))
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+// WriteMeta stores the sidecar metadata of the cached file at path.
+func WriteMeta(path string, m Meta) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(MetaPath(path), data, 0644)
+}
+
+// FindByObjectPrefix walks the disk cache in dir for entries whose metadata
+// records an object key starting with prefix, and calls fn with the cache
+// key and metadata of each until fn returns false. Entries written before
+// object keys were recorded are not found.
+func FindByObjectPrefix(dir, prefix string, fn func(cacheKey string, m Meta) bool) error {
+	blobDir := filepath.Join(dir, DedupDir)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // skip errors
+		}
+		if d.IsDir() {
+			if path == blobDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		cachePath, ok := strings.CutSuffix(path, ".meta")
+		if !ok {
+			return nil
+		}
+		m, err := ReadMeta(cachePath)
+		if err != nil || m.ObjectKey == "" || !strings.HasPrefix(m.ObjectKey, prefix) {
+			return nil
+		}
+		if !fn(filepath.Base(cachePath), m) {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return err
+}
+
+// SameVersion reports whether the metadata describes the given origin
+// version. The ETag is authoritative; the modification time is only used when
+// the origin reports no ETag.
+func (m Meta) SameVersion(etag string, lastModified time.Time) bool {
+	if m.ETag != "" || etag != "" {
+		return m.ETag == etag
+	}
+	return !m.LastModified.IsZero() && m.LastModified.Equal(lastModified)
+}
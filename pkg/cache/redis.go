@@ -2,29 +2,36 @@ package cache
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-// Ensure RedisCache implements CacheProvider
-var _ CacheProvider = (*RedisCache)(nil)
+// Ensure RedisCache implements CacheProvider and Remover
+var (
+	_ CacheProvider = (*RedisCache)(nil)
+	_ Remover       = (*RedisCache)(nil)
+)
 
 type RedisCache struct {
 	client redis.UniversalClient
+	ttl    time.Duration
 }
 
-func NewRedisCache(addrs []string, password string, db int) *RedisCache {
+func NewRedisCache(addrs []string, password string, db int, defaultTTL time.Duration) *RedisCache {
 	return &RedisCache{
 		client: redis.NewUniversalClient(&redis.UniversalOptions{
 			Addrs:    addrs,
 			Password: password,
 			DB:       db,
 		}),
+		ttl: defaultTTL,
 	}
 }
 
-func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, bool) {
+func (c *RedisCache) Get(ctx context.Context, key string) (data []byte, found bool) {
+	defer func(start time.Time) { ObserveLookup("redis", start, found) }(time.Now())
 	val, err := c.client.Get(ctx, key).Bytes()
 	if err != nil {
 		return nil, false
@@ -32,7 +39,11 @@ func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, bool) {
 	return val, true
 }
 
+// Set stores value for ttl, or for the cache's default TTL when ttl is 0.
 func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
 	return c.client.Set(ctx, key, value, ttl).Err()
 }
 
@@ -40,6 +51,56 @@ func (c *RedisCache) Delete(ctx context.Context, key string) error {
 	return c.client.Del(ctx, key).Err()
 }
 
+// Remove deletes key and reports whether it existed, from the count DEL
+// returns.
+func (c *RedisCache) Remove(ctx context.Context, key string) (bool, error) {
+	n, err := c.client.Del(ctx, key).Result()
+	return n > 0, err
+}
+
+// cacheKeyPattern matches cache keys (SHA-256 hex, optionally suffixed as
+// LQIP keys are), and none of the lock, stats or rate limit keys that may
+// share the database.
+var cacheKeyPattern = strings.Repeat("[0-9a-f]", 64) + "*"
+
+// Flush deletes the cache entries from Redis, which every instance shares,
+// so it does nothing unless shared is set. Other keys in the database are
+// left alone.
+func (c *RedisCache) Flush(ctx context.Context, shared bool) error {
+	if !shared {
+		return nil
+	}
+	if cluster, ok := c.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return flushKeys(ctx, node)
+		})
+	}
+	return flushKeys(ctx, c.client)
+}
+
+// flushKeys deletes the cache keys of one node. Keys are unlinked one by one
+// in pipelined batches, as cluster slots forbid multi-key deletes.
+func flushKeys(ctx context.Context, client redis.Cmdable) error {
+	iter := client.Scan(ctx, 0, cacheKeyPattern, 1000).Iterator()
+	pipe := client.Pipeline()
+	for iter.Next(ctx) {
+		pipe.Unlink(ctx, iter.Val())
+		if pipe.Len() >= 1000 {
+			if _, err := pipe.Exec(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if pipe.Len() > 0 {
+		_, err := pipe.Exec(ctx)
+		return err
+	}
+	return nil
+}
+
 func (c *RedisCache) Health(ctx context.Context) error {
 	return c.client.Ping(ctx).Err()
 }
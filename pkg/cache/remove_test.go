@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"context"
+	"testing"
+)
+
+// TestRemove reports whether a deleted entry existed, without reading it.
+func TestRemove(t *testing.T) {
+	ctx := context.Background()
+	key := cacheKey("photos/a.jpg?w=300")
+
+	memory := NewMemoryCacheWithOptions(MemoryCacheOptions{LimitBytes: 1 << 20})
+	defer memory.cache.Close()
+	redisCache, fake := newFakeRedisCache()
+	for _, tt := range []struct {
+		name  string
+		cache interface {
+			CacheProvider
+			Remover
+		}
+		wait func()
+	}{
+		{name: "memory", cache: memory, wait: memory.cache.Wait},
+		{name: "redis", cache: redisCache, wait: func() {}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cache.Set(ctx, key, []byte("image"), 0)
+			tt.wait()
+			if found, err := tt.cache.Remove(ctx, key); err != nil || !found {
+				t.Errorf("Remove of a cached entry = %v, %v, want true", found, err)
+			}
+			if found, err := tt.cache.Remove(ctx, key); err != nil || found {
+				t.Errorf("Remove of a removed entry = %v, %v, want false", found, err)
+			}
+		})
+	}
+	if fake.gets != 0 {
+		t.Errorf("%d GETs sent to Redis, want none", fake.gets)
+	}
+}
@@ -25,18 +25,21 @@ func (c *TieredCache) Get(ctx context.Context, key string) ([]byte, bool) {
 	if val, found := c.L1.Get(ctx, key); found {
 		return val, true
 	}
+	// Then L2
+	return c.GetShared(ctx, key)
+}
 
-	// Try L2
+// GetShared looks key up in L2 only, copying a hit to L1. Callers that check
+// the disk cache between the tiers (CACHE_LOOKUP_ORDER=memory,disk,redis)
+// ask L1 and then GetShared.
+func (c *TieredCache) GetShared(ctx context.Context, key string) ([]byte, bool) {
 	if c.L2 != nil {
 		if val, found := c.L2.Get(ctx, key); found {
-			// Populate L1 (TTL? We don't have explicit TTL here, relying on L1 defaults)
-			// Ideally Set should take 0 for default, or we pass a standard TTL.
-			// Since MemoryCache ignores TTL in our current impl (uses construction TTL), 0 is fine.
+			// Populate L1 with its default TTL
 			c.L1.Set(ctx, key, val, 0)
 			return val, true
 		}
 	}
-
 	return nil, false
 }
 
@@ -60,6 +63,16 @@ func (c *TieredCache) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+func (c *TieredCache) Flush(ctx context.Context, shared bool) error {
+	if err := c.L1.Flush(ctx, shared); err != nil {
+		return err
+	}
+	if c.L2 != nil {
+		return c.L2.Flush(ctx, shared)
+	}
+	return nil
+}
+
 func (c *TieredCache) Health(ctx context.Context) error {
 	// Check L2 if available, as L1 is memory and usually safe
 	if c.L2 != nil {
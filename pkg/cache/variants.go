@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+const (
+	// maxTrackedObjects bounds the index; past it the least recently used
+	// half of the objects is forgotten
+	maxTrackedObjects = 100000
+	// maxTrackedVariants bounds the variants remembered per object when no
+	// cap evicts them
+	maxTrackedVariants = 1000
+)
+
+// Variant is a processed variant of an object.
+type Variant struct {
+	Key      string    `json:"key"`
+	Params   string    `json:"params"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// Variants indexes the processed variants served for each object key, in
+// memory. Variants built before a restart are only known again once served.
+type Variants struct {
+	mu      sync.Mutex
+	objects map[string]*objectVariants
+}
+
+type objectVariants struct {
+	variants map[string]*Variant
+	lastUsed time.Time
+}
+
+// NewVariants returns an empty index.
+func NewVariants() *Variants {
+	return &Variants{objects: make(map[string]*objectVariants)}
+}
+
+// Touch records a use of the variant cacheKey of objectKey. When the object
+// has more than limit variants (0 for no limit), the least recently used
+// ones are dropped from the index and returned for eviction.
+func (v *Variants) Touch(objectKey, cacheKey, params string, limit int) []Variant {
+	now := time.Now()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	obj, ok := v.objects[objectKey]
+	if !ok {
+		if len(v.objects) >= maxTrackedObjects {
+			v.prune()
+		}
+		obj = &objectVariants{variants: make(map[string]*Variant)}
+		v.objects[objectKey] = obj
+	}
+	obj.lastUsed = now
+	if variant, ok := obj.variants[cacheKey]; ok {
+		variant.LastUsed = now
+		return nil
+	}
+	obj.variants[cacheKey] = &Variant{Key: cacheKey, Params: params, LastUsed: now}
+	metrics.VariantsPerObject.Observe(float64(len(obj.variants)))
+
+	keep := limit
+	if keep <= 0 {
+		keep = maxTrackedVariants
+	}
+	if len(obj.variants) <= keep {
+		return nil
+	}
+	dropped := obj.oldest(len(obj.variants) - keep)
+	for _, d := range dropped {
+		delete(obj.variants, d.Key)
+	}
+	if limit <= 0 {
+		// Only forgotten, the files stay until the cleaner removes them
+		return nil
+	}
+	return dropped
+}
+
+// Remove forgets a variant, e.g. once purged.
+func (v *Variants) Remove(objectKey, cacheKey string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if obj, ok := v.objects[objectKey]; ok {
+		delete(obj.variants, cacheKey)
+		if len(obj.variants) == 0 {
+			delete(v.objects, objectKey)
+		}
+	}
+}
+
+// List returns the known variants of objectKey, most recently used first.
+func (v *Variants) List(objectKey string) []Variant {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	obj, ok := v.objects[objectKey]
+	if !ok {
+		return []Variant{}
+	}
+	list := obj.oldest(len(obj.variants))
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list
+}
+
+// WithPrefix returns up to limit tracked object keys starting with prefix.
+func (v *Variants) WithPrefix(prefix string, limit int) []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var keys []string
+	for objectKey := range v.objects {
+		if len(keys) >= limit {
+			break
+		}
+		if strings.HasPrefix(objectKey, prefix) {
+			keys = append(keys, objectKey)
+		}
+	}
+	return keys
+}
+
+// oldest returns the n least recently used variants, oldest first.
+func (o *objectVariants) oldest(n int) []Variant {
+	list := make([]Variant, 0, len(o.variants))
+	for _, variant := range o.variants {
+		list = append(list, *variant)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastUsed.Before(list[j].LastUsed) })
+	return list[:n]
+}
+
+// prune forgets the least recently used half of the objects.
+func (v *Variants) prune() {
+	keys := make([]string, 0, len(v.objects))
+	for k := range v.objects {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return v.objects[keys[i]].lastUsed.Before(v.objects[keys[j]].lastUsed) })
+	for _, k := range keys[:len(keys)/2] {
+		delete(v.objects, k)
+	}
+}
@@ -2,25 +2,55 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// ErrNoReloadSource is returned by Reload when the configuration was given
+// programmatically, so there is nothing to read it from again.
+var ErrNoReloadSource = errors.New("configuration was not loaded from the environment and cannot be reloaded")
+
 // Manager holds the current configuration and manages reloads
 type Manager struct {
-	config Config
-	mu     sync.RWMutex
+	config     Config
+	generation uint64
+	mu         sync.RWMutex
+	// load reads the configuration again on Reload; nil when it was given
+	// programmatically
+	load func() Config
 }
 
 // NewManager creates a new configuration manager
 func NewManager() *Manager {
+	return NewManagerFromEnvironment(LoadConfig())
+}
+
+// NewManagerFromEnvironment creates a configuration manager starting from
+// cfg, which the caller read from the environment and may have adjusted.
+// Reload reads the environment (and .env) again.
+func NewManagerFromEnvironment(cfg Config) *Manager {
+	return &Manager{
+		config: cfg,
+		load:   loadEnvironment,
+	}
+}
+
+// NewManagerWithConfig creates a configuration manager holding cfg, given
+// programmatically. Reload fails with ErrNoReloadSource rather than replace
+// it with whatever the environment holds.
+func NewManagerWithConfig(cfg Config) *Manager {
 	return &Manager{
-		config: LoadConfig(),
+		config: cfg,
 	}
 }
 
@@ -31,26 +61,231 @@ func (m *Manager) Get() Config {
 	return m.config
 }
 
-// Reload reloads the configuration from environment variables
+// Generation counts successful reloads, so values derived from the
+// configuration can tell when to recompute.
+func (m *Manager) Generation() uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.generation
+}
+
+// Reload reads the configuration again from where it came from. An invalid
+// configuration is rejected and the current one kept.
 func (m *Manager) Reload() error {
-	// Overload will overwrite existing env vars with values from .env
-	if err := godotenv.Overload(); err != nil {
-		// It's okay if .env doesn't exist, we just reload from OS env
+	if m.load == nil {
+		return ErrNoReloadSource
+	}
+	newConfig := m.load()
+	if err := newConfig.Validate(); err != nil {
+		return err
 	}
-
-	newConfig := LoadConfig()
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.config = newConfig
+	m.generation++
 	return nil
 }
 
+// loadEnvironment reads the configuration from environment variables, with
+// the values of .env taking precedence.
+func loadEnvironment() Config {
+	// Overload will overwrite existing env vars with values from .env
+	if err := godotenv.Overload(); err != nil {
+		// It's okay if .env doesn't exist, we just reload from OS env
+	}
+	return LoadConfig()
+}
+
+// File classes a DefaultImageRule can be limited to.
+const (
+	FileClassImage = "image"
+	FileClassVideo = "video"
+)
+
+// DefaultImageRule picks the default image served for missing objects under
+// a key prefix and/or of a file class. Empty fields match any key.
+type DefaultImageRule struct {
+	Prefix string `json:"prefix"`
+	// Class is FileClassImage or FileClassVideo
+	Class string `json:"class"`
+	Path  string `json:"path"`
+}
+
+// TransformPolicy limits the derivatives that may be served for a key
+// prefix. Zero values leave the corresponding property unrestricted.
+type TransformPolicy struct {
+	MaxWidth  int `json:"max_width"`
+	MaxHeight int `json:"max_height"`
+	// Formats lists the allowed output formats (e.g. "jpeg", "webp")
+	Formats    []string `json:"formats"`
+	MaxQuality int      `json:"max_quality"`
+	// Passthrough permits serving the unprocessed original
+	Passthrough bool `json:"passthrough"`
+}
+
 // Config holds application configuration
 type Config struct {
 	// Features
 	Presets          map[string]string
 	DefaultImagePath string
+	// DefaultImages are tried in order before DefaultImagePath
+	DefaultImages     []DefaultImageRule
+	PathOptions       bool
+	PathOptionsMarker string
+	// Unknown ?preset= names get 400 with PresetStrict, and are otherwise
+	// replaced by DefaultPreset (ignored when it is empty)
+	PresetStrict  bool
+	DefaultPreset string
+	// Passthrough-only prefixes are never re-encoded; process-only prefixes never serve originals
+	PassthroughOnlyPrefixes []string
+	PassthroughOnlyStrict   bool
+	ProcessOnlyPrefixes     []string
+	ProcessOnlyPreset       string
+	// Originals larger than MaxPassthroughBytes (0 = no limit) are not
+	// passed through, except under LargePassthroughPrefixes
+	MaxPassthroughBytes      int64
+	LargePassthroughPrefixes []string
+	// VideoDisabledStatus (501 or 400) answers transform parameters on
+	// videos while EnableVideoThumbnail is off
+	VideoDisabledStatus int
+	// ServableExtensions lists the extensions (lowercase, without the dot)
+	// that are served at all, "*" for any; DeniedKeyRegexp, compiled from
+	// DeniedKeyPattern, rejects matching keys. Both answer 404 without an
+	// origin request.
+	ServableExtensions []string
+	DeniedKeyPattern   string
+	DeniedKeyRegexp    *regexp.Regexp
+	// DebugHeaders adds X-Quirm-Key/Options/Variant to asset responses
+	DebugHeaders bool
+	// DemoMode serves bundled sample images from memory instead of S3
+	DemoMode bool
+	// EnablePlayground serves the admin URL builder at /_playground
+	EnablePlayground bool
+	// EnablePlaceholders serves generated placeholders at /_placeholder/WxH,
+	// up to PlaceholderMaxDimension pixels on each side
+	EnablePlaceholders      bool
+	PlaceholderMaxDimension int
+	// RateLimitIPv6Prefix aggregates IPv6 clients to this prefix length
+	RateLimitIPv6Prefix int
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For names the
+	// client, for rate limits, ALLOWED_CIDRS and the audit log
+	TrustedProxies []*net.IPNet
+	// TextTemplates are named texts rendered tiled with request values (text_tpl)
+	TextTemplates map[string]string
+	// HedgeAfter fires a second origin GET when the first has not answered
+	// within this delay (0 disables); HedgeMaxPercent caps the share of hedged GETs
+	HedgeAfter      time.Duration
+	HedgeMaxPercent int
+	// Origin calls slower than OriginSlowThreshold are logged (0 disables).
+	// Idempotent reads are retried OriginReadRetries times, after
+	// OriginRetryBackoff doubling each time
+	OriginSlowThreshold time.Duration
+	OriginReadRetries   int
+	OriginRetryBackoff  time.Duration
+	// Variant builds failing with a transient origin or libvips error are
+	// retried ProcessRetries times (at most 2), after ProcessRetryBackoff
+	// doubling each time
+	ProcessRetries      int
+	ProcessRetryBackoff time.Duration
+	// CacheDedup stores identical processed outputs once, hard-linked per variant
+	CacheDedup bool
+	// MaxVariantsPerObject evicts the least recently used processed variants
+	// of an object beyond this many (0 = unlimited)
+	MaxVariantsPerObject int
+	// PurgePrefixMaxEntries caps the cache entries one prefix purge deletes;
+	// callers repeat the purge until it is no longer truncated
+	PurgePrefixMaxEntries int
+	// AnimatedVideoMaxFrames caps the frames of a GIF transcoded to MP4/WebM
+	AnimatedVideoMaxFrames int
+	// VideoPresignTTL is the validity of the presigned URLs ffmpeg reads
+	// videos from; it must outlast the slowest ffmpeg run
+	VideoPresignTTL time.Duration
+	// MaxConcurrentFFmpeg bounds the ffmpeg processes running at once (0 =
+	// no limit); others wait up to FFmpegQueueTimeout, then get a 503
+	MaxConcurrentFFmpeg int
+	FFmpegQueueTimeout  time.Duration
+	// Animated outputs (video animated thumbnails, GIFs converted to video)
+	// are capped at this size, below the stills' limits (0 = no cap)
+	MaxAnimatedWidth  int
+	MaxAnimatedHeight int
+	// Smaller w and h values are raised to MinOutputWidth/MinOutputHeight,
+	// or rejected with 400 when MinOutputStrict is set
+	MinOutputWidth  int
+	MinOutputHeight int
+	MinOutputStrict bool
+	// GIFs with more frames, or a larger width or height, are served
+	// unchanged by optimize=true
+	GIFOptimizeMaxFrames    int
+	GIFOptimizeMaxDimension int
+	// AutoGrayscale turns auto_gray on for every request: monochrome JPEG
+	// and PNG outputs are encoded single-band
+	AutoGrayscale bool
+	// CanonicalizeURLs is "redirect" to 301 non-canonical query strings, or "off"
+	CanonicalizeURLs string
+	// StatsBackend ("memory" or "redis") enables per-key request stats; StatsMaxKeys bounds the keys per day
+	StatsBackend string
+	StatsMaxKeys int
+	// ErrorWebhookURL receives batched summaries of keys failing repeatedly:
+	// ErrorWebhookThreshold failures within ErrorWebhookWindow, at most once per key per ErrorWebhookInterval
+	ErrorWebhookURL       string
+	ErrorWebhookThreshold int
+	ErrorWebhookWindow    time.Duration
+	ErrorWebhookInterval  time.Duration
+	// ProcessedWebhookURL receives the metadata of every processed variant,
+	// each POST bounded by ProcessedWebhookTimeout. Processed hooks run on
+	// ProcessedHookWorkers workers with up to ProcessedHookQueueSize waiting
+	ProcessedWebhookURL     string
+	ProcessedWebhookTimeout time.Duration
+	ProcessedHookWorkers    int
+	ProcessedHookQueueSize  int
+	// EnforceExpires honors "expires" on unsigned requests too; MaxURLLifetime
+	// caps how far out a signed expires may be (0 = no cap)
+	EnforceExpires   bool
+	MaxURLLifetime   time.Duration
+	ExpiresClockSkew time.Duration
+	// AuditLog records privileged operations, to AuditLogPath (JSON lines) or
+	// the regular log; AuditLogSize recent events are kept for /_audit/recent
+	AuditLog     bool
+	AuditLogPath string
+	AuditLogSize int
+	// CapabilitiesRequireAdmin restricts /_capabilities to admins
+	CapabilitiesRequireAdmin bool
+	// AllowHeaderDefaults lets trusted callers default q, format and bg with
+	// X-Quirm-Default-* request headers
+	AllowHeaderDefaults bool
+	// AllowEncodedSlashes accepts "%2F" in object keys, as a plain slash
+	AllowEncodedSlashes bool
+	// Bearer tokens (JWT) accepted in place of a URL signature
+	AuthJWTSecret     string
+	AuthJWKSURL       string
+	AuthTokenCacheTTL time.Duration
+	// Request limits, checked before any endpoint parses the request
+	MaxURLLength int
+	MaxBodyBytes int64
+	// Peers shares processed variants across replicas; PeerSelf is this node's entry
+	Peers    []string
+	PeerSelf string
+	// AllowedTransforms limits the derivatives served per key prefix
+	AllowedTransforms    map[string]TransformPolicy
+	allowedTransformsErr error
+	defaultImagesErr     error
+	// HonorObjectMetadata applies processing hints from x-amz-meta-* metadata
+	HonorObjectMetadata bool
+	// VersionParams are cache-busting query parameters (e.g. "v"): part of
+	// the cache key and of signatures, ignored by processing, and allowed
+	// unsigned on their own
+	VersionParams []string
+	// Immutable URLs: "/<content-hash>/key" validated against the origin ETag
+	ImmutableURLs     bool
+	ImmutableMismatch string // "redirect" or "notfound"
+	// Client hints (Save-Data, ECT)
+	ClientHints          bool
+	SaveDataQualityDelta int
+	SlowNetworkMaxWidth  int
+	ClientHintWidths     []int
+	// EncodingPreference orders passthrough content codings when the client rates them equally
+	EncodingPreference []string
 
 	S3Endpoint        string
 	S3Region          string
@@ -60,34 +295,109 @@ type Config struct {
 	S3SecretKey       string
 	S3ForcePathStyle  bool
 	S3UseCustomDomain bool
-	Port              string
-	CacheDir          string
-	CacheTTL          time.Duration
-	CleanupInterval   time.Duration
-	Debug             bool
+	// S3RequestPayer is "requester" for requester-pays buckets
+	S3RequestPayer        string
+	S3ExpectedBucketOwner string
+	// Origin connection pools. Warmup uses its own pool of at most
+	// S3BackgroundMaxConns connections.
+	S3MaxIdleConns       int
+	S3MaxConnsPerHost    int // 0 = unlimited
+	S3IdleConnTimeout    time.Duration
+	S3BackgroundMaxConns int
+	// StatCacheTTL controls how long object metadata lookups are cached (0 disables)
+	StatCacheTTL    time.Duration
+	StatCacheSize   int
+	Port            string
+	CacheDir        string
+	CacheTTL        time.Duration
+	CleanupInterval time.Duration
+	Debug           bool
+	// NotFoundCacheTTL controls how long keys the origin reported missing
+	// are answered 404 without asking it again (0 disables)
+	NotFoundCacheTTL  time.Duration
+	NotFoundCacheSize int
+	// CacheHardTTL is the age at which the cleaner deletes disk entries; older
+	// entries are never served, not even stale
+	CacheHardTTL time.Duration
+	// CacheMaxSizeMB is the disk cache budget (0 = unlimited); the cleaner
+	// evicts entries chosen by CacheEvictionPolicy ("lru" or "lfu") past it
+	CacheMaxSizeMB      int64
+	CacheEvictionPolicy string
+	// LegacyCacheKeys looks processed entries up under their pre-canonical
+	// (raw parameter) key when the canonical key misses
+	LegacyCacheKeys bool
+	// CacheLookupOrder is the order processed variants are looked up in:
+	// "memory,redis,disk" or "memory,disk,redis"
+	CacheLookupOrder string
+	// StaleServeMax bounds how long past CacheTTL a stale disk entry may still be served (0 = no limit)
+	StaleServeMax time.Duration
+	// DiskProbeInterval is how often an unwritable cache directory is re-checked
+	DiskProbeInterval time.Duration
+	// SelfTestInterval is the least time between two /_selftest runs
+	SelfTestInterval time.Duration
+	// DiskCacheMinBytes is the size below which processed outputs are only
+	// kept in the memory/Redis cache, not written to disk (0 = always write)
+	DiskCacheMinBytes int
+	// TextCacheTTL is the memory/Redis TTL of text responses (blurhash and
+	// palette), which are cheap to hold longer than images
+	TextCacheTTL time.Duration
 	// Memory Cache
 	MemoryCacheSize       int
 	MemoryCacheLimitBytes int64
+	MemoryCacheTTL        time.Duration
+	// MemoryCacheAvgItemBytes is the expected average entry size, which
+	// sizes the admission counters of a byte-limited memory cache
+	MemoryCacheAvgItemBytes int64
+	// MemoryCacheMetrics exports the memory cache's hit and admission ratios
+	MemoryCacheMetrics bool
+	RedisCacheTTL      time.Duration
 	// New Configs
 	SecretKey        string
 	WatermarkPath    string
 	WatermarkOpacity float64
-	MaxImageSizeMB   int64
-	EnableMetrics    bool
+	// WatermarkMinWidth and WatermarkMinHeight exempt outputs narrower or
+	// shorter than them from the watermark and text templates (0 = no minimum)
+	WatermarkMinWidth  int
+	WatermarkMinHeight int
+	MaxImageSizeMB     int64
+	EnableMetrics      bool
 	// Security
 	AllowedDomains   []string
 	AllowedCIDRs     []string     // Added for IP Allowlist
 	AllowedCIDRNets  []*net.IPNet // Added for IP Allowlist optimization
 	AllowedCountries []string
 	RateLimit        int // Requests per second
+	AdminToken       string
 	// Features
 	EnableVideoThumbnail bool
 	FaceFinderPath       string
 	AIModelPath          string
+	// Warmup
+	WarmupConcurrency int
+	WarmupQueueSize   int
+	WarmupHistorySize int
+	WarmupRetention   time.Duration
+	// AnalyzeConcurrency is the number of keys POST /_analyze works on at once
+	AnalyzeConcurrency int
+	// Cost log: sampled per-stage processing timings
+	CostLogSampleRate float64
+	CostLogSize       int
+	CostLogAnonymize  bool
+	CostLogSlog       bool
 	// Redis
 	RedisAddr     string
 	RedisPassword string
 	RedisDB       int
+	// RefreshLock makes instances sharing Redis take turns refreshing stale entries
+	RefreshLock    bool
+	RefreshLockTTL time.Duration
+	// RefreshForceAfter rebuilds an unchanged entry after this many revalidations (0 = never)
+	RefreshForceAfter int
+	// Background refreshes of stale entries run on RefreshConcurrency
+	// workers; past RefreshQueueSize waiting ones, more are dropped. Both are
+	// read at the first refresh.
+	RefreshConcurrency int
+	RefreshQueueSize   int
 }
 
 // LoadConfig loads configuration from environment variables
@@ -95,52 +405,434 @@ func LoadConfig() Config {
 	godotenv.Load()
 
 	allowedCIDRs := getEnvSlice("ALLOWED_CIDRS")
-	var allowedCIDRNets []*net.IPNet
-	for _, cidr := range allowedCIDRs {
-		_, ipNet, err := net.ParseCIDR(cidr)
-		if err == nil {
-			allowedCIDRNets = append(allowedCIDRNets, ipNet)
-		}
+	allowedCIDRNets := parseCIDRs(allowedCIDRs)
+
+	cacheTTL := time.Duration(getEnvInt("CACHE_TTL_HOURS", 24)) * time.Hour
+	// Without CACHE_HARD_TTL, entries are kept 24 times the freshness window,
+	// and a week when that would be under a day
+	cacheHardTTL := cacheTTL * 24
+	if cacheHardTTL < 24*time.Hour {
+		cacheHardTTL = 7 * 24 * time.Hour
+	}
+	allowedTransforms, allowedTransformsErr := getEnvPolicies("ALLOWED_TRANSFORMS")
+	defaultImages, defaultImagesErr := getEnvDefaultImages("DEFAULT_IMAGES")
+
+	encodingPreference := getEnvSlice("ENCODING_PREFERENCE")
+	if len(encodingPreference) == 0 {
+		encodingPreference = []string{"br", "gzip", "zstd"}
+	}
+
+	servableExtensions := getEnvSlice("SERVABLE_EXTENSIONS")
+	if len(servableExtensions) == 0 {
+		servableExtensions = slices.Clone(defaultServableExtensions)
+	}
+	for i, ext := range servableExtensions {
+		servableExtensions[i] = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), ".")
+	}
+	// An invalid pattern is reported by Validate
+	deniedKeyPattern := os.Getenv("DENIED_KEY_PATTERN")
+	var deniedKeyRegexp *regexp.Regexp
+	if deniedKeyPattern != "" {
+		deniedKeyRegexp, _ = regexp.Compile(deniedKeyPattern)
 	}
 
 	return Config{
-		RedisAddr:             os.Getenv("REDIS_ADDR"),
-		RedisPassword:         os.Getenv("REDIS_PASSWORD"),
-		RedisDB:               getEnvInt("REDIS_DB", 0),
-		S3Endpoint:            os.Getenv("S3_ENDPOINT"),
-		S3Region:              getEnv("S3_REGION", "auto"),
-		S3Bucket:              os.Getenv("S3_BUCKET"),
-		S3BackupBucket:        os.Getenv("S3_BACKUP_BUCKET"),
-		S3AccessKey:           os.Getenv("S3_ACCESS_KEY"),
-		S3SecretKey:           os.Getenv("S3_SECRET_KEY"),
-		S3ForcePathStyle:      getEnvBool("S3_FORCE_PATH_STYLE", false),
-		S3UseCustomDomain:     getEnvBool("S3_USE_CUSTOM_DOMAIN", false),
-		Port:                  getEnv("PORT", "8080"),
-		CacheDir:              getEnv("CACHE_DIR", "./cache_data"),
-		CacheTTL:              time.Duration(getEnvInt("CACHE_TTL_HOURS", 24)) * time.Hour,
-		CleanupInterval:       time.Duration(getEnvInt("CLEANUP_INTERVAL_MINS", 60)) * time.Minute,
-		Debug:                 getEnvBool("DEBUG", false),
-		MemoryCacheSize:       getEnvInt("MEMORY_CACHE_SIZE", 100),
-		MemoryCacheLimitBytes: int64(getEnvInt("MEMORY_CACHE_LIMIT_BYTES", 0)),
-		SecretKey:             os.Getenv("SECRET_KEY"),
-		WatermarkPath:         os.Getenv("WATERMARK_PATH"),
-		WatermarkOpacity:      getEnvFloat("WATERMARK_OPACITY", 0.5),
-		MaxImageSizeMB:        int64(getEnvInt("MAX_IMAGE_SIZE_MB", 20)),
-		EnableMetrics:         getEnvBool("ENABLE_METRICS", false),
-		AllowedDomains:        getEnvSlice("ALLOWED_DOMAINS"),
-		AllowedCIDRs:          allowedCIDRs,
-		AllowedCIDRNets:       allowedCIDRNets,
-		AllowedCountries:      getEnvSlice("ALLOWED_COUNTRIES"),
-		RateLimit:             getEnvInt("RATE_LIMIT", 10),
-		EnableVideoThumbnail:  getEnvBool("ENABLE_VIDEO_THUMBNAIL", false),
-		FaceFinderPath:        getEnv("FACE_FINDER_PATH", "facefinder"),
-		AIModelPath:           os.Getenv("AI_MODEL_PATH"),
-		Presets:               getEnvMap("PRESETS"),
-		DefaultImagePath:      getEnv("DEFAULT_IMAGE_PATH", "./assets/Teaserverse_icon.png"),
+		RedisAddr:               os.Getenv("REDIS_ADDR"),
+		RedisPassword:           os.Getenv("REDIS_PASSWORD"),
+		RedisDB:                 getEnvInt("REDIS_DB", 0),
+		RefreshLock:             getEnvBool("REFRESH_LOCK", true),
+		RefreshLockTTL:          time.Duration(getEnvInt("REFRESH_LOCK_TTL_SECS", 60)) * time.Second,
+		S3Endpoint:              os.Getenv("S3_ENDPOINT"),
+		S3Region:                getEnv("S3_REGION", "auto"),
+		S3Bucket:                os.Getenv("S3_BUCKET"),
+		S3BackupBucket:          os.Getenv("S3_BACKUP_BUCKET"),
+		S3AccessKey:             os.Getenv("S3_ACCESS_KEY"),
+		S3SecretKey:             os.Getenv("S3_SECRET_KEY"),
+		S3ForcePathStyle:        getEnvBool("S3_FORCE_PATH_STYLE", false),
+		S3UseCustomDomain:       getEnvBool("S3_USE_CUSTOM_DOMAIN", false),
+		S3RequestPayer:          os.Getenv("S3_REQUEST_PAYER"),
+		S3ExpectedBucketOwner:   os.Getenv("S3_EXPECTED_BUCKET_OWNER"),
+		S3MaxIdleConns:          getEnvInt("S3_MAX_IDLE_CONNS", 100),
+		S3MaxConnsPerHost:       getEnvInt("S3_MAX_CONNS_PER_HOST", 0),
+		S3IdleConnTimeout:       getEnvDuration("S3_IDLE_CONN_TIMEOUT", 90*time.Second),
+		S3BackgroundMaxConns:    getEnvInt("S3_BACKGROUND_MAX_CONNS", 8),
+		StatCacheTTL:            time.Duration(getEnvInt("STAT_CACHE_TTL_SECS", 10)) * time.Second,
+		StatCacheSize:           getEnvInt("STAT_CACHE_SIZE", 10000),
+		NotFoundCacheTTL:        getEnvDuration("NOT_FOUND_CACHE_TTL", time.Minute),
+		NotFoundCacheSize:       getEnvInt("NOT_FOUND_CACHE_SIZE", 10000),
+		Port:                    getEnv("PORT", "8080"),
+		CacheDir:                getEnv("CACHE_DIR", "./cache_data"),
+		CacheTTL:                cacheTTL,
+		CacheHardTTL:            getEnvDuration("CACHE_HARD_TTL", cacheHardTTL),
+		CacheMaxSizeMB:          int64(getEnvInt("CACHE_MAX_SIZE_MB", 0)),
+		CacheEvictionPolicy:     getEnv("CACHE_EVICTION_POLICY", "lru"),
+		LegacyCacheKeys:         getEnvBool("CACHE_KEY_LEGACY_FALLBACK", true),
+		CacheLookupOrder:        strings.ReplaceAll(getEnv("CACHE_LOOKUP_ORDER", CacheLookupRedisFirst), " ", ""),
+		CleanupInterval:         time.Duration(getEnvInt("CLEANUP_INTERVAL_MINS", 60)) * time.Minute,
+		Debug:                   getEnvBool("DEBUG", false),
+		MemoryCacheSize:         getEnvInt("MEMORY_CACHE_SIZE", 100),
+		MemoryCacheLimitBytes:   int64(getEnvInt("MEMORY_CACHE_LIMIT_BYTES", 0)),
+		MemoryCacheTTL:          getEnvDuration("MEMORY_CACHE_TTL", cacheTTL),
+		MemoryCacheAvgItemBytes: int64(getEnvInt("MEMORY_CACHE_AVG_ITEM_BYTES", 10240)),
+		MemoryCacheMetrics:      getEnvBool("MEMORY_CACHE_METRICS", false),
+		RedisCacheTTL:           getEnvDuration("REDIS_CACHE_TTL", cacheTTL),
+		StaleServeMax:           getEnvDuration("STALE_SERVE_MAX", 0),
+		SecretKey:               os.Getenv("SECRET_KEY"),
+		WatermarkPath:           os.Getenv("WATERMARK_PATH"),
+		WatermarkOpacity:        getEnvFloat("WATERMARK_OPACITY", 0.5),
+		WatermarkMinWidth:       getEnvInt("WATERMARK_MIN_WIDTH", 0),
+		WatermarkMinHeight:      getEnvInt("WATERMARK_MIN_HEIGHT", 0),
+		MaxImageSizeMB:          int64(getEnvInt("MAX_IMAGE_SIZE_MB", 20)),
+		EnableMetrics:           getEnvBool("ENABLE_METRICS", false),
+		AllowedDomains:          getEnvSlice("ALLOWED_DOMAINS"),
+		AllowedCIDRs:            allowedCIDRs,
+		AllowedCIDRNets:         allowedCIDRNets,
+		AllowedCountries:        getEnvSlice("ALLOWED_COUNTRIES"),
+		RateLimit:               getEnvInt("RATE_LIMIT", 10),
+		AdminToken:              os.Getenv("ADMIN_TOKEN"),
+		EnableVideoThumbnail:    getEnvBool("ENABLE_VIDEO_THUMBNAIL", false),
+		FaceFinderPath:          getEnv("FACE_FINDER_PATH", "facefinder"),
+		AIModelPath:             os.Getenv("AI_MODEL_PATH"),
+		Presets:                 getEnvMap("PRESETS"),
+		PresetStrict:            getEnvBool("PRESET_STRICT", false),
+		DefaultPreset:           os.Getenv("DEFAULT_PRESET"),
+		DefaultImagePath:        getEnv("DEFAULT_IMAGE_PATH", "./assets/Teaserverse_icon.png"),
+		DefaultImages:           defaultImages,
+		defaultImagesErr:        defaultImagesErr,
+		WarmupConcurrency:       getEnvInt("WARMUP_CONCURRENCY", 2),
+		WarmupQueueSize:         getEnvInt("WARMUP_QUEUE_SIZE", 1000),
+		WarmupHistorySize:       getEnvInt("WARMUP_HISTORY_SIZE", 1000),
+		WarmupRetention:         time.Duration(getEnvInt("WARMUP_RETENTION_MINS", 60)) * time.Minute,
+		AnalyzeConcurrency:      getEnvInt("ANALYZE_CONCURRENCY", 4),
+		CostLogSampleRate:       getEnvFloat("COST_LOG_SAMPLE_RATE", 0),
+		CostLogSize:             getEnvInt("COST_LOG_SIZE", 500),
+		CostLogAnonymize:        getEnvBool("COST_LOG_ANONYMIZE", false),
+		CostLogSlog:             getEnvBool("COST_LOG_SLOG", false),
+		PathOptions:             getEnvBool("PATH_OPTIONS", false),
+		PathOptionsMarker:       os.Getenv("PATH_OPTIONS_MARKER"),
+		EncodingPreference:      encodingPreference,
+
+		ClientHints:          getEnvBool("CLIENT_HINTS", false),
+		SaveDataQualityDelta: getEnvInt("SAVE_DATA_QUALITY_DELTA", 20),
+		SlowNetworkMaxWidth:  getEnvInt("SLOW_NETWORK_MAX_WIDTH", 640),
+		ClientHintWidths:     getEnvIntSlice("CLIENT_HINT_WIDTHS", []int{320, 640, 960, 1280, 1920}),
+
+		DiskProbeInterval: time.Duration(getEnvInt("DISK_PROBE_INTERVAL_SECS", 30)) * time.Second,
+		SelfTestInterval:  getEnvDuration("SELFTEST_INTERVAL", 30*time.Second),
+		DiskCacheMinBytes: getEnvInt("DISK_CACHE_MIN_BYTES", 0),
+		TextCacheTTL:      getEnvDuration("TEXT_CACHE_TTL", 7*24*time.Hour),
+
+		// Stale refresh
+		RefreshForceAfter:  getEnvInt("REFRESH_FORCE_AFTER", 0),
+		RefreshConcurrency: getEnvInt("REFRESH_CONCURRENCY", 4),
+		RefreshQueueSize:   getEnvInt("REFRESH_QUEUE_SIZE", 1000),
+
+		DemoMode:     getEnvBool("DEMO_MODE", false),
+		DebugHeaders: getEnvBool("DEBUG_HEADERS", false),
+
+		// Transform policies
+		AllowedTransforms:    allowedTransforms,
+		allowedTransformsErr: allowedTransformsErr,
+
+		HonorObjectMetadata: getEnvBool("HONOR_OBJECT_METADATA", false),
+		EnablePlayground:    getEnvBool("ENABLE_PLAYGROUND", false),
+
+		EnablePlaceholders:      getEnvBool("ENABLE_PLACEHOLDERS", false),
+		PlaceholderMaxDimension: getEnvInt("PLACEHOLDER_MAX_DIMENSION", 4000),
+
+		RateLimitIPv6Prefix: getEnvInt("RATE_LIMIT_IPV6_PREFIX", 64),
+		TrustedProxies:      parseCIDRs(getEnvSlice("TRUSTED_PROXIES")),
+
+		TextTemplates: getEnvMap("TEXT_TEMPLATES"),
+
+		// Origin hedging
+		HedgeAfter:      getEnvDuration("HEDGE_AFTER", 0),
+		HedgeMaxPercent: getEnvInt("HEDGE_MAX_PERCENT", 5),
+
+		// Origin instrumentation
+		OriginSlowThreshold: getEnvDuration("ORIGIN_SLOW_THRESHOLD", 2*time.Second),
+		OriginReadRetries:   getEnvInt("ORIGIN_READ_RETRIES", 0),
+		OriginRetryBackoff:  getEnvDuration("ORIGIN_RETRY_BACKOFF", 100*time.Millisecond),
+
+		// Processing retries
+		ProcessRetries:      getEnvInt("PROCESS_RETRIES", 1),
+		ProcessRetryBackoff: getEnvDuration("PROCESS_RETRY_BACKOFF", 200*time.Millisecond),
+
+		AnimatedVideoMaxFrames: getEnvInt("ANIMATED_VIDEO_MAX_FRAMES", 1000),
+		VideoPresignTTL:        getEnvDuration("VIDEO_PRESIGN_TTL", 15*time.Minute),
+		MaxConcurrentFFmpeg:    getEnvInt("MAX_CONCURRENT_FFMPEG", 2),
+		FFmpegQueueTimeout:     getEnvDuration("FFMPEG_QUEUE_TIMEOUT", 10*time.Second),
+		MaxAnimatedWidth:       getEnvInt("MAX_ANIMATED_WIDTH", 0),
+		MaxAnimatedHeight:      getEnvInt("MAX_ANIMATED_HEIGHT", 0),
+		MinOutputWidth:         getEnvInt("MIN_OUTPUT_WIDTH", 1),
+		MinOutputHeight:        getEnvInt("MIN_OUTPUT_HEIGHT", 1),
+		MinOutputStrict:        getEnvBool("MIN_OUTPUT_STRICT", false),
+
+		GIFOptimizeMaxFrames:    getEnvInt("GIF_OPTIMIZE_MAX_FRAMES", 500),
+		GIFOptimizeMaxDimension: getEnvInt("GIF_OPTIMIZE_MAX_DIMENSION", 1024),
+		AutoGrayscale:           getEnvBool("AUTO_GRAYSCALE", false),
+
+		CacheDedup: getEnvBool("CACHE_DEDUP", false),
+
+		MaxVariantsPerObject:  getEnvInt("MAX_VARIANTS_PER_OBJECT", 0),
+		PurgePrefixMaxEntries: getEnvInt("PURGE_PREFIX_MAX_ENTRIES", 10000),
+
+		CanonicalizeURLs: getEnv("CANONICALIZE_URLS", "off"),
+
+		// Request stats
+		StatsBackend: os.Getenv("STATS_BACKEND"),
+		StatsMaxKeys: getEnvInt("STATS_MAX_KEYS", 10000),
+
+		// Error webhook
+		ErrorWebhookURL:       os.Getenv("ERROR_WEBHOOK_URL"),
+		ErrorWebhookThreshold: getEnvInt("ERROR_WEBHOOK_THRESHOLD", 10),
+		ErrorWebhookWindow:    getEnvDuration("ERROR_WEBHOOK_WINDOW", 10*time.Minute),
+		ErrorWebhookInterval:  getEnvDuration("ERROR_WEBHOOK_INTERVAL", time.Hour),
+
+		// Processed hooks
+		ProcessedWebhookURL:     os.Getenv("PROCESSED_WEBHOOK_URL"),
+		ProcessedWebhookTimeout: getEnvDuration("PROCESSED_WEBHOOK_TIMEOUT", 10*time.Second),
+		ProcessedHookWorkers:    getEnvInt("PROCESSED_HOOK_WORKERS", 2),
+		ProcessedHookQueueSize:  getEnvInt("PROCESSED_HOOK_QUEUE_SIZE", 1000),
+
+		// Link expiry
+		EnforceExpires:   getEnvBool("ENFORCE_EXPIRES", false),
+		MaxURLLifetime:   getEnvDuration("MAX_URL_LIFETIME", 0),
+		ExpiresClockSkew: getEnvDuration("EXPIRES_CLOCK_SKEW", 60*time.Second),
+
+		// Audit log
+		AuditLog:     getEnvBool("AUDIT_LOG", false),
+		AuditLogPath: os.Getenv("AUDIT_LOG_PATH"),
+		AuditLogSize: getEnvInt("AUDIT_LOG_SIZE", 1000),
+
+		CapabilitiesRequireAdmin: getEnvBool("CAPABILITIES_REQUIRE_ADMIN", false),
+		AllowHeaderDefaults:      getEnvBool("ALLOW_HEADER_DEFAULTS", false),
+		AllowEncodedSlashes:      getEnvBool("ALLOW_ENCODED_SLASHES", false),
+
+		// Token auth
+		AuthJWTSecret:     os.Getenv("AUTH_JWT_SECRET"),
+		AuthJWKSURL:       os.Getenv("AUTH_JWKS_URL"),
+		AuthTokenCacheTTL: getEnvDuration("AUTH_TOKEN_CACHE_TTL", 30*time.Second),
+
+		// Request limits
+		MaxURLLength: getEnvInt("MAX_URL_LENGTH", 4096),
+		MaxBodyBytes: int64(getEnvInt("MAX_BODY_BYTES", 1<<20)),
+
+		// Peer routing
+		Peers:    getEnvSlice("PEERS"),
+		PeerSelf: os.Getenv("PEER_SELF"),
+
+		VersionParams: getEnvSlice("VERSION_PARAMS"),
+
+		// Immutable URLs
+		ImmutableURLs:     getEnvBool("IMMUTABLE_URLS", false),
+		ImmutableMismatch: getEnv("IMMUTABLE_MISMATCH", "redirect"),
+
+		// Prefix zones
+		PassthroughOnlyPrefixes: getEnvSlice("PASSTHROUGH_ONLY_PREFIXES"),
+		PassthroughOnlyStrict:   getEnvBool("PASSTHROUGH_ONLY_STRICT", false),
+		ProcessOnlyPrefixes:     getEnvSlice("PROCESS_ONLY_PREFIXES"),
+		ProcessOnlyPreset:       os.Getenv("PROCESS_ONLY_PRESET"),
+
+		MaxPassthroughBytes:      int64(getEnvInt("MAX_PASSTHROUGH_BYTES", 0)),
+		LargePassthroughPrefixes: getEnvSlice("LARGE_PASSTHROUGH_PREFIXES"),
+		VideoDisabledStatus:      getEnvInt("VIDEO_DISABLED_STATUS", 501),
+
+		// Request filter
+		ServableExtensions: servableExtensions,
+		DeniedKeyPattern:   deniedKeyPattern,
+		DeniedKeyRegexp:    deniedKeyRegexp,
+	}
+}
+
+// Lookup orders of CACHE_LOOKUP_ORDER.
+const (
+	CacheLookupRedisFirst = "memory,redis,disk"
+	CacheLookupDiskFirst  = "memory,disk,redis"
+)
+
+// defaultServableExtensions are the image, video and static file types quirm
+// knows how to serve.
+var defaultServableExtensions = []string{
+	"jpg", "jpeg", "png", "gif", "webp", "avif", "pdf",
+	"mp4", "mov", "webm",
+	"svg", "ico", "css", "js",
+}
+
+// Validate checks settings that only make sense together.
+func (c Config) Validate() error {
+	var problems []string
+	if c.MemoryCacheTTL > c.CacheTTL {
+		problems = append(problems, fmt.Sprintf("MEMORY_CACHE_TTL (%s) must not exceed the disk freshness CACHE_TTL_HOURS (%s)", c.MemoryCacheTTL, c.CacheTTL))
+	}
+	if c.CacheHardTTL < c.CacheTTL {
+		problems = append(problems, fmt.Sprintf("CACHE_HARD_TTL (%s) must not be shorter than CACHE_TTL_HOURS (%s)", c.CacheHardTTL, c.CacheTTL))
+	}
+	if c.CacheEvictionPolicy != "lru" && c.CacheEvictionPolicy != "lfu" {
+		problems = append(problems, fmt.Sprintf("CACHE_EVICTION_POLICY must be \"lru\" or \"lfu\", got %q", c.CacheEvictionPolicy))
 	}
+	if c.WatermarkMinWidth < 0 || c.WatermarkMinHeight < 0 {
+		problems = append(problems, fmt.Sprintf("WATERMARK_MIN_WIDTH and WATERMARK_MIN_HEIGHT must not be negative, got %d and %d", c.WatermarkMinWidth, c.WatermarkMinHeight))
+	}
+	if c.DiskCacheMinBytes < 0 {
+		problems = append(problems, fmt.Sprintf("DISK_CACHE_MIN_BYTES must not be negative, got %d", c.DiskCacheMinBytes))
+	}
+	if c.TextCacheTTL < 0 {
+		problems = append(problems, fmt.Sprintf("TEXT_CACHE_TTL must not be negative, got %s", c.TextCacheTTL))
+	}
+	if c.CacheLookupOrder != CacheLookupRedisFirst && c.CacheLookupOrder != CacheLookupDiskFirst {
+		problems = append(problems, fmt.Sprintf("CACHE_LOOKUP_ORDER must be %q or %q, got %q", CacheLookupRedisFirst, CacheLookupDiskFirst, c.CacheLookupOrder))
+	}
+	if c.CacheMaxSizeMB < 0 {
+		problems = append(problems, fmt.Sprintf("CACHE_MAX_SIZE_MB must not be negative, got %d", c.CacheMaxSizeMB))
+	}
+	if c.NotFoundCacheTTL < 0 {
+		problems = append(problems, fmt.Sprintf("NOT_FOUND_CACHE_TTL must not be negative, got %s", c.NotFoundCacheTTL))
+	}
+	if _, err := regexp.Compile(c.DeniedKeyPattern); err != nil {
+		problems = append(problems, fmt.Sprintf("DENIED_KEY_PATTERN is not a valid regular expression: %v", err))
+	}
+	if c.MemoryCacheAvgItemBytes < 1 {
+		problems = append(problems, fmt.Sprintf("MEMORY_CACHE_AVG_ITEM_BYTES must be at least 1, got %d", c.MemoryCacheAvgItemBytes))
+	}
+	if c.MemoryCacheTTL < 0 || c.RedisCacheTTL < 0 || c.StaleServeMax < 0 {
+		problems = append(problems, "cache TTLs must not be negative")
+	}
+	if c.defaultImagesErr != nil {
+		problems = append(problems, fmt.Sprintf("DEFAULT_IMAGES is not valid JSON: %v", c.defaultImagesErr))
+	}
+	for i, rule := range c.DefaultImages {
+		if rule.Path == "" {
+			problems = append(problems, fmt.Sprintf("DEFAULT_IMAGES rule %d has no path", i+1))
+		}
+		if rule.Class != "" && rule.Class != FileClassImage && rule.Class != FileClassVideo {
+			problems = append(problems, fmt.Sprintf("DEFAULT_IMAGES rule %d: class must be \"image\" or \"video\", got %q", i+1, rule.Class))
+		}
+	}
+	if c.allowedTransformsErr != nil {
+		problems = append(problems, fmt.Sprintf("ALLOWED_TRANSFORMS is not valid JSON: %v", c.allowedTransformsErr))
+	}
+	if c.ImmutableMismatch != "redirect" && c.ImmutableMismatch != "notfound" {
+		problems = append(problems, fmt.Sprintf("IMMUTABLE_MISMATCH must be \"redirect\" or \"notfound\", got %q", c.ImmutableMismatch))
+	}
+	if c.RateLimitIPv6Prefix < 0 || c.RateLimitIPv6Prefix > 128 {
+		problems = append(problems, fmt.Sprintf("RATE_LIMIT_IPV6_PREFIX must be between 0 and 128, got %d", c.RateLimitIPv6Prefix))
+	}
+	if c.MaxAnimatedWidth < 0 || c.MaxAnimatedHeight < 0 {
+		problems = append(problems, "MAX_ANIMATED_WIDTH and MAX_ANIMATED_HEIGHT must not be negative")
+	}
+	if _, ok := c.Presets[c.DefaultPreset]; c.DefaultPreset != "" && !ok {
+		problems = append(problems, fmt.Sprintf("DEFAULT_PRESET %q is not defined in PRESETS", c.DefaultPreset))
+	}
+	if c.MinOutputWidth < 1 || c.MinOutputHeight < 1 {
+		problems = append(problems, "MIN_OUTPUT_WIDTH and MIN_OUTPUT_HEIGHT must be at least 1")
+	}
+	if c.VideoPresignTTL < time.Minute || c.VideoPresignTTL > 7*24*time.Hour {
+		// S3 refuses to presign for more than a week
+		problems = append(problems, fmt.Sprintf("VIDEO_PRESIGN_TTL must be between 1m and 168h, got %s", c.VideoPresignTTL))
+	}
+	if c.MaxConcurrentFFmpeg > 0 && c.FFmpegQueueTimeout <= 0 {
+		problems = append(problems, fmt.Sprintf("FFMPEG_QUEUE_TIMEOUT must be positive, got %s", c.FFmpegQueueTimeout))
+	}
+	if c.CanonicalizeURLs != "off" && c.CanonicalizeURLs != "redirect" {
+		problems = append(problems, fmt.Sprintf("CANONICALIZE_URLS must be \"redirect\" or \"off\", got %q", c.CanonicalizeURLs))
+	}
+	if c.VideoDisabledStatus != 501 && c.VideoDisabledStatus != 400 {
+		problems = append(problems, fmt.Sprintf("VIDEO_DISABLED_STATUS must be 501 or 400, got %d", c.VideoDisabledStatus))
+	}
+	switch c.StatsBackend {
+	case "", "memory":
+	case "redis":
+		if c.RedisAddr == "" {
+			problems = append(problems, "STATS_BACKEND=redis requires REDIS_ADDR")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("STATS_BACKEND must be \"memory\" or \"redis\", got %q", c.StatsBackend))
+	}
+	if c.ErrorWebhookURL != "" {
+		if c.ErrorWebhookThreshold < 1 {
+			problems = append(problems, fmt.Sprintf("ERROR_WEBHOOK_THRESHOLD must be at least 1, got %d", c.ErrorWebhookThreshold))
+		}
+		if c.ErrorWebhookWindow <= 0 || c.ErrorWebhookInterval <= 0 {
+			problems = append(problems, "ERROR_WEBHOOK_WINDOW and ERROR_WEBHOOK_INTERVAL must be positive")
+		}
+	}
+	if c.ProcessedWebhookURL != "" && c.ProcessedWebhookTimeout <= 0 {
+		problems = append(problems, fmt.Sprintf("PROCESSED_WEBHOOK_TIMEOUT must be positive, got %s", c.ProcessedWebhookTimeout))
+	}
+	if c.ProcessedHookWorkers < 1 || c.ProcessedHookQueueSize < 1 {
+		problems = append(problems, "PROCESSED_HOOK_WORKERS and PROCESSED_HOOK_QUEUE_SIZE must be at least 1")
+	}
+	if c.MaxURLLifetime < 0 || c.ExpiresClockSkew < 0 {
+		problems = append(problems, "MAX_URL_LIFETIME and EXPIRES_CLOCK_SKEW must not be negative")
+	}
+	if c.OriginSlowThreshold < 0 || c.OriginReadRetries < 0 || c.OriginRetryBackoff < 0 {
+		problems = append(problems, "ORIGIN_SLOW_THRESHOLD, ORIGIN_READ_RETRIES and ORIGIN_RETRY_BACKOFF must not be negative")
+	}
+	if c.RefreshConcurrency < 1 || c.RefreshQueueSize < 1 {
+		problems = append(problems, "REFRESH_CONCURRENCY and REFRESH_QUEUE_SIZE must be at least 1")
+	}
+	if c.PlaceholderMaxDimension < 1 {
+		problems = append(problems, fmt.Sprintf("PLACEHOLDER_MAX_DIMENSION must be at least 1, got %d", c.PlaceholderMaxDimension))
+	}
+	if c.SelfTestInterval < 0 {
+		problems = append(problems, fmt.Sprintf("SELFTEST_INTERVAL must not be negative, got %s", c.SelfTestInterval))
+	}
+	if c.PurgePrefixMaxEntries < 1 {
+		problems = append(problems, fmt.Sprintf("PURGE_PREFIX_MAX_ENTRIES must be at least 1, got %d", c.PurgePrefixMaxEntries))
+	}
+	if c.ProcessRetries < 0 || c.ProcessRetries > 2 {
+		problems = append(problems, fmt.Sprintf("PROCESS_RETRIES must be between 0 and 2, got %d", c.ProcessRetries))
+	}
+	if c.ProcessRetryBackoff < 0 {
+		problems = append(problems, fmt.Sprintf("PROCESS_RETRY_BACKOFF must not be negative, got %s", c.ProcessRetryBackoff))
+	}
+	if c.HedgeAfter < 0 {
+		problems = append(problems, fmt.Sprintf("HEDGE_AFTER must not be negative, got %s", c.HedgeAfter))
+	}
+	if c.S3MaxIdleConns < 0 || c.S3MaxConnsPerHost < 0 || c.S3IdleConnTimeout < 0 {
+		problems = append(problems, "S3_MAX_IDLE_CONNS, S3_MAX_CONNS_PER_HOST and S3_IDLE_CONN_TIMEOUT must not be negative")
+	}
+	if c.S3BackgroundMaxConns < 1 {
+		problems = append(problems, fmt.Sprintf("S3_BACKGROUND_MAX_CONNS must be at least 1, got %d", c.S3BackgroundMaxConns))
+	}
+	if c.HedgeMaxPercent < 0 || c.HedgeMaxPercent > 100 {
+		problems = append(problems, fmt.Sprintf("HEDGE_MAX_PERCENT must be between 0 and 100, got %d", c.HedgeMaxPercent))
+	}
+	if len(c.Peers) > 0 && !slices.Contains(c.Peers, c.PeerSelf) {
+		problems = append(problems, fmt.Sprintf("PEER_SELF (%q) must be one of PEERS", c.PeerSelf))
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
 }
 
 // Helpers
+func getEnvPolicies(key string) (map[string]TransformPolicy, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return nil, nil
+	}
+	var m map[string]TransformPolicy
+	if err := json.Unmarshal([]byte(val), &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func getEnvDefaultImages(key string) ([]DefaultImageRule, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return nil, nil
+	}
+	var rules []DefaultImageRule
+	if err := json.Unmarshal([]byte(val), &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
 func getEnvMap(key string) map[string]string {
 	val := os.Getenv(key)
 	if val == "" {
@@ -167,6 +859,18 @@ func getEnvSlice(key string) []string {
 	return nil
 }
 
+// parseCIDRs parses a list of CIDRs, skipping invalid entries.
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err == nil {
+			nets = append(nets, ipNet)
+		}
+	}
+	return nets
+}
+
 func splitString(s string) []string {
 	// Simple split by comma
 	var result []string
@@ -200,6 +904,33 @@ func getEnvInt(key string, fallback int) int {
 	}
 	return fallback
 }
+func getEnvIntSlice(key string, fallback []int) []int {
+	values := getEnvSlice(key)
+	if len(values) == 0 {
+		return fallback
+	}
+	var result []int
+	for _, v := range values {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n > 0 {
+			result = append(result, n)
+		}
+	}
+	if len(result) == 0 {
+		return fallback
+	}
+	return result
+}
+
+// getEnvDuration parses Go durations such as "5m" or "24h".
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		val, err := time.ParseDuration(value)
+		if err == nil {
+			return val
+		}
+	}
+	return fallback
+}
 func getEnvFloat(key string, fallback float64) float64 {
 	if value, ok := os.LookupEnv(key); ok {
 		val, err := strconv.ParseFloat(value, 64)
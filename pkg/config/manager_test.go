@@ -0,0 +1,44 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestManagerReload(t *testing.T) {
+	t.Setenv("PORT", "8081")
+	// NewManagerFromEnvironment starts from what the caller read, even when
+	// the caller adjusted it
+	base := LoadConfig()
+	base.Port = "9000"
+
+	tests := []struct {
+		name     string
+		manager  *Manager
+		wantErr  error
+		wantPort string // after PORT changes and Reload
+	}{
+		{name: "programmatic", manager: NewManagerWithConfig(base), wantErr: ErrNoReloadSource, wantPort: "9000"},
+		{name: "environment", manager: NewManagerFromEnvironment(base), wantPort: "8082"},
+		{name: "environment at startup", manager: NewManager(), wantPort: "8082"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PORT", "8082")
+			err := tt.manager.Reload()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Reload() = %v, want %v", err, tt.wantErr)
+			}
+			if got := tt.manager.Get().Port; got != tt.wantPort {
+				t.Errorf("port %s after Reload, want %s", got, tt.wantPort)
+			}
+			wantGeneration := uint64(1)
+			if tt.wantErr != nil {
+				wantGeneration = 0
+			}
+			if got := tt.manager.Generation(); got != wantGeneration {
+				t.Errorf("generation %d, want %d", got, wantGeneration)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package costlog
+
+import (
+	"log/slog"
+	"math/rand"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// Entry is one sampled processing run.
+type Entry struct {
+	Time         time.Time          `json:"time"`
+	Key          string             `json:"key,omitempty"`
+	Pattern      string             `json:"pattern"`
+	Options      Options            `json:"options"`
+	SourceBytes  int                `json:"source_bytes"`
+	SourceWidth  int                `json:"source_width"`
+	SourceHeight int                `json:"source_height"`
+	OutputFormat string             `json:"output_format"`
+	OutputBytes  int                `json:"output_bytes"`
+	StagesMS     map[string]float64 `json:"stages_ms"`
+	TotalMS      float64            `json:"total_ms"`
+}
+
+// Options is the subset of the processing options that drives cost.
+type Options struct {
+	Width    int    `json:"w,omitempty"`
+	Height   int    `json:"h,omitempty"`
+	Fit      string `json:"fit,omitempty"`
+	Format   string `json:"format,omitempty"`
+	Quality  int    `json:"q,omitempty"`
+	Focus    string `json:"focus,omitempty"`
+	Effect   string `json:"effect,omitempty"`
+	Text     bool   `json:"text,omitempty"`
+	Blurhash bool   `json:"blurhash,omitempty"`
+	Smart    bool   `json:"smart,omitempty"`
+	Animated bool   `json:"animated,omitempty"`
+	Page     int    `json:"page,omitempty"`
+}
+
+// Log keeps the most recent sampled entries in a bounded ring.
+type Log struct {
+	rate      float64
+	anonymize bool
+	emit      bool
+
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+// New returns a cost log sampling the given fraction (0-1] of processing
+// runs. With anonymize set, object keys are never recorded, only a pattern
+// without file names; with emit set, every entry is also logged via slog.
+func New(rate float64, size int, anonymize, emit bool) *Log {
+	if size <= 0 {
+		size = 1
+	}
+	return &Log{
+		rate:      rate,
+		anonymize: anonymize,
+		emit:      emit,
+		entries:   make([]Entry, size),
+	}
+}
+
+// Sample reports whether the next processing run should be recorded.
+func (l *Log) Sample() bool {
+	return l != nil && l.rate > 0 && (l.rate >= 1 || rand.Float64() < l.rate)
+}
+
+// Record adds a processing run to the log.
+func (l *Log) Record(objectKey string, opts processor.ImageOptions, stats *processor.Stats) {
+	entry := Entry{
+		Time:         time.Now(),
+		Pattern:      Pattern(objectKey, l.anonymize),
+		Options:      optionsOf(opts),
+		SourceBytes:  stats.SourceBytes,
+		SourceWidth:  stats.SourceWidth,
+		SourceHeight: stats.SourceHeight,
+		OutputFormat: stats.OutputFormat,
+		OutputBytes:  stats.OutputBytes,
+		StagesMS:     make(map[string]float64, len(stats.Stages)),
+	}
+	if !l.anonymize {
+		entry.Key = objectKey
+	}
+	for _, st := range stats.Stages {
+		ms := float64(st.Duration.Microseconds()) / 1000
+		entry.StagesMS[st.Name] += ms
+		entry.TotalMS += ms
+	}
+
+	l.mu.Lock()
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+	l.mu.Unlock()
+
+	if l.emit {
+		slog.Info("Processing cost",
+			"key", entry.Key,
+			"pattern", entry.Pattern,
+			"options", entry.Options,
+			"source_bytes", entry.SourceBytes,
+			"source_width", entry.SourceWidth,
+			"source_height", entry.SourceHeight,
+			"output_format", entry.OutputFormat,
+			"output_bytes", entry.OutputBytes,
+			"stages_ms", entry.StagesMS,
+			"total_ms", entry.TotalMS,
+		)
+	}
+}
+
+// Entries returns the recorded entries, oldest first.
+func (l *Log) Entries() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.full {
+		return append([]Entry(nil), l.entries[:l.next]...)
+	}
+	out := make([]Entry, 0, len(l.entries))
+	out = append(out, l.entries[l.next:]...)
+	return append(out, l.entries[:l.next]...)
+}
+
+var numericRun = regexp.MustCompile(`[0-9]+`)
+
+// Pattern templates an object key so that keys differing only in IDs group
+// together: path segments containing digits become "{id}". With anonymize,
+// the file name is replaced by "*" as well and only its extension is kept.
+func Pattern(objectKey string, anonymize bool) string {
+	dir, file := path.Split(objectKey)
+
+	var segments []string
+	for _, seg := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if seg == "" {
+			continue
+		}
+		if numericRun.MatchString(seg) {
+			seg = "{id}"
+		}
+		segments = append(segments, seg)
+	}
+
+	ext := path.Ext(file)
+	name := strings.TrimSuffix(file, ext)
+	switch {
+	case anonymize:
+		name = "*"
+	case numericRun.MatchString(name):
+		name = "{id}"
+	}
+	segments = append(segments, name+strings.ToLower(ext))
+	return strings.Join(segments, "/")
+}
+
+func optionsOf(opts processor.ImageOptions) Options {
+	return Options{
+		Width:    opts.Width,
+		Height:   opts.Height,
+		Fit:      opts.Fit,
+		Format:   opts.Format,
+		Quality:  opts.Quality,
+		Focus:    opts.Focus,
+		Effect:   opts.Effect,
+		Text:     opts.Text != "",
+		Blurhash: opts.Blurhash,
+		Smart:    opts.SmartCompression,
+		Animated: opts.Animated,
+		Page:     opts.Page,
+	}
+}
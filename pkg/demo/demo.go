@@ -0,0 +1,121 @@
+// Package demo bundles a few sample images and an index page, so quirm can
+// be tried out without an S3 bucket.
+package demo
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+
+	"github.com/CodeTease/quirm/pkg/storage"
+)
+
+//go:embed samples
+var samples embed.FS
+
+// Prefix is the key prefix the sample images are stored under.
+const Prefix = "samples/"
+
+// Example is a transformation shown for every sample on the index page.
+type Example struct {
+	Name  string
+	Query template.URL
+}
+
+// Examples lists the transformations linked from the index page.
+var Examples = []Example{
+	{"Resize", "w=400"},
+	{"Cover crop", "w=300&h=300&fit=cover"},
+	{"WebP", "w=400&format=webp"},
+	{"AVIF", "w=400&format=avif"},
+	{"Grayscale", "w=400&effect=grayscale"},
+	{"Sepia", "w=400&effect=sepia"},
+	{"Palette", "palette=true"},
+	{"BlurHash", "blurhash=true"},
+}
+
+// Storage returns an in-memory storage provider holding the sample images.
+func Storage() (*storage.MemoryProvider, error) {
+	provider := storage.NewMemoryProvider()
+	keys, err := Keys()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		data, err := samples.ReadFile(key)
+		if err != nil {
+			return nil, err
+		}
+		provider.Put(key, data, mime.TypeByExtension(path.Ext(key)))
+	}
+	return provider, nil
+}
+
+// Keys returns the object keys of the sample images.
+func Keys() ([]string, error) {
+	entries, err := fs.ReadDir(samples, "samples")
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			keys = append(keys, Prefix+entry.Name())
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Quirm demo</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+section { margin-bottom: 2em; }
+img { max-width: 240px; display: block; margin-bottom: .5em; }
+ul { padding-left: 1.2em; }
+code { background: #f3f3f3; padding: 0 .3em; }
+</style>
+</head>
+<body>
+<h1>Quirm demo</h1>
+<p>These images are served from memory through the regular request pipeline. Edit the query strings to try other options.</p>
+{{range .Keys}}
+<section>
+<h2><code>/{{.}}</code></h2>
+<a href="/{{.}}"><img src="/{{.}}?w=240" alt="{{.}}"></a>
+<ul>
+{{$key := .}}{{range $.Examples}}<li><a href="/{{$key}}?{{.Query}}">{{.Name}}</a> <code>?{{.Query}}</code></li>
+{{end}}</ul>
+</section>
+{{end}}
+</body>
+</html>
+`))
+
+// HandleIndex renders a page listing the samples with example transformations.
+func HandleIndex(w http.ResponseWriter, r *http.Request) {
+	keys, err := Keys()
+	if err != nil {
+		slog.Error("Failed to list demo samples", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = indexTemplate.Execute(w, struct {
+		Keys     []string
+		Examples []Example
+	}{keys, Examples})
+	if err != nil {
+		slog.Error("Failed to render demo index", "error", err)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/config"
+)
+
+// requireAdmin guards administrative endpoints. A request is allowed when it
+// carries the configured ADMIN_TOKEN as a bearer token, or when it comes from
+// one of the trusted CIDRs. It writes the error response and returns false
+// otherwise.
+func (h *Handler) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	cfg := h.ConfigManager.Get()
+
+	if hasAdminToken(cfg, r) {
+		return true
+	}
+
+	if isTrustedIP(cfg, clientAddr(cfg, r)) {
+		return true
+	}
+
+	if cfg.AdminToken == "" && len(cfg.AllowedCIDRNets) == 0 {
+		http.Error(w, "Admin endpoints are disabled", http.StatusForbidden)
+		return false
+	}
+
+	w.Header().Set("WWW-Authenticate", `Bearer realm="quirm"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+// hasAdminToken reports whether r carries the configured ADMIN_TOKEN.
+func hasAdminToken(cfg config.Config, r *http.Request) bool {
+	if cfg.AdminToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) == 1
+}
+
+// isTrustedIP reports whether remoteAddr falls inside one of the allowed CIDRs.
+func isTrustedIP(cfg config.Config, remoteAddr string) bool {
+	return trustedNet(cfg, remoteAddr) != nil
+}
+
+// trustedNet returns the first allowed CIDR containing remoteAddr, or nil.
+func trustedNet(cfg config.Config, remoteAddr string) *net.IPNet {
+	addr, ok := clientIP(remoteAddr)
+	if !ok {
+		return nil
+	}
+	parsedIP := net.IP(addr.AsSlice())
+	for _, ipNet := range cfg.AllowedCIDRNets {
+		if ipNet.Contains(parsedIP) {
+			return ipNet
+		}
+	}
+	return nil
+}
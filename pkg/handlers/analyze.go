@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+	"github.com/CodeTease/quirm/pkg/storage"
+)
+
+// maxAnalyzeKeys bounds the keys of one /_analyze request.
+const maxAnalyzeKeys = 1000
+
+type analyzeRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// analyzeResult is one NDJSON line of /_analyze: the analysis of a key, or
+// the error that prevented it.
+type analyzeResult struct {
+	Key        string   `json:"key"`
+	Error      string   `json:"error,omitempty"`
+	Format     string   `json:"format,omitempty"`
+	Width      int      `json:"width,omitempty"`
+	Height     int      `json:"height,omitempty"`
+	Pages      int      `json:"pages,omitempty"`
+	Colors     []string `json:"colors,omitempty"`
+	Blurhash   string   `json:"blurhash,omitempty"`
+	Brightness *float64 `json:"brightness,omitempty"`
+}
+
+// HandleAnalyze reports the format, dimensions, dominant colors, blurhash
+// and average brightness of a batch of images (POST /_analyze, admin only),
+// for ingestion pipelines. The body is {"keys": ["images/a.jpg", ...]}.
+//
+// Each key is fetched and decoded once, ANALYZE_CONCURRENCY at a time, and
+// the ?palette=true and ?blurhash=true caches are filled on the way. Results
+// are streamed as NDJSON, one line per key in completion order; a key that
+// fails gets an "error" line and does not affect the others.
+func (h *Handler) HandleAnalyze(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+
+	var req analyzeRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if len(req.Keys) == 0 {
+		http.Error(w, "No keys given", http.StatusBadRequest)
+		return
+	}
+	if len(req.Keys) > maxAnalyzeKeys {
+		http.Error(w, fmt.Sprintf("At most %d keys per request", maxAnalyzeKeys), http.StatusBadRequest)
+		return
+	}
+
+	cfg := h.ConfigManager.Get()
+	ctx := storage.WithBackground(r.Context())
+
+	results := make(chan analyzeResult)
+	go func() {
+		sem := make(chan struct{}, max(cfg.AnalyzeConcurrency, 1))
+		var wg sync.WaitGroup
+		for _, key := range req.Keys {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				results <- h.analyzeKey(ctx, cfg, key)
+			}(key)
+		}
+		wg.Wait()
+		close(results)
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	// A client that went away cancels ctx, which makes the remaining keys
+	// fail fast; results are still drained so every worker can finish
+	for res := range results {
+		enc.Encode(res)
+		rc.Flush()
+	}
+}
+
+// analyzeKey fetches and analyzes one key of an /_analyze batch.
+func (h *Handler) analyzeKey(ctx context.Context, cfg config.Config, key string) analyzeResult {
+	objectKey, _, ok := requestTarget(cfg, cleanPath(key))
+	if !ok || filterKey(cfg, objectKey) != "" {
+		return analyzeResult{Key: key, Error: "invalid key"}
+	}
+	res := analyzeResult{Key: objectKey}
+	if !isImageFile(objectKey) {
+		res.Error = "not an image"
+		return res
+	}
+
+	reader, info, err := h.S3.GetObjectIfNoneMatch(ctx, objectKey, "")
+	if err != nil {
+		res.Error = analyzeError(err)
+		return res
+	}
+	defer reader.Close()
+	if cfg.MaxImageSizeMB > 0 && info.Size > cfg.MaxImageSizeMB*1024*1024 {
+		res.Error = (&FileSizeError{MaxSizeMB: cfg.MaxImageSizeMB}).Error()
+		return res
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		res.Error = analyzeError(err)
+		return res
+	}
+
+	analysis, err := processor.Analyze(data)
+	if err != nil {
+		slog.Warn("Image analysis failed", "objectKey", objectKey, "error", err)
+		res.Error = err.Error()
+		return res
+	}
+	h.storeAnalysis(ctx, cfg, objectKey, info, analysis)
+
+	res.Format, res.Width, res.Height, res.Pages = analysis.Format, analysis.Width, analysis.Height, analysis.Pages
+	res.Colors, res.Blurhash, res.Brightness = analysis.Colors, analysis.Blurhash, &analysis.Brightness
+	return res
+}
+
+// analyzeError is the message of an origin error in /_analyze results.
+func analyzeError(err error) string {
+	if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "404") {
+		return "not found"
+	}
+	var kmsErr *storage.KMSAccessError
+	if errors.As(err, &kmsErr) {
+		return "origin denied access"
+	}
+	return err.Error()
+}
+
+// storeAnalysis caches the palette and blurhash of objectKey as
+// ?palette=true and ?blurhash=true would. The blurhash is only stored when
+// no watermark applies, as a watermarked image hashes differently.
+func (h *Handler) storeAnalysis(ctx context.Context, cfg config.Config, objectKey string, info storage.ObjectInfo, analysis processor.Analysis) {
+	if h.Cache != nil {
+		if doc, err := paletteDocument(analysis.Colors); err == nil {
+			h.Cache.Set(ctx, cache.GenerateKeyProcessed(objectKey, url.Values{"palette": {"true"}}, "json"), doc, cfg.TextCacheTTL)
+		}
+	}
+
+	v := h.resolveVariant(cfg, objectKey, "", url.Values{"blurhash": {"true"}}, http.Header{})
+	opts := v.opts
+	if cfg.HonorObjectMetadata {
+		opts = applyObjectHints(opts, info.Metadata)
+	}
+	if wmImg, _, _ := h.WM.Get(); wmImg != nil && !opts.NoWatermark {
+		return
+	}
+
+	hash := []byte(analysis.Blurhash)
+	cachePath := cache.GetCachePath(h.CacheDir, v.cacheKey)
+	if err := h.saveProcessed(cachePath, hash); err != nil {
+		slog.Warn("Failed to cache blurhash", "objectKey", objectKey, "error", err)
+		return
+	}
+	if !h.Disk.Degraded() && !h.memoryOnly(hash) {
+		if err := cache.WriteMeta(cachePath, cache.Meta{ETag: info.ETag, LastModified: info.LastModified, Metadata: info.Metadata, ObjectKey: objectKey}); err != nil {
+			slog.Warn("Failed to write cache metadata", "path", cachePath, "error", err)
+		}
+	}
+	if h.Cache != nil {
+		h.Cache.Set(ctx, v.cacheKey, hash, cfg.TextCacheTTL)
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// clampAnimated caps the size of an animated output (video animated
+// thumbnails, GIFs converted to video) at MAX_ANIMATED_WIDTH and
+// MAX_ANIMATED_HEIGHT. Requested sizes above the caps are scaled down,
+// keeping their aspect ratio, and written back to params so that every
+// oversized request shares one cache entry. The caps also bound outputs
+// whose size follows the source. It returns a description of the clamp for
+// the X-Quirm-Clamped header, or "" when the request was within the caps.
+func clampAnimated(cfg config.Config, opts *processor.ImageOptions, params url.Values) string {
+	maxW, maxH := cfg.MaxAnimatedWidth, cfg.MaxAnimatedHeight
+	opts.MaxWidth = minPositive(opts.MaxWidth, maxW)
+	opts.MaxHeight = minPositive(opts.MaxHeight, maxH)
+
+	w, h := opts.Width, opts.Height
+	scale := 1.0
+	if maxW > 0 && w > maxW {
+		scale = float64(maxW) / float64(w)
+	}
+	if maxH > 0 && h > maxH {
+		scale = min(scale, float64(maxH)/float64(h))
+	}
+	if scale == 1 {
+		return ""
+	}
+
+	if w > 0 {
+		opts.Width = max(1, int(float64(w)*scale))
+		params.Set("w", strconv.Itoa(opts.Width))
+	}
+	if h > 0 {
+		opts.Height = max(1, int(float64(h)*scale))
+		params.Set("h", strconv.Itoa(opts.Height))
+	}
+	return fmt.Sprintf("requested=%dx%d, served=%dx%d", w, h, opts.Width, opts.Height)
+}
+
+// minPositive returns the smaller of a and b, ignoring zeros (no limit).
+func minPositive(a, b int) int {
+	if a <= 0 {
+		return b
+	}
+	if b <= 0 {
+		return a
+	}
+	return min(a, b)
+}
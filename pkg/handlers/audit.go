@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/CodeTease/quirm/pkg/audit"
+	"github.com/CodeTease/quirm/pkg/config"
+)
+
+// auditParams are dropped from audited parameters: they are credentials.
+var auditParams = []string{"s", "token"}
+
+// audit records a privileged operation on objectKey before it runs.
+func (h *Handler) audit(r *http.Request, action, objectKey string, params url.Values) {
+	if h.Audit == nil {
+		return
+	}
+	cfg := h.ConfigManager.Get()
+	h.Audit.Record(audit.Event{
+		Action:   action,
+		ClientIP: auditClientIP(cfg, r),
+		Actor:    auditActor(cfg, r),
+		Key:      objectKey,
+		Params:   redactParams(params).Encode(),
+	})
+}
+
+// auditWarmup records a warmup request for the given URLs.
+func (h *Handler) auditWarmup(r *http.Request, urls []string) {
+	if h.Audit == nil {
+		return
+	}
+	cfg := h.ConfigManager.Get()
+	targets := make([]string, 0, len(urls))
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			targets = append(targets, "(invalid url)")
+			continue
+		}
+		u.User = nil
+		u.RawQuery = redactParams(u.Query()).Encode()
+		targets = append(targets, u.String())
+	}
+	h.Audit.Record(audit.Event{
+		Action:   audit.Warmup,
+		ClientIP: auditClientIP(cfg, r),
+		Actor:    auditActor(cfg, r),
+		Targets:  targets,
+	})
+}
+
+// auditClientIP is the full client address; unlike clientKey, IPv6 clients
+// are not aggregated to their network.
+func auditClientIP(cfg config.Config, r *http.Request) string {
+	remoteAddr := clientAddr(cfg, r)
+	if addr, ok := clientIP(remoteAddr); ok {
+		return addr.String()
+	}
+	return remoteAddr
+}
+
+type watermarkBypassKey struct{}
+
+// withWatermarkBypassAudit returns a context whose builds call record when
+// they leave the watermark out because of the object's no-watermark
+// metadata, which is only known once the source has been fetched.
+func withWatermarkBypassAudit(ctx context.Context, record func()) context.Context {
+	return context.WithValue(ctx, watermarkBypassKey{}, record)
+}
+
+// auditWatermarkBypass records a watermark bypass of the build running in ctx.
+func auditWatermarkBypass(ctx context.Context) {
+	if record, ok := ctx.Value(watermarkBypassKey{}).(func()); ok {
+		record()
+	}
+}
+
+// auditActor names how r was authorized, without revealing the credential.
+func auditActor(cfg config.Config, r *http.Request) string {
+	if hasAdminToken(cfg, r) {
+		return "admin_token"
+	}
+	if ipNet := trustedNet(cfg, clientAddr(cfg, r)); ipNet != nil {
+		return "cidr:" + ipNet.String()
+	}
+	if r.Context().Value(tokenAuthKey{}) != nil {
+		return "token"
+	}
+	if cfg.SecretKey != "" && r.URL.Query().Has("s") {
+		return "signature"
+	}
+	return "anonymous"
+}
+
+func redactParams(params url.Values) url.Values {
+	out := make(url.Values, len(params))
+	for k, v := range params {
+		out[k] = v
+	}
+	for _, k := range auditParams {
+		out.Del(k)
+	}
+	return out
+}
+
+// HandleAuditRecent lists the most recent audit events (GET /_audit/recent, admin only).
+func (h *Handler) HandleAuditRecent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	if h.Audit == nil {
+		http.Error(w, "Audit log is not enabled", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"events": h.Audit.Recent()})
+}
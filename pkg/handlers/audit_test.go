@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/CodeTease/quirm/pkg/audit"
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/sign"
+	"github.com/CodeTease/quirm/pkg/storage"
+	"github.com/CodeTease/quirm/pkg/warmup"
+	"github.com/CodeTease/quirm/pkg/watermark"
+)
+
+// testPNG returns a plain white PNG of the given size.
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// unreadableStorage serves object bodies that fail on the first read, so a
+// build stops right after it has fetched the source and its metadata.
+type unreadableStorage struct {
+	*storage.MemoryProvider
+}
+
+func (s unreadableStorage) GetObjectIfNoneMatch(ctx context.Context, key, etag string) (io.ReadCloser, storage.ObjectInfo, error) {
+	body, info, err := s.MemoryProvider.GetObjectIfNoneMatch(ctx, key, etag)
+	if err != nil {
+		return nil, info, err
+	}
+	body.Close()
+	return io.NopCloser(iotest.ErrReader(io.ErrUnexpectedEOF)), info, nil
+}
+
+// TestAuditPrivilegedPaths sends one request down every audited path and
+// expects exactly one record of its action, without credentials.
+func TestAuditPrivilegedPaths(t *testing.T) {
+	const adminToken, secret = "admin-secret", "sign-secret"
+	dir := t.TempDir()
+	wmPath := filepath.Join(t.TempDir(), "wm.png")
+	if err := os.WriteFile(wmPath, testPNG(t, 8, 8), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	objects := storage.NewMemoryProvider()
+	objects.Put("photos/a.png", testPNG(t, 40, 40), "image/png")
+	if err := objects.SetMetadata("photos/a.png", map[string]string{"no-watermark": "true"}); err != nil {
+		t.Fatal(err)
+	}
+
+	auditLog := audit.New(slog.New(slog.NewTextHandler(io.Discard, nil)), 100)
+	h := &Handler{
+		ConfigManager: config.NewManagerWithConfig(config.Config{
+			AdminToken:          adminToken,
+			SecretKey:           secret,
+			CacheTTL:            time.Hour,
+			WatermarkPath:       wmPath,
+			HonorObjectMetadata: true,
+		}),
+		S3:       unreadableStorage{objects},
+		WM:       watermark.NewManager(wmPath, 0.5, false),
+		Group:    &singleflight.Group{},
+		CacheDir: dir,
+		Cache:    newMapCache(),
+		Limiter:  &quotaLimiter{quota: 10, used: map[string]int{}},
+		Audit:    auditLog,
+	}
+	h.Warmup = warmup.NewQueue(func(ctx context.Context, target string, header http.Header) error { return nil }, 1, 10, 10, time.Minute, time.Minute)
+	admin := http.Header{"Authorization": {"Bearer " + adminToken}}
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		header  http.Header
+		body    string
+		handler http.HandlerFunc
+		action  string
+		key     string
+		actor   string
+	}{
+		{name: "purge", method: http.MethodDelete, target: "/photos/a.png?w=300", header: admin, handler: h.serveAsset, action: audit.Purge, key: "photos/a.png", actor: "admin_token"},
+		{name: "prefix purge", method: http.MethodDelete, target: "/photos/?prefix=true", header: admin, handler: h.serveAsset, action: audit.Purge, key: "photos/", actor: "admin_token"},
+		{name: "original", target: sign.SignURL(secret, "/photos/a.png", url.Values{"original": {"true"}}, time.Time{}), handler: h.serveAsset, action: audit.OriginalAccess, key: "photos/a.png", actor: "signature"},
+		{name: "watermark bypass", target: sign.SignURL(secret, "/photos/a.png", url.Values{"w": {"20"}}, time.Time{}), handler: h.serveAsset, action: audit.WatermarkBypass, key: "photos/a.png", actor: "signature"},
+		{name: "cache flush", method: http.MethodPost, target: "/_admin/cache/flush", header: admin, handler: h.HandleCacheFlush, action: audit.CacheFlush, actor: "admin_token"},
+		{name: "rate limit reset", method: http.MethodPost, target: "/_admin/ratelimit/reset?key=192.0.2.9", header: admin, handler: h.HandleRateLimitReset, action: audit.RateLimitReset, actor: "admin_token"},
+		{name: "warmup", method: http.MethodPost, target: "/warmup", header: admin, body: `{"urls": ["/photos/a.png?w=300&s=secret-sig"]}`, handler: h.HandleWarmup, action: audit.Warmup, actor: "admin_token"},
+		{name: "plain request", target: "/photos/a.png", handler: h.serveAsset},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, tt.target, strings.NewReader(tt.body))
+			r.RemoteAddr = "192.0.2.1:4000"
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			before := len(auditLog.Recent())
+			tt.handler(httptest.NewRecorder(), r)
+
+			events := auditLog.Recent()[before:]
+			if tt.action == "" {
+				if len(events) != 0 {
+					t.Fatalf("audited %+v, want nothing", events)
+				}
+				return
+			}
+			if len(events) != 1 {
+				t.Fatalf("%d audit records %+v, want one", len(events), events)
+			}
+			e := events[0]
+			if e.Action != tt.action || e.Key != tt.key || e.Actor != tt.actor || e.ClientIP != "192.0.2.1" {
+				t.Errorf("audited %+v, want action %s, key %q, actor %s from 192.0.2.1", e, tt.action, tt.key, tt.actor)
+			}
+			recorded := e.Params + strings.Join(e.Targets, " ")
+			for _, secretValue := range []string{adminToken, secret, "s="} {
+				if strings.Contains(recorded, secretValue) {
+					t.Errorf("audit record %+v contains %q", e, secretValue)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/audit"
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/metrics"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// acceptsMultipart reports whether the client accepts multipart/mixed
+// responses.
+func acceptsMultipart(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(mediaType), "multipart/mixed") {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// lqipKey is the cache key of the placeholder that goes with a variant.
+func lqipKey(cacheKey string) string {
+	return cacheKey + "-lqip"
+}
+
+// serveBundle answers ?bundle=lqip with a multipart/mixed response holding a
+// tiny WebP placeholder followed by the processed image, so a page can
+// inline the first part and swap in the second.
+func (h *Handler) serveBundle(w http.ResponseWriter, r *http.Request, objectKey string, v variant) {
+	etag := `"` + lqipKey(v.cacheKey) + `"`
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	src := &sourceObject{}
+	ctx := withWatermarkBypassAudit(withSourceObject(r.Context(), src), func() {
+		h.audit(r, audit.WatermarkBypass, objectKey, r.URL.Query())
+	})
+	image, lqip, err := h.bundleParts(ctx, objectKey, v)
+	if err != nil {
+		if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "404") {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		if writeOriginAccessError(w, objectKey, err) {
+			return
+		}
+		slog.Error("Bundle processing failed", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.Header().Set("ETag", etag)
+	setCacheControl(w)
+	frames := src.frames
+	if frames == 0 {
+		frames = h.recordedFrames(v)
+	}
+	setStaticHeader(w, v, frames)
+
+	parts := []struct {
+		name, contentType string
+		data              []byte
+	}{
+		{"lqip", "image/webp", lqip},
+		{"image", processedContentType(image, objectKey, v.opts), image},
+	}
+	for _, p := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", p.contentType)
+		header.Set("Content-Length", strconv.Itoa(len(p.data)))
+		header.Set("Content-Disposition", `inline; name="`+p.name+`"`)
+		pw, err := mw.CreatePart(header)
+		if err != nil {
+			return
+		}
+		if _, err := pw.Write(p.data); err != nil {
+			return
+		}
+	}
+	mw.Close()
+}
+
+// bundleParts returns the processed image and its placeholder, each cached
+// under its own key. Both are built from a single decode on a miss.
+func (h *Handler) bundleParts(ctx context.Context, objectKey string, v variant) ([]byte, []byte, error) {
+	cfg := h.ConfigManager.Get()
+	imagePath := cache.GetCachePath(h.CacheDir, v.cacheKey)
+	lqipPath := cache.GetCachePath(h.CacheDir, lqipKey(v.cacheKey))
+
+	load := func(key, path string) []byte {
+		if h.Cache != nil {
+			if data, found := h.Cache.Get(ctx, key); found {
+				return data
+			}
+		}
+		if isFresh(path, cfg.CacheTTL) {
+			if data, err := os.ReadFile(path); err == nil {
+				return data
+			}
+		}
+		return nil
+	}
+
+	image, lqip := load(v.cacheKey, imagePath), load(lqipKey(v.cacheKey), lqipPath)
+	if image != nil && lqip != nil {
+		metrics.CacheOpsTotal.WithLabelValues("hit_cache").Inc()
+		return image, lqip, nil
+	}
+
+	metrics.CacheOpsTotal.WithLabelValues("miss").Inc()
+	result, err, _ := h.Group.Do(lqipKey(v.cacheKey), func() (interface{}, error) {
+		return h.processBundleAndSave(ctx, objectKey, imagePath, lqipPath, v)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	parts := result.([2][]byte)
+	return parts[0], parts[1], nil
+}
+
+func (h *Handler) processBundleAndSave(ctx context.Context, objectKey, imagePath, lqipPath string, v variant) ([2][]byte, error) {
+	cfg := h.ConfigManager.Get()
+	opts := v.opts
+
+	// Reject oversized originals before any bytes are transferred
+	if cfg.MaxImageSizeMB > 0 {
+		info, err := h.S3.StatObject(ctx, objectKey)
+		if err != nil {
+			return [2][]byte{}, err
+		}
+		if info.Size > cfg.MaxImageSizeMB*1024*1024 {
+			return [2][]byte{}, &FileSizeError{MaxSizeMB: cfg.MaxImageSizeMB}
+		}
+	}
+
+	// The download carries the object's version and metadata
+	reader, info, err := h.S3.GetObjectIfNoneMatch(ctx, objectKey, "")
+	if err != nil {
+		return [2][]byte{}, err
+	}
+	defer reader.Close()
+	if cfg.HonorObjectMetadata {
+		opts = applyObjectHints(opts, info.Metadata)
+	}
+
+	// The object may have changed since it was stat'ed
+	if cfg.MaxImageSizeMB > 0 && info.Size > cfg.MaxImageSizeMB*1024*1024 {
+		return [2][]byte{}, &FileSizeError{MaxSizeMB: cfg.MaxImageSizeMB}
+	}
+
+	wmImg, wmOpacity, err := h.WM.Get()
+	if err != nil {
+		slog.Warn("Error loading watermark", "error", err)
+	}
+	if opts.NoWatermark && wmImg != nil {
+		auditWatermarkBypass(ctx)
+		wmImg = nil
+	}
+
+	var stats *processor.Stats
+	if opts.Static {
+		stats = &processor.Stats{}
+		ctx = processor.WithStats(ctx, stats)
+	}
+	buf, lqipBuf, err := processor.ProcessBundle(ctx, reader, opts, wmImg, wmOpacity, objectKey)
+	if err != nil {
+		return [2][]byte{}, err
+	}
+	recordFrames(ctx, stats)
+	image, lqip := buf.Bytes(), lqipBuf.Bytes()
+
+	if err := errors.Join(h.saveProcessed(imagePath, image), h.saveProcessed(lqipPath, lqip)); err != nil {
+		return [2][]byte{}, err
+	}
+	// The image is also served on its own, through the sidecar like any
+	// other variant
+	if !h.Disk.Degraded() && !h.memoryOnly(image) {
+		meta := cache.Meta{ETag: info.ETag, LastModified: info.LastModified, Metadata: info.Metadata, ObjectKey: objectKey,
+			ContentType: processedContentType(image, objectKey, opts)}
+		if stats != nil {
+			meta.SourceFrames = stats.SourceFrames
+		}
+		if err := cache.WriteMeta(imagePath, meta); err != nil {
+			slog.Warn("Failed to write cache metadata", "path", imagePath, "error", err)
+		}
+	}
+	if h.Cache != nil {
+		h.Cache.Set(ctx, v.cacheKey, image, 0)
+		h.Cache.Set(ctx, lqipKey(v.cacheKey), lqip, 0)
+	}
+	return [2][]byte{image, lqip}, nil
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/storage"
+	"github.com/CodeTease/quirm/pkg/watermark"
+)
+
+// statCounter counts the stats and downloads made through it.
+type statCounter struct {
+	fetchCounter
+	stats atomic.Int32
+}
+
+func (s *statCounter) StatObject(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	s.stats.Add(1)
+	return s.fetchCounter.StatObject(ctx, key)
+}
+
+// TestBundleFetch reads the source of a bundle with a single request, and
+// stats it first only to enforce MAX_IMAGE_SIZE_MB.
+func TestBundleFetch(t *testing.T) {
+	objects := storage.NewMemoryProvider()
+	objects.Put("photos/small.png", testPNG(t, 40, 40), "image/png")
+	objects.Put("photos/large.png", bytes.Repeat([]byte{0x42}, 2<<20), "image/png")
+	tests := []struct {
+		name      string
+		object    string
+		maxSizeMB int64
+		wantStats int32
+		wantGets  int32
+		tooLarge  bool
+	}{
+		{name: "no size limit", object: "photos/small.png", wantGets: 1},
+		{name: "under the size limit", object: "photos/small.png", maxSizeMB: 1, wantStats: 1, wantGets: 1},
+		{name: "over the size limit", object: "photos/large.png", maxSizeMB: 1, wantStats: 1, tooLarge: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := config.Config{MaxImageSizeMB: tt.maxSizeMB}
+			origin := &statCounter{fetchCounter: fetchCounter{StorageProvider: unreadableStorage{objects}}}
+			h := &Handler{
+				ConfigManager: config.NewManagerWithConfig(cfg),
+				S3:            origin,
+				WM:            watermark.NewManager("", 0.5, false),
+				CacheDir:      dir,
+			}
+			params, _ := url.ParseQuery("w=100&bundle=lqip")
+			v := h.resolveVariant(cfg, tt.object, "", params, http.Header{})
+
+			// The build stops once the source is fetched
+			_, err := h.processBundleAndSave(context.Background(), tt.object, dir+"/image", dir+"/lqip", v)
+			var sizeErr *FileSizeError
+			if errors.As(err, &sizeErr) != tt.tooLarge {
+				t.Errorf("error %v, want a size error %v", err, tt.tooLarge)
+			}
+			if n := origin.stats.Load(); n != tt.wantStats {
+				t.Errorf("%d stats, want %d", n, tt.wantStats)
+			}
+			if n := origin.fetches.Load(); n != tt.wantGets {
+				t.Errorf("%d downloads, want %d", n, tt.wantGets)
+			}
+		})
+	}
+}
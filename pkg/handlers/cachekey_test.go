@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+)
+
+// mapCache is a synchronous cache.CacheProvider for tests.
+type mapCache struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+}
+
+func newMapCache() *mapCache {
+	return &mapCache{entries: map[string][]byte{}}
+}
+
+func (c *mapCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.entries[key]
+	return data, ok
+}
+
+func (c *mapCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = value
+	return nil
+}
+
+func (c *mapCache) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+	return nil
+}
+
+func (c *mapCache) Health(ctx context.Context) error { return nil }
+
+func (c *mapCache) Flush(ctx context.Context, shared bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+	return nil
+}
+
+func TestCacheKeyEquivalence(t *testing.T) {
+	h := &Handler{}
+	cfg := config.Config{}
+	key := func(query string) string {
+		params, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h.resolveVariant(cfg, "photos/a.jpg", "", params, http.Header{}).cacheKey
+	}
+
+	tests := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{name: "param order", a: "w=300&h=200&q=70", b: "q=70&h=200&w=300", same: true},
+		{name: "fit omitted", a: "w=300&h=200", b: "w=300&h=200&fit=contain", same: true},
+		{name: "fit case", a: "w=300&h=200&fit=COVER", b: "w=300&h=200&fit=cover", same: true},
+		{name: "format case", a: "w=300&format=WEBP", b: "w=300&format=webp", same: true},
+		{name: "focus case", a: "w=300&h=300&fit=cover&focus=Smart", b: "w=300&h=300&fit=cover&focus=smart", same: true},
+		{name: "repeated value", a: "w=300&w=500", b: "w=300", same: true},
+		{name: "fit cover", a: "w=300&h=200", b: "w=300&h=200&fit=cover"},
+		{name: "width", a: "w=300", b: "w=301"},
+		{name: "format", a: "w=300&format=webp", b: "w=300&format=png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := key(tt.a), key(tt.b)
+			if (a == b) != tt.same {
+				t.Errorf("%s: key %q, %s: key %q, want same %v", tt.a, a, tt.b, b, tt.same)
+			}
+		})
+	}
+}
+
+// TestLegacyFallback finds entries written under the raw parameter key and
+// adopts them under the canonical one.
+func TestLegacyFallback(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	memory := newMapCache()
+	h := &Handler{Cache: memory, CacheDir: dir}
+	params := url.Values{"h": {"200"}, "w": {"300"}, "fit": {"COVER"}}
+	v := h.resolveVariant(config.Config{LegacyCacheKeys: true}, "photos/a.jpg", "", params, http.Header{})
+	if v.legacyKey == "" || v.legacyKey == v.cacheKey {
+		t.Fatalf("legacy key %q, cache key %q: want distinct keys", v.legacyKey, v.cacheKey)
+	}
+
+	if _, found := h.legacyCached(ctx, memory.Get, v); found {
+		t.Fatal("found an entry in an empty cache")
+	}
+	memory.Set(ctx, v.legacyKey, []byte("variant"), 0)
+	if data, found := h.legacyCached(ctx, memory.Get, v); !found || string(data) != "variant" {
+		t.Fatalf("legacyCached() = %q, %v, want the legacy entry", data, found)
+	}
+	if data, found := memory.Get(ctx, v.cacheKey); !found || string(data) != "variant" {
+		t.Errorf("entry not copied to its canonical key")
+	}
+
+	cachePath := cache.GetCachePath(dir, v.cacheKey)
+	if h.adoptLegacyFile(cachePath, v) {
+		t.Fatal("adopted a missing legacy file")
+	}
+	legacyPath := cache.GetCachePath(dir, v.legacyKey)
+	if err := os.MkdirAll(filepath.Dir(legacyPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(legacyPath, []byte("variant"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !h.adoptLegacyFile(cachePath, v) {
+		t.Fatal("legacy file not adopted")
+	}
+	if data, err := os.ReadFile(cachePath); err != nil || string(data) != "variant" {
+		t.Errorf("canonical entry = %q, %v, want the legacy file", data, err)
+	}
+
+	// Without the fallback there is no legacy key to look up
+	if v := h.resolveVariant(config.Config{}, "photos/a.jpg", "", params, http.Header{}); v.legacyKey != "" {
+		t.Errorf("legacy key %q with the fallback disabled", v.legacyKey)
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+	"github.com/CodeTease/quirm/pkg/sign"
+)
+
+// withCanonicalURLs answers GET and HEAD requests whose query string is not
+// in canonical form (see sign.Canonical) with a 301 to the canonical URL when
+// CANONICALIZE_URLS=redirect, so CDNs in front of quirm cache one URL per
+// variant. With SECRET_KEY set only validly signed requests are redirected,
+// re-signed for the canonical query; anything else is left to the signature
+// stage. Requests carrying a token in the query are never redirected, as the
+// token's params claim covers the query as sent.
+func (h *Handler) withCanonicalURLs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.ConfigManager.Get()
+		if cfg.CanonicalizeURLs != "redirect" || r.URL.RawQuery == "" ||
+			(r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+		if query.Has("token") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		canonical := sign.Canonical(query)
+		if cfg.SecretKey != "" {
+			signed := signedPath(cfg, r.URL.Path)
+			if !query.Has("s") || !validateSignature(signed, query, cfg.SecretKey) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(canonical) > 0 {
+				canonical.Set("s", sign.Signature(cfg.SecretKey, signed, canonical))
+			}
+		}
+
+		target := canonical.Encode()
+		if target == r.URL.RawQuery {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// A relative reference keeps the redirect correct behind a path
+		// prefix, e.g. when quirm is mounted with http.StripPrefix
+		location := "?" + target
+		if target == "" {
+			location = path.Base(r.URL.EscapedPath())
+		}
+		metrics.CanonicalRedirectsTotal.Inc()
+		w.Header().Set("Location", location)
+		setCacheControl(w)
+		w.WriteHeader(http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// capabilitiesVersion is bumped when the document changes incompatibly.
+const capabilitiesVersion = 1
+
+// paramSpec describes a query parameter and the values it accepts.
+type paramSpec struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"` // int, float, bool, string, color or enum
+	Values []string `json:"values,omitempty"`
+	Min    *float64 `json:"min,omitempty"`
+	Max    *float64 `json:"max,omitempty"`
+}
+
+type featureSpec struct {
+	Enabled bool        `json:"enabled"`
+	Params  []paramSpec `json:"params"`
+}
+
+type capabilitiesDoc struct {
+	Version int `json:"version"`
+	Formats struct {
+		Input  []string `json:"input"`
+		Output []string `json:"output"`
+	} `json:"formats"`
+	Features map[string]featureSpec `json:"features"`
+	// Presets lists the preset names, not their definitions
+	Presets []string         `json:"presets"`
+	Limits  map[string]int64 `json:"limits"`
+}
+
+func intParam(name string, min, max float64) paramSpec {
+	return paramSpec{Name: name, Type: "int", Min: &min, Max: &max}
+}
+
+func floatParam(name string, min, max float64) paramSpec {
+	return paramSpec{Name: name, Type: "float", Min: &min, Max: &max}
+}
+
+func boolParam(name string) paramSpec {
+	return paramSpec{Name: name, Type: "bool"}
+}
+
+func enumParam(name string, values ...string) paramSpec {
+	return paramSpec{Name: name, Type: "enum", Values: values}
+}
+
+func stringParam(name string) paramSpec {
+	return paramSpec{Name: name, Type: "string"}
+}
+
+func colorParam(name string) paramSpec {
+	return paramSpec{Name: name, Type: "color"}
+}
+
+// buildCapabilities assembles the capabilities document from cfg and the
+// probed libvips formats, ffmpeg binary and face cascade.
+func buildCapabilities(cfg config.Config) capabilitiesDoc {
+	detected := processor.DetectCapabilities()
+	video := cfg.EnableVideoThumbnail && detected.FFmpeg
+
+	var doc capabilitiesDoc
+	doc.Version = capabilitiesVersion
+	doc.Formats.Input = []string{"jpeg", "png", "gif", "webp", "pdf"}
+	if video {
+		doc.Formats.Input = append(doc.Formats.Input, "mp4", "mov", "webm")
+	}
+	doc.Formats.Output = append([]string{}, detected.Formats...)
+	doc.Formats.Output = append(doc.Formats.Output, "ico")
+	if detected.FFmpeg {
+		doc.Formats.Output = append(doc.Formats.Output, "mp4", "webm")
+	}
+	formatValues := append([]string{"auto", "original"}, doc.Formats.Output...)
+
+	doc.Features = map[string]featureSpec{
+		"resize": {Enabled: true, Params: []paramSpec{
+			intParam("w", float64(cfg.MinOutputWidth), 1<<16), intParam("h", float64(cfg.MinOutputHeight), 1<<16),
+			enumParam("fit", processor.FitModes...),
+			intParam("q", 1, 100),
+		}},
+		"format": {Enabled: true, Params: []paramSpec{
+			enumParam("format", formatValues...),
+			enumParam("neg", "off"),
+			colorParam("bg"),
+		}},
+		"smart_crop": {Enabled: true, Params: []paramSpec{enumParam("focus", "smart")}},
+		"ai_smart_crop": {Enabled: cfg.AIModelPath != "", Params: []paramSpec{
+			enumParam("focus", "smart"),
+		}},
+		"face_crop": {Enabled: detected.FaceDetection, Params: []paramSpec{
+			enumParam("focus", "face", "faces"),
+			floatParam("face_pad", 0, 2),
+		}},
+		"rotate": {Enabled: true, Params: []paramSpec{
+			enumParam("rot", "90", "180", "270"), enumParam("flip", processor.FlipModes...),
+		}},
+		"crop": {Enabled: true, Params: []paramSpec{
+			intParam("cx", 0, 1<<16), intParam("cy", 0, 1<<16), intParam("cw", 1, 1<<16), intParam("ch", 1, 1<<16),
+		}},
+		"focal_point": {Enabled: true, Params: []paramSpec{
+			floatParam("fp-x", 0, 1), floatParam("fp-y", 0, 1),
+		}},
+		"adjustments": {Enabled: true, Params: []paramSpec{
+			enumParam("effect", "grayscale", "sepia"),
+			{Name: "brightness", Type: "float"}, {Name: "contrast", Type: "float"},
+		}},
+		"text_overlay": {Enabled: true, Params: []paramSpec{
+			stringParam("text"), colorParam("color"), {Name: "ts", Type: "float"}, stringParam("font"),
+		}},
+		"text_templates": {Enabled: len(cfg.TextTemplates) > 0, Params: []paramSpec{
+			enumParam("text_tpl", sortedKeys(cfg.TextTemplates)...),
+		}},
+		"blurhash":     {Enabled: true, Params: []paramSpec{boolParam("blurhash")}},
+		"palette":      {Enabled: true, Params: []paramSpec{enumParam("palette", "true")}},
+		"lqip":         {Enabled: true, Params: []paramSpec{enumParam("bundle", "lqip")}},
+		"icons":        {Enabled: true, Params: []paramSpec{stringParam("sizes")}},
+		"pages":        {Enabled: true, Params: []paramSpec{intParam("page", 1, 1<<16)}},
+		"original":     {Enabled: cfg.SecretKey != "" || len(cfg.AllowedCIDRNets) > 0, Params: []paramSpec{enumParam("original", "true")}},
+		"static":       {Enabled: true, Params: []paramSpec{boolParam("static")}},
+		"gif_optimize": {Enabled: true, Params: []paramSpec{boolParam("optimize")}},
+		"force":        {Enabled: true, Params: []paramSpec{boolParam("force")}},
+		"auto_gray":    {Enabled: true, Params: []paramSpec{boolParam("auto_gray")}},
+		"gif_to_video": {Enabled: detected.FFmpeg, Params: []paramSpec{
+			enumParam("format", "mp4", "webm"),
+		}},
+		"video_thumbnails": {Enabled: video, Params: []paramSpec{
+			floatParam("t", 0, 1<<20),
+			enumParam("format", "storyboard"),
+		}},
+		"video_animated": {Enabled: video, Params: []paramSpec{
+			boolParam("animated"), floatParam("t", 0, 1<<20),
+			intParam("fps", 1, processor.AnimatedMaxFPS), boolParam("boomerang"),
+		}},
+		"video_cards": {Enabled: video, Params: []paramSpec{enumParam("videocard", "true")}},
+		"presets": {Enabled: len(cfg.Presets) > 0, Params: []paramSpec{
+			enumParam("preset", sortedKeys(cfg.Presets)...),
+		}},
+		"signed_urls": {Enabled: cfg.SecretKey != "", Params: []paramSpec{
+			stringParam("s"), intParam("expires", 0, 1<<62),
+		}},
+		"path_options": {Enabled: cfg.PathOptions, Params: []paramSpec{}},
+	}
+	doc.Presets = sortedKeys(cfg.Presets)
+
+	doc.Limits = map[string]int64{
+		"max_image_size_mb":       cfg.MaxImageSizeMB,
+		"max_url_length":          int64(cfg.MaxURLLength),
+		"max_param_length":        maxParamLength,
+		"max_text_length":         maxTextParamLength,
+		"max_icon_size":           256,
+		"max_animated_frames":     processor.AnimatedMaxFrames,
+		"max_video_frames":        int64(cfg.AnimatedVideoMaxFrames),
+		"max_animated_width":      int64(cfg.MaxAnimatedWidth),
+		"max_animated_height":     int64(cfg.MaxAnimatedHeight),
+		"max_url_lifetime_s":      int64(cfg.MaxURLLifetime.Seconds()),
+		"rate_limit_per_second":   int64(cfg.RateLimit),
+		"gif_optimize_max_frames": int64(cfg.GIFOptimizeMaxFrames),
+	}
+	return doc
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// HandleCapabilities describes what this deployment supports
+// (GET /_capabilities), for client SDK generators. The document is built
+// once per configuration and rebuilt after a reload. With
+// CAPABILITIES_REQUIRE_ADMIN it is admin only, as it reveals configuration.
+func (h *Handler) HandleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.ConfigManager.Get().CapabilitiesRequireAdmin && !h.requireAdmin(w, r) {
+		return
+	}
+
+	generation := h.ConfigManager.Generation()
+	h.capsMu.Lock()
+	if h.capsDoc == nil || h.capsGen != generation {
+		doc, err := json.Marshal(buildCapabilities(h.ConfigManager.Get()))
+		if err != nil {
+			h.capsMu.Unlock()
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		h.capsDoc, h.capsGen = doc, generation
+	}
+	doc := h.capsDoc
+	h.capsMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(doc)
+}
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware wraps a handler with one stage of the request chain.
+type Middleware func(http.Handler) http.Handler
+
+// Stage names accepted by Chain.
+const (
+	StageTracing   = "tracing"
+	StageMetrics   = "metrics"
+	StageMethods   = "methods"
+	StageSecurity  = "security"
+	StageRateLimit = "ratelimit"
+	StageCanonical = "canonical"
+	StageSignature = "signature"
+	StageExpires   = "expires"
+)
+
+// Chain builds the asset request pipeline: tracing, metrics, method checks,
+// security (CIDR, domain and country allowlists), rate limiting, canonical
+// URL redirects, signature verification and link expiry around the core
+// asset handler. Stages named in skip are left out, for deployments that
+// embed quirm and handle them elsewhere.
+func (h *Handler) Chain(skip ...string) http.Handler {
+	return h.buildChain(http.HandlerFunc(h.serveAsset), skip)
+}
+
+// PlaceholderChain builds the pipeline of generated placeholders: the stages
+// of Chain except method checks, made by HandlePlaceholder itself, and
+// canonical URL redirects. Placeholders are open to the clients assets are
+// open to, and parameters need a signature like those of assets.
+func (h *Handler) PlaceholderChain(skip ...string) http.Handler {
+	return h.buildChain(http.HandlerFunc(h.HandlePlaceholder), append([]string{StageMethods, StageCanonical}, skip...))
+}
+
+// buildChain wraps handler in the stages of the request chain not named in
+// skip.
+func (h *Handler) buildChain(handler http.Handler, skip []string) http.Handler {
+	stages := []struct {
+		name string
+		mw   Middleware
+	}{
+		{StageTracing, h.withTracing},
+		{StageMetrics, h.withMetrics},
+		{StageMethods, h.withMethods},
+		{StageSecurity, h.withSecurity},
+		{StageRateLimit, h.withRateLimit},
+		{StageCanonical, h.withCanonicalURLs},
+		{StageSignature, h.withSignature},
+		{StageExpires, h.withExpires},
+	}
+
+	skipped := make(map[string]bool, len(skip))
+	for _, name := range skip {
+		skipped[name] = true
+	}
+
+	for i := len(stages) - 1; i >= 0; i-- {
+		if !skipped[stages[i].name] {
+			handler = stages[i].mw(handler)
+		}
+	}
+	return handler
+}
+
+// withTracing starts the server span, continuing any incoming trace.
+func (h *Handler) withTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		tracer := otel.Tracer("quirm/http")
+		ctx, span := tracer.Start(ctx, "HandleRequest",
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(r.Method),
+				semconv.HTTPURLKey.String(r.URL.String()),
+				semconv.UserAgentOriginalKey.String(r.UserAgent()),
+				attribute.String("client.ip", clientKey(h.ConfigManager.Get(), r)),
+			),
+			trace.WithSpanKind(trace.SpanKindServer),
+		)
+		defer span.End()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// withMetrics records request counts and latency when metrics are enabled.
+func (h *Handler) withMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.ConfigManager.Get().EnableMetrics {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		start := time.Now()
+		defer func() {
+			duration := time.Since(start).Seconds()
+			status := strconv.Itoa(rec.statusCode)
+			pathLabel := "/{image}" // Generic placeholder as requested
+			metrics.HTTPRequestsTotal.WithLabelValues(r.Method, status, pathLabel).Inc()
+			metrics.HTTPRequestDuration.WithLabelValues(r.Method, status, pathLabel).Observe(duration)
+			trace.SpanFromContext(r.Context()).SetAttributes(semconv.HTTPStatusCodeKey.Int(rec.statusCode))
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// assetMethods are the methods asset URLs answer to.
+const assetMethods = "GET, HEAD, DELETE, OPTIONS"
+
+// withMethods answers OPTIONS with 204 and rejects methods other than GET,
+// HEAD and DELETE with 405. It runs before rate limiting, so clients probing
+// with other methods do not use up anyone's quota.
+func (h *Handler) withMethods(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodDelete:
+			next.ServeHTTP(w, r)
+		case http.MethodOptions:
+			w.Header().Set("Allow", assetMethods)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", assetMethods)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// withSecurity enforces the IP/CIDR, domain (Referer/Origin) and country
+// allowlists. Requests forwarded by a peer were checked by the forwarding
+// replica, against the client's own address.
+func (h *Handler) withSecurity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.ConfigManager.Get()
+		if h.Peers.Forwarded(r, cfg.Peers) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 0. Security: IP/CIDR Allowlist
+		// If the IP is in the allowed CIDR list, we bypass Domain Whitelisting
+		ipAllowed := isTrustedIP(cfg, clientAddr(cfg, r))
+
+		// 0.1 Security: Domain Whitelisting
+		// Only check if IP is NOT explicitly allowed (and if domains are configured)
+		if !ipAllowed && len(cfg.AllowedDomains) > 0 {
+			referer := r.Header.Get("Referer")
+			origin := r.Header.Get("Origin")
+			domainAllowed := false
+
+			if referer != "" {
+				if h.domainAllowed(cfg, referer) {
+					domainAllowed = true
+				}
+			}
+			if origin != "" {
+				if h.domainAllowed(cfg, origin) {
+					domainAllowed = true
+				}
+			}
+
+			if referer == "" && origin == "" {
+				// If no referer/origin, we usually allow unless strict mode is on.
+				// Currently implementation allows it.
+				domainAllowed = true
+			}
+
+			if !domainAllowed && (referer != "" || origin != "") {
+				http.Error(w, "Forbidden Domain", http.StatusForbidden)
+				return
+			}
+		} else if !ipAllowed && len(cfg.AllowedCIDRNets) > 0 && len(cfg.AllowedDomains) == 0 {
+			// If only CIDRs are configured and IP didn't match -> Forbidden
+			http.Error(w, "Forbidden IP", http.StatusForbidden)
+			return
+		}
+
+		// 0.2 Security: GeoIP
+		if len(cfg.AllowedCountries) > 0 {
+			country := r.Header.Get("CF-IPCountry")
+			if country == "" {
+				country = r.Header.Get("X-Country-Code")
+			}
+
+			if country != "" {
+				allowed := false
+				for _, c := range cfg.AllowedCountries {
+					if strings.EqualFold(c, country) {
+						allowed = true
+						break
+					}
+				}
+				if !allowed {
+					http.Error(w, "Forbidden Country", http.StatusForbidden)
+					return
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// domainAllowed checks a Referer or Origin value against the allowed
+// domains, exact or "*", and the compiled domain regexes.
+func (h *Handler) domainAllowed(cfg config.Config, val string) bool {
+	u, err := url.Parse(val)
+	if err != nil {
+		return false
+	}
+	// Check exact/wildcard domains first
+	for _, d := range cfg.AllowedDomains {
+		if d == "*" {
+			return true
+		}
+		if !strings.HasPrefix(d, "^") && d == u.Host {
+			return true
+		}
+	}
+	// Check Regex
+	for _, re := range h.AllowedDomainsRegex {
+		if re.MatchString(u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// withRateLimit applies the per-IP rate limit. Requests forwarded by a peer
+// were already counted against their client by the forwarding replica.
+func (h *Handler) withRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.ConfigManager.Get()
+		if cfg.RateLimit > 0 && h.Limiter != nil && !h.Peers.Forwarded(r, cfg.Peers) {
+			if !h.Limiter.Allow(clientKey(cfg, r)) {
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withSignature verifies the URL signature when SECRET_KEY is set. The
+// signature covers the full request path, including any options segment but
+// not the content hash of an immutable URL, so version redirects keep it
+// valid. Requests without parameters need no signature. With token auth
+// configured, a valid bearer token is accepted instead of the signature and
+// an invalid one is rejected with 401.
+func (h *Handler) withSignature(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.ConfigManager.Get()
+		tokens := tokenKeys(cfg).Enabled()
+		if cfg.SecretKey == "" && !tokens {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Invalid paths are rejected by serveAsset
+		objectKey, pathParams, ok := requestTarget(cfg, r.URL.Path)
+		if tokens {
+			if raw := requestToken(r); raw != "" {
+				r = withoutTokenParam(r)
+				if ok {
+					params := mergePathOptions(pathParams, r.URL.Query())
+					if err := h.checkToken(r, cfg, raw, objectKey, params); err != nil {
+						writeTokenError(w, err)
+						return
+					}
+				}
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenAuthKey{}, true)))
+				return
+			}
+		}
+
+		// Cache version parameters are signed like any other: unsigned, they
+		// would let anyone add cache entries at will
+		queryParams := r.URL.Query()
+		if ok && (len(queryParams) > 0 || len(pathParams) > 0) {
+			if cfg.SecretKey == "" {
+				writeTokenError(w, errMissingToken)
+				return
+			}
+			sig := queryParams.Get("s")
+			if sig == "" {
+				http.Error(w, "Missing signature", http.StatusForbidden)
+				return
+			}
+			if !validateSignature(signedPath(cfg, r.URL.Path), queryParams, cfg.SecretKey) {
+				http.Error(w, "Invalid signature", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// stageRequest describes a request sent through a single stage.
+type stageRequest struct {
+	name    string
+	cfg     config.Config
+	method  string
+	target  string
+	remote  string
+	header  http.Header
+	want    int
+	reached bool
+}
+
+// runStage sends tt through stage and checks the status and whether the
+// request reached the next handler.
+func runStage(t *testing.T, h *Handler, stage func(*Handler, http.Handler) http.Handler, tt stageRequest) *httptest.ResponseRecorder {
+	t.Helper()
+	h.ConfigManager = config.NewManagerWithConfig(tt.cfg)
+	reached := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true })
+
+	method := tt.method
+	if method == "" {
+		method = http.MethodGet
+	}
+	r := httptest.NewRequest(method, tt.target, nil)
+	if tt.remote != "" {
+		r.RemoteAddr = tt.remote
+	}
+	for k, v := range tt.header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	stage(h, next).ServeHTTP(w, r)
+	if w.Code != tt.want {
+		t.Errorf("status %d, want %d", w.Code, tt.want)
+	}
+	if reached != tt.reached {
+		t.Errorf("reached the next handler: %v, want %v", reached, tt.reached)
+	}
+	return w
+}
+
+func mustCIDR(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ipNet
+}
+
+func TestWithSecurity(t *testing.T) {
+	office := []*net.IPNet{mustCIDR(t, "10.0.0.0/8")}
+	domains := config.Config{AllowedDomains: []string{"example.com"}, AllowedCIDRNets: office}
+	h := &Handler{AllowedDomainsRegex: []*regexp.Regexp{regexp.MustCompile(`^[a-z]+\.example\.org$`)}}
+
+	tests := []stageRequest{
+		{name: "nothing configured", want: http.StatusOK, reached: true},
+		{name: "CIDR only, inside", cfg: config.Config{AllowedCIDRNets: office}, remote: "10.1.2.3:4000", want: http.StatusOK, reached: true},
+		{name: "CIDR only, outside", cfg: config.Config{AllowedCIDRNets: office}, remote: "192.0.2.1:4000", want: http.StatusForbidden},
+		{name: "allowed referer", cfg: domains, remote: "192.0.2.1:4000", header: http.Header{"Referer": {"https://example.com/page"}}, want: http.StatusOK, reached: true},
+		{name: "allowed origin by regex", cfg: domains, remote: "192.0.2.1:4000", header: http.Header{"Origin": {"https://cdn.example.org"}}, want: http.StatusOK, reached: true},
+		{name: "foreign referer", cfg: domains, remote: "192.0.2.1:4000", header: http.Header{"Referer": {"https://evil.test/"}}, want: http.StatusForbidden},
+		{name: "foreign referer from an allowed CIDR", cfg: domains, remote: "10.1.2.3:4000", header: http.Header{"Referer": {"https://evil.test/"}}, want: http.StatusOK, reached: true},
+		{name: "no referer or origin", cfg: domains, remote: "192.0.2.1:4000", want: http.StatusOK, reached: true},
+		{name: "allowed country", cfg: config.Config{AllowedCountries: []string{"VN"}}, header: http.Header{"Cf-Ipcountry": {"vn"}}, want: http.StatusOK, reached: true},
+		{name: "other country", cfg: config.Config{AllowedCountries: []string{"VN"}}, header: http.Header{"X-Country-Code": {"US"}}, want: http.StatusForbidden},
+		{name: "unknown country", cfg: config.Config{AllowedCountries: []string{"VN"}}, want: http.StatusOK, reached: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.target = "/photos/a.jpg"
+			runStage(t, h, (*Handler).withSecurity, tt)
+		})
+	}
+}
+
+// quotaLimiter allows a fixed number of requests per key.
+type quotaLimiter struct {
+	quota int
+	used  map[string]int
+}
+
+func (l *quotaLimiter) Allow(key string) bool {
+	l.used[key]++
+	return l.used[key] <= l.quota
+}
+
+func (l *quotaLimiter) Reset(key string) error {
+	delete(l.used, key)
+	return nil
+}
+
+func TestWithRateLimit(t *testing.T) {
+	limiter := &quotaLimiter{quota: 2, used: map[string]int{}}
+	h := &Handler{Limiter: limiter}
+	cfg := config.Config{RateLimit: 2, RateLimitIPv6Prefix: 64}
+
+	tests := []stageRequest{
+		{name: "first", cfg: cfg, remote: "192.0.2.1:4000", want: http.StatusOK, reached: true},
+		{name: "second", cfg: cfg, remote: "192.0.2.1:4001", want: http.StatusOK, reached: true},
+		{name: "over the limit", cfg: cfg, remote: "192.0.2.1:4002", want: http.StatusTooManyRequests},
+		{name: "another client", cfg: cfg, remote: "192.0.2.2:4000", want: http.StatusOK, reached: true},
+		{name: "IPv6 address", cfg: cfg, remote: "[2001:db8::1]:4000", want: http.StatusOK, reached: true},
+		{name: "same IPv6 network", cfg: cfg, remote: "[2001:db8::2]:4000", want: http.StatusOK, reached: true},
+		{name: "IPv6 network over the limit", cfg: cfg, remote: "[2001:db8::3]:4000", want: http.StatusTooManyRequests},
+		{name: "limit disabled", cfg: config.Config{}, remote: "192.0.2.1:4003", want: http.StatusOK, reached: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.target = "/photos/a.jpg"
+			runStage(t, h, (*Handler).withRateLimit, tt)
+		})
+	}
+}
+
+func TestWithCanonicalURLs(t *testing.T) {
+	redirect := config.Config{CanonicalizeURLs: "redirect"}
+	tests := []struct {
+		stageRequest
+		location string
+	}{
+		{stageRequest: stageRequest{name: "off", target: "/a.jpg?w=1&h=2", want: http.StatusOK, reached: true}},
+		{stageRequest: stageRequest{name: "canonical", cfg: redirect, target: "/a.jpg?h=2&w=1", want: http.StatusOK, reached: true}},
+		{stageRequest: stageRequest{name: "reordered", cfg: redirect, target: "/a.jpg?w=1&h=2", want: http.StatusMovedPermanently}, location: "?h=2&w=1"},
+		{stageRequest: stageRequest{name: "DELETE", cfg: redirect, method: http.MethodDelete, target: "/a.jpg?w=1&h=2", want: http.StatusOK, reached: true}},
+		{stageRequest: stageRequest{name: "token", cfg: redirect, target: "/a.jpg?w=1&token=x", want: http.StatusOK, reached: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := runStage(t, &Handler{}, (*Handler).withCanonicalURLs, tt.stageRequest)
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
+
+func TestWithExpires(t *testing.T) {
+	past := "?w=1&expires=" + strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	future := "?w=1&expires=" + strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	tests := []stageRequest{
+		{name: "no expiry", target: "/a.jpg?w=1", want: http.StatusOK, reached: true},
+		{name: "unsigned, not enforced", target: "/a.jpg" + past, want: http.StatusOK, reached: true},
+		{name: "unsigned, enforced", cfg: config.Config{EnforceExpires: true}, target: "/a.jpg" + past, want: http.StatusGone},
+		{name: "signed and expired", cfg: config.Config{SecretKey: "k"}, target: "/a.jpg" + past + "&s=x", want: http.StatusGone},
+		{name: "signed and valid", cfg: config.Config{SecretKey: "k"}, target: "/a.jpg" + future + "&s=x", want: http.StatusOK, reached: true},
+		{name: "signed beyond the lifetime", cfg: config.Config{SecretKey: "k", MaxURLLifetime: time.Minute}, target: "/a.jpg" + future + "&s=x", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runStage(t, &Handler{}, (*Handler).withExpires, tt)
+		})
+	}
+}
+
+func TestWithTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		otel.SetTextMapPropagator(propagator)
+	})
+
+	h := &Handler{ConfigManager: config.NewManagerWithConfig(config.Config{})}
+	var inner trace.SpanContext
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inner = trace.SpanContextFromContext(r.Context())
+	})
+	r := httptest.NewRequest(http.MethodGet, "/a.jpg", nil)
+	r.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.withTracing(next).ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "HandleRequest" {
+		t.Fatalf("ended spans %v, want one HandleRequest span", spans)
+	}
+	if !inner.Equal(spans[0].SpanContext()) {
+		t.Error("the next handler does not run in the request span")
+	}
+	if got := spans[0].SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace %s, want the incoming trace", got)
+	}
+	if spans[0].SpanKind() != trace.SpanKindServer {
+		t.Errorf("span kind %v, want server", spans[0].SpanKind())
+	}
+}
+
+func TestWithMetrics(t *testing.T) {
+	teapots := metrics.HTTPRequestsTotal.WithLabelValues(http.MethodGet, "418", "/{image}")
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+
+	for _, enabled := range []bool{false, true} {
+		before := testutil.ToFloat64(teapots)
+		h := &Handler{ConfigManager: config.NewManagerWithConfig(config.Config{EnableMetrics: enabled})}
+		w := httptest.NewRecorder()
+		h.withMetrics(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a.jpg", nil))
+		if w.Code != http.StatusTeapot {
+			t.Errorf("metrics %v: status %d, want %d", enabled, w.Code, http.StatusTeapot)
+		}
+		want := 0.0
+		if enabled {
+			want = 1
+		}
+		if got := testutil.ToFloat64(teapots) - before; got != want {
+			t.Errorf("metrics %v: %v requests counted, want %v", enabled, got, want)
+		}
+	}
+}
+
+// TestChainStages checks the order of the stages and that skipped stages
+// are left out.
+func TestChainStages(t *testing.T) {
+	h := &Handler{
+		ConfigManager: config.NewManagerWithConfig(config.Config{
+			RateLimit:       1,
+			AllowedCIDRNets: []*net.IPNet{mustCIDR(t, "10.0.0.0/8")},
+			SecretKey:       "k",
+		}),
+		Limiter: &quotaLimiter{quota: 0, used: map[string]int{}},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		skip   []string
+		want   int
+	}{
+		{name: "methods before security", method: http.MethodPost, target: "/a.jpg", want: http.StatusMethodNotAllowed},
+		{name: "security before the rate limit", target: "/a.jpg", want: http.StatusForbidden},
+		{name: "rate limit before signatures", target: "/a.jpg?w=1", skip: []string{StageSecurity}, want: http.StatusTooManyRequests},
+		{name: "signature", target: "/a.jpg?w=1", skip: []string{StageSecurity, StageRateLimit}, want: http.StatusForbidden},
+		{name: "all checks skipped", target: "/a.jpg?w=1", skip: []string{StageSecurity, StageRateLimit, StageSignature}, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, tt.target, nil)
+			r.RemoteAddr = "192.0.2.1:4000"
+			w := httptest.NewRecorder()
+			h.buildChain(ok, tt.skip).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
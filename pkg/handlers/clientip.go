@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/config"
+)
+
+// clientIP parses the address of the client from a RemoteAddr, with or
+// without a port. IPv4-mapped IPv6 addresses are returned as IPv4 and zones
+// are dropped, so "[::ffff:10.0.0.1]:443" and "10.0.0.1" are the same client.
+func clientIP(remoteAddr string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(remoteAddr); err == nil {
+		return addrPort.Addr().Unmap().WithZone(""), true
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(remoteAddr, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// clientAddr is the address of the client of r. When the connection comes
+// from one of TRUSTED_PROXIES, X-Forwarded-For is walked from the right past
+// the trusted proxies to the first other address; entries to its left were
+// sent by the client and may be forged. A malformed entry stops the walk at
+// the proxy that added it.
+func clientAddr(cfg config.Config, r *http.Request) string {
+	addr := r.RemoteAddr
+	if len(cfg.TrustedProxies) == 0 {
+		return addr
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0 && trustedProxy(cfg, addr); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, ok := clientIP(hop); !ok {
+			break
+		}
+		addr = hop
+	}
+	return addr
+}
+
+// trustedProxy reports whether remoteAddr is one of TRUSTED_PROXIES.
+func trustedProxy(cfg config.Config, remoteAddr string) bool {
+	addr, ok := clientIP(remoteAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range cfg.TrustedProxies {
+		if ipNet.Contains(addr.AsSlice()) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientKey identifies the client of r for rate limiting and logging. IPv6
+// clients are aggregated to their RATE_LIMIT_IPV6_PREFIX network, since a
+// single subscriber usually controls a whole /64. Unparsable addresses are
+// used as they are.
+func clientKey(cfg config.Config, r *http.Request) string {
+	return addrKey(cfg, clientAddr(cfg, r))
+}
+
+// addrKey is clientKey for a bare address.
+func addrKey(cfg config.Config, remoteAddr string) string {
+	addr, ok := clientIP(remoteAddr)
+	if !ok {
+		return remoteAddr
+	}
+	if addr.Is6() && cfg.RateLimitIPv6Prefix > 0 && cfg.RateLimitIPv6Prefix < 128 {
+		if prefix, err := addr.Prefix(cfg.RateLimitIPv6Prefix); err == nil {
+			return prefix.String()
+		}
+	}
+	return addr.String()
+}
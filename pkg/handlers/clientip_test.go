@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CodeTease/quirm/pkg/config"
+)
+
+func TestClientKey(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	behindProxy := config.Config{RateLimitIPv6Prefix: 64, TrustedProxies: []*net.IPNet{proxies}}
+
+	tests := []struct {
+		name   string
+		cfg    config.Config
+		remote string
+		xff    []string
+		want   string
+	}{
+		{name: "IPv4 with port", remote: "192.0.2.1:41000", want: "192.0.2.1"},
+		{name: "IPv4 without port", remote: "192.0.2.1", want: "192.0.2.1"},
+		{name: "mapped IPv4", remote: "[::ffff:192.0.2.1]:41000", want: "192.0.2.1"},
+		{name: "IPv6 per address", remote: "[2001:db8:1:2:3:4:5:6]:41000", want: "2001:db8:1:2:3:4:5:6"},
+		{name: "IPv6 /64", cfg: config.Config{RateLimitIPv6Prefix: 64}, remote: "[2001:db8:1:2:3:4:5:6]:41000", want: "2001:db8:1:2::/64"},
+		{name: "IPv6 /48", cfg: config.Config{RateLimitIPv6Prefix: 48}, remote: "[2001:db8:1:2::9]:41000", want: "2001:db8:1::/48"},
+		{name: "IPv6 /128", cfg: config.Config{RateLimitIPv6Prefix: 128}, remote: "[2001:db8::9]:41000", want: "2001:db8::9"},
+		{name: "IPv6 zone", cfg: config.Config{RateLimitIPv6Prefix: 64}, remote: "[fe80::1%eth0]:41000", want: "fe80::/64"},
+		{name: "IPv6 without port", cfg: config.Config{RateLimitIPv6Prefix: 64}, remote: "[2001:db8::9]", want: "2001:db8::/64"},
+		{name: "malformed", remote: "not-an-address", want: "not-an-address"},
+		{name: "empty", remote: "", want: ""},
+		{name: "bad port", remote: "192.0.2.1:http", want: "192.0.2.1:http"},
+		{name: "X-Forwarded-For without TRUSTED_PROXIES", remote: "10.0.0.5:41000", xff: []string{"192.0.2.1"}, want: "10.0.0.5"},
+		{name: "X-Forwarded-For from an untrusted peer", cfg: behindProxy, remote: "198.51.100.7:41000", xff: []string{"192.0.2.1"}, want: "198.51.100.7"},
+		{name: "trusted proxy", cfg: behindProxy, remote: "10.0.0.5:41000", xff: []string{"192.0.2.1"}, want: "192.0.2.1"},
+		{name: "spoofed leftmost entry", cfg: behindProxy, remote: "10.0.0.5:41000", xff: []string{"203.0.113.9, 192.0.2.1"}, want: "192.0.2.1"},
+		{name: "proxy chain", cfg: behindProxy, remote: "10.0.0.5:41000", xff: []string{"192.0.2.1, 10.1.0.1", "10.2.0.1"}, want: "192.0.2.1"},
+		{name: "only proxies", cfg: behindProxy, remote: "10.0.0.5:41000", xff: []string{"10.1.0.1"}, want: "10.1.0.1"},
+		{name: "IPv6 client behind a proxy", cfg: behindProxy, remote: "10.0.0.5:41000", xff: []string{"2001:db8:1:2::9"}, want: "2001:db8:1:2::/64"},
+		{name: "malformed entry", cfg: behindProxy, remote: "10.0.0.5:41000", xff: []string{"192.0.2.1, unknown"}, want: "10.0.0.5"},
+		{name: "empty header", cfg: behindProxy, remote: "10.0.0.5:41000", xff: []string{""}, want: "10.0.0.5"},
+		{name: "no header", cfg: behindProxy, remote: "10.0.0.5:41000", want: "10.0.0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/a.jpg", nil)
+			r.RemoteAddr = tt.remote
+			for _, xff := range tt.xff {
+				r.Header.Add("X-Forwarded-For", xff)
+			}
+			if got := clientKey(tt.cfg, r); got != tt.want {
+				t.Errorf("clientKey(%q, X-Forwarded-For %q) = %q, want %q", tt.remote, tt.xff, got, tt.want)
+			}
+		})
+	}
+}
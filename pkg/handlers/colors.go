@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// colorParams are the parameters holding a color, parsed by
+// processor.ParseColor.
+var colorParams = []string{"color", "bg"}
+
+// checkColors rejects a color parameter that processor.ParseColor cannot
+// parse, naming the parameter, instead of letting the SVG renderer or the
+// flatten step guess.
+func checkColors(params url.Values) error {
+	for _, param := range colorParams {
+		value := params.Get(param)
+		if value == "" {
+			continue
+		}
+		if _, err := processor.ParseColor(value); err != nil {
+			return fmt.Errorf("%s: %v", param, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/CodeTease/quirm/pkg/config"
+)
+
+// TestColorParams rejects an unparsable color with a 400 that names the
+// parameter, before anything is fetched.
+func TestColorParams(t *testing.T) {
+	h := &Handler{ConfigManager: config.NewManagerWithConfig(config.Config{})}
+	tests := []struct {
+		query string
+		param string
+	}{
+		{query: "text=hi&color=notacolor", param: "color"},
+		{query: "w=100&bg=%23ggg", param: "bg"},
+		{query: "w=100&bg=white&color=rgb(300,0,0)", param: "color"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.serveAsset(w, httptest.NewRequest(http.MethodGet, "/photos/a.jpg?"+tt.query, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, want 400", w.Code)
+			}
+			if body := w.Body.String(); !strings.Contains(body, tt.param+":") {
+				t.Errorf("error %q does not name %s", strings.TrimSpace(body), tt.param)
+			}
+		})
+	}
+
+	for _, query := range []string{"w=100&bg=white", "w=100&bg=ff000080", "text=hi&color=rgba(0,0,0,0.5)"} {
+		params, _ := url.ParseQuery(query)
+		if err := checkColors(params); err != nil {
+			t.Errorf("%s: %v", query, err)
+		}
+	}
+}
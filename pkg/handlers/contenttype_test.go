@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// Encoded outputs, as far as their signature goes
+var (
+	jpegOutput = []byte("\xFF\xD8\xFF\xE0\x00\x10JFIF\x00\x01\x01\x00")
+	webpOutput = []byte("RIFF\x24\x00\x00\x00WEBPVP8 \x00\x00")
+	avifOutput = []byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00")
+	icoOutput  = []byte("\x00\x00\x01\x00\x01\x00\x10\x10\x00\x00\x01\x00")
+)
+
+func TestContentTypeFor(t *testing.T) {
+	tests := []struct {
+		objectKey string
+		format    string
+		want      string
+	}{
+		{objectKey: "a.jpg", want: "image/jpeg"},
+		{objectKey: "a.jpeg", want: "image/jpeg"},
+		{objectKey: "A.JPG", want: "image/jpeg"},
+		{objectKey: "a.png", want: "image/png"},
+		{objectKey: "a.gif", want: "image/gif"},
+		{objectKey: "a.webp", want: "image/webp"},
+		{objectKey: "a.avif", want: "image/avif"},
+		{objectKey: "a.jxl", want: "image/jxl"},
+		{objectKey: "a.pdf", want: "application/pdf"},
+		{objectKey: "a.css", want: "text/css"},
+		{objectKey: "a.js", want: "application/javascript"},
+		{objectKey: "a.svg", want: "image/svg+xml"},
+		{objectKey: "a.mp4", want: "video/mp4"},
+		{objectKey: "a.webm", want: "video/webm"},
+		{objectKey: "a.ico", want: "image/x-icon"},
+		{objectKey: "a.bin", want: "application/octet-stream"},
+		{objectKey: "a", want: "application/octet-stream"},
+		// A requested format wins over the extension
+		{objectKey: "a.png", format: "webp", want: "image/webp"},
+		{objectKey: "a.png", format: "jpg", want: "image/jpeg"},
+		{objectKey: "a.png", format: "jpeg", want: "image/jpeg"},
+		{objectKey: "a.png", format: "AVIF", want: "image/avif"},
+		{objectKey: "a.png", format: "ico", want: "image/x-icon"},
+		{objectKey: "a.mov", format: "mp4", want: "video/mp4"},
+	}
+	for _, tt := range tests {
+		if got := contentTypeFor(tt.objectKey, tt.format); got != tt.want {
+			t.Errorf("contentTypeFor(%q, %q) = %q, want %q", tt.objectKey, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestProcessedContentType(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		opts processor.ImageOptions
+		want string
+	}{
+		{name: "as requested", data: webpOutput, opts: processor.ImageOptions{Format: "webp"}, want: "image/webp"},
+		{name: "jpg", data: jpegOutput, opts: processor.ImageOptions{Format: "jpg"}, want: "image/jpeg"},
+		{name: "source format kept", data: jpegOutput, want: "image/jpeg"},
+		{name: "AVIF fell back to WebP", data: webpOutput, opts: processor.ImageOptions{Format: "avif"}, want: "image/webp"},
+		{name: "AVIF", data: avifOutput, opts: processor.ImageOptions{Format: "avif"}, want: "image/avif"},
+		{name: "icon", data: icoOutput, opts: processor.ImageOptions{Format: "ico"}, want: "image/x-icon"},
+		{name: "blurhash", data: []byte("LEHV6nWB2yk8pyo0adR*.7kCMdnj"), opts: processor.ImageOptions{Blurhash: true}, want: "text/plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := processedContentType(tt.data, "photos/a.png", tt.opts); got != tt.want {
+				t.Errorf("processedContentType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestServedContentType serves a variant requested as AVIF but encoded as
+// WebP from memory and from disk: both name the format it is in.
+func TestServedContentType(t *testing.T) {
+	h := &Handler{ConfigManager: config.NewManagerWithConfig(config.Config{})}
+	v := variant{shouldProcess: true, encodingType: "identity", opts: processor.ImageOptions{Format: "avif"}}
+	dir := t.TempDir()
+	write := func(name string, meta *cache.Meta) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, webpOutput, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if meta != nil {
+			if err := cache.WriteMeta(path, *meta); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return path
+	}
+	recorded, unrecorded := write("recorded", &cache.Meta{ContentType: "image/webp"}), write("unrecorded", nil)
+
+	tests := []struct {
+		name  string
+		serve func(w http.ResponseWriter, r *http.Request)
+		want  string
+	}{
+		{name: "memory hit", serve: func(w http.ResponseWriter, r *http.Request) {
+			serveBytes(w, r, webpOutput, "photos/a.png", v.opts)
+		}, want: "image/webp"},
+		{name: "disk hit", serve: func(w http.ResponseWriter, r *http.Request) {
+			h.serveVariantFile(w, r, recorded, "photos/a.png", v)
+		}, want: "image/webp"},
+		// Entries written before the type was recorded are sniffed
+		{name: "disk hit without metadata", serve: func(w http.ResponseWriter, r *http.Request) {
+			h.serveVariantFile(w, r, unrecorded, "photos/a.png", v)
+		}, want: "image/webp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.serve(w, httptest.NewRequest(http.MethodGet, "/photos/a.png?format=avif", nil))
+			if got := w.Header().Get("Content-Type"); got != tt.want {
+				t.Errorf("Content-Type = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import "net/http"
+
+// HandleCosts lists the sampled processing cost entries (GET /_debug/costs, admin only).
+func (h *Handler) HandleCosts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	if h.Costs == nil {
+		http.Error(w, "Cost log is not enabled", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"entries": h.Costs.Entries()})
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// grayscaleHeaderBytes is how much of a disk entry is read to find out
+// whether it was encoded single-band; image headers come first.
+const grayscaleHeaderBytes = 64 << 10
+
+// setDebugHeaders exposes how a request was resolved when DEBUG_HEADERS is
+// enabled: the storage key after rewrites, the effective options after
+// presets, path options, client hints and auto-format, and the cache key.
+// The options are recomputed for every request, so cache hits carry the same
+// headers as misses. Signature parameters never take part in any of them.
+func setDebugHeaders(w http.ResponseWriter, cfg config.Config, objectKey string, v variant) {
+	if !cfg.DebugHeaders {
+		return
+	}
+	w.Header().Set("X-Quirm-Key", objectKey)
+	if v.shouldProcess {
+		w.Header().Set("X-Quirm-Options", v.opts.Canonical())
+	} else {
+		w.Header().Set("X-Quirm-Options", "passthrough;encoding="+v.encodingType)
+	}
+	w.Header().Set("X-Quirm-Variant", v.cacheKey)
+	if v.policy != nil {
+		w.Header().Set("X-Quirm-Policy", v.policyPrefix)
+	}
+}
+
+// setGrayscaleHeader reports in X-Quirm-Grayscale whether an auto_gray
+// variant, whose output starts with data, was encoded single-band. It is
+// read from the output rather than remembered, so hits carry it too.
+func setGrayscaleHeader(w http.ResponseWriter, cfg config.Config, v variant, data []byte) {
+	if !cfg.DebugHeaders || !v.shouldProcess || !v.opts.AutoGray || v.opts.Blurhash {
+		return
+	}
+	if header, err := processor.ReadHeader(data); err == nil {
+		w.Header().Set("X-Quirm-Grayscale", strconv.FormatBool(header.Bands <= 2))
+	}
+}
+
+// setGrayscaleHeaderFile is setGrayscaleHeader for a disk entry.
+func setGrayscaleHeaderFile(w http.ResponseWriter, cfg config.Config, v variant, path string) {
+	if !cfg.DebugHeaders || !v.shouldProcess || !v.opts.AutoGray || v.opts.Blurhash {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	data, _ := io.ReadAll(io.LimitReader(file, grayscaleHeaderBytes))
+	setGrayscaleHeader(w, cfg, v, data)
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// checkDimensions validates the output geometry. fit must be one of
+// processor.FitModes. w and h must be positive integers, and values below
+// MIN_OUTPUT_WIDTH/MIN_OUTPUT_HEIGHT are raised to the minimum, or rejected
+// when MIN_OUTPUT_STRICT is set. Raised values end up in params, so every
+// junk size shares the cache entry of the minimum.
+func checkDimensions(cfg config.Config, params url.Values) (url.Values, error) {
+	if fit := strings.ToLower(params.Get("fit")); fit != "" && !slices.Contains(processor.FitModes, fit) {
+		return params, fmt.Errorf("fit must be one of %s", strings.Join(processor.FitModes, ", "))
+	}
+	for _, d := range []struct {
+		param string
+		min   int
+	}{{"w", cfg.MinOutputWidth}, {"h", cfg.MinOutputHeight}} {
+		value := params.Get(d.param)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return params, fmt.Errorf("%s must be a positive integer", d.param)
+		}
+		if n >= d.min {
+			continue
+		}
+		if cfg.MinOutputStrict {
+			return params, fmt.Errorf("%s must be at least %d", d.param, d.min)
+		}
+		params.Set(d.param, strconv.Itoa(d.min))
+	}
+	return params, nil
+}
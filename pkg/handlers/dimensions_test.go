@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/CodeTease/quirm/pkg/config"
+)
+
+func TestCheckDimensions(t *testing.T) {
+	lenient := config.Config{MinOutputWidth: 16, MinOutputHeight: 16}
+	strict := config.Config{MinOutputWidth: 16, MinOutputHeight: 16, MinOutputStrict: true}
+
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		query   string
+		want    string // params after the check; unused on error
+		wantErr bool   // answered with 400
+	}{
+		{name: "valid", cfg: lenient, query: "w=300&h=200", want: "h=200&w=300"},
+		{name: "unset", cfg: lenient, query: "fit=cover", want: "fit=cover"},
+		{name: "zero width", cfg: lenient, query: "w=0&h=200", wantErr: true},
+		{name: "negative width", cfg: lenient, query: "w=-300", wantErr: true},
+		{name: "negative height", cfg: lenient, query: "w=300&h=-1", wantErr: true},
+		{name: "non-numeric", cfg: lenient, query: "w=abc", wantErr: true},
+		{name: "fractional", cfg: lenient, query: "h=2.5", wantErr: true},
+		{name: "unknown fit", cfg: lenient, query: "w=300&fit=stretch", wantErr: true},
+		{name: "fit case-insensitive", cfg: lenient, query: "w=300&fit=COVER", want: "fit=COVER&w=300"},
+		{name: "below minimum clamped", cfg: lenient, query: "w=1&h=8", want: "h=16&w=16"},
+		{name: "at minimum", cfg: strict, query: "w=16&h=16", want: "h=16&w=16"},
+		{name: "below minimum strict", cfg: strict, query: "w=8", wantErr: true},
+		{name: "height below minimum strict", cfg: strict, query: "w=300&h=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := checkDimensions(tt.cfg, params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkDimensions(%s) error = %v, want error %v", tt.query, err, tt.wantErr)
+			}
+			if !tt.wantErr && got.Encode() != tt.want {
+				t.Errorf("checkDimensions(%s) = %s, want %s", tt.query, got.Encode(), tt.want)
+			}
+		})
+	}
+}
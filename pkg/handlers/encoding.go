@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+)
+
+// supportedEncodings are the content codings quirm can store passthrough files in.
+var supportedEncodings = map[string]bool{
+	"br":   true,
+	"gzip": true,
+	"zstd": true,
+}
+
+// negotiateEncoding picks the content coding for a passthrough response from
+// the client's Accept-Encoding header (RFC 9110 section 12.5.3). The highest
+// q-value wins; ties are broken by the configured preference order. Codings
+// with q=0 are never chosen, "*" covers codings the client did not list, and
+// "identity" is returned when nothing better is acceptable.
+func negotiateEncoding(acceptEncoding string, preference []string) string {
+	if acceptEncoding == "" {
+		return "identity"
+	}
+
+	qualities := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, q, ok := parseEncodingQuality(part)
+		if !ok {
+			continue
+		}
+		if coding == "*" {
+			wildcard = q
+			continue
+		}
+		qualities[coding] = q
+	}
+
+	best, bestQ := "identity", 0.0
+	for _, coding := range preference {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if !supportedEncodings[coding] {
+			continue
+		}
+		q, listed := qualities[coding]
+		if !listed {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// parseEncodingQuality splits one Accept-Encoding element such as "br;q=0.8"
+// into its lower-cased coding and quality (1 when omitted).
+func parseEncodingQuality(part string) (string, float64, bool) {
+	coding, params, _ := strings.Cut(part, ";")
+	coding = strings.ToLower(strings.TrimSpace(coding))
+	if coding == "" {
+		return "", 0, false
+	}
+
+	q := 1.0
+	for _, param := range strings.Split(params, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return "", 0, false
+		}
+		q = parsed
+	}
+	return coding, q, true
+}
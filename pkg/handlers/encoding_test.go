@@ -0,0 +1,36 @@
+package handlers
+
+import "testing"
+
+func TestNegotiateEncoding(t *testing.T) {
+	preference := []string{"zstd", "br", "gzip"}
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		want           string
+	}{
+		{"no header", "", "identity"},
+		{"identity only", "identity", "identity"},
+		{"identity preferred over nothing", "identity;q=1, deflate", "identity"},
+		{"single coding", "gzip", "gzip"},
+		{"case-insensitive", "GZip", "gzip"},
+		{"ties follow the preference", "gzip, br", "br"},
+		{"higher q wins", "br;q=0.5, gzip", "gzip"},
+		{"wildcard", "*", "zstd"},
+		{"wildcard covers unlisted codings", "*;q=0.5, gzip;q=0.8", "gzip"},
+		{"wildcard with a rejection", "*, zstd;q=0", "br"},
+		{"all rejected", "gzip;q=0, br;q=0, zstd;q=0", "identity"},
+		{"wildcard rejected", "*;q=0", "identity"},
+		{"wildcard rejected, one allowed", "*;q=0, gzip", "gzip"},
+		{"invalid q ignored", "br;q=2, gzip", "gzip"},
+		{"unsupported coding", "deflate, compress", "identity"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := negotiateEncoding(tt.acceptEncoding, preference); got != tt.want {
+				t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.acceptEncoding, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,19 @@
 package handlers
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+	"github.com/CodeTease/quirm/pkg/storage"
+)
+
+// errDiskUnavailable is returned by the passthrough path while the disk cache
+// cannot be written; the object is then streamed from the origin instead.
+var errDiskUnavailable = errors.New("disk cache unavailable")
 
 type FileSizeError struct {
 	MaxSizeMB int64
@@ -9,3 +22,67 @@ type FileSizeError struct {
 func (e *FileSizeError) Error() string {
 	return fmt.Sprintf("file size exceeds limit of %d MB", e.MaxSizeMB)
 }
+
+// passthroughSizeError is returned for originals above MAX_PASSTHROUGH_BYTES
+// outside LARGE_PASSTHROUGH_PREFIXES.
+type passthroughSizeError struct {
+	size  int64
+	limit int64
+}
+
+func (e *passthroughSizeError) Error() string {
+	return fmt.Sprintf("object of %d bytes exceeds the passthrough limit of %d bytes", e.size, e.limit)
+}
+
+// checkPassthroughSize refuses to pass through an original of size bytes
+// when it is above MAX_PASSTHROUGH_BYTES and objectKey is not under one of
+// LARGE_PASSTHROUGH_PREFIXES. Unknown sizes (0) are let through.
+func checkPassthroughSize(cfg config.Config, objectKey string, size int64) error {
+	if cfg.MaxPassthroughBytes <= 0 || size <= cfg.MaxPassthroughBytes || matchesPrefix(cfg.LargePassthroughPrefixes, objectKey) {
+		return nil
+	}
+	return &passthroughSizeError{size: size, limit: cfg.MaxPassthroughBytes}
+}
+
+// writePassthroughTooLarge answers 403 with the limit when err is a
+// passthroughSizeError, and returns true; other errors are left to the
+// caller.
+func writePassthroughTooLarge(w http.ResponseWriter, err error) bool {
+	var sizeErr *passthroughSizeError
+	if !errors.As(err, &sizeErr) {
+		return false
+	}
+	writeLimitViolation(w, http.StatusForbidden, "passthrough_bytes", limitViolation{
+		Error: "object too large to be served unprocessed",
+		Field: "size",
+		Limit: sizeErr.limit,
+	})
+	return true
+}
+
+// writeOriginAccessError handles origin errors caused by the storage
+// configuration rather than the request, such as an SSE-KMS key policy that
+// does not grant quirm access. It logs them distinctly, answers 502 and
+// returns true; other errors are left to the caller.
+func writeOriginAccessError(w http.ResponseWriter, objectKey string, err error) bool {
+	var kmsErr *storage.KMSAccessError
+	if errors.As(err, &kmsErr) {
+		slog.Error("Origin denied access: check the KMS key policy", "objectKey", objectKey, "error", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return true
+	}
+	return false
+}
+
+// writeFFmpegBusy answers 503 with a Retry-After when err comes from a video
+// operation that found no free ffmpeg slot, and returns true; other errors
+// are left to the caller.
+func writeFFmpegBusy(w http.ResponseWriter, objectKey string, err error) bool {
+	if !errors.Is(err, processor.ErrFFmpegBusy) {
+		return false
+	}
+	slog.Warn("Video processing rejected: all ffmpeg slots busy", "objectKey", objectKey)
+	w.Header().Set("Retry-After", "5")
+	http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	return true
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	errLinkExpired     = errors.New("link expired")
+	errInvalidExpires  = errors.New("invalid expires")
+	errLifetimeTooLong = errors.New("expires is further out than MAX_URL_LIFETIME allows")
+)
+
+// checkExpires validates an "expires" value (Unix time) against now. Links
+// count as expired skew after their expiry, so clocks slightly ahead of the
+// signer do not reject fresh links. With maxLifetime set, expiries further
+// than now+maxLifetime (plus skew) are rejected as well.
+func checkExpires(value string, now time.Time, skew, maxLifetime time.Duration) error {
+	expires, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return errInvalidExpires
+	}
+	at := time.Unix(expires, 0)
+	if now.After(at.Add(skew)) {
+		return errLinkExpired
+	}
+	if maxLifetime > 0 && at.After(now.Add(maxLifetime+skew)) {
+		return errLifetimeTooLong
+	}
+	return nil
+}
+
+// withExpires enforces the "expires" parameter: always on signed requests,
+// where the signature covers it, and on any request with ENFORCE_EXPIRES.
+// MAX_URL_LIFETIME only applies to signed requests, as it bounds how long a
+// leaked signature stays usable.
+func (h *Handler) withExpires(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.ConfigManager.Get()
+
+		// Invalid paths are rejected by serveAsset
+		_, pathParams, ok := requestTarget(cfg, r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		query := r.URL.Query()
+		params := mergePathOptions(pathParams, query)
+		signed := cfg.SecretKey != "" && query.Has("s")
+		if !params.Has("expires") || (!signed && !cfg.EnforceExpires) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var maxLifetime time.Duration
+		if signed {
+			maxLifetime = cfg.MaxURLLifetime
+		}
+		if err := checkExpires(params.Get("expires"), time.Now(), cfg.ExpiresClockSkew, maxLifetime); err != nil {
+			writeExpiresError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeExpiresError answers expired links with 410 Gone, which clients can
+// tell apart from a 403 for a bad signature.
+func writeExpiresError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errLinkExpired):
+		setCacheControl(w)
+		http.Error(w, "Link expired", http.StatusGone)
+	case errors.Is(err, errLifetimeTooLong):
+		http.Error(w, "Link lifetime exceeds the allowed maximum", http.StatusForbidden)
+	default:
+		http.Error(w, "Invalid expires", http.StatusBadRequest)
+	}
+}
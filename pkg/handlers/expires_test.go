@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCheckExpires(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	at := func(d time.Duration) string { return strconv.FormatInt(now.Add(d).Unix(), 10) }
+	const skew = time.Minute
+
+	tests := []struct {
+		name        string
+		value       string
+		maxLifetime time.Duration
+		want        error
+		status      int
+	}{
+		{name: "valid", value: at(time.Hour), status: http.StatusOK},
+		{name: "expiring now", value: at(0), status: http.StatusOK},
+		{name: "within skew", value: at(-30 * time.Second), status: http.StatusOK},
+		{name: "at the skew limit", value: at(-skew), status: http.StatusOK},
+		{name: "expired", value: at(-skew - time.Second), want: errLinkExpired, status: http.StatusGone},
+		{name: "long expired", value: at(-24 * time.Hour), want: errLinkExpired, status: http.StatusGone},
+		{name: "within MAX_URL_LIFETIME", value: at(time.Hour), maxLifetime: time.Hour, status: http.StatusOK},
+		{name: "lifetime within skew", value: at(time.Hour + 30*time.Second), maxLifetime: time.Hour, status: http.StatusOK},
+		{name: "beyond MAX_URL_LIFETIME", value: at(2 * time.Hour), maxLifetime: time.Hour, want: errLifetimeTooLong, status: http.StatusForbidden},
+		{name: "far future", value: "9999999999", maxLifetime: 168 * time.Hour, want: errLifetimeTooLong, status: http.StatusForbidden},
+		{name: "far future without a maximum", value: "9999999999", status: http.StatusOK},
+		{name: "not a number", value: "tomorrow", want: errInvalidExpires, status: http.StatusBadRequest},
+		{name: "empty", value: "", want: errInvalidExpires, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkExpires(tt.value, now, skew, tt.maxLifetime)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("checkExpires(%q) = %v, want %v", tt.value, err, tt.want)
+			}
+			if err == nil {
+				return
+			}
+			w := httptest.NewRecorder()
+			writeExpiresError(w, err)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
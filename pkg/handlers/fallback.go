@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// fileClass returns the class of objectKey DEFAULT_IMAGES rules match
+// against, or "" for files that are neither images nor videos.
+func fileClass(objectKey string) string {
+	switch {
+	case isVideoFile(objectKey):
+		return config.FileClassVideo
+	case isImageFile(objectKey):
+		return config.FileClassImage
+	}
+	return ""
+}
+
+// defaultImageFor returns the default image for a missing objectKey: the
+// path of the first DEFAULT_IMAGES rule matching its prefix and class, or
+// DEFAULT_IMAGE_PATH. It returns "" when there is none.
+func defaultImageFor(cfg config.Config, objectKey string) string {
+	class := fileClass(objectKey)
+	for _, rule := range cfg.DefaultImages {
+		if rule.Class != "" && rule.Class != class {
+			continue
+		}
+		if strings.HasPrefix(objectKey, rule.Prefix) {
+			return rule.Path
+		}
+	}
+	return cfg.DefaultImagePath
+}
+
+// serveDefaultImage answers a request for a missing object with its default
+// image, processed with the requested options (size, fit, format...) when
+// the request is for a processed variant. It reports false when there is no
+// default image or it cannot be read, which the caller answers with 404:
+// a default image is never looked up in the origin or replaced by another
+// one, so a missing asset cannot cause a fallback loop.
+func (h *Handler) serveDefaultImage(w http.ResponseWriter, r *http.Request, cfg config.Config, objectKey string, v variant) bool {
+	path := defaultImageFor(cfg, objectKey)
+	if path == "" {
+		return false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Error("Default image unavailable", "path", path, "objectKey", objectKey, "error", err)
+		return false
+	}
+
+	if !v.shouldProcess || v.original {
+		http.ServeFile(w, r, path)
+		return true
+	}
+
+	// The default image is a still: video outputs become a thumbnail
+	opts := v.opts
+	opts.Animated = false
+	if opts.Format == "mp4" || opts.Format == "webm" {
+		opts.Format = ""
+	}
+	// Rendered defaults are shared by every missing key asking for the same
+	// options, and kept in the memory/Redis cache only
+	cacheKey := cache.GenerateKeyOptions("_default/"+path, opts.Canonical(), opts.Format)
+	if h.Cache != nil {
+		if cached, found := h.Cache.Get(r.Context(), cacheKey); found {
+			w.Header().Set("Content-Type", processedContentType(cached, path, opts))
+			w.Write(cached)
+			return true
+		}
+	}
+	buf, err := processor.Process(r.Context(), bytes.NewReader(data), opts, nil, 0, path)
+	if err != nil {
+		// Better the default image as it is than a 404
+		slog.Warn("Failed to process default image", "path", path, "error", err)
+		setContentType(w, path, "")
+		w.Write(data)
+		return true
+	}
+	out := buf.Bytes()
+	if h.Cache != nil {
+		h.Cache.Set(r.Context(), cacheKey, out, 0)
+	}
+	w.Header().Set("Content-Type", processedContentType(out, path, opts))
+	w.Write(out)
+	return true
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/config"
+)
+
+// Request filter reasons, the "reason" label of quirm_filtered_requests_total.
+const (
+	filterExtension = "extension"
+	filterPattern   = "pattern"
+)
+
+// filterKey tells why objectKey is never served (SERVABLE_EXTENSIONS,
+// DENIED_KEY_PATTERN), or returns "" when it may be. Filtered keys are
+// answered 404 before any origin request, so scanner probes cost nothing
+// upstream.
+func filterKey(cfg config.Config, objectKey string) string {
+	if cfg.DeniedKeyRegexp != nil && cfg.DeniedKeyRegexp.MatchString(objectKey) {
+		return filterPattern
+	}
+	if len(cfg.ServableExtensions) == 0 || slices.Contains(cfg.ServableExtensions, "*") {
+		return ""
+	}
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(objectKey)), ".")
+	if !slices.Contains(cfg.ServableExtensions, ext) {
+		return filterExtension
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/CodeTease/quirm/pkg/audit"
+)
+
+// HandleCacheFlush empties the in-memory cache of this instance
+// (POST /_admin/cache/flush, admin only). The Redis cache is shared by every
+// instance and only flushed with scope=redis. The disk cache is left alone.
+func (h *Handler) HandleCacheFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	if h.Cache == nil {
+		http.Error(w, "Cache is not enabled", http.StatusNotFound)
+		return
+	}
+
+	scope := r.URL.Query().Get("scope")
+	if scope != "" && scope != "memory" && scope != "redis" {
+		http.Error(w, `scope must be "memory" or "redis"`, http.StatusBadRequest)
+		return
+	}
+	shared := scope == "redis"
+	h.audit(r, audit.CacheFlush, "", url.Values{"scope": {scope}})
+	if err := h.Cache.Flush(r.Context(), shared); err != nil {
+		slog.Error("Failed to flush cache", "shared", shared, "error", err)
+		http.Error(w, "Failed to flush cache", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Cache flushed", "shared", shared)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"flushed": true, "redis": shared})
+}
+
+// HandleRateLimitReset clears the rate limit of a client
+// (POST /_admin/ratelimit/reset?key=<ip>, admin only). IPv6 addresses are
+// mapped to their RATE_LIMIT_IPV6_PREFIX network like incoming requests.
+func (h *Handler) HandleRateLimitReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	if h.Limiter == nil {
+		http.Error(w, "Rate limiting is not enabled", http.StatusNotFound)
+		return
+	}
+
+	raw := r.URL.Query().Get("key")
+	if raw == "" {
+		http.Error(w, "Missing key", http.StatusBadRequest)
+		return
+	}
+	key := addrKey(h.ConfigManager.Get(), raw)
+	h.audit(r, audit.RateLimitReset, "", url.Values{"key": {key}})
+	if err := h.Limiter.Reset(key); err != nil {
+		slog.Error("Failed to reset rate limit", "key", key, "error", err)
+		http.Error(w, "Failed to reset rate limit", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"reset": key})
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"context"
+	"io"
+
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// optimizeGIFAndSave re-encodes the GIF at objectKey at its own size
+// ("anim.gif?optimize=true") and stores the result at destPath. GIFs the
+// optimizer leaves alone are stored as they are, so the variant never grows.
+func (h *Handler) optimizeGIFAndSave(ctx context.Context, objectKey, destPath string, opts processor.ImageOptions) ([]byte, error) {
+	cfg := h.ConfigManager.Get()
+
+	reader, info, err := h.S3.GetObjectIfNoneMatch(ctx, objectKey, "")
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	recordSource(ctx, info)
+	if cfg.MaxImageSizeMB > 0 && info.Size > cfg.MaxImageSizeMB*1024*1024 {
+		return nil, &FileSizeError{MaxSizeMB: cfg.MaxImageSizeMB}
+	}
+	original, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := processor.OptimizeGIF(original, processor.GIFOptimizeOptions{
+		MaxFrames:    cfg.GIFOptimizeMaxFrames,
+		MaxDimension: cfg.GIFOptimizeMaxDimension,
+		Quality:      opts.Quality,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := h.saveProcessed(destPath, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// isVideoConversion reports whether a request transcodes an animated GIF to
+// a video container ("giant.gif?format=mp4").
+func isVideoConversion(objectKey string, opts processor.ImageOptions) bool {
+	return strings.EqualFold(filepath.Ext(objectKey), ".gif") && processor.IsVideoFormat(opts.Format)
+}
+
+// convertAnimatedAndSave transcodes the GIF at objectKey to the requested
+// video format with ffmpeg and stores the result at destPath.
+func (h *Handler) convertAnimatedAndSave(ctx context.Context, objectKey, destPath string, opts processor.ImageOptions) ([]byte, error) {
+	cfg := h.ConfigManager.Get()
+
+	// ffmpeg may stream the original from a presigned URL, so the size limit
+	// is checked up front
+	if cfg.MaxImageSizeMB > 0 {
+		info, err := h.S3.StatObject(ctx, objectKey)
+		if err != nil {
+			return nil, err
+		}
+		recordSource(ctx, info)
+		if info.Size > cfg.MaxImageSizeMB*1024*1024 {
+			return nil, &FileSizeError{MaxSizeMB: cfg.MaxImageSizeMB}
+		}
+	}
+
+	data, err := h.withVideoInput(ctx, objectKey, true, func(input string) ([]byte, error) {
+		opts := sourceHints(ctx, cfg, opts)
+		buf, err := processor.ConvertAnimatedToVideo(ctx, input, processor.VideoConversionOptions{
+			Format:    opts.Format,
+			Width:     opts.Width,
+			Height:    opts.Height,
+			MaxFrames: cfg.AnimatedVideoMaxFrames,
+			MaxWidth:  opts.MaxWidth,
+			MaxHeight: opts.MaxHeight,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := h.saveProcessed(destPath, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// headerPrefixes are the growing prefixes of an original tried in turn to
+// read its header. Most formats need far less than the first one.
+var headerPrefixes = []int64{64 << 10, 512 << 10, 4 << 20}
+
+// readHeader reads the format and dimensions of an original of the given
+// size from a prefix fetched with a range GET, growing the prefix while the
+// header is incomplete. When the backend fails the range request, or no
+// prefix is enough, the whole object is fetched instead.
+func (h *Handler) readHeader(ctx context.Context, objectKey string, size int64) (processor.ImageHeader, error) {
+	var fetched int64
+	for _, n := range headerPrefixes {
+		if size > 0 && n >= size {
+			break
+		}
+		data, err := h.readRange(ctx, objectKey, n)
+		if err != nil {
+			if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "404") {
+				return processor.ImageHeader{}, err
+			}
+			slog.Debug("Range GET failed, fetching the whole object", "objectKey", objectKey, "error", err)
+			break
+		}
+		fetched += int64(len(data))
+		if hdr, err := processor.ReadHeader(data); err == nil {
+			if size > fetched {
+				metrics.OriginRangeBytesSaved.Add(float64(size - fetched))
+			}
+			return hdr, nil
+		}
+		if int64(len(data)) < n {
+			// The prefix was the whole object
+			return processor.ReadHeader(data)
+		}
+	}
+
+	reader, _, err := h.S3.GetObject(ctx, objectKey)
+	if err != nil {
+		return processor.ImageHeader{}, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return processor.ImageHeader{}, err
+	}
+	return processor.ReadHeader(data)
+}
+
+func (h *Handler) readRange(ctx context.Context, objectKey string, n int64) ([]byte, error) {
+	body, err := h.S3.GetObjectRange(ctx, objectKey, 0, n)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(io.LimitReader(body, n))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty range")
+	}
+	return data, nil
+}
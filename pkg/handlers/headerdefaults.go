@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/CodeTease/quirm/pkg/config"
+)
+
+// headerDefaults maps the headers internal callers set defaults with to the
+// parameters they default.
+var headerDefaults = []struct{ header, param string }{
+	{"X-Quirm-Default-Quality", "q"},
+	{"X-Quirm-Default-Format", "format"},
+	{"X-Quirm-Default-Bg", "bg"},
+}
+
+// headerDefaultsVary is the Vary value of responses when header defaults are
+// allowed.
+const headerDefaultsVary = "X-Quirm-Default-Quality, X-Quirm-Default-Format, X-Quirm-Default-Bg"
+
+// applyHeaderDefaults fills in q, format and bg from the X-Quirm-Default-*
+// headers where the URL leaves them unset (ALLOW_HEADER_DEFAULTS). Only
+// requests with the admin token or from ALLOWED_CIDRS may set them. The
+// defaults end up in params, so they are part of the cache key like any
+// parameter.
+func applyHeaderDefaults(cfg config.Config, r *http.Request, params url.Values) url.Values {
+	if !cfg.AllowHeaderDefaults || (!hasAdminToken(cfg, r) && !isTrustedIP(cfg, clientAddr(cfg, r))) {
+		return params
+	}
+	for _, d := range headerDefaults {
+		value := r.Header.Get(d.header)
+		if value == "" || len(value) > maxParamLength || params.Get(d.param) != "" {
+			continue
+		}
+		params.Set(d.param, value)
+	}
+	return params
+}
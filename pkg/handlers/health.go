@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// capabilities lists the optional features a client may use.
+type capabilities struct {
+	Formats         []string `json:"formats"`
+	VideoThumbnails bool     `json:"video_thumbnails"`
+	FaceDetection   bool     `json:"face_detection"`
+	AISmartCrop     bool     `json:"ai_smart_crop"`
+}
+
+// probeCapabilities combines the detected processor capabilities with the
+// features enabled in cfg.
+func probeCapabilities(cfg config.Config) capabilities {
+	detected := processor.DetectCapabilities()
+	return capabilities{
+		Formats:         detected.Formats,
+		VideoThumbnails: cfg.EnableVideoThumbnail && detected.FFmpeg,
+		FaceDetection:   detected.FaceDetection,
+		AISmartCrop:     cfg.AIModelPath != "",
+	}
+}
+
+// HandleHealth checks connectivity to the storage backend and the cache
+// (Redis if configured).
+func (h *Handler) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	status := "ok"
+	statusCode := http.StatusOK
+	details := make(map[string]string)
+
+	// Check S3
+	if err := h.S3.Health(ctx); err != nil {
+		status = "error"
+		statusCode = http.StatusServiceUnavailable
+		details["s3"] = err.Error()
+		slog.Error("Health check failed: S3", "error", err)
+	} else {
+		details["s3"] = "ok"
+	}
+
+	// Check Cache (Redis if configured)
+	if h.Cache != nil {
+		if err := h.Cache.Health(ctx); err != nil {
+			status = "error"
+			statusCode = http.StatusServiceUnavailable
+			details["cache"] = err.Error()
+			slog.Error("Health check failed: Cache", "error", err)
+		} else {
+			details["cache"] = "ok"
+		}
+	}
+
+	// A degraded disk cache still serves requests, so it does not fail the check
+	if degraded, err := h.Disk.Status(); degraded {
+		if status == "ok" {
+			status = "degraded"
+		}
+		details["disk"] = err.Error()
+	} else {
+		details["disk"] = "ok"
+	}
+
+	// Face crops fall back to center crops without the cascade. A file that
+	// is there but unusable is a misconfiguration and degrades the check; a
+	// missing one only means face detection is not set up.
+	if cfg := h.ConfigManager.Get(); cfg.FaceFinderPath != "" {
+		if err := processor.FaceDetectionError(); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) && status == "ok" {
+				status = "degraded"
+			}
+			details["face_detection"] = err.Error()
+		} else {
+			details["face_detection"] = "ok"
+		}
+	}
+
+	// Images keep being served when the watermark is broken, so it does not
+	// fail the check either; the last good image stays in use if there is one
+	if h.WM != nil {
+		// Get picks up a replaced file, as a request would
+		h.WM.Get()
+		if wm := h.WM.Status(); wm.Path != "" {
+			if wm.LastError != "" && status == "ok" {
+				status = "degraded"
+			}
+			switch {
+			case wm.LastError == "":
+				details["watermark"] = "ok"
+			case wm.Loaded:
+				details["watermark"] = "previous image in use: " + wm.LastError
+			default:
+				details["watermark"] = wm.LastError
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	detailsJSON, _ := json.Marshal(details)
+	capsJSON, _ := json.Marshal(probeCapabilities(h.ConfigManager.Get()))
+	fmt.Fprintf(w, `{"status": "%s", "details": %s, "capabilities": %s}`, status, string(detailsJSON), string(capsJSON))
+}
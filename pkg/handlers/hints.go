@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// applyClientHints adjusts the options for clients that ask to save data
+// (Save-Data: on) or report a slow connection (ECT: 2g / slow-2g). Saving
+// data lowers the quality and turns JPEG output into the smallest format
+// the client accepts and policy allows; a slow connection caps the width,
+// also of outputs that keep the source's. It returns the buckets that were
+// applied, to be folded into the cache key; raw hint values never reach the
+// key so variants stay few.
+func applyClientHints(cfg config.Config, opts *processor.ImageOptions, header http.Header, policy *config.TransformPolicy) []string {
+	var buckets []string
+
+	if strings.EqualFold(strings.TrimSpace(header.Get("Save-Data")), "on") {
+		quality := opts.Quality
+		if quality == 0 {
+			quality = 80
+		}
+		quality -= cfg.SaveDataQualityDelta
+		if quality < 10 {
+			quality = 10
+		}
+		opts.Quality = quality
+		// Auto-format already picked the smallest format for requests without
+		// one; an asked-for JPEG is the largest of the lossy formats
+		if normalizeFormat(opts.Format) == "jpeg" {
+			if format := negotiateFormat(header.Get("Accept"), policy); format != "" {
+				opts.Format = format
+			}
+		}
+		buckets = append(buckets, "savedata")
+	}
+
+	switch strings.ToLower(strings.TrimSpace(header.Get("ECT"))) {
+	case "2g", "slow-2g":
+		max := cfg.SlowNetworkMaxWidth
+		switch {
+		case max <= 0:
+		case opts.Width > max:
+			if opts.Height > 0 {
+				opts.Height = opts.Height * max / opts.Width
+			}
+			opts.Width = max
+			buckets = append(buckets, "slownet")
+		case opts.Width == 0 && (opts.MaxWidth == 0 || opts.MaxWidth > max):
+			// Without a width the output keeps the source's, or follows the height
+			opts.MaxWidth = max
+			buckets = append(buckets, "slownet")
+		}
+	}
+
+	return buckets
+}
+
+// widthHintHeaders are the client hints that drive the output width.
+var widthHintHeaders = []string{"Sec-CH-Width", "Sec-CH-Viewport-Width", "Sec-CH-DPR"}
+
+// applyWidthHints picks the output width from the Sec-CH-Width hint (already
+// in device pixels), or from Sec-CH-Viewport-Width times Sec-CH-DPR, rounded
+// up to the next configured bucket so the number of variants stays bounded.
+// It returns the bucket for the cache key, or nil when no hint was usable.
+func applyWidthHints(cfg config.Config, opts *processor.ImageOptions, header http.Header) []string {
+	width := parseHint(header.Get("Sec-CH-Width"))
+	if width == 0 {
+		dpr := parseHint(header.Get("Sec-CH-DPR"))
+		if dpr == 0 {
+			dpr = 1
+		}
+		width = parseHint(header.Get("Sec-CH-Viewport-Width")) * dpr
+	}
+	if width <= 0 {
+		return nil
+	}
+
+	bucket := bucketWidth(int(math.Ceil(width)), cfg.ClientHintWidths)
+	if bucket <= 0 {
+		return nil
+	}
+	opts.Width = bucket
+	return []string{"w" + strconv.Itoa(bucket)}
+}
+
+// bucketWidth rounds width up to the smallest bucket that fits it, capped at
+// the largest bucket. Without buckets the width is used as is.
+func bucketWidth(width int, buckets []int) int {
+	if len(buckets) == 0 {
+		return width
+	}
+	sorted := append([]int(nil), buckets...)
+	sort.Ints(sorted)
+	for _, b := range sorted {
+		if b >= width {
+			return b
+		}
+	}
+	return sorted[len(sorted)-1]
+}
+
+func parseHint(value string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || v < 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0
+	}
+	return v
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+func TestApplyClientHints(t *testing.T) {
+	cfg := config.Config{SaveDataQualityDelta: 20, SlowNetworkMaxWidth: 640}
+	webpOnly := &config.TransformPolicy{Formats: []string{"webp", "jpeg"}}
+
+	tests := []struct {
+		name    string
+		opts    processor.ImageOptions
+		header  http.Header
+		policy  *config.TransformPolicy
+		want    processor.ImageOptions
+		buckets []string
+	}{
+		{
+			name: "no hints",
+			opts: processor.ImageOptions{Width: 1200, Format: "jpeg"},
+			want: processor.ImageOptions{Width: 1200, Format: "jpeg"},
+		},
+		{
+			name:    "save-data lowers the default quality",
+			opts:    processor.ImageOptions{Width: 300, Format: "avif"},
+			header:  http.Header{"Save-Data": {"on"}},
+			want:    processor.ImageOptions{Width: 300, Format: "avif", Quality: 60},
+			buckets: []string{"savedata"},
+		},
+		{
+			name:    "save-data keeps quality above 10",
+			opts:    processor.ImageOptions{Quality: 25},
+			header:  http.Header{"Save-Data": {"on"}},
+			want:    processor.ImageOptions{Quality: 10},
+			buckets: []string{"savedata"},
+		},
+		{
+			name:    "save-data turns jpeg into avif",
+			opts:    processor.ImageOptions{Format: "jpg"},
+			header:  http.Header{"Save-Data": {"on"}, "Accept": {"image/avif,image/webp,*/*"}},
+			want:    processor.ImageOptions{Format: "avif", Quality: 60},
+			buckets: []string{"savedata"},
+		},
+		{
+			name:    "save-data respects the policy formats",
+			opts:    processor.ImageOptions{Format: "jpeg"},
+			header:  http.Header{"Save-Data": {"on"}, "Accept": {"image/avif,image/webp,*/*"}},
+			policy:  webpOnly,
+			want:    processor.ImageOptions{Format: "webp", Quality: 60},
+			buckets: []string{"savedata"},
+		},
+		{
+			name:    "save-data keeps jpeg for clients without alternatives",
+			opts:    processor.ImageOptions{Format: "jpeg"},
+			header:  http.Header{"Save-Data": {"on"}, "Accept": {"image/*"}},
+			want:    processor.ImageOptions{Format: "jpeg", Quality: 60},
+			buckets: []string{"savedata"},
+		},
+		{
+			name:    "save-data keeps png",
+			opts:    processor.ImageOptions{Format: "png"},
+			header:  http.Header{"Save-Data": {"on"}, "Accept": {"image/avif"}},
+			want:    processor.ImageOptions{Format: "png", Quality: 60},
+			buckets: []string{"savedata"},
+		},
+		{
+			name:    "2g caps the width and scales the height",
+			opts:    processor.ImageOptions{Width: 1280, Height: 720},
+			header:  http.Header{"Ect": {"2g"}},
+			want:    processor.ImageOptions{Width: 640, Height: 360},
+			buckets: []string{"slownet"},
+		},
+		{
+			name:    "slow-2g caps outputs without a width",
+			opts:    processor.ImageOptions{Height: 900},
+			header:  http.Header{"Ect": {"slow-2g"}},
+			want:    processor.ImageOptions{Height: 900, MaxWidth: 640},
+			buckets: []string{"slownet"},
+		},
+		{
+			name:    "2g lowers a larger maximum width",
+			opts:    processor.ImageOptions{MaxWidth: 1000},
+			header:  http.Header{"Ect": {"2g"}},
+			want:    processor.ImageOptions{MaxWidth: 640},
+			buckets: []string{"slownet"},
+		},
+		{
+			name:   "2g keeps a smaller maximum width",
+			opts:   processor.ImageOptions{MaxWidth: 400},
+			header: http.Header{"Ect": {"2g"}},
+			want:   processor.ImageOptions{MaxWidth: 400},
+		},
+		{
+			name:   "2g keeps narrow outputs",
+			opts:   processor.ImageOptions{Width: 320},
+			header: http.Header{"Ect": {"2g"}},
+			want:   processor.ImageOptions{Width: 320},
+		},
+		{
+			name:   "4g changes nothing",
+			opts:   processor.ImageOptions{Width: 1280},
+			header: http.Header{"Ect": {"4g"}},
+			want:   processor.ImageOptions{Width: 1280},
+		},
+		{
+			name:    "save-data on 2g",
+			opts:    processor.ImageOptions{Width: 1280, Format: "jpeg"},
+			header:  http.Header{"Save-Data": {"on"}, "Ect": {"2g"}, "Accept": {"image/webp"}},
+			want:    processor.ImageOptions{Width: 640, Format: "webp", Quality: 60},
+			buckets: []string{"savedata", "slownet"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			buckets := applyClientHints(cfg, &opts, tt.header, tt.policy)
+			if opts != tt.want {
+				t.Errorf("options = %+v, want %+v", opts, tt.want)
+			}
+			if !slices.Equal(buckets, tt.buckets) {
+				t.Errorf("buckets = %v, want %v", buckets, tt.buckets)
+			}
+		})
+	}
+}
+
+func TestBucketWidth(t *testing.T) {
+	buckets := []int{1280, 320, 640}
+	tests := []struct {
+		width   int
+		buckets []int
+		want    int
+	}{
+		{width: 1, buckets: buckets, want: 320},
+		{width: 320, buckets: buckets, want: 320},
+		{width: 321, buckets: buckets, want: 640},
+		{width: 1000, buckets: buckets, want: 1280},
+		{width: 1280, buckets: buckets, want: 1280},
+		{width: 4000, buckets: buckets, want: 1280},
+		{width: 777, want: 777},
+	}
+	for _, tt := range tests {
+		if got := bucketWidth(tt.width, tt.buckets); got != tt.want {
+			t.Errorf("bucketWidth(%d, %v) = %d, want %d", tt.width, tt.buckets, got, tt.want)
+		}
+	}
+}
+
+func TestApplyWidthHints(t *testing.T) {
+	cfg := config.Config{ClientHintWidths: []int{320, 640, 960, 1280, 1920}}
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		want    int
+		buckets []string
+	}{
+		{name: "no hints"},
+		{name: "width", header: http.Header{"Sec-Ch-Width": {"500"}}, want: 640, buckets: []string{"w640"}},
+		{name: "width on a bucket", header: http.Header{"Sec-Ch-Width": {"960"}}, want: 960, buckets: []string{"w960"}},
+		{name: "fractional width rounds up", header: http.Header{"Sec-Ch-Width": {"320.5"}}, want: 640, buckets: []string{"w640"}},
+		{name: "width capped at the largest bucket", header: http.Header{"Sec-Ch-Width": {"3000"}}, want: 1920, buckets: []string{"w1920"}},
+		{name: "viewport", header: http.Header{"Sec-Ch-Viewport-Width": {"400"}}, want: 640, buckets: []string{"w640"}},
+		{name: "viewport times DPR", header: http.Header{"Sec-Ch-Viewport-Width": {"400"}, "Sec-Ch-Dpr": {"2"}}, want: 960, buckets: []string{"w960"}},
+		{name: "fractional DPR", header: http.Header{"Sec-Ch-Viewport-Width": {"412"}, "Sec-Ch-Dpr": {"2.625"}}, want: 1280, buckets: []string{"w1280"}},
+		{name: "width beats viewport", header: http.Header{"Sec-Ch-Width": {"300"}, "Sec-Ch-Viewport-Width": {"1000"}, "Sec-Ch-Dpr": {"3"}}, want: 320, buckets: []string{"w320"}},
+		{name: "DPR alone", header: http.Header{"Sec-Ch-Dpr": {"2"}}},
+		{name: "malformed", header: http.Header{"Sec-Ch-Width": {"wide"}}},
+		{name: "negative", header: http.Header{"Sec-Ch-Width": {"-500"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts processor.ImageOptions
+			buckets := applyWidthHints(cfg, &opts, tt.header)
+			if opts.Width != tt.want {
+				t.Errorf("width = %d, want %d", opts.Width, tt.want)
+			}
+			if !slices.Equal(buckets, tt.buckets) {
+				t.Errorf("buckets = %v, want %v", buckets, tt.buckets)
+			}
+		})
+	}
+}
+
+// TestWidthHintsVariant checks that an explicit width beats the hints and
+// that hinted widths share the variant of their bucket.
+func TestWidthHintsVariant(t *testing.T) {
+	h := &Handler{}
+	cfg := config.Config{ClientHints: true, ClientHintWidths: []int{320, 640, 960}}
+	resolve := func(params url.Values, width string) variant {
+		return h.resolveVariant(cfg, "photos/a.jpg", "", params, http.Header{"Sec-Ch-Width": {width}})
+	}
+
+	explicit := resolve(url.Values{"w": {"500"}}, "900")
+	if explicit.opts.Width != 500 {
+		t.Errorf("explicit w=500 with a hint of 900: width %d", explicit.opts.Width)
+	}
+	if other := resolve(url.Values{"w": {"500"}}, "300"); other.cacheKey != explicit.cacheKey {
+		t.Errorf("hints changed the cache key of an explicit width: %q, %q", explicit.cacheKey, other.cacheKey)
+	}
+
+	a, b := resolve(url.Values{}, "500"), resolve(url.Values{}, "600")
+	if a.opts.Width != 640 || b.opts.Width != 640 {
+		t.Errorf("hinted widths %d, %d, want 640", a.opts.Width, b.opts.Width)
+	}
+	if a.cacheKey != b.cacheKey {
+		t.Errorf("one bucket, two cache keys: %q, %q", a.cacheKey, b.cacheKey)
+	}
+	if c := resolve(url.Values{}, "700"); c.cacheKey == a.cacheKey {
+		t.Errorf("buckets 640 and 960 share the cache key %q", a.cacheKey)
+	}
+}
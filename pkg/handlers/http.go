@@ -4,18 +4,17 @@ import (
 	"bytes"
 	"context"
 	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
-	"net"
+	"maps"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
-	"sort"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,17 +22,24 @@ import (
 
 	"golang.org/x/sync/singleflight"
 
+	"github.com/CodeTease/quirm/pkg/audit"
 	"github.com/CodeTease/quirm/pkg/cache"
 	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/costlog"
+	"github.com/CodeTease/quirm/pkg/hooks"
 	"github.com/CodeTease/quirm/pkg/metrics"
+	"github.com/CodeTease/quirm/pkg/notify"
+	"github.com/CodeTease/quirm/pkg/peers"
 	"github.com/CodeTease/quirm/pkg/processor"
 	"github.com/CodeTease/quirm/pkg/ratelimit"
+	"github.com/CodeTease/quirm/pkg/sign"
+	"github.com/CodeTease/quirm/pkg/stats"
 	"github.com/CodeTease/quirm/pkg/storage"
+	"github.com/CodeTease/quirm/pkg/token"
+	"github.com/CodeTease/quirm/pkg/warmup"
 	"github.com/CodeTease/quirm/pkg/watermark"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/propagation"
-	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -55,243 +61,287 @@ type Handler struct {
 	CacheDir            string
 	Cache               cache.CacheProvider
 	Limiter             ratelimit.Limiter
+	Warmup              *warmup.Queue
+	RefreshLock         cache.Locker       // optional, deduplicates stale refreshes across instances
+	Costs               *costlog.Log       // optional, samples processing cost
+	Disk                *cache.DiskMonitor // optional, degrades to serving without the disk cache
+	Peers               *peers.Router      // optional, forwards processed variants to their owner
+	Tokens              *token.Verifier    // optional, memoizes bearer token verification
+	Stats               stats.Recorder     // optional, counts requests per object key
+	Notifier            *notify.Notifier   // optional, reports keys failing repeatedly
+	Hooks               *hooks.Dispatcher  // optional, runs hooks on processed variants
+	Audit               *audit.Log         // optional, records privileged operations
+	Variants            *cache.Variants    // optional, tracks the processed variants of each object
+	Access              *cache.AccessStats // optional, counts disk cache hits for LFU eviction
 	AllowedDomainsRegex []*regexp.Regexp
 	mu                  sync.Mutex
+
+	chainOnce sync.Once
+	chain     http.Handler
+
+	// background refreshes of stale entries
+	refreshOnce  sync.Once
+	refreshQueue *refreshQueue
+
+	// capabilities document, rebuilt when the config generation changes
+	capsMu  sync.Mutex
+	capsGen uint64
+	capsDoc []byte
+
+	// last /_selftest report, reused within SELFTEST_INTERVAL
+	selfTestMu sync.Mutex
+	selfTest   *selfTestReport
+
+	// requests per preset since start, for /_stats/presets
+	presetMu     sync.Mutex
+	presetCounts map[string]int64
 }
 
+// HandleRequest serves an asset through the full request chain.
 func (h *Handler) HandleRequest(w http.ResponseWriter, r *http.Request) {
-	// Start Tracing Span
-	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
-	tracer := otel.Tracer("quirm/http")
-	ctx, span := tracer.Start(ctx, "HandleRequest",
-		trace.WithAttributes(
-			semconv.HTTPMethodKey.String(r.Method),
-			semconv.HTTPURLKey.String(r.URL.String()),
-			semconv.UserAgentOriginalKey.String(r.UserAgent()),
-			attribute.String("client.ip", r.RemoteAddr),
-		),
-		trace.WithSpanKind(trace.SpanKindServer),
-	)
-	defer span.End()
-
-	// Update request with context
-	r = r.WithContext(ctx)
+	h.chainOnce.Do(func() {
+		h.chain = h.Chain()
+	})
+	h.chain.ServeHTTP(w, r)
+}
 
-	// Get current config
+// serveAsset is the core of the request chain: it resolves the requested
+// variant and serves it from memory, disk or the origin. Security, rate
+// limiting and signature checks have already run in the chain.
+func (h *Handler) serveAsset(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := trace.SpanFromContext(ctx)
 	cfg := h.ConfigManager.Get()
 
-	// Wrap writer if metrics enabled
-	var rec *statusRecorder
-	if cfg.EnableMetrics {
-		rec = &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
-		w = rec
+	if err := checkEncodedSlash(cfg, r.URL); err != nil {
+		http.Error(w, "Invalid Path: "+err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	start := time.Now()
-	defer func() {
-		if cfg.EnableMetrics {
-			duration := time.Since(start).Seconds()
-			status := strconv.Itoa(rec.statusCode)
-			pathLabel := "/{image}" // Generic placeholder as requested
-			metrics.HTTPRequestsTotal.WithLabelValues(r.Method, status, pathLabel).Inc()
-			metrics.HTTPRequestDuration.WithLabelValues(r.Method, status, pathLabel).Observe(duration)
-		}
-		if rec != nil {
-			span.SetAttributes(semconv.HTTPStatusCodeKey.Int(rec.statusCode))
+	// 0.55 Feature: Purge by prefix, before the filter as the path is a
+	// prefix, not an object key
+	if isPrefixPurge(r) {
+		prefix, ok := purgePrefix(cfg, r.URL.Path)
+		if !ok {
+			http.Error(w, "Invalid Path", http.StatusBadRequest)
+			return
 		}
-	}()
+		h.audit(r, audit.Purge, prefix, r.URL.Query())
+		h.handlePrefixPurge(w, r, prefix)
+		return
+	}
 
-	// 0. Security: IP/CIDR Allowlist
-	// If the IP is in the allowed CIDR list, we bypass Domain Whitelisting
-	ipAllowed := false
-	ip := r.RemoteAddr
-	if host, _, err := net.SplitHostPort(ip); err == nil {
-		ip = host
-	}
-
-	if len(cfg.AllowedCIDRNets) > 0 {
-		parsedIP := net.ParseIP(ip)
-		if parsedIP != nil {
-			for _, ipNet := range cfg.AllowedCIDRNets {
-				if ipNet.Contains(parsedIP) {
-					ipAllowed = true
-					break
-				}
-			}
-		}
+	objectKey, pathParams, ok := requestTarget(cfg, r.URL.Path)
+	if !ok {
+		http.Error(w, "Invalid Path", http.StatusBadRequest)
+		return
 	}
-	// Fallback check for exact IPs if any (though usually we use CIDRs)
-	// If needed we can check AllowedCIDRs strings too if they weren't valid CIDRs but might be IPs
 
-	// 0.1 Security: Domain Whitelisting
-	// Only check if IP is NOT explicitly allowed (and if domains are configured)
-	if !ipAllowed && len(cfg.AllowedDomains) > 0 {
-		referer := r.Header.Get("Referer")
-		origin := r.Header.Get("Origin")
-		domainAllowed := false
+	// Feature: Request filter (scanner probes never reach the origin)
+	if reason := filterKey(cfg, objectKey); reason != "" {
+		metrics.FilteredRequestsTotal.WithLabelValues(reason).Inc()
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
 
-		check := func(val string) bool {
-			if val == "" {
-				return false
-			}
-			u, err := url.Parse(val)
-			if err != nil {
-				return false
-			}
-			// Check exact/wildcard domains first
-			for _, d := range cfg.AllowedDomains {
-				if d == "*" {
-					return true
-				}
-				if !strings.HasPrefix(d, "^") && d == u.Host {
-					return true
-				}
-			}
-			// Check Regex
-			for _, re := range h.AllowedDomainsRegex {
-				if re.MatchString(u.Host) {
-					return true
-				}
-			}
-			return false
+	// Feature: Immutable URLs ("/<content-hash>/path/to/img.jpg")
+	// The hash is part of the cache key, so an entry built from an earlier
+	// object is never served under the current hash
+	contentHash, _ := splitVersion(cfg, r.URL.Path)
+	if contentHash != "" {
+		if !h.checkVersion(w, r, cfg, contentHash, objectKey) {
+			return
 		}
+	}
 
-		if referer != "" {
-			if check(referer) {
-				domainAllowed = true
-			}
-		}
-		if origin != "" {
-			if check(origin) {
-				domainAllowed = true
-			}
-		}
+	queryParams := r.URL.Query()
+	if violation := checkParamLengths(queryParams); violation != nil {
+		writeLimitViolation(w, http.StatusBadRequest, "param_length", *violation)
+		return
+	}
+	if violation := checkParamLengths(pathParams); violation != nil {
+		writeLimitViolation(w, http.StatusBadRequest, "param_length", *violation)
+		return
+	}
 
-		if referer == "" && origin == "" {
-			// If no referer/origin, we usually allow unless strict mode is on.
-			// Currently implementation allows it.
-			domainAllowed = true
-		}
+	// 1.5 Feature: Named Presets
+	// Presets are expanded into the query before anything else reads it, so every
+	// parameter (palette, storyboard, watermark toggles...) also works inside a preset.
+	// The signature is still checked against the URL as it was sent.
+	params, presetLabel, err := resolvePreset(cfg, mergePathOptions(pathParams, queryParams))
+	// Forwarded requests were counted by the replica that received them
+	if presetLabel != "" && r.Header.Get(peers.Header) == "" {
+		h.countPreset(presetLabel)
+	}
+	if err != nil {
+		http.Error(w, "Invalid preset: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	params, err = expandPresets(params, cfg.Presets)
+	if err != nil {
+		slog.Error("Invalid preset configuration", "error", err)
+		http.Error(w, "Invalid preset configuration", http.StatusInternalServerError)
+		return
+	}
 
-		if !domainAllowed && (referer != "" || origin != "") {
-			http.Error(w, "Forbidden Domain", http.StatusForbidden)
+	// 1.55 Feature: Untouched originals, for signed or trusted requests only
+	if wantsOriginal(params) && r.Method != http.MethodDelete {
+		if !originalAllowed(cfg, r) {
+			http.Error(w, "Originals require a signed URL", http.StatusForbidden)
 			return
 		}
-	} else if !ipAllowed && len(cfg.AllowedCIDRNets) > 0 && len(cfg.AllowedDomains) == 0 {
-		// If only CIDRs are configured and IP didn't match -> Forbidden
-		http.Error(w, "Forbidden IP", http.StatusForbidden)
-		return
+		h.audit(r, audit.OriginalAccess, objectKey, params)
 	}
 
-	// 0.2 Security: GeoIP
-	if len(cfg.AllowedCountries) > 0 {
-		country := r.Header.Get("CF-IPCountry")
-		if country == "" {
-			country = r.Header.Get("X-Country-Code")
-		}
+	// 1.6 Feature: Passthrough-only and process-only prefixes
+	params, err = applyZones(cfg, objectKey, params)
+	switch {
+	case errors.Is(err, errTransformsNotAllowed):
+		http.Error(w, "Transformations are not allowed for this path", http.StatusBadRequest)
+		return
+	case errors.Is(err, errOriginalNotAllowed):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	case err != nil:
+		slog.Error("Invalid preset configuration", "error", err)
+		http.Error(w, "Invalid preset configuration", http.StatusInternalServerError)
+		return
+	}
 
-		if country != "" {
-			allowed := false
-			for _, c := range cfg.AllowedCountries {
-				if strings.EqualFold(c, country) {
-					allowed = true
-					break
-				}
-			}
-			if !allowed {
-				http.Error(w, "Forbidden Country", http.StatusForbidden)
-				return
-			}
-		}
+	// 1.65 Output geometry, before the cache key is derived from it
+	if params, err = checkDimensions(cfg, params); err != nil {
+		http.Error(w, "Invalid parameter: "+err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// 0.5 Security: Rate Limiting
-	// IP is already extracted above
+	// 0.6 Feature: Purge Cache
+	if r.Method == http.MethodDelete {
+		h.audit(r, audit.Purge, objectKey, params)
+		h.handlePurge(w, r, objectKey, contentHash, params)
+		return
+	}
 
-	if cfg.RateLimit > 0 && h.Limiter != nil {
-		if !h.Limiter.Allow(ip) {
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
-			return
-		}
+	// 1.7 Feature: Text templates (tiled, per-request text watermarks)
+	params, err = applyTextTemplate(cfg, params)
+	if err != nil {
+		http.Error(w, "Invalid text template: "+err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	cleanedPath := filepath.ToSlash(filepath.Clean(r.URL.Path))
-	objectKey := strings.TrimPrefix(cleanedPath, "/")
+	// 1.8 Feature: Defaults set by internal callers in request headers
+	params = applyHeaderDefaults(cfg, r, params)
 
-	if strings.Contains(objectKey, "..") || objectKey == ".env" || objectKey == "" {
-		http.Error(w, "Invalid Path", http.StatusBadRequest)
+	// 1.85 Colors, wherever they came from
+	if err := checkColors(params); err != nil {
+		http.Error(w, "Invalid parameter: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	queryParams := r.URL.Query()
+	// Feature: Color Palette
+	if params.Get("palette") == "true" {
+		h.handlePalette(w, r, objectKey, params)
+		return
+	}
 
-	// 1. Security: Signature Verification
-	if cfg.SecretKey != "" && len(queryParams) > 0 {
-		sig := queryParams.Get("s")
-		if sig == "" {
-			http.Error(w, "Missing signature", http.StatusForbidden)
-			return
+	// Feature: Video card (poster + hover preview from one source download)
+	if params.Get("videocard") == "true" {
+		h.handleVideoCard(w, r, cfg, objectKey, params)
+		return
+	}
+
+	// Feature: LQIP bundle. The parameter selects the response shape, not the
+	// image, so the image part shares the cache entry of the plain request.
+	bundle := params.Get("bundle") == "lqip"
+	params.Del("bundle")
+
+	// 2. Parse Image Options and resolve the cache variant
+	v := h.resolveVariant(cfg, objectKey, contentHash, params, r.Header)
+	// Process-only keys never pass the master through, whatever left the
+	// request unprocessed (q=80, neg=off, w=0, unknown parameters)
+	if !v.shouldProcess && matchesPrefix(cfg.ProcessOnlyPrefixes, objectKey) {
+		if params, err = processOnlyDefault(cfg); err == nil {
+			v = h.resolveVariant(cfg, objectKey, contentHash, params, r.Header)
 		}
-		if !validateSignature(r.URL.Path, queryParams, cfg.SecretKey) {
-			http.Error(w, "Invalid signature", http.StatusForbidden)
+		if err != nil || !v.shouldProcess {
+			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
 	}
-
-	// 0.6 Feature: Purge Cache
-	if r.Method == http.MethodDelete {
-		h.handlePurge(w, r, objectKey, queryParams)
+	imgOpts, cacheKey, encodingType := v.opts, v.cacheKey, v.encodingType
+	ctx = withCacheVersion(ctx, v.version)
+	shouldProcess, isVideo := v.shouldProcess, v.isVideo
+	setDebugHeaders(w, cfg, objectKey, v)
+
+	// Without ENABLE_VIDEO_THUMBNAIL, a video with transform parameters would
+	// be passed through whole to a client that wanted a poster
+	if isVideo && !shouldProcess && (hasTransformParams(cfg, queryParams) || len(pathParams) > 0) {
+		writeJSON(w, cfg.VideoDisabledStatus, map[string]string{
+			"error": "video thumbnails are disabled",
+			"hint":  "request the video without parameters to get the original, or enable ENABLE_VIDEO_THUMBNAIL on the server",
+		})
 		return
 	}
 
-	// 2. Parse Image Options
-	imgOpts := parseImageOptions(queryParams, cfg.Presets)
-
-	// Feature: Color Palette
-	if queryParams.Get("palette") == "true" {
-		h.handlePalette(w, r, objectKey, queryParams)
+	// Transform policies are enforced on the effective options
+	if violation := checkPolicy(v); violation != nil {
+		writePolicyViolation(w, violation)
 		return
 	}
 
-	// Determine Mode
-	isImage := isImageFile(objectKey)
-	isVideo := isVideoFile(objectKey)
-
-	// Video Thumbnail Logic
-	if isVideo && cfg.EnableVideoThumbnail {
-		if imgOpts.Format == "" {
-			imgOpts.Format = "jpeg"
+	// Feature: Peer routing. Processed variants are served by the replica
+	// owning their cache key, so each variant is built and stored once.
+	if shouldProcess && len(cfg.Peers) > 0 && r.Header.Get(peers.Header) == "" {
+		owner, local := h.Peers.Owner(cfg.Peers, cfg.PeerSelf, cacheKey)
+		if !local {
+			h.Peers.Forward(w, r, owner, cfg.PeerSelf, func(w http.ResponseWriter, r *http.Request) {
+				r.Header.Set(peers.Header, cfg.PeerSelf)
+				h.serveAsset(w, r)
+			})
+			return
 		}
+		metrics.PeerRequestsTotal.WithLabelValues("local").Inc()
+	}
+
+	if h.Stats != nil {
+		sw := &statsWriter{ResponseWriter: w}
+		w = sw
+		defer h.recordStats(objectKey, sw)
 	}
 
-	// Auto-Format Logic: Check Accept Header
-	if isImage && imgOpts.Format == "" {
-		acceptHeader := r.Header.Get("Accept")
-		if strings.Contains(acceptHeader, "image/avif") {
-			imgOpts.Format = "avif"
-		} else if strings.Contains(acceptHeader, "image/webp") {
-			imgOpts.Format = "webp"
+	if imgOpts.Format == "ico" {
+		if _, err := processor.ParseICOSizes(imgOpts.Sizes); err != nil {
+			http.Error(w, "Invalid icon sizes: "+err.Error(), http.StatusBadRequest)
+			return
 		}
 	}
 
-	shouldProcess := (isImage && (imgOpts.Width > 0 || imgOpts.Height > 0 || imgOpts.Fit != "" || imgOpts.Format != "" || imgOpts.Blurhash)) || (isVideo && (cfg.EnableVideoThumbnail || imgOpts.Format == "storyboard"))
+	if isVideo && imgOpts.Animated {
+		if err := animatedThumbnailOptions(imgOpts, "").Validate(); err != nil {
+			http.Error(w, "Invalid animation: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
-	cacheKey := ""
-	encodingType := "identity"
+	if v.clamped != "" {
+		w.Header().Set("X-Quirm-Clamped", v.clamped)
+	}
+	bundled := bundle && shouldProcess && !isVideo && !imgOpts.Blurhash
+	setVary(w.Header(), cfg, v, bundled)
 
-	if shouldProcess {
-		cacheKey = cache.GenerateKeyProcessed(objectKey, queryParams, imgOpts.Format)
-	} else {
-		// Passthrough Mode
-		acceptEncoding := r.Header.Get("Accept-Encoding")
-		if strings.Contains(acceptEncoding, "br") {
-			encodingType = "br"
-		} else if strings.Contains(acceptEncoding, "gzip") {
-			encodingType = "gzip"
+	if bundled {
+		if acceptsMultipart(r.Header.Get("Accept")) {
+			h.serveBundle(w, r, objectKey, v)
+			return
 		}
-		cacheKey = cache.GenerateKeyOriginal(objectKey, encodingType)
 	}
 
+	// Processed variants count towards MAX_VARIANTS_PER_OBJECT once served
+	tracked := shouldProcess
+	defer func() {
+		if tracked {
+			h.trackVariant(cfg, objectKey, cacheKey, params)
+		}
+	}()
+
 	// ETag Check
 	etag := `"` + cacheKey + `"`
 	if match := r.Header.Get("If-None-Match"); match != "" {
@@ -301,51 +351,75 @@ func (h *Handler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Memory/Redis Cache Check
-	if h.Cache != nil {
-		if data, found := h.Cache.Get(ctx, cacheKey); found {
+	// Memory/Redis Cache Check. Redis may be left for after the disk
+	// (CACHE_LOOKUP_ORDER).
+	cacheBefore, cacheAfter := h.lookupTiers(cfg)
+	serveCached := func(get cacheGetter) bool {
+		data, found := get(ctx, cacheKey)
+		if !found {
+			data, found = h.legacyCached(ctx, get, v)
+		}
+		if found {
 			span.AddEvent("Cache Hit")
 			metrics.CacheOpsTotal.WithLabelValues("hit_cache").Inc()
 			w.Header().Set("ETag", etag)
-			w.Header().Set("Cache-Control", "public, max-age=86400")
-
-			// If blurhash, text/plain
-			if imgOpts.Blurhash {
-				w.Header().Set("Content-Type", "text/plain")
-			} else {
-				setContentType(w, objectKey, imgOpts.Format)
-			}
-
-			w.Write(data)
-			return
+			setGrayscaleHeader(w, cfg, v, data)
+			setStaticHeader(w, v, h.recordedFrames(v))
+			serveBytes(w, r, data, objectKey, imgOpts)
 		}
+		return found
+	}
+	if cacheBefore != nil && serveCached(cacheBefore) {
+		return
 	}
 
 	cacheFilePath := cache.GetCachePath(h.CacheDir, cacheKey)
 
 	// Check file existence and age
+	diskStart := time.Now()
 	fileInfo, err := os.Stat(cacheFilePath)
+	if err != nil && h.adoptLegacyFile(cacheFilePath, v) {
+		fileInfo, err = os.Stat(cacheFilePath)
+	}
 	fileExists := err == nil
+	cache.ObserveLookup("disk", diskStart, fileExists)
+
+	// Entries stale for longer than StaleServeMax, or due for deletion by the
+	// cleaner, are rebuilt before serving
+	var state entryState
+	if fileExists {
+		state = diskEntryState(cfg, time.Since(cache.EntryTime(cacheFilePath, fileInfo)))
+	}
+	if fileExists && state == entryExpired {
+		span.AddEvent("Stale Expired")
+		fileExists = false
+	}
 
 	// Check if we should serve stale content
 	if fileExists {
 		// If file is older than CacheTTL, we serve it but trigger update
-		if time.Since(fileInfo.ModTime()) > cfg.CacheTTL {
-			// Trigger background update
-			go func() {
-				// Create a background context linked to the original trace?
-				// Usually background tasks are separate traces or linked.
-				// We'll just use Background for now to avoid cancellation issues.
-				_, _, _ = h.Group.Do(cacheKey, func() (interface{}, error) {
-					return h.updateCache(context.Background(), objectKey, cacheFilePath, cacheKey, imgOpts, encodingType, shouldProcess, isVideo)
+		if state == entryStale {
+			// Trigger background update. Refreshes run detached from the
+			// request, so its cancellation does not abort them.
+			queued := h.refreshes().submit(cacheKey, func(ctx context.Context) error {
+				ctx = withCacheVersion(ctx, v.version)
+				if !h.acquireRefresh(ctx, cacheKey, cfg.RefreshLockTTL) {
+					return nil
+				}
+				_, err, _ := h.Group.Do(cacheKey, func() (interface{}, error) {
+					return h.refreshCache(ctx, objectKey, cacheFilePath, cacheKey, imgOpts, encodingType, shouldProcess, isVideo)
 				})
-			}()
+				return err
+			})
+			if !queued {
+				span.AddEvent("Refresh Dropped")
+			}
 
 			span.AddEvent("Serve Stale")
 			metrics.CacheOpsTotal.WithLabelValues("hit_stale").Inc()
 			// Serve the file
 			w.Header().Set("ETag", etag)
-			serveFile(w, cacheFilePath, encodingType, objectKey, imgOpts.Format)
+			h.serveVariantFile(w, r, cacheFilePath, objectKey, v)
 			return
 		}
 
@@ -353,14 +427,20 @@ func (h *Handler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		span.AddEvent("Disk Hit")
 		metrics.CacheOpsTotal.WithLabelValues("hit_disk").Inc()
 		w.Header().Set("ETag", etag)
-		serveFile(w, cacheFilePath, encodingType, objectKey, imgOpts.Format)
+		h.serveVariantFile(w, r, cacheFilePath, objectKey, v)
+		return
+	}
+
+	if cacheAfter != nil && serveCached(cacheAfter) {
 		return
 	}
 
 	span.AddEvent("Cache Miss")
-	_, err, _ = h.Group.Do(cacheKey, func() (interface{}, error) {
+	// The build that runs records its source here
+	src := &sourceObject{}
+	result, err, _ := h.Group.Do(cacheKey, func() (interface{}, error) {
 		// Double check inside singleflight
-		if storage.FileExists(cacheFilePath) {
+		if isFresh(cacheFilePath, cfg.CacheTTL) {
 			// If it appeared while waiting
 			metrics.CacheOpsTotal.WithLabelValues("hit_disk").Inc()
 			return nil, nil
@@ -368,14 +448,35 @@ func (h *Handler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		metrics.CacheOpsTotal.WithLabelValues("miss").Inc()
 
 		slog.Debug("Processing MISS", "objectKey", objectKey, "cacheKey", cacheKey)
+		ctx := withWatermarkBypassAudit(withSourceObject(ctx, src), func() {
+			h.audit(r, audit.WatermarkBypass, objectKey, params)
+		})
+		start := time.Now()
+		defer func() { markProcessed(w, time.Since(start)) }()
 		return h.updateCache(ctx, objectKey, cacheFilePath, cacheKey, imgOpts, encodingType, shouldProcess, isVideo)
 	})
 
+	if errors.Is(err, errDiskUnavailable) {
+		span.AddEvent("Disk Unavailable")
+		h.streamOriginal(w, r, objectKey)
+		return
+	}
 	if err != nil {
+		tracked = false
+		// A full ffmpeg queue is load, and a large original a refusal, not
+		// faults of the object
+		if writeFFmpegBusy(w, objectKey, err) || writePassthroughTooLarge(w, err) {
+			return
+		}
+		if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "404") || strings.Contains(err.Error(), "NoSuchKey") {
+			h.Notifier.Report(notify.NotFound, objectKey, r.URL.RawQuery, nil)
+		} else {
+			h.Notifier.Report(notify.ProcessingError, objectKey, r.URL.RawQuery, err)
+		}
+
 		// Feature: Fallback/Default Image
-		if cfg.DefaultImagePath != "" {
-			if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "404") || strings.Contains(err.Error(), "NoSuchKey") {
-				http.ServeFile(w, r, cfg.DefaultImagePath)
+		if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "404") || strings.Contains(err.Error(), "NoSuchKey") {
+			if h.serveDefaultImage(w, r, cfg, objectKey, v) {
 				return
 			}
 		}
@@ -384,32 +485,113 @@ func (h *Handler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Not Found", http.StatusNotFound)
 			return
 		}
-		slog.Error("Request processing failed", "error", err)
+		if writeOriginAccessError(w, objectKey, err) {
+			return
+		}
+		slog.Error("Request processing failed", "objectKey", objectKey, "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("ETag", etag)
-	serveFile(w, cacheFilePath, encodingType, objectKey, imgOpts.Format)
+	// Without a writable disk cache the processed bytes are served directly
+	if data, _ := result.([]byte); len(data) > 0 && !storage.FileExists(cacheFilePath) {
+		setGrayscaleHeader(w, cfg, v, data)
+		setStaticHeader(w, v, src.frames)
+		serveBytes(w, r, data, objectKey, imgOpts)
+		return
+	}
+	h.serveVariantFile(w, r, cacheFilePath, objectKey, v)
+}
+
+// cacheGetter looks a key up in some tiers of the memory/Redis cache.
+type cacheGetter func(ctx context.Context, key string) ([]byte, bool)
+
+// lookupTiers returns the memory/Redis lookups to make before and after the
+// disk cache. With CACHE_LOOKUP_ORDER=memory,disk,redis, a tiered cache asks
+// Redis only when the disk misses, sparing hosts with a warm disk the Redis
+// round trip; otherwise all tiers are asked before the disk, so a host with
+// a cold disk does not rebuild what Redis holds. Both are nil without a
+// memory/Redis cache.
+func (h *Handler) lookupTiers(cfg config.Config) (before, after cacheGetter) {
+	if h.Cache == nil {
+		return nil, nil
+	}
+	if tiered, ok := h.Cache.(*cache.TieredCache); ok && tiered.L2 != nil && cfg.CacheLookupOrder == config.CacheLookupDiskFirst {
+		return tiered.L1.Get, tiered.GetShared
+	}
+	return h.Cache.Get, nil
+}
+
+// streamOriginal serves an unprocessed object straight from the origin, used
+// while the disk cache cannot be written.
+func (h *Handler) streamOriginal(w http.ResponseWriter, r *http.Request, objectKey string) {
+	reader, size, err := h.S3.GetObject(r.Context(), objectKey)
+	if err != nil {
+		if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "404") {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		if writeOriginAccessError(w, objectKey, err) {
+			return
+		}
+		slog.Error("Request processing failed", "objectKey", objectKey, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+	if writePassthroughTooLarge(w, checkPassthroughSize(h.ConfigManager.Get(), objectKey, size)) {
+		return
+	}
+
+	setContentType(w, objectKey, "")
+	setCacheControl(w)
+	if size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	io.Copy(w, reader)
 }
 
 func (h *Handler) handlePalette(w http.ResponseWriter, r *http.Request, objectKey string, params url.Values) {
+	data, err := h.palette(r.Context(), objectKey, params)
+	if err != nil {
+		if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "404") {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		if writeOriginAccessError(w, objectKey, err) {
+			return
+		}
+		slog.Error("Palette extraction failed", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setCacheControl(w)
+	w.Write(data)
+}
+
+// paletteDocument returns the JSON palette document of colors.
+func paletteDocument(colors []string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{"colors": colors})
+}
+
+// palette returns the JSON palette document for objectKey, from cache when possible.
+func (h *Handler) palette(ctx context.Context, objectKey string, params url.Values) ([]byte, error) {
 	cacheKey := cache.GenerateKeyProcessed(objectKey, params, "json")
 
 	// Check Cache
 	if h.Cache != nil {
-		if data, found := h.Cache.Get(r.Context(), cacheKey); found {
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("Cache-Control", "public, max-age=86400")
-			w.Write(data)
-			return
+		if data, found := h.Cache.Get(ctx, cacheKey); found {
+			return data, nil
 		}
 	}
 
 	// Fetch and Process
 	// We use singleflight to avoid duplicate processing
 	res, err, _ := h.Group.Do(cacheKey, func() (interface{}, error) {
-		reader, _, err := h.S3.GetObject(r.Context(), objectKey)
+		reader, _, err := h.S3.GetObject(ctx, objectKey)
 		if err != nil {
 			return nil, err
 		}
@@ -419,38 +601,114 @@ func (h *Handler) handlePalette(w http.ResponseWriter, r *http.Request, objectKe
 		if err != nil {
 			return nil, err
 		}
+		return paletteDocument(colors)
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		resp := map[string]interface{}{
-			"colors": colors,
-		}
+	data := res.([]byte)
 
-		data, err := json.Marshal(resp)
-		if err != nil {
+	// Save to Cache
+	if h.Cache != nil {
+		h.Cache.Set(ctx, cacheKey, data, h.ConfigManager.Get().TextCacheTTL)
+	}
+	return data, nil
+}
+
+// acquireRefresh reports whether this instance should refresh the stale entry
+// for cacheKey. With a shared lock configured only one instance wins per lock
+// period; the others keep serving the stale copy. Lock errors fail open.
+func (h *Handler) acquireRefresh(ctx context.Context, cacheKey string, ttl time.Duration) bool {
+	if h.RefreshLock == nil {
+		return true
+	}
+	ok, err := h.RefreshLock.TryLock(ctx, "refresh:"+cacheKey, ttl)
+	if err != nil {
+		slog.Warn("Failed to acquire refresh lock", "key", cacheKey, "error", err)
+		metrics.RefreshLockTotal.WithLabelValues("error").Inc()
+		return true
+	}
+	if !ok {
+		metrics.RefreshLockTotal.WithLabelValues("contended").Inc()
+		return false
+	}
+	metrics.RefreshLockTotal.WithLabelValues("acquired").Inc()
+	return true
+}
+
+// refreshCache brings a stale entry up to date. When the origin object is
+// unchanged since the entry was built, the entry is only marked fresh again;
+// otherwise (or every RefreshForceAfter revalidations) it is rebuilt.
+func (h *Handler) refreshCache(ctx context.Context, objectKey, destPath, cacheKey string, opts processor.ImageOptions, encodingType string, shouldProcess, isVideo bool) ([]byte, error) {
+	// Passthrough entries revalidate with a conditional fetch, which costs
+	// a single request whether or not the original has changed
+	if !shouldProcess {
+		changed, err := h.fetchPassthrough(ctx, objectKey, destPath, encodingType)
+		switch {
+		case err != nil:
+			metrics.RefreshTotal.WithLabelValues("error").Inc()
 			return nil, err
+		case changed:
+			metrics.RefreshTotal.WithLabelValues("reprocessed").Inc()
+		default:
+			metrics.RefreshTotal.WithLabelValues("revalidated").Inc()
 		}
-		return data, nil
-	})
+		return nil, nil
+	}
+
+	if h.revalidate(ctx, objectKey, destPath, cacheKey, shouldProcess) {
+		metrics.RefreshTotal.WithLabelValues("revalidated").Inc()
+		return nil, nil
+	}
 
+	data, err := h.updateCache(ctx, objectKey, destPath, cacheKey, opts, encodingType, shouldProcess, isVideo)
 	if err != nil {
-		if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "404") {
-			http.Error(w, "Not Found", http.StatusNotFound)
-			return
-		}
-		slog.Error("Palette extraction failed", "error", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		metrics.RefreshTotal.WithLabelValues("error").Inc()
+		return nil, err
 	}
+	metrics.RefreshTotal.WithLabelValues("reprocessed").Inc()
+	return data, nil
+}
 
-	data := res.([]byte)
+// revalidate compares the origin version recorded in the sidecar metadata of
+// destPath with the current object. If it is unchanged, the file's mtime and
+// the memory/Redis entry are renewed and true is returned.
+func (h *Handler) revalidate(ctx context.Context, objectKey, destPath, cacheKey string, shouldProcess bool) bool {
+	cfg := h.ConfigManager.Get()
 
-	// Save to Cache
-	if h.Cache != nil {
-		h.Cache.Set(r.Context(), cacheKey, data, h.ConfigManager.Get().CacheTTL)
+	meta, err := cache.ReadMeta(destPath)
+	if err != nil {
+		return false
+	}
+	if cfg.RefreshForceAfter > 0 && meta.Revalidations+1 >= cfg.RefreshForceAfter {
+		return false
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "public, max-age=86400")
-	w.Write(data)
+	info, err := h.S3.StatObject(ctx, objectKey)
+	if err != nil || !meta.SameVersion(info.ETag, info.LastModified) {
+		return false
+	}
+	// Metadata can be replaced without changing the ETag
+	if cfg.HonorObjectMetadata && !maps.Equal(meta.Metadata, info.Metadata) {
+		return false
+	}
+
+	if err := cache.Touch(destPath); err != nil {
+		return false
+	}
+	meta.Revalidations++
+	if err := cache.WriteMeta(destPath, meta); err != nil {
+		slog.Warn("Failed to update cache metadata", "path", destPath, "error", err)
+	}
+
+	// Passthrough files are not kept in the memory/Redis cache
+	if shouldProcess && h.Cache != nil {
+		if data, err := os.ReadFile(destPath); err == nil {
+			h.Cache.Set(ctx, cacheKey, data, 0)
+		}
+	}
+	return true
 }
 
 func (h *Handler) updateCache(ctx context.Context, objectKey, destPath, cacheKey string, opts processor.ImageOptions, encodingType string, shouldProcess, isVideo bool) ([]byte, error) {
@@ -461,50 +719,193 @@ func (h *Handler) updateCache(ctx context.Context, objectKey, destPath, cacheKey
 
 	cfg := h.ConfigManager.Get()
 
-	if shouldProcess {
-		if isVideo && cfg.EnableVideoThumbnail {
-			data, err := h.processVideoAndSave(ctx, objectKey, destPath, opts)
-			if err == nil && h.Cache != nil && len(data) > 0 {
-				h.Cache.Set(ctx, cacheKey, data, cfg.CacheTTL)
-			}
-			return data, err
-		}
+	// Builds record the version of the object they downloaded, and resolve
+	// the processing hints of its metadata rather than each request, so
+	// cache hits never need the metadata. Passthrough originals record their
+	// version while being fetched.
+	src := sourceObjectFrom(ctx)
+	if src == nil {
+		src = &sourceObject{}
+		ctx = withSourceObject(ctx, src)
+	}
 
-		data, err := h.processAndSave(ctx, objectKey, destPath, opts)
-		if err == nil && h.Cache != nil && len(data) > 0 {
-			h.Cache.Set(ctx, cacheKey, data, cfg.CacheTTL)
+	// Transient origin and libvips failures get another try (PROCESS_RETRIES)
+	start := time.Now()
+	data, err := withBuildRetries(ctx, cfg, func() ([]byte, error) {
+		switch {
+		case !shouldProcess:
+			return h.fetchAndSave(ctx, objectKey, destPath, encodingType)
+		case isVideo && cfg.EnableVideoThumbnail:
+			return h.processVideoAndSave(ctx, objectKey, destPath, opts)
+		case isVideoConversion(objectKey, opts):
+			return h.convertAnimatedAndSave(ctx, objectKey, destPath, opts)
+		case opts.Optimize:
+			return h.optimizeGIFAndSave(ctx, objectKey, destPath, opts)
+		default:
+			return h.processAndSave(ctx, objectKey, destPath, opts)
 		}
+	})
+	if err == nil && shouldProcess && h.Cache != nil && len(data) > 0 {
+		h.Cache.Set(ctx, cacheKey, data, textCacheTTL(cfg, opts))
+	}
+	if err != nil {
 		return data, err
 	}
-	return h.fetchAndSave(ctx, objectKey, destPath, encodingType)
+	opts = sourceHints(ctx, cfg, opts)
+	if shouldProcess && len(data) > 0 {
+		h.Hooks.Dispatch(objectKey, opts, data, processedContentType(data, objectKey, opts),
+			hooks.OutputMeta{CacheKey: cacheKey, Duration: time.Since(start)})
+	}
+
+	if shouldProcess && src.ok && !h.Disk.Degraded() && !h.memoryOnly(data) {
+		info := src.info
+		meta := cache.Meta{ETag: info.ETag, LastModified: info.LastModified, Metadata: info.Metadata, ObjectKey: objectKey, SourceFrames: src.frames}
+		if len(data) > 0 {
+			meta.ContentType = processedContentType(data, objectKey, opts)
+		}
+		if err := cache.WriteMeta(destPath, meta); err != nil {
+			slog.Warn("Failed to write cache metadata", "path", destPath, "error", err)
+		}
+	}
+	return data, nil
 }
 
 func (h *Handler) fetchAndSave(ctx context.Context, objectKey, destPath, encodingType string) ([]byte, error) {
-	reader, _, err := h.S3.GetObject(ctx, objectKey)
+	_, err := h.fetchPassthrough(ctx, objectKey, destPath, encodingType)
+	return nil, err
+}
+
+// fetchPassthrough stores the original of objectKey at destPath in the given
+// encoding. It reports whether the file was rewritten; an unchanged original
+// only has its cache files marked fresh again.
+func (h *Handler) fetchPassthrough(ctx context.Context, objectKey, destPath, encodingType string) (bool, error) {
+	if encodingType == "identity" {
+		return h.fetchOriginal(ctx, objectKey, destPath)
+	}
+
+	// Compressed copies are derived from the identity copy on disk, so every
+	// encoding of an object costs a single origin fetch. Requests for other
+	// encodings share an in-flight identity fetch through the singleflight key.
+	if h.Disk.Degraded() {
+		return false, errDiskUnavailable
+	}
+
+	identityKey := originalCacheKey(objectKey, cacheVersionFrom(ctx), "identity")
+	identityPath := cache.GetCachePath(h.CacheDir, identityKey)
+	changed := true
+	if !isFresh(identityPath, h.ConfigManager.Get().CacheTTL) {
+		result, err, _ := h.Group.Do(identityKey, func() (interface{}, error) {
+			return h.fetchOriginal(ctx, objectKey, identityPath)
+		})
+		if err != nil {
+			return false, err
+		}
+		changed = result.(bool)
+	}
+
+	// An unchanged original keeps its compressed copy
+	if !changed && touch(destPath) {
+		return false, nil
+	}
+
+	original, err := os.Open(identityPath)
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	defer original.Close()
+
+	err = os.MkdirAll(filepath.Dir(destPath), 0755)
+	if err == nil {
+		err = storage.AtomicWrite(destPath, original, encodingType, h.CacheDir)
+	}
+	if h.Disk.Fail(err) {
+		return false, errDiskUnavailable
+	}
+	return err == nil, err
+}
+
+// fetchOriginal stores the uncompressed original of objectKey at destPath.
+// When a copy is already on disk the fetch is conditional on its recorded
+// ETag, so an unchanged original is not downloaded again. It reports whether
+// the file was rewritten.
+func (h *Handler) fetchOriginal(ctx context.Context, objectKey, destPath string) (bool, error) {
+	if h.Disk.Degraded() {
+		return false, errDiskUnavailable
+	}
+
+	var etag string
+	if meta, err := cache.ReadMeta(destPath); err == nil && storage.FileExists(destPath) {
+		etag = meta.ETag
+	}
+
+	reader, info, err := h.S3.GetObjectIfNoneMatch(ctx, objectKey, etag)
+	if errors.Is(err, storage.ErrNotModified) {
+		if fileInfo, statErr := os.Stat(destPath); statErr == nil && touch(destPath) {
+			metrics.ConditionalRefreshBytesSaved.Add(float64(fileInfo.Size()))
+			return false, nil
+		}
+		// The copy vanished in the meantime
+		reader, info, err = h.S3.GetObjectIfNoneMatch(ctx, objectKey, "")
+	}
+	if err != nil {
+		return false, err
 	}
 	defer reader.Close()
+	if err := checkPassthroughSize(h.ConfigManager.Get(), objectKey, info.Size); err != nil {
+		return false, err
+	}
 
 	// Ensure parent dir exists
-	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-		return nil, err
+	err = os.MkdirAll(filepath.Dir(destPath), 0755)
+	if err == nil {
+		// We don't return bytes for passthrough files as we don't cache originals in Redis yet
+		// to avoid high memory/network usage for large files.
+		err = storage.AtomicWrite(destPath, reader, "identity", h.CacheDir)
+	}
+	if h.Disk.Fail(err) {
+		return false, errDiskUnavailable
+	}
+	if err != nil {
+		return false, err
 	}
 
-	// We don't return bytes for fetchAndSave currently as we don't cache originals in Redis yet
-	// to avoid high memory/network usage for large files.
-	return nil, storage.AtomicWrite(destPath, reader, encodingType, h.CacheDir)
+	meta := cache.Meta{ETag: info.ETag, LastModified: info.LastModified, ContentType: info.ContentType, ObjectKey: objectKey, Version: cacheVersionFrom(ctx)}
+	if err := cache.WriteMeta(destPath, meta); err != nil {
+		slog.Warn("Failed to write cache metadata", "path", destPath, "error", err)
+	}
+	return true, nil
+}
+
+// touch marks the cache file at path as fresh. It returns false if the file
+// does not exist.
+func touch(path string) bool {
+	return cache.Touch(path) == nil
 }
 
 func (h *Handler) processAndSave(ctx context.Context, objectKey, destPath string, opts processor.ImageOptions) ([]byte, error) {
-	reader, size, err := h.S3.GetObject(ctx, objectKey)
+	cfg := h.ConfigManager.Get()
+
+	// Reject oversized originals before any bytes are transferred
+	if cfg.MaxImageSizeMB > 0 {
+		info, err := h.S3.StatObject(ctx, objectKey)
+		if err != nil {
+			return nil, err
+		}
+		if info.Size > cfg.MaxImageSizeMB*1024*1024 {
+			return nil, &FileSizeError{MaxSizeMB: cfg.MaxImageSizeMB}
+		}
+	}
+
+	reader, info, err := h.S3.GetObjectIfNoneMatch(ctx, objectKey, "")
 	if err != nil {
 		return nil, err
 	}
 	defer reader.Close()
+	recordSource(ctx, info)
+	opts = sourceHints(ctx, cfg, opts)
 
-	cfg := h.ConfigManager.Get()
-	if cfg.MaxImageSizeMB > 0 && size > cfg.MaxImageSizeMB*1024*1024 {
+	// The object may have changed since it was stat'ed
+	if cfg.MaxImageSizeMB > 0 && info.Size > cfg.MaxImageSizeMB*1024*1024 {
 		return nil, &FileSizeError{MaxSizeMB: cfg.MaxImageSizeMB}
 	}
 
@@ -514,118 +915,140 @@ func (h *Handler) processAndSave(ctx context.Context, objectKey, destPath string
 		slog.Warn("Error loading watermark", "error", err)
 		// Continue without watermark? Or fail? The original code warned but continued.
 	}
+	if opts.NoWatermark && wmImg != nil {
+		auditWatermarkBypass(ctx)
+		wmImg = nil
+	}
 
-	buf, err := processor.Process(ctx, reader, opts, wmImg, wmOpacity, objectKey)
+	source, err := io.ReadAll(reader)
 	if err != nil {
 		return nil, err
 	}
+	if mayServeSource(cfg, objectKey, int64(len(source))) && processor.SkipsProcessing(source, opts, wmImg != nil, objectKey) {
+		metrics.SkippedNoopProcessTotal.Inc()
+		if err := h.saveProcessed(destPath, source); err != nil {
+			return nil, err
+		}
+		return source, nil
+	}
 
-	// Return bytes for memory cache
-	// Capture bytes BEFORE writing, as AtomicWrite drains the buffer
-	data := buf.Bytes()
-
-	// Ensure parent dir exists
-	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-		return nil, err
+	// Static variants need the frame count of their source for
+	// X-Quirm-Static, which the processor reports in its stats
+	var stats *processor.Stats
+	sampled := h.Costs.Sample()
+	if sampled || opts.Static {
+		stats = &processor.Stats{}
+		ctx = processor.WithStats(ctx, stats)
 	}
 
-	err = storage.AtomicWrite(destPath, bytes.NewReader(data), "identity", h.CacheDir)
+	buf, err := processor.Process(ctx, bytes.NewReader(source), opts, wmImg, wmOpacity, objectKey)
 	if err != nil {
 		return nil, err
 	}
+	recordFrames(ctx, stats)
+	if sampled {
+		h.Costs.Record(objectKey, opts, stats)
+	}
 
+	// Return bytes for memory cache
+	// Capture bytes BEFORE writing, as AtomicWrite drains the buffer
+	data := buf.Bytes()
+
+	if err := h.saveProcessed(destPath, data); err != nil {
+		return nil, err
+	}
 	return data, nil
 }
 
-func (h *Handler) handlePurge(w http.ResponseWriter, r *http.Request, objectKey string, params url.Values) {
-	// Determine keys to purge
-	// If params provided, we generate processed key.
-	// If no params, we might want to purge original? But usually we purge processed variants.
-	// If "all" param provided?
+// mayServeSource reports whether a source that already fits a request may be
+// served in place of a processed variant: only where its original could be
+// passed through anyway, as it keeps the metadata processing strips.
+func mayServeSource(cfg config.Config, objectKey string, size int64) bool {
+	if _, policy := matchPolicy(cfg, objectKey); policy != nil && !policy.Passthrough {
+		return false
+	}
+	return !matchesPrefix(cfg.ProcessOnlyPrefixes, objectKey) && checkPassthroughSize(cfg, objectKey, size) == nil
+}
 
-	// Implementation: Purge specific variant based on params
-	// Need to parse options to generate key properly
-	cfg := h.ConfigManager.Get()
-	imgOpts := parseImageOptions(params, cfg.Presets)
-	isImage := isImageFile(objectKey)
-	isVideo := isVideoFile(objectKey)
+// textCacheTTL is the memory/Redis TTL of a processed variant:
+// TEXT_CACHE_TTL for blurhash strings, the cache default (0) otherwise.
+func textCacheTTL(cfg config.Config, opts processor.ImageOptions) time.Duration {
+	if opts.Blurhash {
+		return cfg.TextCacheTTL
+	}
+	return 0
+}
 
-	shouldProcess := (isImage && (imgOpts.Width > 0 || imgOpts.Height > 0 || imgOpts.Fit != "" || imgOpts.Format != "" || imgOpts.Blurhash)) || (isVideo && cfg.EnableVideoThumbnail)
+// memoryOnly reports whether processed bytes are smaller than
+// DISK_CACHE_MIN_BYTES and so only kept in the memory/Redis cache. Without a
+// memory/Redis cache everything goes to disk, as nothing else would hold it.
+func (h *Handler) memoryOnly(data []byte) bool {
+	return h.Cache != nil && len(data) > 0 && len(data) < h.ConfigManager.Get().DiskCacheMinBytes
+}
 
-	var cacheKey string
-	if shouldProcess {
-		cacheKey = cache.GenerateKeyProcessed(objectKey, params, imgOpts.Format)
-	} else {
-		// Passthrough
-		cacheKey = cache.GenerateKeyOriginal(objectKey, "identity")
+// saveProcessed writes processed bytes to the disk cache. When the disk
+// cannot be written (full, read-only, permissions) the cache is marked
+// degraded and the bytes are only kept in the memory/Redis cache, as are
+// outputs under DISK_CACHE_MIN_BYTES. With CACHE_DEDUP identical outputs
+// share one file through hard links.
+func (h *Handler) saveProcessed(destPath string, data []byte) error {
+	if h.Disk.Degraded() {
+		return nil
 	}
-
-	// Delete from Cache Provider (Memory + Redis)
-	if h.Cache != nil {
-		if err := h.Cache.Delete(r.Context(), cacheKey); err != nil {
-			slog.Warn("Failed to delete from cache provider", "key", cacheKey, "error", err)
+	if h.memoryOnly(data) {
+		// A copy written before the threshold was raised would otherwise be
+		// served, and refreshed, in place of the memory/Redis entry
+		for _, path := range []string{destPath, cache.MetaPath(destPath)} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				slog.Warn("Failed to delete from disk", "path", path, "error", err)
+			}
 		}
+		return nil
 	}
 
-	// Delete from Disk
-	cacheFilePath := cache.GetCachePath(h.CacheDir, cacheKey)
-	if err := os.Remove(cacheFilePath); err != nil && !os.IsNotExist(err) {
-		slog.Warn("Failed to delete from disk", "path", cacheFilePath, "error", err)
+	// Ensure parent dir exists
+	err := os.MkdirAll(filepath.Dir(destPath), 0755)
+	deduped := false
+	if err == nil && h.ConfigManager.Get().CacheDedup {
+		var reused bool
+		reused, err = cache.WriteDeduplicated(h.CacheDir, destPath, data)
+		switch {
+		case err == nil:
+			deduped = true
+			if reused {
+				metrics.CacheDedupBytesSaved.Add(float64(len(data)))
+			}
+		case !cache.IsDiskError(err):
+			// e.g. hard links unsupported: store a plain copy
+			slog.Debug("Cache dedup failed, writing a copy", "path", destPath, "error", err)
+			err = nil
+		}
 	}
-
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Purged"))
+	if err == nil && !deduped {
+		err = storage.AtomicWrite(destPath, bytes.NewReader(data), "identity", h.CacheDir)
+	}
+	if h.Disk.Fail(err) {
+		return nil
+	}
+	return err
 }
 
 func (h *Handler) processVideoAndSave(ctx context.Context, objectKey, destPath string, opts processor.ImageOptions) ([]byte, error) {
-	// 1. Try to get Presigned URL
-	videoURL, err := h.S3.GetPresignedURL(ctx, objectKey, 15*time.Minute)
-
-	// If getting presigned URL fails, or we decide to fallback (logic simplified here)
-	// We might fallback to download. But for now, if it's S3Client, it should support it.
-	// However, other providers might not.
-	// If error, we fallback to download mode.
-
-	var inputPath string
-	var cleanup func()
-
-	if err == nil && videoURL != "" {
-		inputPath = videoURL
-		cleanup = func() {} // No cleanup needed for URL
-	} else {
-		// Fallback: Download to temp file
-		// Create temp file
-		tmpFile, err := os.CreateTemp(h.CacheDir, "video-*.tmp")
-		if err != nil {
-			return nil, err
-		}
-		// Register cleanup
-		cleanup = func() {
-			tmpFile.Close()
-			os.Remove(tmpFile.Name())
-		}
-		// Ensure cleanup happens if we return early (but we need it to persist for GenerateThumbnail)
-		// We defer cleanup at the end of function, which is fine since GenerateThumbnail is synchronous.
-		defer cleanup()
-
-		// Download video
-		reader, _, err := h.S3.GetObject(ctx, objectKey)
-		if err != nil {
-			return nil, err
-		}
-		defer reader.Close()
-
-		_, err = io.Copy(tmpFile, reader)
-		if err != nil {
-			return nil, err
-		}
-		inputPath = tmpFile.Name()
+	data, err := h.withVideoInput(ctx, objectKey, true, func(input string) ([]byte, error) {
+		return renderVideo(ctx, input, objectKey, sourceHints(ctx, h.ConfigManager.Get(), opts))
+	})
+	if err != nil {
+		return nil, err
 	}
+	if err := h.saveProcessed(destPath, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
 
-	// Generate Thumbnail
-	var buf *bytes.Buffer
-	var data []byte
-
+// renderVideo produces the storyboard, animated thumbnail or still
+// thumbnail selected by opts from the video at inputPath.
+func renderVideo(ctx context.Context, inputPath, objectKey string, opts processor.ImageOptions) ([]byte, error) {
 	if opts.Format == "storyboard" {
 		// Storyboard generation
 		cols := 5
@@ -637,12 +1060,14 @@ func (h *Handler) processVideoAndSave(ctx context.Context, objectKey, destPath s
 			interval = strconv.Itoa(opts.Page)
 		}
 
-		buf, err = processor.GenerateStoryboard(inputPath, interval, cols, rows, opts.Width)
+		buf, err := processor.GenerateStoryboard(ctx, inputPath, interval, cols, rows, opts.Width)
 		if err != nil {
 			return nil, err
 		}
-		data = buf.Bytes()
-	} else if opts.Animated {
+		return buf.Bytes(), nil
+	}
+
+	if opts.Animated {
 		// Generate Animated Thumbnail (3 seconds)
 		// We respect requested Width/Height and Format if "webp" or "gif".
 		// If format is not specified or something else, default to GIF for animated requests unless it's WebP.
@@ -651,41 +1076,39 @@ func (h *Handler) processVideoAndSave(ctx context.Context, objectKey, destPath s
 			targetFormat = "webp"
 		}
 
-		buf, err = processor.GenerateAnimatedThumbnail(inputPath, "3", opts.Width, opts.Height, targetFormat)
-		if err != nil {
-			return nil, err
-		}
-		data = buf.Bytes()
-	} else {
-		// We use "00:00:01" as default timestamp if not provided via some param (not spec'd, so default)
-		buf, err = processor.GenerateThumbnail(inputPath, "00:00:01")
-		if err != nil {
-			return nil, err
-		}
-
-		// Now we have the thumbnail image in buf (JPEG).
-		// Pipe it through Processor.Process to handle resizing/watermarking.
-		buf2, err := processor.Process(ctx, buf, opts, nil, 0, objectKey+".jpg") // Treat as jpg
+		buf, err := processor.GenerateAnimatedThumbnail(ctx, inputPath, animatedThumbnailOptions(opts, targetFormat))
 		if err != nil {
 			return nil, err
 		}
-		data = buf2.Bytes()
+		return buf.Bytes(), nil
 	}
 
-	// Ensure parent dir exists
-	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+	// Stills are taken at "t" if given, one second in otherwise
+	timestamp := "00:00:01"
+	if opts.AnimStart > 0 {
+		timestamp = strconv.FormatFloat(opts.AnimStart, 'f', -1, 64)
+	}
+	buf, err := processor.GenerateThumbnail(ctx, inputPath, timestamp)
+	if err != nil {
 		return nil, err
 	}
 
-	err = storage.AtomicWrite(destPath, bytes.NewReader(data), "identity", h.CacheDir)
+	// Now we have the thumbnail image in buf (JPEG).
+	// Pipe it through Processor.Process to handle resizing/watermarking.
+	buf2, err := processor.Process(ctx, buf, opts, nil, 0, objectKey+".jpg") // Treat as jpg
 	if err != nil {
 		return nil, err
 	}
-	return data, nil
-
+	return buf2.Bytes(), nil
 }
 
 func setContentType(w http.ResponseWriter, objectKey, forcedFormat string) {
+	w.Header().Set("Content-Type", contentTypeFor(objectKey, forcedFormat))
+}
+
+// contentTypeFor returns the MIME type of a response for objectKey, or for
+// forcedFormat when the output was converted.
+func contentTypeFor(objectKey, forcedFormat string) string {
 	mimeType := "application/octet-stream"
 
 	// If processed, we trust forcedFormat. If not, we use objectKey extension.
@@ -707,77 +1130,49 @@ func setContentType(w http.ResponseWriter, objectKey, forcedFormat string) {
 		mimeType = "image/webp"
 	case ".avif":
 		mimeType = "image/avif"
+	case ".jxl":
+		mimeType = "image/jxl"
+	case ".pdf":
+		mimeType = "application/pdf"
 	case ".css":
 		mimeType = "text/css"
 	case ".js":
 		mimeType = "application/javascript"
 	case ".svg":
 		mimeType = "image/svg+xml"
+	case ".mp4":
+		mimeType = "video/mp4"
+	case ".webm":
+		mimeType = "video/webm"
+	case ".ico":
+		mimeType = "image/x-icon"
 	}
-	w.Header().Set("Content-Type", mimeType)
+	return mimeType
 }
 
-func validateSignature(path string, params url.Values, secret string) bool {
-	// Check expiry first if present
-	if expiresStr := params.Get("expires"); expiresStr != "" {
-		expires, err := strconv.ParseInt(expiresStr, 10, 64)
-		if err == nil {
-			if time.Now().Unix() > expires {
-				return false
-			}
-		} else {
-			// Invalid expires format
-			return false
-		}
-	}
-
-	keys := make([]string, 0, len(params))
-	for k := range params {
-		if k == "s" {
-			continue
-		}
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	var b strings.Builder
-	b.WriteString(path)
-	if len(keys) > 0 {
-		b.WriteString("?")
+// processedContentType returns the MIME type of a processed variant: the
+// format data was actually encoded in, which can differ from the requested
+// one, or the requested format for outputs libvips does not recognize
+// (icons, videos).
+func processedContentType(data []byte, objectKey string, opts processor.ImageOptions) string {
+	if opts.Blurhash {
+		return "text/plain"
 	}
-	for i, k := range keys {
-		b.WriteString(k)
-		b.WriteString("=")
-		b.WriteString(params.Get(k))
-		if i < len(keys)-1 {
-			b.WriteString("&")
-		}
+	if contentType := processor.SniffContentType(data); contentType != "" {
+		return contentType
 	}
+	return contentTypeFor(objectKey, opts.Format)
+}
 
-	toSign := b.String()
-
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write([]byte(toSign))
-	expected := hex.EncodeToString(mac.Sum(nil))
-
+func validateSignature(path string, params url.Values, secret string) bool {
+	// expires is covered by the signature and checked by withExpires
 	got := params.Get("s")
-	return hmac.Equal([]byte(got), []byte(expected))
-}
-
-func parseImageOptions(params url.Values, presets map[string]string) processor.ImageOptions {
-	// Feature: Named Presets
-	if presetName := params.Get("preset"); presetName != "" && len(presets) > 0 {
-		if presetQuery, ok := presets[presetName]; ok {
-			// Parse preset values
-			presetParams, err := url.ParseQuery(presetQuery)
-			if err == nil {
-				// Strict Mode: When a preset is used, we ONLY use the preset's parameters.
-				// We ignore any other dimensions provided in the original query to prevent overriding.
-				return parseImageOptions(presetParams, nil)
-			}
-		}
-	}
+	return hmac.Equal([]byte(got), []byte(sign.Signature(secret, path, params)))
+}
 
+// parseImageOptions reads the processing options from params.
+// Named presets must already have been merged in with expandPresets.
+func parseImageOptions(params url.Values) processor.ImageOptions {
 	opts := processor.ImageOptions{}
 	if w := params.Get("w"); w != "" {
 		opts.Width, _ = strconv.Atoi(w)
@@ -785,22 +1180,62 @@ func parseImageOptions(params url.Values, presets map[string]string) processor.I
 	if h := params.Get("h"); h != "" {
 		opts.Height, _ = strconv.Atoi(h)
 	}
-	opts.Fit = params.Get("fit")
-	opts.Format = params.Get("format") // "jpeg", "png"
+	// Keywords are case-insensitive, so FIT=COVER and fit=cover share a key
+	opts.Fit = strings.ToLower(params.Get("fit"))
+	// Without a fit, a w x h box is filled keeping the aspect ratio, which
+	// is what contain does; the two share a cache entry. Icons default to
+	// cover on their own.
+	if opts.Fit == "" && opts.Width > 0 && opts.Height > 0 && !strings.EqualFold(params.Get("format"), "ico") {
+		opts.Fit = "contain"
+	}
+	opts.Format = strings.ToLower(params.Get("format")) // "jpeg", "png"
+
+	// Rotation and mirroring; other values are ignored
+	if rot, err := strconv.Atoi(params.Get("rot")); err == nil && slices.Contains(processor.RotateAngles, rot) {
+		opts.Rotate = rot
+	}
+	if flip := strings.ToLower(params.Get("flip")); slices.Contains(processor.FlipModes, flip) {
+		opts.Flip = flip
+	}
+
+	// Explicit crop region in source pixels, clamped to the image when
+	// processed; negative values count as unset
+	for _, p := range []struct {
+		name  string
+		value *int
+	}{{"cx", &opts.CropX}, {"cy", &opts.CropY}, {"cw", &opts.CropWidth}, {"ch", &opts.CropHeight}} {
+		if n, err := strconv.Atoi(params.Get(p.name)); err == nil && n > 0 {
+			*p.value = n
+		}
+	}
 	if q := params.Get("q"); q != "" {
 		opts.Quality, _ = strconv.Atoi(q)
 	}
 
-	opts.Focus = params.Get("focus")
+	opts.Focus = strings.ToLower(params.Get("focus"))
+	// Focal point crop (fp-x/fp-y as fractions of the image size)
+	if fx, fy, ok := parseFocalPoint(params.Get("fp-x"), params.Get("fp-y")); ok {
+		opts.Focus = "point"
+		opts.FocalX, opts.FocalY = fx, fy
+	}
+	// Margin around detected faces, as a fraction of the face box size
+	if fp := params.Get("face_pad"); fp != "" {
+		if pad, err := strconv.ParseFloat(fp, 64); err == nil && pad > 0 {
+			opts.FacePad = min(pad, 2)
+		}
+	}
 	opts.Text = params.Get("text")
+	opts.TextTiled = params.Get("text_tpl") != ""
 	opts.TextColor = params.Get("color") // map 'color' param to TextColor
+	opts.Background = params.Get("bg")
+	opts.Sizes = params.Get("sizes")
 
 	if ts := params.Get("ts"); ts != "" {
 		opts.TextSize, _ = strconv.ParseFloat(ts, 64)
 	}
 
 	// Effects
-	opts.Effect = params.Get("effect")
+	opts.Effect = strings.ToLower(params.Get("effect"))
 	opts.Font = params.Get("font")
 
 	if b := params.Get("brightness"); b != "" {
@@ -826,6 +1261,33 @@ func parseImageOptions(params url.Values, presets map[string]string) processor.I
 		opts.Animated = true
 	}
 
+	// Animated video thumbnail clip
+	if t := params.Get("t"); t != "" {
+		opts.AnimStart, _ = strconv.ParseFloat(t, 64)
+	}
+	if fps := params.Get("fps"); fps != "" {
+		opts.AnimFPS, _ = strconv.Atoi(fps)
+	}
+	if b := params.Get("boomerang"); b == "true" || b == "1" {
+		opts.Boomerang = true
+	}
+
+	if o := params.Get("optimize"); o == "true" || o == "1" {
+		opts.Optimize = true
+	}
+	if f := params.Get("force"); f == "true" || f == "1" {
+		opts.Force = true
+	}
+	if g := params.Get("auto_gray"); g == "true" || g == "1" {
+		opts.AutoGray = true
+	}
+
+	// static wins over animated (reduced motion)
+	if st := params.Get("static"); st == "true" || st == "1" {
+		opts.Static = true
+		opts.Animated = false
+	}
+
 	// Parse Page
 	if p := params.Get("page"); p != "" {
 		if pageVal, err := strconv.Atoi(p); err == nil && pageVal > 0 {
@@ -836,17 +1298,79 @@ func parseImageOptions(params url.Values, presets map[string]string) processor.I
 	return opts
 }
 
+// entryState is how serveAsset treats a disk entry of a given age.
+type entryState int
+
+const (
+	entryFresh   entryState = iota // served as is
+	entryStale                     // served while a refresh runs
+	entryExpired                   // rebuilt before serving
+)
+
+// diskEntryState classifies a disk entry of the given age: fresh up to
+// CacheTTL, then stale until staleExpired.
+func diskEntryState(cfg config.Config, age time.Duration) entryState {
+	switch {
+	case staleExpired(cfg, age):
+		return entryExpired
+	case age > cfg.CacheTTL:
+		return entryStale
+	}
+	return entryFresh
+}
+
+// staleExpired reports whether a disk entry of the given age is too old to
+// be served stale: past StaleServeMax beyond CacheTTL, or past CacheHardTTL,
+// after which the cleaner may delete it at any time.
+func staleExpired(cfg config.Config, age time.Duration) bool {
+	if cfg.CacheHardTTL > 0 && age > cfg.CacheHardTTL {
+		return true
+	}
+	return cfg.StaleServeMax > 0 && age > cfg.CacheTTL+cfg.StaleServeMax
+}
+
+// isFresh reports whether the cache file at path exists and is younger than ttl.
+func isFresh(path string, ttl time.Duration) bool {
+	info, err := os.Stat(path)
+	return err == nil && time.Since(cache.EntryTime(path, info)) <= ttl
+}
+
 func isImageFile(key string) bool {
 	ext := strings.ToLower(filepath.Ext(key))
 	return ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".gif" || ext == ".webp" || ext == ".pdf"
 }
 
+// animatedThumbnailOptions maps the request options to the clip rendered
+// for animated video thumbnails.
+func animatedThumbnailOptions(opts processor.ImageOptions, format string) processor.AnimatedThumbnailOptions {
+	return processor.AnimatedThumbnailOptions{
+		Start:     opts.AnimStart,
+		FPS:       opts.AnimFPS,
+		Boomerang: opts.Boomerang,
+		Width:     opts.Width,
+		Height:    opts.Height,
+		Format:    format,
+		MaxWidth:  opts.MaxWidth,
+		MaxHeight: opts.MaxHeight,
+	}
+}
+
 func isVideoFile(key string) bool {
 	ext := strings.ToLower(filepath.Ext(key))
 	return ext == ".mp4" || ext == ".mov" || ext == ".webm"
 }
 
-func serveFile(w http.ResponseWriter, path string, encoding string, objectKey string, forcedFormat string) {
+// serveBytes writes a processed variant held in memory, honoring Range and
+// the conditional headers of r like a disk hit.
+func serveBytes(w http.ResponseWriter, r *http.Request, data []byte, objectKey string, opts processor.ImageOptions) {
+	setCacheControl(w)
+	w.Header().Set("Content-Type", processedContentType(data, objectKey, opts))
+	http.ServeContent(w, r, objectKey, time.Time{}, bytes.NewReader(data))
+}
+
+// serveFile serves a disk cache entry. Range, If-Range and the conditional
+// headers of r are honored, so players can seek in large videos.
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, path string, encoding string, objectKey string, forcedFormat string) {
 	file, err := os.Open(path)
 	if err != nil {
 		http.Error(w, "Cache miss mid-flight", http.StatusInternalServerError)
@@ -854,45 +1378,28 @@ func serveFile(w http.ResponseWriter, path string, encoding string, objectKey st
 	}
 	defer file.Close()
 
-	now := time.Now()
-	os.Chtimes(path, now, now)
+	// Entries are touched on every hit, so their time is when they were
+	// last written or served: never before their content changed
+	var modTime time.Time
+	if info, err := file.Stat(); err == nil {
+		modTime = cache.EntryTime(path, info)
+	}
+	cache.Touch(path)
+	h.Access.Record(filepath.Base(path))
 
 	switch encoding {
 	case "br":
 		w.Header().Set("Content-Encoding", "br")
 	case "gzip":
 		w.Header().Set("Content-Encoding", "gzip")
+	case "zstd":
+		w.Header().Set("Content-Encoding", "zstd")
 	}
 
-	mimeType := "application/octet-stream"
-
-	// If processed, we trust forcedFormat. If not, we use objectKey extension.
-	ext := ""
-	if forcedFormat != "" {
-		ext = "." + forcedFormat
-	} else {
-		ext = filepath.Ext(objectKey)
-	}
-
-	switch strings.ToLower(ext) {
-	case ".jpg", ".jpeg":
-		mimeType = "image/jpeg"
-	case ".png":
-		mimeType = "image/png"
-	case ".gif":
-		mimeType = "image/gif"
-	case ".webp":
-		mimeType = "image/webp"
-	case ".avif":
-		mimeType = "image/avif"
-	case ".css":
-		mimeType = "text/css"
-	case ".js":
-		mimeType = "application/javascript"
-	case ".svg":
-		mimeType = "image/svg+xml"
+	// A Content-Type set by the caller (recorded with the entry) wins
+	if w.Header().Get("Content-Type") == "" {
+		setContentType(w, objectKey, forcedFormat)
 	}
-	w.Header().Set("Content-Type", mimeType)
-	w.Header().Set("Cache-Control", "public, max-age=86400")
-	http.ServeContent(w, &http.Request{}, objectKey, time.Now(), file)
+	setCacheControl(w)
+	http.ServeContent(w, r, objectKey, modTime, file)
 }
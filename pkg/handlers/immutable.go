@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/sign"
+	"github.com/CodeTease/quirm/pkg/storage"
+)
+
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// splitVersion separates the leading content hash segment of an immutable
+// URL ("/<hash>/path/to/img.jpg") from the rest of the path. It returns an
+// empty version when immutable URLs are disabled or the path has none.
+func splitVersion(cfg config.Config, urlPath string) (string, string) {
+	if !cfg.ImmutableURLs {
+		return "", urlPath
+	}
+	return sign.SplitVersion(urlPath)
+}
+
+// objectVersion derives the content hash used in immutable URLs from the
+// origin object's ETag, falling back to its modification time.
+func objectVersion(info storage.ObjectInfo) string {
+	tag := strings.Trim(info.ETag, `"`)
+	if tag == "" {
+		tag = info.LastModified.UTC().Format(time.RFC3339Nano)
+	}
+	sum := sha256.Sum256([]byte(tag))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// checkVersion validates the content hash of an immutable URL against the
+// current origin object. When it matches, the response is marked immutable
+// and true is returned. Otherwise it redirects to the current URL (or answers
+// 404 when IMMUTABLE_MISMATCH=notfound) and returns false.
+func (h *Handler) checkVersion(w http.ResponseWriter, r *http.Request, cfg config.Config, version, objectKey string) bool {
+	info, err := h.S3.StatObject(r.Context(), objectKey)
+	if err != nil {
+		if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "404") {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return false
+		}
+		if writeOriginAccessError(w, objectKey, err) {
+			return false
+		}
+		slog.Error("Failed to check object version", "objectKey", objectKey, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+
+	current := objectVersion(info)
+	if version == current {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+		return true
+	}
+
+	if cfg.ImmutableMismatch == "notfound" {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return false
+	}
+
+	_, rest := splitVersion(cfg, r.URL.Path)
+	target := "/" + current + rest
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	// The target changes whenever the object does, so don't let it stick
+	w.Header().Set("Cache-Control", "no-cache")
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+	return false
+}
+
+// setCacheControl sets the default Cache-Control unless an earlier stage
+// (e.g. an immutable URL) already chose one.
+func setCacheControl(w http.ResponseWriter) {
+	if w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type objectInfoResponse struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+	LastModified time.Time `json:"last_modified,omitempty"`
+	ImmutableURL string    `json:"immutable_url,omitempty"`
+	Format       string    `json:"format,omitempty"`
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+	Pages        int       `json:"pages,omitempty"`
+}
+
+// HandleInfo returns the origin metadata of an object (GET /_info/<key>,
+// admin only), including its current immutable URL when those are enabled.
+// For images, the format and dimensions are read from the start of the file.
+func (h *Handler) HandleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+
+	cfg := h.ConfigManager.Get()
+	objectKey, _, ok := requestTarget(cfg, strings.TrimPrefix(r.URL.Path, "/_info"))
+	if !ok {
+		http.Error(w, "Invalid Path", http.StatusBadRequest)
+		return
+	}
+
+	info, err := h.S3.StatObject(r.Context(), objectKey)
+	if err != nil {
+		if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "404") {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		if writeOriginAccessError(w, objectKey, err) {
+			return
+		}
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := objectInfoResponse{
+		Key:          objectKey,
+		Size:         info.Size,
+		ETag:         info.ETag,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+	}
+	if cfg.ImmutableURLs {
+		resp.ImmutableURL = "/" + objectVersion(info) + "/" + objectKey
+	}
+	if isImageFile(objectKey) {
+		if hdr, err := h.readHeader(r.Context(), objectKey, info.Size); err == nil {
+			resp.Format, resp.Width, resp.Height, resp.Pages = hdr.Format, hdr.Width, hdr.Height, hdr.Pages
+		} else {
+			slog.Debug("Failed to read image header", "objectKey", objectKey, "error", err)
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// Processed variants used to be keyed by their raw query parameters
+// (cache.GenerateKeyProcessed) and are now keyed by their effective options
+// (cache.GenerateKeyOptions). With CACHE_KEY_LEGACY_FALLBACK, an entry missing
+// under its new key is looked up under the old one and, when found, stored
+// under the new key, so an upgrade does not start from a cold cache.
+
+// legacyCached returns the entry of v under its legacy key in the cache
+// tiers get looks in, copying it to its new key.
+func (h *Handler) legacyCached(ctx context.Context, get cacheGetter, v variant) ([]byte, bool) {
+	if v.legacyKey == "" {
+		return nil, false
+	}
+	data, found := get(ctx, v.legacyKey)
+	if found {
+		metrics.CacheOpsTotal.WithLabelValues("hit_legacy").Inc()
+		h.Cache.Set(ctx, v.cacheKey, data, 0)
+	}
+	return data, found
+}
+
+// adoptLegacyFile hard-links the disk entry of v under its legacy key to
+// cachePath, reporting whether there was one. The link keeps the entry's
+// modification time, so it goes stale when the old entry would have.
+func (h *Handler) adoptLegacyFile(cachePath string, v variant) bool {
+	if v.legacyKey == "" {
+		return false
+	}
+	legacyPath := cache.GetCachePath(h.CacheDir, v.legacyKey)
+	if _, err := os.Stat(legacyPath); err != nil {
+		return false
+	}
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return false
+	}
+	if err := os.Link(legacyPath, cachePath); err != nil && !os.IsExist(err) {
+		return false
+	}
+	metrics.CacheOpsTotal.WithLabelValues("hit_legacy").Inc()
+	return true
+}
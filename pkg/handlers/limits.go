@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// Longest accepted parameter values. Overlay text gets more room than the
+// numeric and keyword options.
+const (
+	maxTextParamLength = 200
+	maxParamLength     = 100
+)
+
+// limitViolation names the request limit a request exceeds.
+type limitViolation struct {
+	Error string `json:"error"`
+	Field string `json:"field"`
+	Limit int64  `json:"limit"`
+}
+
+func writeLimitViolation(w http.ResponseWriter, status int, rule string, violation limitViolation) {
+	metrics.PolicyViolations.WithLabelValues(rule).Inc()
+	writeJSON(w, status, violation)
+}
+
+// WithLimits rejects URLs longer than MAX_URL_LENGTH with 414 and caps
+// request bodies at MAX_BODY_BYTES, before any endpoint parses them.
+func (h *Handler) WithLimits(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.ConfigManager.Get()
+		if cfg.MaxURLLength > 0 && len(r.RequestURI) > cfg.MaxURLLength {
+			writeLimitViolation(w, http.StatusRequestURITooLong, "url_length", limitViolation{
+				Error: "URL too long",
+				Field: "url",
+				Limit: int64(cfg.MaxURLLength),
+			})
+			return
+		}
+		if cfg.MaxBodyBytes > 0 {
+			http.MaxBytesHandler(next, cfg.MaxBodyBytes).ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkParamLengths returns the first parameter whose value is too long.
+func checkParamLengths(params url.Values) *limitViolation {
+	for name, values := range params {
+		limit := maxParamLength
+		if name == "text" {
+			limit = maxTextParamLength
+		}
+		for _, value := range values {
+			if len(value) > limit {
+				return &limitViolation{
+					Error: "parameter value too long",
+					Field: name,
+					Limit: int64(limit),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// decodeJSONBody decodes the request body into v. It writes a 413 when the
+// body exceeds MAX_BODY_BYTES, or a 400 when it is not valid JSON, and
+// returns false in both cases.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeLimitViolation(w, http.StatusRequestEntityTooLarge, "body_size", limitViolation{
+			Error: "request body too large",
+			Field: "body",
+			Limit: tooLarge.Limit,
+		})
+		return false
+	}
+	http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+	return false
+}
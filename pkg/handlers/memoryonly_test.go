@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/storage"
+	"github.com/CodeTease/quirm/pkg/watermark"
+)
+
+// fetchCounter counts the origin fetches made through it.
+type fetchCounter struct {
+	storage.StorageProvider
+	fetches atomic.Int32
+}
+
+func (s *fetchCounter) GetObjectIfNoneMatch(ctx context.Context, key, etag string) (io.ReadCloser, storage.ObjectInfo, error) {
+	s.fetches.Add(1)
+	return s.StorageProvider.GetObjectIfNoneMatch(ctx, key, etag)
+}
+
+// TestMemoryOnlyRestart builds a variant under DISK_CACHE_MIN_BYTES and one
+// above it, then restarts: the small one is gone with the memory cache and
+// built again, the large one is still served from disk.
+func TestMemoryOnlyRestart(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.Config{CacheTTL: time.Hour, DiskCacheMinBytes: 64}
+	objects := storage.NewMemoryProvider()
+	objects.Put("photos/a.png", testPNG(t, 40, 40), "image/png")
+
+	// start returns a fresh instance on the shared disk cache. Its builds
+	// stop once the source is fetched, so they can be counted without
+	// libvips.
+	start := func() (*Handler, *fetchCounter) {
+		origin := &fetchCounter{StorageProvider: unreadableStorage{objects}}
+		return &Handler{
+			ConfigManager: config.NewManagerWithConfig(cfg),
+			S3:            origin,
+			WM:            watermark.NewManager("", 0.5, false),
+			Group:         &singleflight.Group{},
+			CacheDir:      dir,
+			Cache:         newMapCache(),
+		}, origin
+	}
+	png := func(size int) []byte {
+		return append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0x42}, size-8)...)
+	}
+	variants := []struct {
+		query    string
+		data     []byte
+		onDisk   bool
+		rebuilds bool // after the restart
+	}{
+		{query: "w=100", data: png(30), rebuilds: true},
+		{query: "w=300", data: png(200), onDisk: true},
+	}
+	get := func(h *Handler, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.serveAsset(w, httptest.NewRequest(http.MethodGet, "/photos/a.png?"+query, nil))
+		return w
+	}
+
+	h, origin := start()
+	for _, v := range variants {
+		// What a build stores
+		params, _ := url.ParseQuery(v.query)
+		key := h.resolveVariant(cfg, "photos/a.png", "", params, http.Header{}).cacheKey
+		path := cache.GetCachePath(dir, key)
+		if err := h.saveProcessed(path, v.data); err != nil {
+			t.Fatal(err)
+		}
+		h.Cache.Set(context.Background(), key, v.data, 0)
+
+		if _, err := os.Stat(path); (err == nil) != v.onDisk {
+			t.Errorf("%s: %d bytes on disk %v, want %v", v.query, len(v.data), err == nil, v.onDisk)
+		}
+		if w := get(h, v.query); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), v.data) {
+			t.Errorf("%s before the restart: status %d with %d bytes, want the cached variant", v.query, w.Code, w.Body.Len())
+		}
+	}
+	if n := origin.fetches.Load(); n != 0 {
+		t.Fatalf("%d origin fetches for cached variants", n)
+	}
+
+	h, origin = start()
+	for _, v := range variants {
+		before := origin.fetches.Load()
+		w := get(h, v.query)
+		if rebuilt := origin.fetches.Load() > before; rebuilt != v.rebuilds {
+			t.Errorf("%s after the restart: rebuilt %v, want %v", v.query, rebuilt, v.rebuilds)
+		}
+		if !v.rebuilds && (w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), v.data)) {
+			t.Errorf("%s after the restart: status %d with %d bytes, want the disk entry", v.query, w.Code, w.Body.Len())
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithMethods(t *testing.T) {
+	tests := []struct {
+		method string
+		want   int
+		served bool
+		allow  bool
+	}{
+		{method: http.MethodGet, want: http.StatusOK, served: true},
+		{method: http.MethodHead, want: http.StatusOK, served: true},
+		{method: http.MethodDelete, want: http.StatusOK, served: true},
+		{method: http.MethodOptions, want: http.StatusNoContent, allow: true},
+		{method: http.MethodPost, want: http.StatusMethodNotAllowed, allow: true},
+		{method: http.MethodPut, want: http.StatusMethodNotAllowed, allow: true},
+		{method: http.MethodPatch, want: http.StatusMethodNotAllowed, allow: true},
+		{method: "PROPFIND", want: http.StatusMethodNotAllowed, allow: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			served := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { served = true })
+			w := httptest.NewRecorder()
+			(&Handler{}).withMethods(next).ServeHTTP(w, httptest.NewRequest(tt.method, "/photos/a.jpg", nil))
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+			if served != tt.served {
+				t.Errorf("served %v, want %v", served, tt.served)
+			}
+			allow := w.Header().Get("Allow")
+			if tt.allow && allow != assetMethods {
+				t.Errorf("Allow %q, want %q", allow, assetMethods)
+			}
+			if !tt.allow && allow != "" {
+				t.Errorf("Allow %q on a served request", allow)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+	"github.com/CodeTease/quirm/pkg/storage"
+)
+
+// Object metadata keys recognized as processing hints (x-amz-meta-<key>).
+const (
+	metaFocalPoint  = "focal-point"  // "0.3,0.6": fractions from the left and top
+	metaNoWatermark = "no-watermark" // "true": never watermark this object
+	metaMaxWidth    = "max-width"    // "1600": cap the output width
+)
+
+// sourceObject receives the ObjectInfo of the origin object a build read,
+// taken from the response of the download itself, so recording the entry's
+// version and honouring its metadata costs no extra request.
+type sourceObject struct {
+	info storage.ObjectInfo
+	ok   bool
+	// frames is the frame count of the source of a static variant
+	frames int
+}
+
+type sourceObjectKey struct{}
+
+// withSourceObject returns a context whose builds record their source in src.
+func withSourceObject(ctx context.Context, src *sourceObject) context.Context {
+	return context.WithValue(ctx, sourceObjectKey{}, src)
+}
+
+func sourceObjectFrom(ctx context.Context) *sourceObject {
+	src, _ := ctx.Value(sourceObjectKey{}).(*sourceObject)
+	return src
+}
+
+// recordSource notes info as the source of the build running in ctx.
+func recordSource(ctx context.Context, info storage.ObjectInfo) {
+	if src := sourceObjectFrom(ctx); src != nil {
+		src.info, src.ok = info, true
+	}
+}
+
+// recordFrames notes the frame count of the source of the build running in
+// ctx, as reported by the processor.
+func recordFrames(ctx context.Context, stats *processor.Stats) {
+	if src := sourceObjectFrom(ctx); src != nil && stats != nil {
+		src.frames = stats.SourceFrames
+	}
+}
+
+// sourceHints applies the processing hints of the source recorded in ctx to
+// opts when HONOR_OBJECT_METADATA is on. Builds call it once they have
+// fetched the source.
+func sourceHints(ctx context.Context, cfg config.Config, opts processor.ImageOptions) processor.ImageOptions {
+	if src := sourceObjectFrom(ctx); cfg.HonorObjectMetadata && src != nil && src.ok {
+		return applyObjectHints(opts, src.info.Metadata)
+	}
+	return opts
+}
+
+// applyObjectHints folds the processing hints found in an object's metadata
+// into opts. Explicit request options win: the focal point only applies
+// without a focus parameter and the width cap only without an explicit
+// width. The watermark can be suppressed by the metadata alone.
+func applyObjectHints(opts processor.ImageOptions, metadata map[string]string) processor.ImageOptions {
+	if len(metadata) == 0 {
+		return opts
+	}
+
+	if opts.Focus == "" && opts.Fit == "cover" {
+		if x, y, ok := strings.Cut(metadata[metaFocalPoint], ","); ok {
+			if fx, fy, ok := parseFocalPoint(x, y); ok {
+				opts.Focus = "point"
+				opts.FocalX, opts.FocalY = fx, fy
+			}
+		}
+	}
+
+	if v, err := strconv.ParseBool(metadata[metaNoWatermark]); err == nil && v {
+		opts.NoWatermark = true
+	}
+
+	if opts.Width == 0 {
+		if n, err := strconv.Atoi(metadata[metaMaxWidth]); err == nil && n > 0 {
+			if opts.MaxWidth == 0 || n < opts.MaxWidth {
+				opts.MaxWidth = n
+			}
+		}
+	}
+
+	return opts
+}
+
+// parseFocalPoint parses a focal point given as two fractions in [0, 1].
+func parseFocalPoint(x, y string) (float64, float64, bool) {
+	if x == "" || y == "" {
+		return 0, 0, false
+	}
+	fx, errX := strconv.ParseFloat(strings.TrimSpace(x), 64)
+	fy, errY := strconv.ParseFloat(strings.TrimSpace(y), 64)
+	if errX != nil || errY != nil || fx < 0 || fx > 1 || fy < 0 || fy > 1 {
+		return 0, 0, false
+	}
+	return fx, fy, true
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+	"github.com/CodeTease/quirm/pkg/storage"
+)
+
+func TestApplyObjectHints(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     processor.ImageOptions
+		metadata map[string]string
+		want     processor.ImageOptions
+	}{
+		{
+			name: "no metadata",
+			opts: processor.ImageOptions{Width: 300, Fit: "cover"},
+			want: processor.ImageOptions{Width: 300, Fit: "cover"},
+		},
+		{
+			name:     "focal point on a cover crop",
+			opts:     processor.ImageOptions{Width: 300, Height: 300, Fit: "cover"},
+			metadata: map[string]string{metaFocalPoint: "0.3, 0.6"},
+			want:     processor.ImageOptions{Width: 300, Height: 300, Fit: "cover", Focus: "point", FocalX: 0.3, FocalY: 0.6},
+		},
+		{
+			name:     "focal point without a crop",
+			opts:     processor.ImageOptions{Width: 300},
+			metadata: map[string]string{metaFocalPoint: "0.3,0.6"},
+			want:     processor.ImageOptions{Width: 300},
+		},
+		{
+			name:     "explicit focus wins",
+			opts:     processor.ImageOptions{Width: 300, Height: 300, Fit: "cover", Focus: "face"},
+			metadata: map[string]string{metaFocalPoint: "0.3,0.6"},
+			want:     processor.ImageOptions{Width: 300, Height: 300, Fit: "cover", Focus: "face"},
+		},
+		{
+			name:     "focal point out of range",
+			opts:     processor.ImageOptions{Width: 300, Height: 300, Fit: "cover"},
+			metadata: map[string]string{metaFocalPoint: "1.5,0.2"},
+			want:     processor.ImageOptions{Width: 300, Height: 300, Fit: "cover"},
+		},
+		{
+			name:     "no watermark",
+			metadata: map[string]string{metaNoWatermark: "true"},
+			want:     processor.ImageOptions{NoWatermark: true},
+		},
+		{
+			name:     "watermark kept",
+			metadata: map[string]string{metaNoWatermark: "no"},
+			want:     processor.ImageOptions{},
+		},
+		{
+			name:     "max width without an explicit width",
+			opts:     processor.ImageOptions{Height: 400},
+			metadata: map[string]string{metaMaxWidth: "1600"},
+			want:     processor.ImageOptions{Height: 400, MaxWidth: 1600},
+		},
+		{
+			name:     "explicit width wins",
+			opts:     processor.ImageOptions{Width: 2000},
+			metadata: map[string]string{metaMaxWidth: "1600"},
+			want:     processor.ImageOptions{Width: 2000},
+		},
+		{
+			name:     "smaller policy cap kept",
+			opts:     processor.ImageOptions{MaxWidth: 1200},
+			metadata: map[string]string{metaMaxWidth: "1600"},
+			want:     processor.ImageOptions{MaxWidth: 1200},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := applyObjectHints(tt.opts, tt.metadata); got != tt.want {
+				t.Errorf("applyObjectHints() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSourceHints follows the hints from the metadata of a stored object to
+// the options of the build that downloads it.
+func TestSourceHints(t *testing.T) {
+	provider := storage.NewMemoryProvider()
+	provider.Put("photos/a.jpg", []byte("jpeg"), "image/jpeg")
+	if err := provider.SetMetadata("photos/a.jpg", map[string]string{metaNoWatermark: "true", metaMaxWidth: "800"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, honor := range []bool{true, false} {
+		ctx := withSourceObject(context.Background(), &sourceObject{})
+		reader, info, err := provider.GetObjectIfNoneMatch(ctx, "photos/a.jpg", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader.Close()
+		recordSource(ctx, info)
+
+		got := sourceHints(ctx, config.Config{HonorObjectMetadata: honor}, processor.ImageOptions{Height: 300})
+		want := processor.ImageOptions{Height: 300}
+		if honor {
+			want.NoWatermark, want.MaxWidth = true, 800
+		}
+		if got != want {
+			t.Errorf("HONOR_OBJECT_METADATA=%v: options = %+v, want %+v", honor, got, want)
+		}
+	}
+
+	// Nothing recorded: the build has not fetched its source
+	ctx := withSourceObject(context.Background(), &sourceObject{})
+	if got := sourceHints(ctx, config.Config{HonorObjectMetadata: true}, processor.ImageOptions{}); got != (processor.ImageOptions{}) {
+		t.Errorf("without a source: options = %+v, want none", got)
+	}
+}
+
+// TestRevalidateMetadata checks that replacing an object's metadata, which
+// keeps its ETag, rebuilds entries built with its hints.
+func TestRevalidateMetadata(t *testing.T) {
+	provider := storage.NewMemoryProvider()
+	provider.Put("photos/a.jpg", []byte("jpeg"), "image/jpeg")
+	metadata := map[string]string{metaMaxWidth: "800"}
+	if err := provider.SetMetadata("photos/a.jpg", metadata); err != nil {
+		t.Fatal(err)
+	}
+	info, err := provider.StatObject(context.Background(), "photos/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Handler{
+		ConfigManager: config.NewManagerWithConfig(config.Config{HonorObjectMetadata: true}),
+		S3:            provider,
+	}
+	path := filepath.Join(t.TempDir(), "entry")
+	if err := os.WriteFile(path, []byte("variant"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta := cache.Meta{ETag: info.ETag, LastModified: info.LastModified, Metadata: metadata, ObjectKey: "photos/a.jpg"}
+	if err := cache.WriteMeta(path, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	if !h.revalidate(context.Background(), "photos/a.jpg", path, "key", true) {
+		t.Fatal("unchanged object was not revalidated")
+	}
+	if err := provider.SetMetadata("photos/a.jpg", map[string]string{metaMaxWidth: "400"}); err != nil {
+		t.Fatal(err)
+	}
+	if h.revalidate(context.Background(), "photos/a.jpg", path, "key", true) {
+		t.Error("object with new metadata was revalidated")
+	}
+}
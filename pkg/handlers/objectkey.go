@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/sign"
+)
+
+// Request paths become object keys, signed paths and purge targets through
+// the same normalization:
+//   - percent-escapes are decoded exactly once, by net/http into URL.Path,
+//     and never again;
+//   - an encoded slash ("%2F") is rejected unless ALLOW_ENCODED_SLASHES is
+//     set, in which case it is a plain "/" (S3 cannot tell them apart);
+//   - "+" is a literal plus, not a space;
+//   - non-ASCII characters are kept byte for byte, without Unicode
+//     normalization, as S3 compares keys byte for byte;
+//   - dot segments and repeated slashes are removed by path.Clean, which
+//     behaves the same on every OS.
+
+var errEncodedSlash = errors.New("encoded slashes are not allowed in object keys")
+
+// checkEncodedSlash rejects URLs whose path holds an encoded slash, unless
+// ALLOW_ENCODED_SLASHES is set.
+func checkEncodedSlash(cfg config.Config, u *url.URL) error {
+	if !cfg.AllowEncodedSlashes && strings.Contains(strings.ToUpper(u.EscapedPath()), "%2F") {
+		return errEncodedSlash
+	}
+	return nil
+}
+
+// cleanPath returns the normalized form of a decoded request path, always
+// starting with a slash.
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// signedPath returns the path a URL signature covers: the normalized path
+// without the immutable version segment.
+func signedPath(cfg config.Config, urlPath string) string {
+	return sign.Path(urlPath, cfg.ImmutableURLs)
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/CodeTease/quirm/pkg/config"
+)
+
+func TestObjectKeyNormalization(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string // request target as sent on the wire
+		allowSlash bool
+		key        string
+		signed     string
+		slashErr   bool
+	}{
+		{name: "space", target: "/a%20b.jpg", key: "a b.jpg", signed: "/a b.jpg"},
+		{name: "unicode", target: "/%E2%82%AC.png", key: "€.png", signed: "/€.png"},
+		{name: "encoded slash", target: "/a%2Fb.jpg", slashErr: true},
+		{name: "encoded slash, lowercase", target: "/a%2fb.jpg", slashErr: true},
+		{name: "encoded slash allowed", target: "/a%2Fb.jpg", allowSlash: true, key: "a/b.jpg", signed: "/a/b.jpg"},
+		{name: "plus", target: "/a+b.jpg", key: "a+b.jpg", signed: "/a+b.jpg"},
+		{name: "decoded once", target: "/a%2520b.jpg", key: "a%20b.jpg", signed: "/a%20b.jpg"},
+		{name: "dot segments", target: "/x/../y//a%20b.jpg", key: "y/a b.jpg", signed: "/y/a b.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{AllowEncodedSlashes: tt.allowSlash}
+			u, err := url.ParseRequestURI(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = checkEncodedSlash(cfg, u)
+			if tt.slashErr {
+				if !errors.Is(err, errEncodedSlash) {
+					t.Fatalf("checkEncodedSlash() = %v, want %v", err, errEncodedSlash)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("checkEncodedSlash() = %v", err)
+			}
+
+			key, _, ok := requestTarget(cfg, u.Path)
+			if !ok || key != tt.key {
+				t.Errorf("requestTarget() = %q, %v, want %q", key, ok, tt.key)
+			}
+			if got := signedPath(cfg, u.Path); got != tt.signed {
+				t.Errorf("signedPath() = %q, want %q", got, tt.signed)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// wantsOriginal reports whether params ask for the untouched original
+// (?original=true): no processing, auto-format, watermark or compression.
+func wantsOriginal(params url.Values) bool {
+	return params.Get("original") == "true"
+}
+
+// originalAllowed reports whether r may ask for the untouched original. The
+// URL must carry a valid signature (or the request a valid bearer token), or
+// the request must come from ALLOWED_CIDRS, so ordinary links cannot be
+// rewritten to bypass watermarks and format rules.
+func originalAllowed(cfg config.Config, r *http.Request) bool {
+	if isTrustedIP(cfg, clientAddr(cfg, r)) || r.Context().Value(tokenAuthKey{}) != nil {
+		return true
+	}
+	return cfg.SecretKey != "" && validateSignature(signedPath(cfg, r.URL.Path), r.URL.Query(), cfg.SecretKey)
+}
+
+// serveVariantFile serves the disk entry of v. Originals carry the
+// Content-Type the origin stored them with and processed variants the one
+// of the format they were encoded in: recorded with the entry, or read from
+// its signature for entries written before it was recorded.
+func (h *Handler) serveVariantFile(w http.ResponseWriter, r *http.Request, path, objectKey string, v variant) {
+	if v.original || v.shouldProcess {
+		meta, err := cache.ReadMeta(path)
+		if err == nil && meta.ContentType != "" {
+			w.Header().Set("Content-Type", meta.ContentType)
+		} else if v.shouldProcess {
+			if contentType := sniffFile(path); contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+		}
+		setStaticHeader(w, v, meta.SourceFrames)
+	}
+	setGrayscaleHeaderFile(w, h.ConfigManager.Get(), v, path)
+	h.serveFile(w, r, path, v.encodingType, objectKey, v.opts.Format)
+}
+
+// sniffFile returns the MIME type of the image encoded in the file at path,
+// from its first bytes, or "" when it is not recognized.
+func sniffFile(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	head := make([]byte, 1024)
+	n, _ := io.ReadFull(file, head)
+	return processor.SniffContentType(head[:n])
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/sign"
+)
+
+func TestOriginalAllowed(t *testing.T) {
+	const secret = "test-secret"
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	signed := sign.SignURL(secret, "/photos/a.jpg", url.Values{"original": {"true"}}, time.Time{})
+
+	tests := []struct {
+		name   string
+		cfg    config.Config
+		target string
+		remote string
+		token  bool
+		want   bool
+	}{
+		{
+			name:   "unsigned",
+			cfg:    config.Config{SecretKey: secret},
+			target: "/photos/a.jpg?original=true",
+			want:   false,
+		},
+		{
+			name:   "signed",
+			cfg:    config.Config{SecretKey: secret},
+			target: signed,
+			want:   true,
+		},
+		{
+			name:   "signed for another key",
+			cfg:    config.Config{SecretKey: secret},
+			target: "/photos/b.jpg?" + mustQuery(t, signed),
+			want:   false,
+		},
+		{
+			name:   "signature without a secret",
+			target: signed,
+			want:   false,
+		},
+		{
+			name:   "trusted CIDR",
+			cfg:    config.Config{AllowedCIDRNets: []*net.IPNet{trusted}},
+			target: "/photos/a.jpg?original=true",
+			remote: "10.1.2.3:51234",
+			want:   true,
+		},
+		{
+			name:   "outside the trusted CIDR",
+			cfg:    config.Config{AllowedCIDRNets: []*net.IPNet{trusted}},
+			target: "/photos/a.jpg?original=true",
+			remote: "192.0.2.1:51234",
+			want:   false,
+		},
+		{
+			name:   "bearer token",
+			target: "/photos/a.jpg?original=true",
+			token:  true,
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.remote != "" {
+				r.RemoteAddr = tt.remote
+			}
+			if tt.token {
+				r = r.WithContext(context.WithValue(r.Context(), tokenAuthKey{}, true))
+			}
+			if got := originalAllowed(tt.cfg, r); got != tt.want {
+				t.Errorf("originalAllowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOriginalPolicy(t *testing.T) {
+	cfg := config.Config{AllowedTransforms: map[string]config.TransformPolicy{
+		"locked/": {MaxWidth: 1200},
+		"open/":   {MaxWidth: 1200, Passthrough: true},
+	}}
+	params := url.Values{"original": {"true"}}
+	h := &Handler{}
+
+	// Even a signed or trusted request gets no original where the policy
+	// forbids passthrough
+	violation := checkPolicy(h.resolveVariant(cfg, "locked/a.jpg", "", params, nil))
+	if violation == nil || violation.Rule != "passthrough" {
+		t.Errorf("locked: violation = %+v, want the passthrough rule", violation)
+	}
+	if violation := checkPolicy(h.resolveVariant(cfg, "open/a.jpg", "", params, nil)); violation != nil {
+		t.Errorf("open: violation = %+v, want none", violation)
+	}
+}
+
+func mustQuery(t *testing.T, rawURL string) string {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.RawQuery
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/storage"
+	"golang.org/x/sync/singleflight"
+)
+
+// countingStorage is a storage.StorageProvider holding one object in memory
+// that counts the object downloads and holds each one for delay.
+type countingStorage struct {
+	data  []byte
+	etag  string
+	delay time.Duration
+	gets  atomic.Int32
+}
+
+var _ storage.StorageProvider = (*countingStorage)(nil)
+
+func (s *countingStorage) info() storage.ObjectInfo {
+	return storage.ObjectInfo{Size: int64(len(s.data)), ETag: s.etag, ContentType: "text/css", LastModified: time.Unix(1_700_000_000, 0)}
+}
+
+func (s *countingStorage) GetObject(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	reader, info, err := s.GetObjectIfNoneMatch(ctx, key, "")
+	return reader, info.Size, err
+}
+
+func (s *countingStorage) GetObjectIfNoneMatch(ctx context.Context, key, etag string) (io.ReadCloser, storage.ObjectInfo, error) {
+	if etag != "" && etag == s.etag {
+		return nil, storage.ObjectInfo{}, storage.ErrNotModified
+	}
+	s.gets.Add(1)
+	time.Sleep(s.delay)
+	return io.NopCloser(bytes.NewReader(s.data)), s.info(), nil
+}
+
+func (s *countingStorage) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	s.gets.Add(1)
+	end := int64(len(s.data))
+	if length > 0 && offset+length < end {
+		end = offset + length
+	}
+	return io.NopCloser(bytes.NewReader(s.data[offset:end])), nil
+}
+
+func (s *countingStorage) ListObjects(ctx context.Context, prefix, startAfter string, fn func(key string) error) error {
+	return nil
+}
+
+func (s *countingStorage) StatObject(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	return s.info(), nil
+}
+
+func (s *countingStorage) GetPresignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return "", nil
+}
+
+func (s *countingStorage) Health(ctx context.Context) error { return nil }
+
+// TestPassthroughEncodingsShareFetch requests three encodings of an original
+// at once, each through its own singleflight key as serveAsset does, and
+// expects a single origin download.
+func TestPassthroughEncodingsShareFetch(t *testing.T) {
+	const objectKey = "css/site.css"
+	original := bytes.Repeat([]byte("body { margin: 0 } "), 200)
+	origin := &countingStorage{data: original, etag: `"v1"`, delay: 100 * time.Millisecond}
+	dir := t.TempDir()
+	h := &Handler{
+		S3:            origin,
+		CacheDir:      dir,
+		Group:         &singleflight.Group{},
+		ConfigManager: config.NewManagerWithConfig(config.Config{CacheTTL: time.Hour}),
+	}
+
+	encodings := []string{"identity", "br", "gzip"}
+	var wg sync.WaitGroup
+	errs := make([]error, len(encodings))
+	for i, encoding := range encodings {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cacheKey := originalCacheKey(objectKey, "", encoding)
+			path := cache.GetCachePath(dir, cacheKey)
+			_, errs[i], _ = h.Group.Do(cacheKey, func() (interface{}, error) {
+				return h.fetchPassthrough(context.Background(), objectKey, path, encoding)
+			})
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("%s: %v", encodings[i], err)
+		}
+	}
+	if n := origin.gets.Load(); n != 1 {
+		t.Errorf("%d origin downloads for three encodings, want 1", n)
+	}
+
+	read := func(encoding string) []byte {
+		data, err := os.ReadFile(cache.GetCachePath(dir, originalCacheKey(objectKey, "", encoding)))
+		if err != nil {
+			t.Fatalf("%s copy: %v", encoding, err)
+		}
+		return data
+	}
+	if !bytes.Equal(read("identity"), original) {
+		t.Error("identity copy differs from the original")
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(read("gzip")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(gz); err != nil || !bytes.Equal(data, original) {
+		t.Errorf("gzip copy does not decompress to the original: %v", err)
+	}
+	if br := read("br"); len(br) == 0 || len(br) >= len(original) {
+		t.Errorf("br copy of %d bytes for a %d byte original", len(br), len(original))
+	}
+
+	// Fresh copies need no origin request at all
+	if _, err := h.fetchPassthrough(context.Background(), objectKey, cache.GetCachePath(dir, originalCacheKey(objectKey, "", "zstd")), "zstd"); err != nil {
+		t.Fatal(err)
+	}
+	if n := origin.gets.Load(); n != 1 {
+		t.Errorf("%d origin downloads after adding zstd, want 1", n)
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// pathOptionName matches the key part of a "key_value" path option token.
+var pathOptionName = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// pathOptionAliases maps short path option names to their query parameter.
+var pathOptionAliases = map[string]string{
+	"f": "format",
+}
+
+// pathOptionParams lists the parameters that may be given in a leading
+// options segment when no marker prefix is configured. Restricting the names
+// keeps ordinary folders such as "my_photos/" from being read as options.
+var pathOptionParams = map[string]bool{
+	"w": true, "h": true, "fit": true, "format": true, "q": true,
+	"focus": true, "text": true, "color": true, "ts": true, "font": true,
+	"effect": true, "brightness": true, "contrast": true, "blurhash": true,
+	"animated": true, "page": true, "preset": true, "palette": true,
+	"expires": true, "static": true, "fp-x": true, "fp-y": true,
+	"neg": true, "t": true, "fps": true, "boomerang": true, "videocard": true,
+	"text_tpl": true, "bg": true, "sizes": true, "face_pad": true,
+	"optimize": true, "force": true, "auto_gray": true, "rot": true, "flip": true, "cx": true, "cy": true, "cw": true, "ch": true,
+}
+
+// parsePathOptions recognizes an imgproxy-style options segment at the start
+// of the object key, e.g. "w_300,h_200,fit_cover,f_webp/products/img.jpg",
+// and returns the remaining object key with the options as query parameters.
+// When marker is set the segment must be introduced by it ("t/<opts>/<key>"),
+// which removes any ambiguity with object keys containing commas.
+func parsePathOptions(objectKey, marker string) (string, url.Values, bool) {
+	rest := objectKey
+	if marker != "" {
+		prefix := strings.Trim(marker, "/") + "/"
+		if !strings.HasPrefix(rest, prefix) {
+			return objectKey, nil, false
+		}
+		rest = rest[len(prefix):]
+	}
+
+	segment, key, found := strings.Cut(rest, "/")
+	if !found || segment == "" || key == "" {
+		return objectKey, nil, false
+	}
+
+	params := url.Values{}
+	for _, token := range strings.Split(segment, ",") {
+		name, value, ok := strings.Cut(token, "_")
+		if !ok || value == "" || !pathOptionName.MatchString(name) {
+			return objectKey, nil, false
+		}
+		if alias, ok := pathOptionAliases[name]; ok {
+			name = alias
+		}
+		// The signature always travels in the query string
+		if name == "s" {
+			return objectKey, nil, false
+		}
+		if marker == "" && !pathOptionParams[name] {
+			return objectKey, nil, false
+		}
+		params.Add(name, value)
+	}
+
+	return key, params, true
+}
+
+// mergePathOptions combines options taken from the path with the query
+// parameters. Query parameters win when both define the same key.
+func mergePathOptions(pathParams, query url.Values) url.Values {
+	if len(pathParams) == 0 {
+		return query
+	}
+	merged := make(url.Values, len(pathParams)+len(query))
+	for k, v := range pathParams {
+		merged[k] = v
+	}
+	for k, v := range query {
+		merged[k] = v
+	}
+	return merged
+}
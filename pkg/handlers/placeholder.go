@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+	"github.com/CodeTease/quirm/pkg/storage"
+)
+
+// placeholderPrefix is the path placeholders are served under.
+const placeholderPrefix = "/_placeholder/"
+
+// HandlePlaceholder generates a placeholder image of the size in the path,
+// e.g. GET /_placeholder/600x400?bg=eeeeee&fg=999999&text=600x400&format=webp
+// (ENABLE_PLACEHOLDERS). Nothing is read from storage: the canvas is drawn
+// and run through the text overlay and encoder of the regular pipeline, and
+// the result is cached like a processed variant. It is served through
+// PlaceholderChain, so the allowlists, rate limit and signatures of asset
+// requests apply.
+func (h *Handler) HandlePlaceholder(w http.ResponseWriter, r *http.Request) {
+	cfg := h.ConfigManager.Get()
+	if !cfg.EnablePlaceholders {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p, err := parsePlaceholder(cfg, strings.TrimPrefix(r.URL.Path, placeholderPrefix), r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The name only gives serveFile and serveBytes the Content-Type
+	name := "_placeholder." + p.Format
+	opts := processor.ImageOptions{Format: p.Format}
+	cacheKey := cache.GenerateKeyOptions("_placeholder", p.Canonical(), p.Format)
+	w.Header().Set("ETag", `"`+cacheKey+`"`)
+
+	ctx := r.Context()
+	if h.Cache != nil {
+		if data, found := h.Cache.Get(ctx, cacheKey); found {
+			serveBytes(w, r, data, name, opts)
+			return
+		}
+	}
+	// Placeholders are deterministic, so a disk copy never goes stale
+	path := cache.GetCachePath(h.CacheDir, cacheKey)
+	if storage.FileExists(path) {
+		h.serveFile(w, r, path, "identity", name, p.Format)
+		return
+	}
+
+	result, err, _ := h.Group.Do(cacheKey, func() (interface{}, error) {
+		buf, err := processor.RenderPlaceholder(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		data := buf.Bytes()
+		if h.Cache != nil {
+			h.Cache.Set(ctx, cacheKey, data, 0)
+		}
+		return data, h.saveProcessed(path, data)
+	})
+	data, _ := result.([]byte)
+	if err != nil && len(data) == 0 {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	serveBytes(w, r, data, name, opts)
+}
+
+// parsePlaceholder reads a placeholder from its size ("600x400") and query
+// parameters. Sizes must be within MIN_OUTPUT_WIDTH/MIN_OUTPUT_HEIGHT and
+// PLACEHOLDER_MAX_DIMENSION, and colors parse with processor.ParseColor.
+func parsePlaceholder(cfg config.Config, size string, params url.Values) (processor.Placeholder, error) {
+	p := processor.Placeholder{
+		Background: params.Get("bg"),
+		Foreground: params.Get("fg"),
+		Text:       params.Get("text"),
+		Format:     normalizeFormat(params.Get("format")),
+	}
+
+	width, height, ok := strings.Cut(size, "x")
+	if !ok {
+		return p, errors.New("size must be WIDTHxHEIGHT, e.g. 600x400")
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		min   int
+		dest  *int
+	}{{"width", width, cfg.MinOutputWidth, &p.Width}, {"height", height, cfg.MinOutputHeight, &p.Height}} {
+		n, err := strconv.Atoi(d.value)
+		if err != nil || n < max(d.min, 1) || n > cfg.PlaceholderMaxDimension {
+			return p, fmt.Errorf("%s must be an integer from %d to %d", d.name, max(d.min, 1), cfg.PlaceholderMaxDimension)
+		}
+		*d.dest = n
+	}
+
+	if p.Background == "" {
+		p.Background = "cccccc"
+	}
+	if p.Foreground == "" {
+		p.Foreground = "666666"
+	}
+	if err := p.Validate(); err != nil {
+		return p, err
+	}
+
+	if p.Format == "" {
+		p.Format = "png"
+	}
+	if !processor.IsOutputFormat(p.Format) {
+		return p, fmt.Errorf("format %q is not supported", p.Format)
+	}
+	if q := params.Get("q"); q != "" {
+		n, err := strconv.Atoi(q)
+		if err != nil || n < 1 || n > 100 {
+			return p, errors.New("q must be an integer from 1 to 100")
+		}
+		p.Quality = n
+	}
+	// The text fits the smaller side unless ts is given
+	p.TextSize = float64(max(min(p.Width, p.Height)/8, 8))
+	if ts := params.Get("ts"); ts != "" {
+		n, err := strconv.ParseFloat(ts, 64)
+		if err != nil || n <= 0 {
+			return p, errors.New("ts must be a positive number")
+		}
+		p.TextSize = n
+	}
+	return p, nil
+}
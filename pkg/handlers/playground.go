@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	_ "embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/sign"
+)
+
+//go:embed playground.html
+var playgroundHTML string
+
+var playgroundTemplate = template.Must(template.New("playground").Parse(playgroundHTML))
+
+// playgroundFormats are the output formats offered by the playground.
+var playgroundFormats = []string{"jpeg", "png", "webp", "avif", "jxl", "gif"}
+
+type playgroundFormat struct {
+	Name      string
+	Supported bool
+}
+
+// HandlePlayground renders an interactive page for building and previewing
+// asset URLs (GET /_playground, admin only, ENABLE_PLAYGROUND). Options the
+// server cannot honour are shown disabled.
+func (h *Handler) HandlePlayground(w http.ResponseWriter, r *http.Request) {
+	cfg := h.ConfigManager.Get()
+	if !cfg.EnablePlayground {
+		http.NotFound(w, r)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+
+	caps := probeCapabilities(cfg)
+	formats := make([]playgroundFormat, 0, len(playgroundFormats))
+	for _, name := range playgroundFormats {
+		formats = append(formats, playgroundFormat{name, slices.Contains(caps.Formats, name)})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := playgroundTemplate.Execute(w, struct {
+		Caps    capabilities
+		Formats []playgroundFormat
+		Signed  bool
+	}{caps, formats, cfg.SecretKey != ""})
+	if err != nil {
+		slog.Error("Failed to render playground", "error", err)
+	}
+}
+
+type signRequest struct {
+	URL string `json:"url"`
+}
+
+// HandlePlaygroundSign signs an asset URL for the playground
+// (POST /_playground/sign, admin only). The body is {"url": "/key?w=300"};
+// the response carries the URL with its "s" parameter, or unchanged when no
+// SECRET_KEY is configured, so the key never reaches the browser.
+func (h *Handler) HandlePlaygroundSign(w http.ResponseWriter, r *http.Request) {
+	cfg := h.ConfigManager.Get()
+	if !cfg.EnablePlayground {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+
+	var req signRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || !strings.HasPrefix(u.Path, "/") || u.Host != "" {
+		http.Error(w, "Invalid url", http.StatusBadRequest)
+		return
+	}
+
+	params := u.Query()
+	params.Del("s")
+	if v := params.Get("expires"); v != "" {
+		if err := checkExpires(v, time.Now(), 0, cfg.MaxURLLifetime); err != nil {
+			http.Error(w, "Cannot sign: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	_, pathParams, _ := requestTarget(cfg, u.Path)
+	if cfg.SecretKey != "" && (len(params) > 0 || len(pathParams) > 0) {
+		params.Set("s", sign.Signature(cfg.SecretKey, signedPath(cfg, u.Path), params))
+	}
+	u.RawQuery = params.Encode()
+	writeJSON(w, http.StatusOK, map[string]string{"url": u.String()})
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// policyViolation names the transform policy rule a request breaks.
+type policyViolation struct {
+	Error  string      `json:"error"`
+	Prefix string      `json:"prefix"`
+	Rule   string      `json:"rule"`
+	Limit  interface{} `json:"limit,omitempty"`
+}
+
+// matchPolicy returns the transform policy of the longest prefix matching
+// objectKey, or nil when the key is unrestricted.
+func matchPolicy(cfg config.Config, objectKey string) (string, *config.TransformPolicy) {
+	var (
+		matched string
+		policy  *config.TransformPolicy
+	)
+	for prefix, p := range cfg.AllowedTransforms {
+		trimmed := strings.TrimPrefix(prefix, "/")
+		if strings.HasPrefix(objectKey, trimmed) && (policy == nil || len(trimmed) > len(matched)) {
+			matched, policy = trimmed, &p
+		}
+	}
+	return matched, policy
+}
+
+// policyAllowsFormat reports whether policy permits the output format.
+func policyAllowsFormat(policy *config.TransformPolicy, format string) bool {
+	if policy == nil || len(policy.Formats) == 0 {
+		return true
+	}
+	for _, f := range policy.Formats {
+		if normalizeFormat(f) == normalizeFormat(format) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeFormat(format string) string {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "jpg" {
+		return "jpeg"
+	}
+	return format
+}
+
+// checkPolicy evaluates the resolved variant against its transform policy.
+// It runs after presets and prefix rules have been applied, so neither can be
+// used to get around the limits. Sizes picked by client hints are clamped
+// while resolving the variant and sizes left open are capped by the
+// processor, so only explicit requests can exceed the maximums.
+func checkPolicy(v variant) *policyViolation {
+	policy := v.policy
+	if policy == nil {
+		return nil
+	}
+	violation := func(rule string, limit interface{}) *policyViolation {
+		return &policyViolation{Error: "transform not allowed by policy", Prefix: v.policyPrefix, Rule: rule, Limit: limit}
+	}
+
+	if !v.shouldProcess {
+		if !policy.Passthrough {
+			return violation("passthrough", false)
+		}
+		return nil
+	}
+	opts := v.opts
+	if policy.MaxWidth > 0 && opts.Width > policy.MaxWidth {
+		return violation("max_width", policy.MaxWidth)
+	}
+	if policy.MaxHeight > 0 && opts.Height > policy.MaxHeight {
+		return violation("max_height", policy.MaxHeight)
+	}
+	if policy.MaxQuality > 0 && opts.Quality > policy.MaxQuality {
+		return violation("max_quality", policy.MaxQuality)
+	}
+	// BlurHash placeholders are text, not images. Without a format the
+	// output keeps the source's, which must be allowed too.
+	if !opts.Blurhash && !policyAllowsFormat(policy, v.outputFormat) {
+		return violation("formats", policy.Formats)
+	}
+	return nil
+}
+
+func writePolicyViolation(w http.ResponseWriter, violation *policyViolation) {
+	metrics.PolicyViolations.WithLabelValues(violation.Rule).Inc()
+	writeJSON(w, http.StatusForbidden, violation)
+}
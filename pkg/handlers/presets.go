@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// maxPresetDepth bounds how many presets may reference each other through
+// their own "preset" parameter.
+const maxPresetDepth = 5
+
+// unknownPreset is the usage label of preset names that are not configured,
+// which keeps the labels bounded by the configuration.
+const unknownPreset = "unknown"
+
+var errUnknownPreset = errors.New("unknown preset")
+
+// resolvePreset handles a ?preset= name that is not configured, so a typo
+// no longer silently serves the full-size original: it is rejected with
+// PRESET_STRICT, and otherwise logged and replaced by DEFAULT_PRESET (or
+// left for expandPresets to ignore when there is none). label is the name
+// the request is counted under, "" without a preset.
+func resolvePreset(cfg config.Config, params url.Values) (_ url.Values, label string, _ error) {
+	name := params.Get("preset")
+	if name == "" {
+		return params, "", nil
+	}
+	if _, ok := cfg.Presets[name]; ok {
+		return params, name, nil
+	}
+	if cfg.PresetStrict {
+		return params, unknownPreset, fmt.Errorf("%w %q", errUnknownPreset, name)
+	}
+	slog.Warn("Unknown preset requested", "preset", name, "default", cfg.DefaultPreset)
+	if cfg.DefaultPreset != "" {
+		params.Set("preset", cfg.DefaultPreset)
+	}
+	return params, unknownPreset, nil
+}
+
+// countPreset records a request naming the preset label.
+func (h *Handler) countPreset(label string) {
+	metrics.PresetRequestsTotal.WithLabelValues(label).Inc()
+	h.presetMu.Lock()
+	defer h.presetMu.Unlock()
+	if h.presetCounts == nil {
+		h.presetCounts = make(map[string]int64)
+	}
+	h.presetCounts[label]++
+}
+
+// HandleStatsPresets reports how many requests named each preset since the
+// instance started (GET /_stats/presets, admin only). Configured presets
+// nobody uses are listed with 0; unknown names are counted under "unknown".
+func (h *Handler) HandleStatsPresets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+
+	counts := make(map[string]int64)
+	for name := range h.ConfigManager.Get().Presets {
+		counts[name] = 0
+	}
+	h.presetMu.Lock()
+	for label, n := range h.presetCounts {
+		counts[label] = n
+	}
+	h.presetMu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"presets": counts})
+}
+
+// expandPresets merges the named preset (and any preset it references) into
+// the request parameters, so that everything downstream works on a single
+// url.Values. Values defined by a preset override the ones given in the query,
+// and an outer preset overrides the presets it references.
+func expandPresets(params url.Values, presets map[string]string) (url.Values, error) {
+	name := params.Get("preset")
+	if name == "" || len(presets) == 0 {
+		return params, nil
+	}
+	if _, ok := presets[name]; !ok {
+		// Unknown names resolvePreset lets through are ignored
+		return params, nil
+	}
+
+	merged := make(url.Values, len(params))
+	for k, v := range params {
+		if k == "preset" {
+			continue
+		}
+		merged[k] = append([]string(nil), v...)
+	}
+
+	applied := make(map[string]bool)
+	seen := make(map[string]bool)
+	for name != "" {
+		if seen[name] {
+			return nil, fmt.Errorf("preset %q is referenced recursively", name)
+		}
+		if len(seen) >= maxPresetDepth {
+			return nil, fmt.Errorf("preset %q exceeds the maximum nesting depth of %d", name, maxPresetDepth)
+		}
+		seen[name] = true
+
+		query, ok := presets[name]
+		if !ok {
+			return nil, fmt.Errorf("preset references unknown preset %q", name)
+		}
+		presetParams, err := url.ParseQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid preset %q: %w", name, err)
+		}
+
+		name = presetParams.Get("preset")
+		for k, v := range presetParams {
+			if k == "preset" || applied[k] {
+				continue
+			}
+			merged[k] = v
+			applied[k] = true
+		}
+	}
+
+	return merged, nil
+}
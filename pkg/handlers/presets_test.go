@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestExpandPresets(t *testing.T) {
+	presets := map[string]string{
+		"thumb":   "w=200&h=200&fit=cover",
+		"webp":    "format=webp&q=70",
+		"card":    "preset=thumb&format=webp",
+		"gallery": "preset=card&q=60",
+		"blur":    "w=32&blurhash=true",
+		"loop-a":  "preset=loop-b",
+		"loop-b":  "preset=loop-a",
+		"self":    "w=10&preset=self",
+		"dangles": "preset=missing",
+		"deep-1":  "preset=deep-2",
+		"deep-2":  "preset=deep-3",
+		"deep-3":  "preset=deep-4",
+		"deep-4":  "preset=deep-5",
+		"deep-5":  "preset=deep-6",
+		"deep-6":  "w=1",
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+		err   string
+	}{
+		{name: "no preset", query: "w=300", want: "w=300"},
+		{name: "unknown preset is ignored", query: "preset=nope&w=300", want: "preset=nope&w=300"},
+		{name: "single", query: "preset=thumb", want: "fit=cover&h=200&w=200"},
+		{name: "preset overrides the query", query: "preset=thumb&w=999&q=50", want: "fit=cover&h=200&q=50&w=200"},
+		{name: "format", query: "preset=webp", want: "format=webp&q=70"},
+		{name: "blurhash", query: "preset=blur", want: "blurhash=true&w=32"},
+		{name: "nested", query: "preset=card", want: "fit=cover&format=webp&h=200&w=200"},
+		{name: "outer preset wins", query: "preset=gallery", want: "fit=cover&format=webp&h=200&q=60&w=200"},
+		{name: "cycle", query: "preset=loop-a", err: "recursively"},
+		{name: "self reference", query: "preset=self", err: "recursively"},
+		{name: "unknown nested preset", query: "preset=dangles", err: "unknown preset"},
+		{name: "too deep", query: "preset=deep-1", err: "nesting depth"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := expandPresets(params, presets)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expandPresets(%q) error %v, want %q", tt.query, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expandPresets(%q): %v", tt.query, err)
+			}
+			if got.Encode() != tt.want {
+				t.Errorf("expandPresets(%q) = %q, want %q", tt.query, got.Encode(), tt.want)
+			}
+		})
+	}
+}
+
+// TestPresetOptions checks that preset values reach the image options like
+// the same parameters given in the query.
+func TestPresetOptions(t *testing.T) {
+	presets := map[string]string{"lqip": "w=32&blurhash=true", "small-webp": "preset=lqip&format=WEBP"}
+	params, err := expandPresets(url.Values{"preset": {"small-webp"}}, presets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := parseImageOptions(params)
+	want := parseImageOptions(url.Values{"w": {"32"}, "blurhash": {"true"}, "format": {"webp"}})
+	if opts != want {
+		t.Errorf("options from the preset %+v, want %+v", opts, want)
+	}
+	if !opts.Blurhash || opts.Format != "webp" || opts.Width != 32 {
+		t.Errorf("options %+v: want a 32px webp blurhash", opts)
+	}
+}
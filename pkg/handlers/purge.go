@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+)
+
+// Purge outcomes, per cache tier.
+const (
+	purgePurged             = "purged"
+	purgeNotFound           = "not_found"
+	purgeSkipped            = "skipped"
+	purgePreconditionFailed = "precondition_failed"
+	purgeError              = "error"
+)
+
+// purgeResult reports what a purge did in each cache tier. Tiers that are
+// not configured are left out.
+type purgeResult struct {
+	Memory string `json:"memory,omitempty"`
+	Redis  string `json:"redis,omitempty"`
+	Disk   string `json:"disk"`
+}
+
+func (h *Handler) handlePurge(w http.ResponseWriter, r *http.Request, objectKey, contentHash string, params url.Values) {
+	// Presets have already been expanded into params by HandleRequest
+	// The keys are those a GET with the same parameters and headers would
+	// be served from: policies, auto-format, client hints, cache versions
+	// and the hash of an immutable URL all take part. ?bundle=lqip shares the plain variant's key.
+	cfg := h.ConfigManager.Get()
+	params.Del("bundle")
+	v := h.resolveVariant(cfg, objectKey, contentHash, params, r.Header)
+
+	// The first key is the entry preconditions are checked against
+	var cacheKeys []string
+	if v.shouldProcess {
+		cacheKeys = append(cacheKeys, v.cacheKey, lqipKey(v.cacheKey))
+		// Entries from before canonical keys may still be served through
+		// the legacy lookup
+		if v.legacyKey != "" {
+			cacheKeys = append(cacheKeys, v.legacyKey, lqipKey(v.legacyKey))
+		}
+	} else {
+		// Passthrough: the compressed copies are derived from the identity
+		// copy, so they are purged together
+		cacheKeys = append(cacheKeys, originalCacheKey(objectKey, v.version, "identity"))
+		for encoding := range supportedEncodings {
+			cacheKeys = append(cacheKeys, originalCacheKey(objectKey, v.version, encoding))
+		}
+	}
+
+	memory, redis := cacheTiers(h.Cache)
+	if !purgePreconditionsMet(r, cache.GetCachePath(h.CacheDir, cacheKeys[0])) {
+		result := purgeResult{Disk: purgePreconditionFailed}
+		if memory != nil {
+			result.Memory = purgeSkipped
+		}
+		if redis != nil {
+			result.Redis = purgeSkipped
+		}
+		writeJSON(w, http.StatusPreconditionFailed, result)
+		return
+	}
+
+	result := purgeResult{
+		Memory: purgeTier(r.Context(), memory, cacheKeys),
+		Redis:  purgeTier(r.Context(), redis, cacheKeys),
+		Disk:   h.purgeDisk(cacheKeys),
+	}
+	if h.Variants != nil {
+		for _, cacheKey := range cacheKeys {
+			h.Variants.Remove(objectKey, cacheKey)
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// purgePreconditionsMet evaluates If-Match and If-Unmodified-Since against
+// the disk entry at path, so a straggling purge does not wipe an entry that
+// was rebuilt in the meantime. If-Match compares the origin ETag the entry
+// was built from; If-Unmodified-Since its write time, and is ignored when
+// If-Match is present. Without a disk entry If-Match fails and
+// If-Unmodified-Since is ignored.
+func purgePreconditionsMet(r *http.Request, path string) bool {
+	ifMatch := r.Header.Get("If-Match")
+	ifUnmodifiedSince := r.Header.Get("If-Unmodified-Since")
+	if ifMatch == "" && ifUnmodifiedSince == "" {
+		return true
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return ifMatch == ""
+	}
+	if ifMatch != "" {
+		if strings.TrimSpace(ifMatch) == "*" {
+			return true
+		}
+		meta, err := cache.ReadMeta(path)
+		return err == nil && meta.ETag != "" && etagListContains(ifMatch, meta.ETag)
+	}
+	since, err := http.ParseTime(ifUnmodifiedSince)
+	if err != nil {
+		return true
+	}
+	return !cache.EntryTime(path, info).Truncate(time.Second).After(since)
+}
+
+// etagListContains reports whether the comma-separated ETag list holds etag.
+// Weak and quoted forms compare equal, as origins differ in how they quote.
+func etagListContains(list, etag string) bool {
+	normalize := func(s string) string {
+		return strings.Trim(strings.TrimPrefix(strings.TrimSpace(s), "W/"), `"`)
+	}
+	want := normalize(etag)
+	for _, candidate := range strings.Split(list, ",") {
+		if normalize(candidate) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheTiers splits the cache provider into its memory and Redis tiers.
+// Providers of other types are reported as the memory tier.
+func cacheTiers(c cache.CacheProvider) (memory, redis cache.CacheProvider) {
+	switch c := c.(type) {
+	case nil:
+		return nil, nil
+	case *cache.TieredCache:
+		if c.L2 != nil {
+			return c.L1, c.L2
+		}
+		return c.L1, nil
+	case *cache.RedisCache:
+		return nil, c
+	default:
+		return c, nil
+	}
+}
+
+// purgeTier deletes keys from one cache tier. Keys are deleted whether or
+// not they were found, as a concurrent write may not be visible yet.
+func purgeTier(ctx context.Context, c cache.CacheProvider, keys []string) string {
+	if c == nil {
+		return ""
+	}
+	result := purgeNotFound
+	for _, key := range keys {
+		_, found := c.Get(ctx, key)
+		if err := c.Delete(ctx, key); err != nil {
+			slog.Warn("Failed to delete from cache provider", "key", key, "error", err)
+			return purgeError
+		}
+		if found {
+			result = purgePurged
+		}
+	}
+	return result
+}
+
+func (h *Handler) purgeDisk(keys []string) string {
+	result := purgeNotFound
+	for _, key := range keys {
+		path := cache.GetCachePath(h.CacheDir, key)
+		err := os.Remove(path)
+		_ = os.Remove(cache.MetaPath(path))
+		switch {
+		case err == nil:
+			result = purgePurged
+		case !os.IsNotExist(err):
+			slog.Warn("Failed to delete from disk", "path", path, "error", err)
+			return purgeError
+		}
+	}
+	return result
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// prefixPurgeConcurrency bounds the cache entries a prefix purge deletes at
+// once, so a large purge does not flood Redis or the disk.
+const prefixPurgeConcurrency = 8
+
+type prefixPurgeResult struct {
+	// Purged counts the entries found and deleted in at least one tier
+	Purged int      `json:"purged"`
+	Errors []string `json:"errors"`
+	// Truncated is set when PURGE_PREFIX_MAX_ENTRIES was reached; the purge
+	// should be repeated until it is not
+	Truncated  bool  `json:"truncated"`
+	DurationMS int64 `json:"duration_ms"`
+}
+
+// prefixPurgeEntry is a cache entry to purge and the object it belongs to.
+type prefixPurgeEntry struct {
+	objectKey string
+	cacheKey  string
+}
+
+// isPrefixPurge reports whether r asks to purge every object under its path
+// (DELETE /products/2024/?prefix=true).
+func isPrefixPurge(r *http.Request) bool {
+	return r.Method == http.MethodDelete && r.URL.Query().Get("prefix") == "true"
+}
+
+// purgePrefix returns the object key prefix a prefix purge of urlPath
+// covers. A trailing slash is kept, so /products/2024/ does not also purge
+// /products/2024-archive.jpg.
+func purgePrefix(cfg config.Config, urlPath string) (string, bool) {
+	objectKey, _, ok := requestTarget(cfg, urlPath)
+	if !ok {
+		return "", false
+	}
+	if strings.HasSuffix(urlPath, "/") {
+		objectKey += "/"
+	}
+	return objectKey, true
+}
+
+// handlePrefixPurge deletes the cached variants and passthrough copies of
+// every object whose key starts with prefix, from every cache tier. Objects
+// are found in the variant index and, for entries the index does not know
+// (built before a restart), through the object key recorded in the disk
+// metadata. At most PURGE_PREFIX_MAX_ENTRIES entries are deleted per call.
+// Purged entries are no longer found, so repeating a purge that was
+// truncated or timed out resumes it, and repeating a complete one is a no-op.
+func (h *Handler) handlePrefixPurge(w http.ResponseWriter, r *http.Request, prefix string) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	start := time.Now()
+	cfg := h.ConfigManager.Get()
+	ctx := r.Context()
+
+	entries, truncated := h.prefixPurgeEntries(prefix, cfg.PurgePrefixMaxEntries)
+	result := prefixPurgeResult{Errors: []string{}, Truncated: truncated}
+	memory, redis := cacheTiers(h.Cache)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, prefixPurgeConcurrency)
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			result.Truncated = true
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(entry prefixPurgeEntry) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			found, err := h.purgeEntry(ctx, memory, redis, entry.cacheKey)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", entry.cacheKey, err))
+				return
+			}
+			if found {
+				result.Purged++
+			}
+			if h.Variants != nil {
+				h.Variants.Remove(entry.objectKey, entry.cacheKey)
+			}
+		}(entry)
+	}
+	wg.Wait()
+
+	outcome := "complete"
+	switch {
+	case len(result.Errors) > 0:
+		outcome = "failed"
+	case result.Truncated:
+		outcome = "truncated"
+	}
+	metrics.PrefixPurgesTotal.WithLabelValues(outcome).Inc()
+	metrics.PrefixPurgeEntriesTotal.Add(float64(result.Purged))
+	result.DurationMS = time.Since(start).Milliseconds()
+	slog.Info("Prefix purge finished", "prefix", prefix, "purged", result.Purged, "errors", len(result.Errors), "truncated", result.Truncated)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// prefixPurgeEntries collects up to limit cache entries of the objects whose
+// key starts with prefix. truncated reports whether more may remain.
+func (h *Handler) prefixPurgeEntries(prefix string, limit int) (entries []prefixPurgeEntry, truncated bool) {
+	seen := make(map[string]bool)
+	add := func(objectKey, cacheKey string) bool {
+		if seen[cacheKey] {
+			return true
+		}
+		if len(entries) >= limit {
+			truncated = true
+			return false
+		}
+		seen[cacheKey] = true
+		entries = append(entries, prefixPurgeEntry{objectKey: objectKey, cacheKey: cacheKey})
+		return true
+	}
+	// The passthrough copies of an object in every encoding, under a cache
+	// version
+	addOriginals := func(objectKey, version string) bool {
+		if !add(objectKey, originalCacheKey(objectKey, version, "identity")) {
+			return false
+		}
+		for encoding := range supportedEncodings {
+			if !add(objectKey, originalCacheKey(objectKey, version, encoding)) {
+				return false
+			}
+		}
+		return true
+	}
+	// The variants and placeholders of an object, and its unversioned
+	// passthrough copies
+	addObject := func(objectKey string) bool {
+		if h.Variants != nil {
+			for _, v := range h.Variants.List(objectKey) {
+				if !add(objectKey, v.Key) || !add(objectKey, lqipKey(v.Key)) {
+					return false
+				}
+			}
+		}
+		return addOriginals(objectKey, "")
+	}
+
+	objects := make(map[string]bool)
+	if h.Variants != nil {
+		for _, objectKey := range h.Variants.WithPrefix(prefix, limit) {
+			objects[objectKey] = true
+			if !addObject(objectKey) {
+				return entries, truncated
+			}
+		}
+	}
+	// Versioned passthrough copies are only known from the version recorded
+	// with their identity copy
+	err := cache.FindByObjectPrefix(h.CacheDir, prefix, func(cacheKey string, m cache.Meta) bool {
+		objectKey := m.ObjectKey
+		if !objects[objectKey] {
+			objects[objectKey] = true
+			if !addObject(objectKey) {
+				return false
+			}
+		}
+		if m.Version != "" && !addOriginals(objectKey, m.Version) {
+			return false
+		}
+		return add(objectKey, cacheKey) && add(objectKey, lqipKey(cacheKey))
+	})
+	if err != nil {
+		slog.Warn("Failed to walk disk cache for prefix purge", "prefix", prefix, "error", err)
+	}
+	return entries, truncated
+}
+
+// purgeEntry deletes one cache entry from every tier and reports whether any
+// tier held it. Deleting an entry that is already gone is not an error.
+func (h *Handler) purgeEntry(ctx context.Context, memory, redis cache.CacheProvider, cacheKey string) (bool, error) {
+	found := false
+	for _, c := range []cache.CacheProvider{memory, redis} {
+		if c == nil {
+			continue
+		}
+		removed, err := removeEntry(ctx, c, cacheKey)
+		if removed {
+			found = true
+		}
+		if err != nil {
+			return found, err
+		}
+	}
+	path := cache.GetCachePath(h.CacheDir, cacheKey)
+	err := os.Remove(path)
+	_ = os.Remove(cache.MetaPath(path))
+	switch {
+	case err == nil:
+		found = true
+	case !os.IsNotExist(err):
+		return found, err
+	}
+	return found, nil
+}
+
+// removeEntry deletes key from c and reports whether it was there. Caches
+// that can tell from the delete itself (cache.Remover) are not read, so a
+// purge never transfers the values it deletes.
+func removeEntry(ctx context.Context, c cache.CacheProvider, key string) (bool, error) {
+	if r, ok := c.(cache.Remover); ok {
+		return r.Remove(ctx, key)
+	}
+	_, found := c.Get(ctx, key)
+	return found, c.Delete(ctx, key)
+}
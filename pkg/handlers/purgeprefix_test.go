@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+)
+
+// removerCache is a mapCache that deletes through cache.Remover and counts
+// the reads made of it.
+type removerCache struct {
+	*mapCache
+	gets atomic.Int32
+}
+
+func (c *removerCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	c.gets.Add(1)
+	return c.mapCache.Get(ctx, key)
+}
+
+func (c *removerCache) Remove(ctx context.Context, key string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, found := c.entries[key]
+	delete(c.entries, key)
+	return found, nil
+}
+
+// TestPrefixPurgeVersioned purges the passthrough copies an object was
+// stored under with a cache version, in every encoding, and tells which
+// entries existed without reading them.
+func TestPrefixPurgeVersioned(t *testing.T) {
+	const objectKey, version, adminToken = "css/site.css", "v=3", "admin-secret"
+	dir := t.TempDir()
+	memory := &removerCache{mapCache: newMapCache()}
+	h := &Handler{
+		S3:       &countingStorage{data: bytes.Repeat([]byte("body { margin: 0 } "), 200), etag: `"v1"`},
+		CacheDir: dir,
+		Cache:    memory,
+		Group:    &singleflight.Group{},
+		ConfigManager: config.NewManagerWithConfig(config.Config{
+			CacheTTL: time.Hour, AdminToken: adminToken, PurgePrefixMaxEntries: 100,
+		}),
+	}
+
+	// Versioned copies on disk, of which only the identity copy has
+	// metadata, and one held in the memory cache
+	ctx := withCacheVersion(context.Background(), version)
+	var paths []string
+	for _, encoding := range []string{"identity", "gzip"} {
+		path := cache.GetCachePath(dir, originalCacheKey(objectKey, version, encoding))
+		if _, err := h.fetchPassthrough(ctx, objectKey, path, encoding); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	if _, err := cache.ReadMeta(paths[1]); err == nil {
+		t.Fatal("the gzip copy has metadata of its own")
+	}
+	memoryKey := originalCacheKey(objectKey, version, "br")
+	memory.Set(ctx, memoryKey, []byte("compressed"), 0)
+
+	r := httptest.NewRequest(http.MethodDelete, "/css/?prefix=true", nil)
+	r.Header.Set("Authorization", "Bearer "+adminToken)
+	w := httptest.NewRecorder()
+	h.handlePrefixPurge(w, r, "css/")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+
+	var result prefixPurgeResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Purged != 3 || len(result.Errors) != 0 || result.Truncated {
+		t.Errorf("result %+v, want 3 entries purged", result)
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left on disk", path)
+		}
+	}
+	if _, found := memory.mapCache.Get(ctx, memoryKey); found {
+		t.Error("memory entry left")
+	}
+	if n := memory.gets.Load(); n != 0 {
+		t.Errorf("%d cache reads, want none", n)
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+	"github.com/CodeTease/quirm/pkg/storage"
+)
+
+// refreshQueue runs background refreshes of stale entries on a fixed pool of
+// workers. A burst of stale hits (e.g. after a long outage) queues up instead
+// of starting an origin fetch and a libvips job each, which would starve
+// interactive misses. Refreshes that do not fit in the queue are dropped; the
+// stale copy is served either way and the next stale hit tries again.
+type refreshQueue struct {
+	tasks chan refreshTask
+
+	mu      sync.Mutex
+	pending map[string]bool // keys queued or running
+}
+
+type refreshTask struct {
+	key string
+	run func(context.Context) error
+}
+
+func newRefreshQueue(workers, queueSize int) *refreshQueue {
+	q := &refreshQueue{
+		tasks:   make(chan refreshTask, max(queueSize, 1)),
+		pending: make(map[string]bool),
+	}
+	for i := 0; i < max(workers, 1); i++ {
+		go q.worker()
+	}
+	return q
+}
+
+// submit queues a refresh of key without blocking and reports whether it
+// was accepted. A key already queued or running is not queued again, as
+// every stale hit until its refresh finishes asks for it.
+func (q *refreshQueue) submit(key string, run func(context.Context) error) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending[key] {
+		return true
+	}
+	select {
+	case q.tasks <- refreshTask{key: key, run: run}:
+		q.pending[key] = true
+		metrics.RefreshQueueDepth.Set(float64(len(q.tasks)))
+		return true
+	default:
+		metrics.BackgroundRefreshesTotal.WithLabelValues("dropped").Inc()
+		return false
+	}
+}
+
+func (q *refreshQueue) worker() {
+	for task := range q.tasks {
+		metrics.RefreshQueueDepth.Set(float64(len(q.tasks)))
+		metrics.RefreshWorkersActive.Inc()
+		start := time.Now()
+		// Refreshes fetch from the origin through the background connection
+		// pool, like warmups
+		err := task.run(storage.WithBackground(context.Background()))
+		metrics.RefreshDuration.Observe(time.Since(start).Seconds())
+		metrics.RefreshWorkersActive.Dec()
+		q.mu.Lock()
+		delete(q.pending, task.key)
+		q.mu.Unlock()
+
+		result := "completed"
+		if err != nil {
+			result = "failed"
+		}
+		metrics.BackgroundRefreshesTotal.WithLabelValues(result).Inc()
+	}
+}
+
+// refreshes returns the handler's refresh queue, started on first use with
+// the REFRESH_CONCURRENCY and REFRESH_QUEUE_SIZE in effect then.
+func (h *Handler) refreshes() *refreshQueue {
+	h.refreshOnce.Do(func() {
+		cfg := h.ConfigManager.Get()
+		h.refreshQueue = newRefreshQueue(cfg.RefreshConcurrency, cfg.RefreshQueueSize)
+	})
+	return h.refreshQueue
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/metrics"
+	"github.com/CodeTease/quirm/pkg/processor"
+	"github.com/CodeTease/quirm/pkg/storage"
+)
+
+// retryableBuild reports whether a failed variant build may succeed when run
+// again: the origin throttled, failed with a server error or reset the
+// connection, or libvips failed for lack of a buffer. Missing objects, other
+// client errors, oversized originals and undecodable images never are.
+func retryableBuild(err error) bool {
+	var sizeErr *FileSizeError
+	if errors.As(err, &sizeErr) || errors.Is(err, errDiskUnavailable) {
+		return false
+	}
+	return storage.IsTransient(err) || processor.IsTransient(err)
+}
+
+// withBuildRetries runs build, and runs it again up to PROCESS_RETRIES times
+// while it fails with a retryable error. The attempt number is recorded on
+// the span of ctx.
+func withBuildRetries(ctx context.Context, cfg config.Config, build func() ([]byte, error)) ([]byte, error) {
+	span := trace.SpanFromContext(ctx)
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			metrics.ProcessRetriesTotal.Inc()
+			span.AddEvent("Retry", trace.WithAttributes(attribute.Int("attempt", attempt)))
+		}
+		data, err := build()
+		span.SetAttributes(attribute.Int("process.attempt", attempt))
+
+		if err == nil || attempt > cfg.ProcessRetries || !retryableBuild(err) || !waitRetry(ctx, cfg.ProcessRetryBackoff<<(attempt-1)) {
+			if attempt > 1 {
+				outcome := "recovered"
+				if err != nil {
+					outcome = "failed"
+				}
+				metrics.ProcessRetryOutcomesTotal.WithLabelValues(outcome).Inc()
+			}
+			return data, err
+		}
+		slog.Warn("Variant build failed, retrying", "attempt", attempt, "error", err)
+	}
+}
+
+// waitRetry waits backoff plus up to half of it again as jitter, so retries
+// of concurrent builds spread out. It returns false without waiting when ctx
+// would expire first, keeping retries within the request's deadline, and
+// false when ctx is cancelled while waiting.
+func waitRetry(ctx context.Context, backoff time.Duration) bool {
+	delay := backoff + rand.N(backoff/2+1)
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+		return false
+	}
+	select {
+	case <-time.After(delay):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/metrics"
+	"github.com/CodeTease/quirm/pkg/processor"
+	"github.com/CodeTease/quirm/pkg/storage"
+)
+
+// selfTestTimeout bounds a whole self-test run.
+const selfTestTimeout = 20 * time.Second
+
+type selfTestStep struct {
+	Name       string  `json:"name"`
+	OK         bool    `json:"ok"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+type selfTestReport struct {
+	OK    bool           `json:"ok"`
+	Steps []selfTestStep `json:"steps"`
+	// RanAt is when the steps ran; within SELFTEST_INTERVAL of it the report
+	// is returned again instead of running them
+	RanAt  time.Time `json:"ran_at"`
+	Cached bool      `json:"cached"`
+}
+
+// HandleSelfTest runs a synthetic round trip through the pipeline
+// (GET /_selftest): a generated image is processed and encoded, ffmpeg
+// encodes one frame when video thumbnails are enabled, and a file is
+// written, read back and deleted in the cache directory. It answers 503 when
+// a step fails, so it can serve as a startup probe. Runs are spaced by at
+// least SELFTEST_INTERVAL; requests in between get the last report.
+func (h *Handler) HandleSelfTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	cfg := h.ConfigManager.Get()
+
+	// Concurrent requests wait for the run in progress and share its report
+	h.selfTestMu.Lock()
+	report := h.selfTest
+	cached := report != nil && time.Since(report.RanAt) < cfg.SelfTestInterval
+	if !cached {
+		report = h.runSelfTest(r.Context(), cfg)
+		h.selfTest = report
+	}
+	h.selfTestMu.Unlock()
+
+	status := http.StatusOK
+	if !report.OK {
+		status = http.StatusServiceUnavailable
+	}
+	doc := *report
+	doc.Cached = cached
+	writeJSON(w, status, doc)
+}
+
+// runSelfTest runs the self-test steps and records the outcome in the
+// quirm_selftest_ok gauge.
+func (h *Handler) runSelfTest(ctx context.Context, cfg config.Config) *selfTestReport {
+	// The report is shared, so a client going away does not fail the run
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), selfTestTimeout)
+	defer cancel()
+
+	report := &selfTestReport{OK: true, Steps: []selfTestStep{}, RanAt: time.Now()}
+	step := func(name string, run func() error) {
+		start := time.Now()
+		err := run()
+		s := selfTestStep{Name: name, OK: err == nil, DurationMS: float64(time.Since(start).Microseconds()) / 1000}
+		if err != nil {
+			s.Error = err.Error()
+			report.OK = false
+		}
+		report.Steps = append(report.Steps, s)
+	}
+
+	step("process", func() error {
+		// Resize, crop and encode, as a typical variant does
+		opts := processor.ImageOptions{Width: 32, Height: 32, Fit: "cover", Format: "webp", Quality: 75}
+		out, err := processor.Process(ctx, bytes.NewReader(processor.SelfTestImage()), opts, nil, 0, "_selftest.png")
+		if err != nil {
+			return err
+		}
+		if processor.SniffContentType(out.Bytes()) != "image/webp" {
+			return errors.New("output is not a WebP image")
+		}
+		return nil
+	})
+	if cfg.EnableVideoThumbnail {
+		step("ffmpeg", func() error {
+			return processor.ProbeFFmpeg(ctx)
+		})
+	}
+	step("cache_dir", func() error {
+		return selfTestCacheDir(h.CacheDir)
+	})
+
+	ok := 0.0
+	if report.OK {
+		ok = 1
+	}
+	metrics.SelfTestOK.Set(ok)
+	return report
+}
+
+// selfTestCacheDir writes a file into dir the way cache entries are written,
+// reads it back and deletes it.
+func selfTestCacheDir(dir string) error {
+	want := []byte("quirm selftest")
+	path := filepath.Join(dir, "_selftest")
+	if err := storage.AtomicWrite(path, bytes.NewReader(want), "identity", dir); err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	got, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return errors.New("read back different content")
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// TestServeRange requests the first 100 bytes of a processed variant from a
+// disk hit and from a memory hit.
+func TestServeRange(t *testing.T) {
+	data := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0x42}, 992)...)
+	path := filepath.Join(t.TempDir(), "entry")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{}
+
+	serves := map[string]func(w http.ResponseWriter, r *http.Request){
+		"disk": func(w http.ResponseWriter, r *http.Request) {
+			h.serveFile(w, r, path, "identity", "photos/a.png", "png")
+		},
+		"memory": func(w http.ResponseWriter, r *http.Request) {
+			serveBytes(w, r, data, "photos/a.png", processor.ImageOptions{Format: "png"})
+		},
+	}
+	for name, serve := range serves {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/photos/a.png?w=100", nil)
+			r.Header.Set("Range", "bytes=0-99")
+			w := httptest.NewRecorder()
+			serve(w, r)
+
+			if w.Code != http.StatusPartialContent {
+				t.Fatalf("status = %d, want 206", w.Code)
+			}
+			if got, want := w.Header().Get("Content-Range"), fmt.Sprintf("bytes 0-99/%d", len(data)); got != want {
+				t.Errorf("Content-Range = %q, want %q", got, want)
+			}
+			if !bytes.Equal(w.Body.Bytes(), data[:100]) {
+				t.Errorf("body is %d bytes, want the first 100", w.Body.Len())
+			}
+			if got := w.Header().Get("Content-Type"); got != "image/png" {
+				t.Errorf("Content-Type = %q, want image/png", got)
+			}
+
+			// Without Range the whole variant is sent
+			r.Header.Del("Range")
+			w = httptest.NewRecorder()
+			serve(w, r)
+			if w.Code != http.StatusOK || w.Body.Len() != len(data) {
+				t.Errorf("without Range: status %d with %d bytes, want 200 with %d", w.Code, w.Body.Len(), len(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/sign"
+)
+
+// TestSignedURLs sends URLs made by sign.SignURL through the signature and
+// expiry checks, as a client of pkg/sign would.
+func TestSignedURLs(t *testing.T) {
+	const secret = "test-secret"
+	future := time.Now().Add(time.Hour)
+	base := config.Config{SecretKey: secret}
+	pathOpts := config.Config{SecretKey: secret, PathOptions: true}
+	immutable := config.Config{SecretKey: secret, ImmutableURLs: true}
+
+	tests := []struct {
+		name   string
+		cfg    config.Config
+		target string
+		want   int
+	}{
+		{
+			name:   "params",
+			cfg:    base,
+			target: sign.SignURL(secret, "/photos/a.jpg", url.Values{"w": {"300"}, "fit": {"cover"}}, time.Time{}),
+			want:   http.StatusOK,
+		},
+		{
+			name:   "no params",
+			cfg:    base,
+			target: sign.SignURL(secret, "/photos/a.jpg", nil, time.Time{}),
+			want:   http.StatusOK,
+		},
+		{
+			name:   "expires",
+			cfg:    base,
+			target: sign.SignURL(secret, "/photos/a.jpg", url.Values{"w": {"300"}}, future),
+			want:   http.StatusOK,
+		},
+		{
+			name:   "expired",
+			cfg:    base,
+			target: sign.SignURL(secret, "/photos/a.jpg", url.Values{"w": {"300"}}, time.Now().Add(-time.Hour)),
+			want:   http.StatusGone,
+		},
+		{
+			name:   "expires beyond MAX_URL_LIFETIME",
+			cfg:    config.Config{SecretKey: secret, MaxURLLifetime: time.Minute},
+			target: sign.SignURL(secret, "/photos/a.jpg", url.Values{"w": {"300"}}, future),
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "expires tampered",
+			cfg:    base,
+			target: strings.Replace(sign.SignURL(secret, "/photos/a.jpg", nil, future), "expires=", "expires=9", 1),
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "preset",
+			cfg:    base,
+			target: sign.SignURL(secret, "/photos/a.jpg", url.Values{"preset": {"thumb"}}, time.Time{}),
+			want:   http.StatusOK,
+		},
+		{
+			name:   "preset swapped",
+			cfg:    base,
+			target: strings.Replace(sign.SignURL(secret, "/photos/a.jpg", url.Values{"preset": {"thumb"}}, time.Time{}), "thumb", "hero", 1),
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "path options",
+			cfg:    pathOpts,
+			target: sign.SignURL(secret, "/w_300,h_200/photos/a.jpg", nil, time.Time{}),
+			want:   http.StatusOK,
+		},
+		{
+			name:   "path options unsigned",
+			cfg:    pathOpts,
+			target: "/w_300,h_200/photos/a.jpg",
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "path options moved",
+			cfg:    pathOpts,
+			target: "/w_900/photos/a.jpg?" + mustQuery(t, sign.SignURL(secret, "/w_300,h_200/photos/a.jpg", nil, time.Time{})),
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "immutable hash not covered",
+			cfg:    immutable,
+			target: "/0123456789abcdef" + sign.SignURL(secret, "/photos/a.jpg", url.Values{"w": {"300"}}, time.Time{}),
+			want:   http.StatusOK,
+		},
+		{
+			name:   "escaped path",
+			cfg:    base,
+			target: sign.SignURL(secret, "/photos/a b €.jpg", url.Values{"w": {"300"}}, time.Time{}),
+			want:   http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{ConfigManager: config.NewManagerWithConfig(tt.cfg)}
+			ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			w := httptest.NewRecorder()
+			h.withSignature(h.withExpires(ok)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.want {
+				t.Errorf("GET %s: status %d, want %d: %s", tt.target, w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+)
+
+// entryClock fakes the passing of time for a disk entry by moving its
+// timestamps back instead of moving the clock forward.
+type entryClock struct {
+	t       *testing.T
+	path    string
+	elapsed time.Duration
+}
+
+// advance moves the clock forward by d.
+func (c *entryClock) advance(d time.Duration) {
+	c.elapsed += d
+	written := time.Now().Add(-c.elapsed)
+	if err := os.Chtimes(c.path, written, written); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// touch marks the entry as served or revalidated now.
+func (c *entryClock) touch() {
+	if !touch(c.path) {
+		c.t.Fatal("entry vanished")
+	}
+	c.elapsed = 0
+}
+
+func (c *entryClock) state(cfg config.Config) entryState {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return diskEntryState(cfg, time.Since(cache.EntryTime(c.path, info)))
+}
+
+// lifecycleStep advances the clock, or touches the entry, and gives the
+// state expected afterwards.
+type lifecycleStep struct {
+	advance time.Duration
+	touch   bool
+	want    entryState
+}
+
+// TestDiskEntryLifecycle follows a disk entry from its build through stale
+// serving to expiry.
+func TestDiskEntryLifecycle(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   config.Config
+		steps []lifecycleStep
+	}{
+		{
+			name: "stale serve window",
+			cfg:  config.Config{CacheTTL: time.Hour, StaleServeMax: 2 * time.Hour, CacheHardTTL: 24 * time.Hour},
+			steps: []lifecycleStep{
+				{0, false, entryFresh},
+				{59 * time.Minute, false, entryFresh},
+				{2 * time.Minute, false, entryStale},
+				{time.Hour, false, entryStale},
+				{58 * time.Minute, false, entryStale},
+				{2 * time.Minute, false, entryExpired},
+				{0, true, entryFresh},
+			},
+		},
+		{
+			name: "hard TTL",
+			cfg:  config.Config{CacheTTL: time.Hour, CacheHardTTL: 24 * time.Hour},
+			steps: []lifecycleStep{
+				{30 * time.Minute, false, entryFresh},
+				{time.Hour, false, entryStale},
+				{22 * time.Hour, false, entryStale},
+				{time.Hour, false, entryExpired},
+			},
+		},
+		{
+			name: "stale forever",
+			cfg:  config.Config{CacheTTL: time.Hour},
+			steps: []lifecycleStep{
+				{2 * time.Hour, false, entryStale},
+				{365 * 24 * time.Hour, false, entryStale},
+				{0, true, entryFresh},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "entry")
+			if err := os.WriteFile(path, []byte("variant"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			clock := &entryClock{t: t, path: path}
+			for i, step := range tt.steps {
+				if step.touch {
+					clock.touch()
+				} else {
+					clock.advance(step.advance)
+				}
+				if got := clock.state(tt.cfg); got != step.want {
+					t.Errorf("step %d, %v after the last write: state %d, want %d", i, clock.elapsed, got, step.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+)
+
+// setStaticHeader sends X-Quirm-Static on a static variant whose source had
+// more than one frame, as recorded by its build: a still of a GIF or WebP
+// that was animated, or of a video. Stills of single-frame sources, and
+// entries whose build is not known, carry no header.
+func setStaticHeader(w http.ResponseWriter, v variant, frames int) {
+	if v.opts.Static && v.shouldProcess && (v.isVideo || frames > 1) {
+		w.Header().Set("X-Quirm-Static", "true")
+	}
+}
+
+// recordedFrames returns the source frame count recorded in the disk
+// metadata of v, for entries served from the memory/Redis cache.
+func (h *Handler) recordedFrames(v variant) int {
+	if !v.opts.Static {
+		return 0
+	}
+	meta, _ := cache.ReadMeta(cache.GetCachePath(h.CacheDir, v.cacheKey))
+	return meta.SourceFrames
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// TestStaticHeader sends X-Quirm-Static only for stills of sources with more
+// than one frame, from the memory cache and from the disk alike.
+func TestStaticHeader(t *testing.T) {
+	cfg := config.Config{CacheTTL: time.Hour}
+	tests := []struct {
+		name   string
+		object string
+		query  string
+		frames int // recorded by the build, 0 without metadata
+		want   bool
+	}{
+		{name: "animated GIF", object: "photos/a.gif", query: "static=true", frames: 12, want: true},
+		{name: "single-frame GIF", object: "photos/a.gif", query: "static=true", frames: 1},
+		{name: "JPEG", object: "photos/a.jpg", query: "static=true&w=100", frames: 1},
+		{name: "unknown build", object: "photos/a.gif", query: "static=true"},
+		{name: "not static", object: "photos/a.gif", query: "w=100", frames: 12},
+	}
+	for _, tt := range tests {
+		for _, tier := range []string{"memory", "disk"} {
+			t.Run(tt.name+"/"+tier, func(t *testing.T) {
+				dir := t.TempDir()
+				h := &Handler{ConfigManager: config.NewManagerWithConfig(cfg), CacheDir: dir, Cache: newMapCache()}
+				params, _ := url.ParseQuery(tt.query)
+				v := h.resolveVariant(cfg, tt.object, "", params, http.Header{})
+				path := cache.GetCachePath(dir, v.cacheKey)
+				if tier == "memory" {
+					h.Cache.Set(context.Background(), v.cacheKey, webpOutput, 0)
+				} else if err := h.saveProcessed(path, webpOutput); err != nil {
+					t.Fatal(err)
+				}
+				if tt.frames > 0 {
+					// A memory hit reads the sidecar its build left on disk
+					os.MkdirAll(filepath.Dir(path), 0755)
+					if err := cache.WriteMeta(path, cache.Meta{ObjectKey: tt.object, SourceFrames: tt.frames}); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				w := httptest.NewRecorder()
+				h.serveAsset(w, httptest.NewRequest(http.MethodGet, "/"+tt.object+"?"+tt.query, nil))
+				if w.Code != http.StatusOK {
+					t.Fatalf("status %d, want 200", w.Code)
+				}
+				if got := w.Header().Get("X-Quirm-Static") == "true"; got != tt.want {
+					t.Errorf("X-Quirm-Static %v, want %v", got, tt.want)
+				}
+				if _, err := os.Stat(path); (err == nil) != (tier == "disk") {
+					t.Errorf("disk entry present %v", err == nil)
+				}
+			})
+		}
+	}
+
+	// A video still is of many frames, whatever was recorded
+	w := httptest.NewRecorder()
+	setStaticHeader(w, variant{opts: processor.ImageOptions{Static: true}, shouldProcess: true, isVideo: true}, 0)
+	if w.Header().Get("X-Quirm-Static") != "true" {
+		t.Error("no X-Quirm-Static on a video still")
+	}
+}
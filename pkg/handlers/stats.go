@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/stats"
+)
+
+// statsWriter counts the bytes of an asset response and notes whether the
+// request had to build the asset.
+type statsWriter struct {
+	http.ResponseWriter
+	bytes      int64
+	miss       bool
+	processing time.Duration
+}
+
+func (w *statsWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// markProcessed records that the request behind w built its asset, taking d.
+// Requests that waited on another request's build are plain misses of that
+// other request and are not marked.
+func markProcessed(w http.ResponseWriter, d time.Duration) {
+	if sw, ok := w.(*statsWriter); ok {
+		sw.miss = true
+		sw.processing = d
+	}
+}
+
+func (h *Handler) recordStats(objectKey string, w *statsWriter) {
+	c := stats.Counters{Requests: 1, Bytes: w.bytes}
+	if w.miss {
+		c.Misses = 1
+		c.ProcessingMS = w.processing.Milliseconds()
+	}
+	h.Stats.Record(objectKey, c)
+}
+
+const (
+	defaultStatsLimit = 100
+	maxStatsLimit     = 1000
+)
+
+// HandleStatsTop lists the most requested object keys of the last days
+// (GET /_stats/top?by=processing_ms&limit=50&days=7, admin only).
+func (h *Handler) HandleStatsTop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	if h.Stats == nil {
+		http.Error(w, "Request stats are not enabled", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	by := query.Get("by")
+	if by == "" {
+		by = stats.Requests
+	}
+	if !stats.ValidMetric(by) {
+		http.Error(w, "Invalid by: must be requests, misses, processing_ms or bytes", http.StatusBadRequest)
+		return
+	}
+	limit := defaultStatsLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxStatsLimit {
+			http.Error(w, "Invalid limit: must be between 1 and "+strconv.Itoa(maxStatsLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	days := stats.Days
+	if v := query.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > stats.Days {
+			http.Error(w, "Invalid days: must be between 1 and "+strconv.Itoa(stats.Days), http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	entries, err := h.Stats.Top(ctx, by, limit, days)
+	if err != nil {
+		http.Error(w, "Failed to read request stats: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"by":      by,
+		"days":    days,
+		"entries": entries,
+	})
+}
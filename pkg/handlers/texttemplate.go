@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/config"
+)
+
+// textTemplateSeparator separates the values filling a text template.
+const textTemplateSeparator = "|"
+
+// applyTextTemplate renders the TEXT_TEMPLATES entry named by "text_tpl"
+// with the values given in "text" (separated by "|") and stores the result
+// in "text". The rendered text is thereby part of the cache key, so every
+// set of values gets its own variant. Requests without "text_tpl" are
+// returned unchanged.
+func applyTextTemplate(cfg config.Config, params url.Values) (url.Values, error) {
+	name := params.Get("text_tpl")
+	if name == "" {
+		return params, nil
+	}
+	tpl, ok := cfg.TextTemplates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown text template %q", name)
+	}
+
+	var values []interface{}
+	if text := params.Get("text"); text != "" {
+		for _, v := range strings.Split(text, textTemplateSeparator) {
+			values = append(values, v)
+		}
+	}
+	if want := strings.Count(strings.ReplaceAll(tpl, "%%", ""), "%s"); want != len(values) {
+		return nil, fmt.Errorf("text template %q takes %d values, got %d", name, want, len(values))
+	}
+
+	rendered := make(url.Values, len(params))
+	for k, v := range params {
+		rendered[k] = v
+	}
+	rendered.Set("text", fmt.Sprintf(tpl, values...))
+	return rendered, nil
+}
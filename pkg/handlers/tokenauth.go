@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/token"
+)
+
+// errMissingToken rejects parameterized requests without a token when token
+// auth is the only authentication configured.
+var errMissingToken = errors.New("missing bearer token")
+
+// tokenError is the body of a 401 for a rejected bearer token. Code tells
+// clients whether to fetch a new token (token_expired) or fix the request.
+type tokenError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+func tokenKeys(cfg config.Config) token.Keys {
+	return token.Keys{Secret: cfg.AuthJWTSecret, JWKSURL: cfg.AuthJWKSURL}
+}
+
+// requestToken returns the bearer token from the Authorization header or the
+// "token" query parameter.
+func requestToken(r *http.Request) string {
+	if raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(raw)
+	}
+	return r.URL.Query().Get("token")
+}
+
+// tokenAuthKey marks the context of requests authorized by a bearer token.
+type tokenAuthKey struct{}
+
+// withoutTokenParam drops the "token" query parameter so it neither reaches
+// the cache key nor the processing options.
+func withoutTokenParam(r *http.Request) *http.Request {
+	query := r.URL.Query()
+	if !query.Has("token") {
+		return r
+	}
+	query.Del("token")
+	r2 := r.Clone(r.Context())
+	r2.URL.RawQuery = query.Encode()
+	r2.RequestURI = r2.URL.RequestURI()
+	return r2
+}
+
+// checkToken verifies raw and its claims against the requested object and
+// parameters, path options included. The width is checked after presets are
+// expanded, so a preset cannot exceed max_w.
+func (h *Handler) checkToken(r *http.Request, cfg config.Config, raw, objectKey string, params url.Values) error {
+	claims, err := h.Tokens.Verify(r.Context(), tokenKeys(cfg), raw)
+	if err != nil {
+		return err
+	}
+	width, _ := strconv.Atoi(params.Get("w"))
+	if expanded, err := expandPresets(params, cfg.Presets); err == nil {
+		width, _ = strconv.Atoi(expanded.Get("w"))
+	}
+	return claims.Allows("/"+objectKey, params, width)
+}
+
+func writeTokenError(w http.ResponseWriter, err error) {
+	code := "invalid_token"
+	challenge := `Bearer error="invalid_token"`
+	switch {
+	case errors.Is(err, errMissingToken):
+		code = "missing_token"
+		challenge = "Bearer"
+	case errors.Is(err, token.ErrExpired):
+		code = "token_expired"
+	case errors.Is(err, token.ErrScope):
+		code = "token_scope"
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	writeJSON(w, http.StatusUnauthorized, tokenError{Error: err.Error(), Code: code})
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// variant describes the cache entry that serves a request and how to build it.
+type variant struct {
+	opts          processor.ImageOptions
+	cacheKey      string
+	encodingType  string
+	shouldProcess bool
+	isVideo       bool
+	// vary lists the client hint headers that select this variant
+	vary []string
+	// negotiated is set when the Accept header picked the output format
+	negotiated bool
+	// policy is the transform policy of the key, if any
+	policy       *config.TransformPolicy
+	policyPrefix string
+	// clamped describes how MAX_ANIMATED_WIDTH/HEIGHT reduced the
+	// requested size, if they did
+	clamped string
+	// legacyKey is the raw parameter key of a processed variant, looked up
+	// when cacheKey misses (CACHE_KEY_LEGACY_FALLBACK)
+	legacyKey string
+	// original is an untouched original (?original=true), served with the
+	// origin's Content-Type
+	original bool
+	// version holds the content hash and VERSION_PARAMS of the request,
+	// which are part of cacheKey (see cacheVersion)
+	version string
+	// outputFormat is the format a processed variant is encoded in, asked
+	// for or implied by the source
+	outputFormat string
+}
+
+// requestTarget turns a request path into the object key, splitting off a
+// leading options segment when path options are enabled. It returns false for
+// paths that must never reach the storage backend.
+func requestTarget(cfg config.Config, urlPath string) (string, url.Values, bool) {
+	_, urlPath = splitVersion(cfg, urlPath)
+	objectKey := strings.TrimPrefix(cleanPath(urlPath), "/")
+
+	// Feature: Path-based options ("/w_300,h_200/img.jpg")
+	var pathParams url.Values
+	if cfg.PathOptions {
+		if key, opts, ok := parsePathOptions(objectKey, cfg.PathOptionsMarker); ok {
+			objectKey, pathParams = key, opts
+		}
+	}
+
+	if strings.Contains(objectKey, "..") || objectKey == ".env" || objectKey == "" {
+		return "", nil, false
+	}
+	return objectKey, pathParams, true
+}
+
+// negotiateFormat returns the smallest output format the Accept header
+// admits and policy allows, AVIF before WebP, or "" when it admits neither.
+func negotiateFormat(accept string, policy *config.TransformPolicy) string {
+	switch {
+	case strings.Contains(accept, "image/avif") && policyAllowsFormat(policy, "avif"):
+		return "avif"
+	case strings.Contains(accept, "image/webp") && policyAllowsFormat(policy, "webp"):
+		return "webp"
+	}
+	return ""
+}
+
+// resolveVariant parses the image options for a request and works out which
+// cache entry (processed or passthrough) serves it. contentHash is the
+// segment of an immutable URL, if any. params must already have presets
+// expanded.
+func (h *Handler) resolveVariant(cfg config.Config, objectKey, contentHash string, params url.Values, header http.Header) variant {
+	imgOpts := parseImageOptions(params)
+	imgOpts.WatermarkMinWidth, imgOpts.WatermarkMinHeight = cfg.WatermarkMinWidth, cfg.WatermarkMinHeight
+	imgOpts.AutoGray = imgOpts.AutoGray || cfg.AutoGrayscale
+	policyPrefix, policy := matchPolicy(cfg, objectKey)
+	version := cacheVersion(cfg, contentHash, params)
+
+	// Originals share the identity passthrough entry; transform policies
+	// still decide whether passthrough is allowed
+	if wantsOriginal(params) {
+		return variant{
+			cacheKey:     originalCacheKey(objectKey, version, "identity"),
+			encodingType: "identity",
+			policy:       policy,
+			policyPrefix: policyPrefix,
+			original:     true,
+			version:      version,
+		}
+	}
+
+	// Determine Mode
+	// Passthrough-only keys are served byte-identical, whatever the client accepts
+	passthroughOnly := isPassthroughOnly(cfg, objectKey)
+	isImage := isImageFile(objectKey) && !passthroughOnly
+	isVideo := isVideoFile(objectKey) && !passthroughOnly
+
+	// Video Thumbnail Logic
+	if isVideo && cfg.EnableVideoThumbnail {
+		if imgOpts.Format == "" {
+			imgOpts.Format = "jpeg"
+		}
+	}
+
+	// format=auto asks for negotiation explicitly; format=original and neg=off
+	// keep the source format, even when the client would accept a better one
+	keepSource := imgOpts.Format == "original" || params.Get("neg") == "off"
+	if keepSource || imgOpts.Format == "auto" {
+		imgOpts.Format = ""
+	}
+
+	// optimize=true re-encodes a GIF that would otherwise be served as is;
+	// it keeps the GIF format, so the Accept header is not consulted
+	optimizeGIF := isImage && imgOpts.Optimize && strings.EqualFold(filepath.Ext(objectKey), ".gif") &&
+		imgOpts.Width == 0 && imgOpts.Height == 0 && imgOpts.Fit == "" && imgOpts.Format == "" &&
+		!imgOpts.Blurhash && !imgOpts.Static
+	imgOpts.Optimize = optimizeGIF
+
+	// Auto-Format Logic: Check Accept Header. A blurhash is text whatever the
+	// format, so it is one variant for every client.
+	negotiated := isImage && imgOpts.Format == "" && !keepSource && !optimizeGIF && !imgOpts.Blurhash
+	if negotiated {
+		imgOpts.Format = negotiateFormat(header.Get("Accept"), policy)
+	}
+
+	// Responsive images: without an explicit size the width comes from client hints
+	var hintExtras, vary []string
+	if cfg.ClientHints && isImage && imgOpts.Width == 0 && imgOpts.Height == 0 && !imgOpts.Blurhash && !optimizeGIF {
+		hintExtras = applyWidthHints(cfg, &imgOpts, header)
+		vary = append(vary, widthHintHeaders...)
+		if policy != nil && policy.MaxWidth > 0 && imgOpts.Width > policy.MaxWidth {
+			imgOpts.Width = policy.MaxWidth
+		}
+	}
+
+	// A cache version selects its own entry, built like any other
+	var extras []string
+	if version != "" {
+		extras = append(extras, version)
+	}
+	if policy != nil && (policy.MaxWidth > 0 || policy.MaxHeight > 0) {
+		imgOpts.MaxWidth, imgOpts.MaxHeight = policy.MaxWidth, policy.MaxHeight
+		extras = append(extras, fmt.Sprintf("max=%dx%d", policy.MaxWidth, policy.MaxHeight))
+	}
+
+	// Animated outputs get their own, usually smaller, size caps
+	var clamped string
+	animated := (isVideo && imgOpts.Animated) || (isImage && isVideoConversion(objectKey, imgOpts))
+	if animated && (cfg.MaxAnimatedWidth > 0 || cfg.MaxAnimatedHeight > 0) {
+		clamped = clampAnimated(cfg, &imgOpts, params)
+		extras = append(extras, fmt.Sprintf("animmax=%dx%d", cfg.MaxAnimatedWidth, cfg.MaxAnimatedHeight))
+	}
+
+	shouldProcess := (isImage && (imgOpts.Width > 0 || imgOpts.Height > 0 || imgOpts.Fit != "" || imgOpts.Format != "" || imgOpts.Blurhash || imgOpts.Static || optimizeGIF)) || (isVideo && (cfg.EnableVideoThumbnail || imgOpts.Format == "storyboard"))
+
+	v := variant{
+		opts:          imgOpts,
+		encodingType:  "identity",
+		shouldProcess: shouldProcess,
+		isVideo:       isVideo,
+		vary:          vary,
+		negotiated:    negotiated,
+		policy:        policy,
+		policyPrefix:  policyPrefix,
+		clamped:       clamped,
+		version:       version,
+	}
+
+	if shouldProcess {
+		extras = append(extras, hintExtras...)
+		if cfg.ClientHints && isImage && !imgOpts.Blurhash {
+			extras = append(extras, applyClientHints(cfg, &v.opts, header, policy)...)
+			v.vary = append(v.vary, "Save-Data", "ECT")
+		}
+		// Negotiated formats are part of the key through imgOpts.Format; kept
+		// source formats through the extension
+		keyFormat := imgOpts.Format
+		if keepSource {
+			keyFormat = strings.TrimPrefix(strings.ToLower(filepath.Ext(objectKey)), ".")
+		}
+		v.outputFormat = normalizeFormat(v.opts.Format)
+		if v.outputFormat == "" {
+			v.outputFormat = processor.DefaultFormat(objectKey)
+		}
+		v.cacheKey = cache.GenerateKeyOptions(objectKey, v.opts.Canonical(), keyFormat, extras...)
+		// Legacy entries of fit-less w x h requests were stretched
+		if cfg.LegacyCacheKeys && (params.Get("fit") != "" || imgOpts.Fit == "") {
+			v.legacyKey = cache.GenerateKeyProcessed(objectKey, params, keyFormat, extras...)
+		}
+	} else {
+		// Passthrough Mode
+		v.encodingType = negotiateEncoding(header.Get("Accept-Encoding"), cfg.EncodingPreference)
+		v.cacheKey = originalCacheKey(objectKey, version, v.encodingType)
+	}
+
+	return v
+}
+
+// setVary lists the request headers that select the response for v, so
+// shared caches keep a copy per value: client hints, header defaults, Accept
+// when it picked the format or may ask for a bundle, and Accept-Encoding
+// for passthrough copies.
+func setVary(header http.Header, cfg config.Config, v variant, bundle bool) {
+	if len(v.vary) > 0 {
+		header.Set("Vary", strings.Join(v.vary, ", "))
+		header.Set("Accept-CH", strings.Join(v.vary, ", "))
+	}
+	if cfg.AllowHeaderDefaults {
+		header.Add("Vary", headerDefaultsVary)
+	}
+	if v.negotiated || bundle {
+		header.Add("Vary", "Accept")
+	}
+	if !v.shouldProcess && !v.original {
+		header.Add("Vary", "Accept-Encoding")
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/CodeTease/quirm/pkg/config"
+)
+
+func TestResolveVariantNegotiationKeys(t *testing.T) {
+	h := &Handler{}
+	cfg := config.Config{}
+	accepts := map[string]string{
+		"avif":    "image/avif,image/webp,image/*,*/*;q=0.8",
+		"avif2":   "image/avif",
+		"webp":    "image/webp,*/*",
+		"neither": "image/*,*/*;q=0.8",
+	}
+	resolve := func(key string, params url.Values, accept string) variant {
+		return h.resolveVariant(cfg, key, "", params, http.Header{"Accept": {accepts[accept]}})
+	}
+
+	// An animated GIF and a PNG with alpha, whose formats carry more than
+	// their pixels
+	for _, src := range []struct{ key, format string }{{"anim.gif", "gif"}, {"logo.png", "png"}} {
+		t.Run(src.key, func(t *testing.T) {
+			auto := url.Values{"w": {"100"}, "format": {"auto"}}
+			if a, b := resolve(src.key, auto, "avif"), resolve(src.key, auto, "avif2"); a.cacheKey != b.cacheKey {
+				t.Errorf("format=auto: clients negotiating AVIF got keys %q and %q", a.cacheKey, b.cacheKey)
+			}
+			seen := map[string]string{}
+			for _, accept := range []string{"avif", "webp", "neither"} {
+				v := resolve(src.key, auto, accept)
+				if other, ok := seen[v.cacheKey]; ok {
+					t.Errorf("format=auto: Accept %s and %s share key %q", accept, other, v.cacheKey)
+				}
+				seen[v.cacheKey] = accept
+			}
+			if got := resolve(src.key, auto, "webp").outputFormat; got != "webp" {
+				t.Errorf("format=auto: output format for a WebP client = %q, want webp", got)
+			}
+			if got := resolve(src.key, auto, "neither").outputFormat; got != src.format {
+				t.Errorf("format=auto: output format without AVIF or WebP = %q, want %s", got, src.format)
+			}
+
+			original := url.Values{"w": {"100"}, "format": {"original"}}
+			negOff := url.Values{"w": {"100"}, "neg": {"off"}}
+			want := resolve(src.key, original, "neither")
+			if !want.shouldProcess || want.outputFormat != src.format {
+				t.Fatalf("format=original: shouldProcess %v, output format %q, want a %s variant", want.shouldProcess, want.outputFormat, src.format)
+			}
+			for _, params := range []url.Values{original, negOff} {
+				for accept := range accepts {
+					if got := resolve(src.key, params, accept).cacheKey; got != want.cacheKey {
+						t.Errorf("%s with Accept %s: key %q, want %q", params.Encode(), accept, got, want.cacheKey)
+					}
+				}
+			}
+			if want.cacheKey == resolve(src.key, auto, "avif").cacheKey {
+				t.Errorf("format=original shares the key of the negotiated AVIF variant")
+			}
+		})
+	}
+}
+
+func TestSetVary(t *testing.T) {
+	h := &Handler{}
+	tests := []struct {
+		name   string
+		cfg    config.Config
+		key    string
+		query  string
+		accept string
+		bundle bool
+		want   []string
+	}{
+		{name: "negotiated format", key: "a.jpg", query: "w=300", want: []string{"Accept"}},
+		{name: "negotiation without a match", key: "a.jpg", accept: "*/*", want: []string{"Accept", "Accept-Encoding"}},
+		{name: "explicit format", key: "a.jpg", query: "w=300&format=png"},
+		{name: "format=original", key: "a.jpg", query: "w=300&format=original"},
+		{name: "neg=off", key: "a.jpg", query: "w=300&neg=off"},
+		{name: "blurhash", key: "a.jpg", query: "blurhash=true"},
+		{name: "bundle", key: "a.jpg", query: "w=300&format=png", bundle: true, want: []string{"Accept"}},
+		{name: "passthrough", key: "a.pdf.zip", want: []string{"Accept-Encoding"}},
+		{name: "original", key: "a.jpg", query: "original=true"},
+		{
+			name:  "client hints",
+			cfg:   config.Config{ClientHints: true},
+			key:   "a.jpg",
+			query: "format=png",
+			want:  []string{strings.Join(append(append([]string{}, widthHintHeaders...), "Save-Data", "ECT"), ", ")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			accept := "image/webp,*/*"
+			if tt.accept != "" {
+				accept = tt.accept
+			}
+			v := h.resolveVariant(tt.cfg, tt.key, "", params, http.Header{"Accept": {accept}})
+			header := http.Header{}
+			setVary(header, tt.cfg, v, tt.bundle)
+			if got := header.Values("Vary"); !slices.Equal(got, tt.want) {
+				t.Errorf("Vary = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// trackVariant records a use of the processed variant cacheKey of objectKey.
+// Variants beyond MAX_VARIANTS_PER_OBJECT are evicted least recently used
+// first, in the background.
+func (h *Handler) trackVariant(cfg config.Config, objectKey, cacheKey string, params url.Values) {
+	if h.Variants == nil {
+		return
+	}
+	evicted := h.Variants.Touch(objectKey, cacheKey, redactParams(params).Encode(), cfg.MaxVariantsPerObject)
+	if len(evicted) == 0 {
+		return
+	}
+	go func() {
+		ctx := context.Background()
+		for _, v := range evicted {
+			slog.Debug("Evicting variant", "objectKey", objectKey, "cacheKey", v.Key, "params", v.Params)
+			h.deleteCacheEntry(ctx, v.Key)
+			h.deleteCacheEntry(ctx, lqipKey(v.Key))
+			metrics.VariantEvictionsTotal.Inc()
+		}
+	}()
+}
+
+// deleteCacheEntry removes cacheKey from the memory/Redis cache and the disk.
+func (h *Handler) deleteCacheEntry(ctx context.Context, cacheKey string) {
+	if h.Cache != nil {
+		if err := h.Cache.Delete(ctx, cacheKey); err != nil {
+			slog.Warn("Failed to delete from cache provider", "key", cacheKey, "error", err)
+		}
+	}
+
+	cacheFilePath := cache.GetCachePath(h.CacheDir, cacheKey)
+	if err := os.Remove(cacheFilePath); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to delete from disk", "path", cacheFilePath, "error", err)
+	}
+	_ = os.Remove(cache.MetaPath(cacheFilePath))
+}
+
+type variantEntry struct {
+	cache.Variant
+	// Size is the size of the file in the disk cache, absent once the
+	// file was removed
+	Size int64 `json:"size,omitempty"`
+}
+
+// HandleVariants lists the processed variants of an object, most recently
+// used first (GET /_variants/<key>, admin only).
+func (h *Handler) HandleVariants(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	if h.Variants == nil {
+		http.Error(w, "Variant index is not enabled", http.StatusNotFound)
+		return
+	}
+
+	cfg := h.ConfigManager.Get()
+	objectKey, _, ok := requestTarget(cfg, strings.TrimPrefix(r.URL.Path, "/_variants"))
+	if !ok {
+		http.Error(w, "Invalid Path", http.StatusBadRequest)
+		return
+	}
+
+	variants := h.Variants.List(objectKey)
+	entries := make([]variantEntry, 0, len(variants))
+	for _, v := range variants {
+		entry := variantEntry{Variant: v}
+		if info, err := os.Stat(cache.GetCachePath(h.CacheDir, v.Key)); err == nil {
+			entry.Size = info.Size()
+		}
+		entries = append(entries, entry)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":      objectKey,
+		"limit":    cfg.MaxVariantsPerObject,
+		"variants": entries,
+	})
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+	"runtime"
+
+	"github.com/CodeTease/quirm/pkg/buildinfo"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+type versionDoc struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	Libvips   string `json:"libvips"`
+	// FFmpeg is the first line of "ffmpeg -version", empty without ffmpeg
+	FFmpeg     string `json:"ffmpeg"`
+	GOMAXPROCS int    `json:"gomaxprocs"`
+	NumCPU     int    `json:"num_cpu"`
+}
+
+// HandleVersion reports the build of the binary and the versions of the
+// libraries and tools it runs with (GET /version), so a deployment can be
+// matched to a release and a bug report to its environment.
+func (h *Handler) HandleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, versionDoc{
+		Version:    buildinfo.Version,
+		Commit:     buildinfo.Commit,
+		BuildDate:  buildinfo.BuildDate,
+		GoVersion:  buildinfo.GoVersion(),
+		Libvips:    processor.LibvipsVersion(),
+		FFmpeg:     processor.FFmpegVersion(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		NumCPU:     runtime.NumCPU(),
+	})
+}
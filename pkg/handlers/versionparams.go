@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+)
+
+// cacheVersion returns the cache version of a request, which selects a cache
+// entry without changing how it is built: the content hash of an immutable
+// URL followed by the VERSION_PARAMS of params (e.g.
+// "0123456789abcdef/v=1712345678"), or "" when there are neither.
+func cacheVersion(cfg config.Config, contentHash string, params url.Values) string {
+	version := url.Values{}
+	for _, name := range cfg.VersionParams {
+		if value := params.Get(name); value != "" {
+			version.Set(name, value)
+		}
+	}
+	if contentHash == "" {
+		return version.Encode()
+	}
+	// Encoded values escape "/", so the hash cannot be mistaken for one
+	return contentHash + "/" + version.Encode()
+}
+
+type cacheVersionKey struct{}
+
+// withCacheVersion returns a context whose passthrough fetches store the
+// original under the given cache version.
+func withCacheVersion(ctx context.Context, version string) context.Context {
+	if version == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, cacheVersionKey{}, version)
+}
+
+func cacheVersionFrom(ctx context.Context) string {
+	version, _ := ctx.Value(cacheVersionKey{}).(string)
+	return version
+}
+
+// originalCacheKey is the cache key of the original of objectKey in the
+// given encoding, under a cache version. Without a version it is the key
+// originals always had.
+func originalCacheKey(objectKey, version, encoding string) string {
+	if version == "" {
+		return cache.GenerateKeyOriginal(objectKey, encoding)
+	}
+	// S3 keys are valid UTF-8 text, which has no use for NUL
+	return cache.GenerateKeyOriginal(objectKey+"\x00"+version, encoding)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/sign"
+)
+
+func TestVersionParamSignatures(t *testing.T) {
+	const secret = "test-secret"
+	cfg := config.Config{SecretKey: secret, VersionParams: []string{"v"}}
+	signed := func(params url.Values) string {
+		return sign.SignURL(secret, "/photos/a.jpg", params, time.Time{})
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{name: "no params", target: "/photos/a.jpg", want: http.StatusOK},
+		{name: "passthrough unsigned", target: "/photos/a.jpg?v=2", want: http.StatusForbidden},
+		{name: "passthrough signed", target: signed(url.Values{"v": {"2"}}), want: http.StatusOK},
+		{name: "processed unsigned", target: "/photos/a.jpg?w=300&v=2", want: http.StatusForbidden},
+		{name: "processed signed", target: signed(url.Values{"w": {"300"}, "v": {"2"}}), want: http.StatusOK},
+		{name: "version added to a signed URL", target: signed(url.Values{"w": {"300"}}) + "&v=3", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{ConfigManager: config.NewManagerWithConfig(cfg)}
+			ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			w := httptest.NewRecorder()
+			h.withSignature(ok).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.want {
+				t.Errorf("GET %s: status %d, want %d", tt.target, w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionParamVariants(t *testing.T) {
+	h := &Handler{}
+	cfg := config.Config{VersionParams: []string{"v"}}
+	resolve := func(query string) variant {
+		params, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h.resolveVariant(cfg, "photos/a.jpg", "", params, nil)
+	}
+
+	for _, mode := range []struct{ name, query string }{{"passthrough", ""}, {"processed", "w=300&"}} {
+		t.Run(mode.name, func(t *testing.T) {
+			plain, v1, v2 := resolve(mode.query), resolve(mode.query+"v=1"), resolve(mode.query+"v=2")
+			if v1.shouldProcess != plain.shouldProcess || v1.opts != plain.opts {
+				t.Errorf("v=1 changed the build: shouldProcess %v, options %+v", v1.shouldProcess, v1.opts)
+			}
+			if plain.cacheKey == v1.cacheKey || v1.cacheKey == v2.cacheKey {
+				t.Errorf("versions share cache keys: %q, %q, %q", plain.cacheKey, v1.cacheKey, v2.cacheKey)
+			}
+			if again := resolve(mode.query + "v=1"); again.cacheKey != v1.cacheKey {
+				t.Errorf("v=1 resolved to %q and %q", v1.cacheKey, again.cacheKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/processor"
+	"github.com/CodeTease/quirm/pkg/sign"
+)
+
+// videoCard is the response of a ?videocard=true request.
+type videoCard struct {
+	Poster   string  `json:"poster"`
+	Preview  string  `json:"preview"`
+	Duration float64 `json:"duration"`
+}
+
+// videoCardInfo is cached on disk next to the artifacts, so a card whose
+// artifacts are cached needs no access to the video at all.
+type videoCardInfo struct {
+	Duration float64 `json:"duration"`
+}
+
+// videoCardParams derives the request parameters of the poster (a JPEG still
+// at "t") and the preview (an animated WebP clip starting at "t") from the
+// parameters of a video card request. Both are canonical, so the cache keys
+// built from them match the artifact URLs.
+func videoCardParams(params url.Values) (poster, preview url.Values) {
+	poster = url.Values{}
+	preview = url.Values{}
+	for k, v := range params {
+		switch k {
+		case "videocard", "s", "expires", "format", "animated":
+			continue
+		case "fps", "boomerang":
+			preview[k] = v
+		default:
+			poster[k] = v
+			preview[k] = v
+		}
+	}
+	poster.Set("format", "jpeg")
+	preview.Set("format", "webp")
+	preview.Set("animated", "true")
+	return sign.Canonical(poster), sign.Canonical(preview)
+}
+
+// handleVideoCard renders the poster and hover preview of a video card from a
+// single acquisition of the source and answers with their URLs and the video
+// duration. Both artifacts are stored under the cache keys of their own URLs,
+// so following requests for them are cache hits.
+func (h *Handler) handleVideoCard(w http.ResponseWriter, r *http.Request, cfg config.Config, objectKey string, params url.Values) {
+	if !isVideoFile(objectKey) || !cfg.EnableVideoThumbnail {
+		http.Error(w, "Video cards require a video and ENABLE_VIDEO_THUMBNAIL", http.StatusBadRequest)
+		return
+	}
+
+	posterParams, previewParams := videoCardParams(params)
+	// The artifact URLs carry no content hash
+	poster := h.resolveVariant(cfg, objectKey, "", posterParams, r.Header)
+	preview := h.resolveVariant(cfg, objectKey, "", previewParams, r.Header)
+	for _, v := range []variant{poster, preview} {
+		if violation := checkPolicy(v); violation != nil {
+			writePolicyViolation(w, violation)
+			return
+		}
+	}
+	if err := animatedThumbnailOptions(preview.opts, "webp").Validate(); err != nil {
+		http.Error(w, "Invalid animation: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cardKey := cache.GenerateKeyProcessed(objectKey, params, "videocard")
+	result, err, _ := h.Group.Do(cardKey, func() (interface{}, error) {
+		return h.buildVideoCard(r.Context(), objectKey, cardKey, poster, preview)
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "404") {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		if writeOriginAccessError(w, objectKey, err) || writeFFmpegBusy(w, objectKey, err) {
+			return
+		}
+		slog.Error("Video card generation failed", "objectKey", objectKey, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	setCacheControl(w)
+	writeJSON(w, http.StatusOK, videoCard{
+		Poster:   artifactURL(cfg, objectKey, posterParams),
+		Preview:  artifactURL(cfg, objectKey, previewParams),
+		Duration: result.(videoCardInfo).Duration,
+	})
+}
+
+// buildVideoCard renders the artifacts missing from the disk cache and probes
+// the duration if it is not cached yet, downloading the video at most once.
+func (h *Handler) buildVideoCard(ctx context.Context, objectKey, cardKey string, poster, preview variant) (videoCardInfo, error) {
+	ttl := h.ConfigManager.Get().CacheTTL
+	cardPath := cache.GetCachePath(h.CacheDir, cardKey)
+
+	var info videoCardInfo
+	haveInfo := false
+	if isFresh(cardPath, ttl) {
+		if data, err := os.ReadFile(cardPath); err == nil && json.Unmarshal(data, &info) == nil {
+			haveInfo = true
+		}
+	}
+
+	var missing []variant
+	for _, v := range []variant{poster, preview} {
+		if !isFresh(cache.GetCachePath(h.CacheDir, v.cacheKey), ttl) {
+			missing = append(missing, v)
+		}
+	}
+	if haveInfo && len(missing) == 0 {
+		return info, nil
+	}
+
+	// Both ffmpeg runs and ffprobe read the same local copy
+	inputPath, _, cleanup, err := h.acquireVideo(ctx, objectKey, false)
+	if err != nil {
+		return info, err
+	}
+	defer cleanup()
+
+	if !haveInfo {
+		if info.Duration, err = processor.ProbeDuration(inputPath); err != nil {
+			return info, err
+		}
+		if data, err := json.Marshal(info); err == nil {
+			if err := h.saveProcessed(cardPath, data); err != nil {
+				slog.Warn("Failed to cache video card", "path", cardPath, "error", err)
+			}
+		}
+	}
+
+	for _, v := range missing {
+		data, err := renderVideo(ctx, inputPath, objectKey, v.opts)
+		if err != nil {
+			return info, err
+		}
+		if err := h.saveProcessed(cache.GetCachePath(h.CacheDir, v.cacheKey), data); err != nil {
+			return info, err
+		}
+		if h.Cache != nil {
+			h.Cache.Set(ctx, v.cacheKey, data, 0)
+		}
+	}
+	return info, nil
+}
+
+// artifactURL returns the URL of a video card artifact, signed when
+// signatures are enabled.
+func artifactURL(cfg config.Config, objectKey string, params url.Values) string {
+	path := "/" + objectKey
+	if cfg.SecretKey != "" {
+		return sign.SignURL(cfg.SecretKey, path, params, time.Time{})
+	}
+	u := url.URL{Path: path, RawQuery: params.Encode()}
+	return u.String()
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// acquireVideo returns an input ffmpeg can read the video from: a presigned
+// URL when allowed and supported by the storage backend, otherwise a
+// temporary copy. presigned tells which one it is. cleanup must be called
+// once the input is no longer needed. The object's version is recorded for
+// the build; a presigned URL carries no response headers, so that takes a
+// StatObject unless the build already made one.
+func (h *Handler) acquireVideo(ctx context.Context, objectKey string, allowURL bool) (input string, presigned bool, cleanup func(), err error) {
+	if allowURL {
+		// Streaming from a presigned URL lets ffmpeg read only what it needs.
+		// Backends without presigned URLs fall back to a download.
+		ttl := h.ConfigManager.Get().VideoPresignTTL
+		if videoURL, err := h.S3.GetPresignedURL(ctx, objectKey, ttl); err == nil && videoURL != "" {
+			if src := sourceObjectFrom(ctx); src != nil && !src.ok {
+				info, err := h.S3.StatObject(ctx, objectKey)
+				if err != nil {
+					return "", false, nil, err
+				}
+				recordSource(ctx, info)
+			}
+			return videoURL, true, func() {}, nil
+		}
+	}
+
+	tmpFile, err := os.CreateTemp(h.CacheDir, "video-*.tmp")
+	if err != nil {
+		return "", false, nil, err
+	}
+	cleanup = func() {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+	}
+
+	reader, info, err := h.S3.GetObjectIfNoneMatch(ctx, objectKey, "")
+	if err != nil {
+		cleanup()
+		return "", false, nil, err
+	}
+	defer reader.Close()
+	recordSource(ctx, info)
+
+	if _, err := io.Copy(tmpFile, reader); err != nil {
+		cleanup()
+		return "", false, nil, err
+	}
+	return tmpFile.Name(), false, cleanup, nil
+}
+
+// withVideoInput runs render on an input from acquireVideo. A presigned URL
+// can still be refused once ffmpeg reads it (clock skew, KMS key policies),
+// in which case the object is downloaded and render retried once.
+func (h *Handler) withVideoInput(ctx context.Context, objectKey string, allowURL bool, render func(input string) ([]byte, error)) ([]byte, error) {
+	input, presigned, cleanup, err := h.acquireVideo(ctx, objectKey, allowURL)
+	if err != nil {
+		return nil, err
+	}
+	data, err := render(input)
+	cleanup()
+
+	span := trace.SpanFromContext(ctx)
+	source := "download"
+	if presigned {
+		source = "presigned"
+		if errors.Is(err, processor.ErrInputRejected) {
+			slog.Warn("Presigned video URL rejected, downloading instead", "objectKey", objectKey, "error", err)
+			span.AddEvent("Presigned URL Rejected")
+
+			input, _, cleanup, err = h.acquireVideo(ctx, objectKey, false)
+			if err != nil {
+				return nil, err
+			}
+			defer cleanup()
+			data, err = render(input)
+			source = "download_fallback"
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.VideoInputTotal.WithLabelValues(source).Inc()
+	span.AddEvent("Video Input", trace.WithAttributes(attribute.String("video.input", source)))
+	return data, nil
+}
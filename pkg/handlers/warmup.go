@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/storage"
+	"github.com/CodeTease/quirm/pkg/warmup"
+)
+
+type warmupRequest struct {
+	URLs   []string `json:"urls"`
+	Accept string   `json:"accept"`
+}
+
+// HandleWarmup queues cache warmup jobs (POST /warmup, admin only).
+// The body is {"urls": ["/images/a.jpg?w=300", ...], "accept": "image/avif"},
+// where accept optionally selects the auto-format variant to warm.
+func (h *Handler) HandleWarmup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	if h.Warmup == nil {
+		http.Error(w, "Warmup is not enabled", http.StatusNotFound)
+		return
+	}
+
+	var req warmupRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if len(req.URLs) == 0 {
+		http.Error(w, "No urls given", http.StatusBadRequest)
+		return
+	}
+
+	header := http.Header{}
+	if req.Accept != "" {
+		header.Set("Accept", req.Accept)
+	}
+
+	h.auditWarmup(r, req.URLs)
+	jobs, err := h.Warmup.Enqueue(req.URLs, header)
+	status := http.StatusAccepted
+	resp := map[string]interface{}{"jobs": jobs}
+	if errors.Is(err, warmup.ErrQueueFull) {
+		status = http.StatusServiceUnavailable
+		resp["error"] = err.Error()
+	}
+	writeJSON(w, status, resp)
+}
+
+// HandleWarmupStatus reports queued, in-progress, completed and failed warmup
+// jobs (GET /warmup/status, admin only). ?state= filters the job list.
+func (h *Handler) HandleWarmupStatus(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	if h.Warmup == nil {
+		http.Error(w, "Warmup is not enabled", http.StatusNotFound)
+		return
+	}
+
+	state := warmup.State(r.URL.Query().Get("state"))
+	switch state {
+	case "", warmup.StateQueued, warmup.StateInProgress, warmup.StateCompleted, warmup.StateFailed:
+	default:
+		http.Error(w, "Invalid state filter", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.Warmup.Status(state))
+}
+
+// Warm populates the caches for target (a request path with optional query,
+// e.g. "/images/a.jpg?w=300") exactly as a GET would, without writing a
+// response. Entries that are already fresh on disk are left alone. Origin
+// fetches use the background connection pool.
+func (h *Handler) Warm(ctx context.Context, target string, header http.Header) error {
+	cfg := h.ConfigManager.Get()
+	ctx = storage.WithBackground(ctx)
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if err := checkEncodedSlash(cfg, u); err != nil {
+		return err
+	}
+	contentHash, _ := splitVersion(cfg, u.Path)
+	objectKey, pathParams, ok := requestTarget(cfg, u.Path)
+	if !ok {
+		return errors.New("invalid path")
+	}
+	params, _, err := resolvePreset(cfg, mergePathOptions(pathParams, u.Query()))
+	if err != nil {
+		return err
+	}
+	if params, err = expandPresets(params, cfg.Presets); err != nil {
+		return err
+	}
+	if params, err = applyZones(cfg, objectKey, params); err != nil {
+		return err
+	}
+	if params, err = checkDimensions(cfg, params); err != nil {
+		return err
+	}
+
+	if params.Get("palette") == "true" {
+		_, err := h.palette(ctx, objectKey, params)
+		return err
+	}
+
+	v := h.resolveVariant(cfg, objectKey, contentHash, params, header)
+	cacheFilePath := cache.GetCachePath(h.CacheDir, v.cacheKey)
+	if isFresh(cacheFilePath, cfg.CacheTTL) {
+		return nil
+	}
+
+	ctx = withCacheVersion(ctx, v.version)
+	_, err, _ = h.Group.Do(v.cacheKey, func() (interface{}, error) {
+		return h.updateCache(ctx, objectKey, cacheFilePath, v.cacheKey, v.opts, v.encodingType, v.shouldProcess, v.isVideo)
+	})
+	return err
+}
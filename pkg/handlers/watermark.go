@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/CodeTease/quirm/pkg/watermark"
+)
+
+// HandleWatermarkReload reloads the watermark file now
+// (POST /_admin/watermark/reload, admin only) and reports the outcome, so
+// deploy scripts can check a replaced watermark before relying on it. A
+// failed reload answers 500 and leaves the previous image in use.
+func (h *Handler) HandleWatermarkReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireAdmin(w, r) {
+		return
+	}
+
+	status, err := h.WM.Reload()
+	if errors.Is(err, watermark.ErrNotConfigured) {
+		http.Error(w, "Watermark is not configured", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("Watermark reload failed", "path", status.Path, "error", err)
+		writeJSON(w, http.StatusInternalServerError, status)
+		return
+	}
+	slog.Info("Watermark reloaded", "path", status.Path)
+	writeJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/CodeTease/quirm/pkg/config"
+)
+
+var (
+	// errTransformsNotAllowed is returned for transform parameters on a
+	// passthrough-only prefix when PASSTHROUGH_ONLY_STRICT is set.
+	errTransformsNotAllowed = errors.New("transformations are not allowed for this path")
+	// errOriginalNotAllowed is returned for bare requests on a process-only
+	// prefix when no default preset is configured.
+	errOriginalNotAllowed = errors.New("originals are not served for this path")
+)
+
+// nonTransformParams are query parameters that never change the output.
+// VERSION_PARAMS never do either.
+var nonTransformParams = map[string]bool{
+	"s":       true,
+	"expires": true,
+	"token":   true,
+}
+
+// applyZones enforces the passthrough-only and process-only prefixes on the
+// (preset-expanded) request parameters. Passthrough-only keys lose every
+// transform parameter, so they always map to the same passthrough cache entry.
+// Bare requests for process-only keys get the configured default preset
+// instead of the original, and ?original=true is refused for them.
+// Requests for the original lose their transform parameters everywhere else.
+func applyZones(cfg config.Config, objectKey string, params url.Values) (url.Values, error) {
+	if wantsOriginal(params) {
+		if matchesPrefix(cfg.ProcessOnlyPrefixes, objectKey) {
+			return nil, errOriginalNotAllowed
+		}
+		return url.Values{"original": {"true"}}, nil
+	}
+
+	if isPassthroughOnly(cfg, objectKey) {
+		if hasTransformParams(cfg, params) {
+			if cfg.PassthroughOnlyStrict {
+				return nil, errTransformsNotAllowed
+			}
+			return url.Values{}, nil
+		}
+		return params, nil
+	}
+
+	if matchesPrefix(cfg.ProcessOnlyPrefixes, objectKey) && !hasTransformParams(cfg, params) {
+		return processOnlyDefault(cfg)
+	}
+
+	return params, nil
+}
+
+// processOnlyDefault returns the parameters of PROCESS_ONLY_PRESET, which
+// process-only keys get in place of a request that would not be processed.
+// Without a usable preset it returns errOriginalNotAllowed.
+func processOnlyDefault(cfg config.Config) (url.Values, error) {
+	if cfg.ProcessOnlyPreset == "" {
+		return nil, errOriginalNotAllowed
+	}
+	preset := url.Values{"preset": {cfg.ProcessOnlyPreset}}
+	expanded, err := expandPresets(preset, cfg.Presets)
+	if err != nil {
+		return nil, err
+	}
+	if !hasTransformParams(cfg, expanded) {
+		// Unknown preset names are ignored by expandPresets
+		return nil, errOriginalNotAllowed
+	}
+	return expanded, nil
+}
+
+func isPassthroughOnly(cfg config.Config, objectKey string) bool {
+	return matchesPrefix(cfg.PassthroughOnlyPrefixes, objectKey)
+}
+
+// hasTransformParams reports whether params ask for any output besides the
+// original: signatures, tokens and cache versions do not.
+func hasTransformParams(cfg config.Config, params url.Values) bool {
+	for k := range params {
+		if !nonTransformParams[k] && !slices.Contains(cfg.VersionParams, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesPrefix(prefixes []string, objectKey string) bool {
+	for _, prefix := range prefixes {
+		prefix = strings.TrimPrefix(strings.TrimSpace(prefix), "/")
+		if prefix != "" && strings.HasPrefix(objectKey, prefix) {
+			return true
+		}
+	}
+	return false
+}
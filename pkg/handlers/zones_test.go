@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/sign"
+)
+
+func TestApplyZones(t *testing.T) {
+	cfg := config.Config{
+		PassthroughOnlyPrefixes: []string{"/static/"},
+		ProcessOnlyPrefixes:     []string{"masters/"},
+		ProcessOnlyPreset:       "web",
+		Presets:                 map[string]string{"web": "w=1200&format=webp"},
+		VersionParams:           []string{"v"},
+	}
+	strict := cfg
+	strict.PassthroughOnlyStrict = true
+	noPreset := cfg
+	noPreset.ProcessOnlyPreset = ""
+
+	tests := []struct {
+		name  string
+		cfg   config.Config
+		key   string
+		query string
+		want  string
+		err   error
+	}{
+		{name: "elsewhere", cfg: cfg, key: "photos/a.jpg", query: "w=300", want: "w=300"},
+		{name: "passthrough-only, bare", cfg: cfg, key: "static/logo.png", query: "", want: ""},
+		{name: "passthrough-only drops transforms", cfg: cfg, key: "static/logo.png", query: "w=300&format=webp", want: ""},
+		{name: "passthrough-only keeps signature and version", cfg: cfg, key: "static/logo.png", query: "s=abc&expires=123&v=2", want: "expires=123&s=abc&v=2"},
+		{name: "passthrough-only, strict", cfg: strict, key: "static/logo.png", query: "w=300", err: errTransformsNotAllowed},
+		{name: "passthrough-only, strict and bare", cfg: strict, key: "static/logo.png", query: "v=2", want: "v=2"},
+		{name: "process-only, bare", cfg: cfg, key: "masters/a.tif", query: "", want: "format=webp&w=1200"},
+		{name: "process-only, signed but bare", cfg: cfg, key: "masters/a.tif", query: "s=abc", want: "format=webp&w=1200"},
+		{name: "process-only with transforms", cfg: cfg, key: "masters/a.tif", query: "w=300", want: "w=300"},
+		{name: "process-only without a preset", cfg: noPreset, key: "masters/a.tif", query: "", err: errOriginalNotAllowed},
+		{name: "process-only original", cfg: cfg, key: "masters/a.tif", query: "original=true", err: errOriginalNotAllowed},
+		{name: "original elsewhere drops transforms", cfg: cfg, key: "photos/a.jpg", query: "original=true&w=300", want: "original=true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := applyZones(tt.cfg, tt.key, params)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("applyZones(%q, %q) error %v, want %v", tt.key, tt.query, err, tt.err)
+			}
+			if err == nil && got.Encode() != tt.want {
+				t.Errorf("applyZones(%q, %q) = %q, want %q", tt.key, tt.query, got.Encode(), tt.want)
+			}
+		})
+	}
+}
+
+// TestZoneSignaturesAndKeys sends requests through the signature check and
+// the zones, and compares the variants they end up with.
+func TestZoneSignaturesAndKeys(t *testing.T) {
+	const secret = "test-secret"
+	cfg := config.Config{
+		SecretKey:               secret,
+		PassthroughOnlyPrefixes: []string{"static/"},
+		ProcessOnlyPrefixes:     []string{"masters/"},
+		ProcessOnlyPreset:       "web",
+		Presets:                 map[string]string{"web": "w=1200&format=webp"},
+	}
+	h := &Handler{ConfigManager: config.NewManagerWithConfig(cfg)}
+	signed := func(path string, params url.Values) string {
+		return sign.SignURL(secret, path, params, time.Time{})
+	}
+
+	// serve runs target through withSignature and the zones, returning the
+	// status and, when it got through, the cache key of its variant.
+	serve := func(target string) (int, string) {
+		var cacheKey string
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			objectKey := strings.TrimPrefix(r.URL.Path, "/")
+			params, err := applyZones(cfg, objectKey, r.URL.Query())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			cacheKey = h.resolveVariant(cfg, objectKey, "", params, http.Header{}).cacheKey
+		})
+		w := httptest.NewRecorder()
+		h.withSignature(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w.Code, cacheKey
+	}
+
+	_, bare := serve("/static/logo.png")
+	tests := []struct {
+		name   string
+		target string
+		status int
+		same   bool
+	}{
+		{name: "unsigned transforms", target: "/static/logo.png?w=300", status: http.StatusForbidden},
+		{name: "signed transforms", target: signed("/static/logo.png", url.Values{"w": {"300"}}), status: http.StatusOK, same: true},
+		{name: "other signed transforms", target: signed("/static/logo.png", url.Values{"w": {"500"}, "format": {"avif"}}), status: http.StatusOK, same: true},
+		{name: "transforms added to a signed URL", target: signed("/static/logo.png", url.Values{"w": {"300"}}) + "&h=10", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, key := serve(tt.target)
+			if status != tt.status {
+				t.Fatalf("GET %s: status %d, want %d", tt.target, status, tt.status)
+			}
+			if status == http.StatusOK && (key == bare) != tt.same {
+				t.Errorf("GET %s: cache key %q, bare request %q, want same %v", tt.target, key, bare, tt.same)
+			}
+		})
+	}
+
+	// A bare process-only request shares the variant of its preset
+	_, defaulted := serve("/masters/a.tif")
+	_, explicit := serve(signed("/masters/a.tif", url.Values{"w": {"1200"}, "format": {"webp"}}))
+	if defaulted == "" || defaulted != explicit {
+		t.Errorf("bare process-only request %q, explicit preset parameters %q: want the same key", defaulted, explicit)
+	}
+	// Cache keys are stable across requests
+	if _, again := serve("/static/logo.png"); again != bare {
+		t.Errorf("cache key %q, then %q", bare, again)
+	}
+}
@@ -0,0 +1,116 @@
+// Package hooks runs custom steps after a variant has been processed (e.g.
+// feeding a dedup index or a search indexer), off the request path.
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// hookTimeout bounds one hook invocation.
+const hookTimeout = 30 * time.Second
+
+// OutputMeta describes a processed variant.
+type OutputMeta struct {
+	// Format is the output format ("webp", "jpeg", "mp4", ...)
+	Format string
+	Bytes  int
+	// Width and Height are 0 for outputs that are not images (blurhash
+	// strings, videos)
+	Width    int
+	Height   int
+	CacheKey string
+	// Duration is how long building the variant took, origin fetch included
+	Duration time.Duration
+}
+
+// ProcessedHook is called after a variant of objectKey was processed with
+// opts. Hooks run in the background: the request does not wait for them and
+// their outcome does not change the response. ctx is canceled after 30
+// seconds.
+type ProcessedHook interface {
+	OnProcessed(ctx context.Context, objectKey string, opts processor.ImageOptions, meta OutputMeta)
+}
+
+type event struct {
+	objectKey   string
+	opts        processor.ImageOptions
+	data        []byte
+	contentType string
+	meta        OutputMeta
+}
+
+// Dispatcher runs hooks on a fixed pool of workers. Dispatch never blocks:
+// when the queue is full the event is dropped and counted.
+type Dispatcher struct {
+	hooks []ProcessedHook
+	queue chan event
+}
+
+// NewDispatcher starts workers running hooks, with up to queueSize events
+// waiting for them.
+func NewDispatcher(hooks []ProcessedHook, workers, queueSize int) *Dispatcher {
+	d := &Dispatcher{
+		hooks: hooks,
+		queue: make(chan event, max(queueSize, 1)),
+	}
+	for i := 0; i < max(workers, 1); i++ {
+		go d.worker()
+	}
+	return d
+}
+
+// Dispatch queues the hooks for a processed variant. data is the output,
+// which is only read, and contentType its Content-Type: the workers fill in
+// the format, size and dimensions of meta from them, outside the request.
+// It is a no-op on a nil Dispatcher.
+func (d *Dispatcher) Dispatch(objectKey string, opts processor.ImageOptions, data []byte, contentType string, meta OutputMeta) {
+	if d == nil || len(d.hooks) == 0 {
+		return
+	}
+	select {
+	case d.queue <- event{objectKey: objectKey, opts: opts, data: data, contentType: contentType, meta: meta}:
+	default:
+		metrics.ProcessedHookDropsTotal.Inc()
+	}
+}
+
+func (d *Dispatcher) worker() {
+	for ev := range d.queue {
+		meta := ev.meta
+		meta.Bytes = len(ev.data)
+		// The format is the one clients get ("image/webp" is "webp")
+		_, meta.Format, _ = strings.Cut(strings.Split(ev.contentType, ";")[0], "/")
+		// Blurhash strings and videos have no image header
+		if header, err := processor.ReadHeader(ev.data); err == nil {
+			meta.Width, meta.Height = header.Width, header.Height
+		}
+		for _, hook := range d.hooks {
+			d.run(hook, ev, meta)
+		}
+	}
+}
+
+// run calls one hook, recovering from its panics so a faulty hook cannot
+// stop the workers.
+func (d *Dispatcher) run(hook ProcessedHook, ev event, meta OutputMeta) {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	start := time.Now()
+	result := "completed"
+	defer func() {
+		if r := recover(); r != nil {
+			result = "panicked"
+			slog.Error("Processed hook panicked", "hook", fmt.Sprintf("%T", hook), "objectKey", ev.objectKey, "panic", r)
+		}
+		metrics.ProcessedHookDuration.Observe(time.Since(start).Seconds())
+		metrics.ProcessedHookInvocationsTotal.WithLabelValues(result).Inc()
+	}()
+	hook.OnProcessed(ctx, ev.objectKey, ev.opts, meta)
+}
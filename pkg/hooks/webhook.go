@@ -0,0 +1,79 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+	"github.com/CodeTease/quirm/pkg/processor"
+)
+
+// Webhook is a ProcessedHook POSTing the metadata of each processed variant
+// as JSON to a URL. Deliveries are not retried.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+type webhookPayload struct {
+	ObjectKey  string  `json:"object_key"`
+	Params     string  `json:"params"`
+	CacheKey   string  `json:"cache_key"`
+	Format     string  `json:"format"`
+	Bytes      int     `json:"bytes"`
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// NewWebhook returns a hook posting to url, each POST bounded by timeout.
+func NewWebhook(url string, timeout time.Duration) *Webhook {
+	return &Webhook{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// OnProcessed implements ProcessedHook.
+func (w *Webhook) OnProcessed(ctx context.Context, objectKey string, opts processor.ImageOptions, meta OutputMeta) {
+	if err := w.post(ctx, objectKey, opts, meta); err != nil {
+		metrics.ProcessedWebhooksTotal.WithLabelValues("failed").Inc()
+		slog.Warn("Failed to deliver processed webhook", "objectKey", objectKey, "error", err)
+		return
+	}
+	metrics.ProcessedWebhooksTotal.WithLabelValues("sent").Inc()
+}
+
+func (w *Webhook) post(ctx context.Context, objectKey string, opts processor.ImageOptions, meta OutputMeta) error {
+	body, err := json.Marshal(webhookPayload{
+		ObjectKey:  objectKey,
+		Params:     opts.Canonical(),
+		CacheKey:   meta.CacheKey,
+		Format:     meta.Format,
+		Bytes:      meta.Bytes,
+		Width:      meta.Width,
+		Height:     meta.Height,
+		DurationMS: float64(meta.Duration.Microseconds()) / 1000,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "quirm")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %d", resp.StatusCode)
+	}
+	return nil
+}
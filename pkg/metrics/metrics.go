@@ -1,10 +1,28 @@
 package metrics
 
 import (
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/CodeTease/quirm/pkg/buildinfo"
 )
 
 var (
+	// BuildInfo is always 1; its labels identify the running binary
+	BuildInfo = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "quirm_build_info",
+			Help: "Version, commit, build date and Go release of the running binary.",
+			ConstLabels: prometheus.Labels{
+				"version":    buildinfo.Version,
+				"commit":     buildinfo.Commit,
+				"build_date": buildinfo.BuildDate,
+				"go_version": buildinfo.GoVersion(),
+			},
+		},
+	)
+
 	// HTTP Metrics
 	HTTPRequestsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -21,6 +39,12 @@ var (
 		},
 		[]string{"method", "status", "path"},
 	)
+	CanonicalRedirectsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "quirm_canonical_redirects_total",
+			Help: "Requests redirected to their canonical URL.",
+		},
+	)
 
 	// Cache Metrics
 	CacheOpsTotal = prometheus.NewCounterVec(
@@ -30,6 +54,182 @@ var (
 		},
 		[]string{"type"}, // hit or miss
 	)
+	CacheLookupDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "quirm_cache_lookup_duration_seconds",
+			Help:    "Duration of cache lookups by tier and result.",
+			Buckets: []float64{.0001, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, 1},
+		},
+		[]string{"tier", "result"}, // memory, redis or disk; hit or miss
+	)
+
+	MemoryCacheHitRatio = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "quirm_memory_cache_hit_ratio",
+			Help: "Hits out of all lookups of the memory cache since start (MEMORY_CACHE_METRICS).",
+		},
+	)
+	MemoryCacheAdmissionRatio = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "quirm_memory_cache_admission_ratio",
+			Help: "Writes admitted out of all writes to the memory cache since start (MEMORY_CACHE_METRICS).",
+		},
+	)
+
+	RefreshLockTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_refresh_lock_total",
+			Help: "Total number of distributed refresh lock attempts.",
+		},
+		[]string{"result"}, // acquired, contended or error
+	)
+
+	RefreshTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_refresh_total",
+			Help: "Total number of stale entry refreshes.",
+		},
+		[]string{"result"}, // revalidated, reprocessed or error
+	)
+
+	BackgroundRefreshesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_background_refreshes_total",
+			Help: "Background refreshes of stale entries by result.",
+		},
+		[]string{"result"}, // completed, failed or dropped
+	)
+	RefreshQueueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "quirm_refresh_queue_depth",
+			Help: "Background refreshes waiting for a worker.",
+		},
+	)
+	RefreshWorkersActive = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "quirm_refresh_workers_active",
+			Help: "Background refreshes running.",
+		},
+	)
+	RefreshDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "quirm_refresh_duration_seconds",
+			Help:    "Duration of background refreshes, from the start of the refresh to its end.",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+
+	OriginRangeBytesSaved = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "quirm_origin_range_bytes_saved_total",
+			Help: "Bytes not downloaded because an image header was read from a range of the original.",
+		},
+	)
+
+	ConditionalRefreshBytesSaved = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "quirm_conditional_refresh_bytes_saved_total",
+			Help: "Bytes not downloaded because a conditional passthrough refresh found the original unchanged.",
+		},
+	)
+
+	CacheDedupBytesSaved = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "quirm_cache_dedup_bytes_saved_total",
+			Help: "Bytes not written to the disk cache because an identical processed output was already stored.",
+		},
+	)
+	VariantsPerObject = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "quirm_variants_per_object",
+			Help:    "Number of processed variants of an object, observed each time the object gains one.",
+			Buckets: []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000},
+		},
+	)
+	VariantEvictionsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "quirm_variant_evictions_total",
+			Help: "Processed variants evicted because their object exceeded MAX_VARIANTS_PER_OBJECT.",
+		},
+	)
+	PrefixPurgesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_prefix_purges_total",
+			Help: "Prefix purges by result.",
+		},
+		[]string{"result"}, // complete, truncated or failed
+	)
+	PrefixPurgeEntriesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "quirm_prefix_purge_entries_total",
+			Help: "Cache entries deleted by prefix purges.",
+		},
+	)
+
+	PeerRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_peer_requests_total",
+			Help: "Processed variant requests by peer route (local, proxied, fallback).",
+		},
+		[]string{"route"},
+	)
+
+	PeerRingRebalances = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "quirm_peer_ring_rebalances_total",
+			Help: "Number of times the peer ring was rebuilt after the peer list changed.",
+		},
+	)
+
+	PolicyViolations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_policy_violations_total",
+			Help: "Requests rejected by request limits or transform policies, by rule.",
+		},
+		[]string{"rule"},
+	)
+
+	PresetRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_preset_requests_total",
+			Help: "Requests naming a preset, by preset.",
+		},
+		[]string{"preset"}, // a configured preset name, or "unknown"
+	)
+
+	FilteredRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_filtered_requests_total",
+			Help: "Requests answered 404 by the request filter without an origin request, by reason.",
+		},
+		[]string{"reason"}, // extension or pattern
+	)
+
+	SelfTestOK = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "quirm_selftest_ok",
+			Help: "1 if the last /_selftest run passed every step, 0 if a step failed.",
+		},
+	)
+	DiskCacheDegraded = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "quirm_disk_cache_degraded",
+			Help: "1 while the disk cache is not writable and requests are served without it.",
+		},
+	)
+	DiskCacheSizeBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "quirm_disk_cache_size_bytes",
+			Help: "Size of the disk cache at the end of the last cleanup.",
+		},
+	)
+	DiskEvictionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_disk_evictions_total",
+			Help: "Disk cache entries evicted to stay within CACHE_MAX_SIZE_MB, by the policy that chose them.",
+		},
+		[]string{"policy"}, // lru or lfu
+	)
 
 	// Processing Metrics
 	ImageProcessDuration = prometheus.NewHistogram(
@@ -39,29 +239,328 @@ var (
 			Buckets: prometheus.DefBuckets,
 		},
 	)
+	FaceDetectionFallbacksTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_face_detection_fallbacks_total",
+			Help: "focus=face/faces crops that fell back to a center crop.",
+		},
+		[]string{"reason"}, // unavailable or no_faces
+	)
 	ImageProcessErrorsTotal = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Name: "quirm_image_process_errors_total",
 			Help: "Total number of image processing errors.",
 		},
 	)
+	SkippedNoopProcessTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "quirm_skipped_noop_process_total",
+			Help: "Variant builds that served the source as is, as it already fit the request.",
+		},
+	)
+	ProcessRetriesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "quirm_process_retries_total",
+			Help: "Variant builds retried after a transient origin or libvips error.",
+		},
+	)
+	ProcessRetryOutcomesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_process_retry_outcomes_total",
+			Help: "Outcome of variant builds that were retried.",
+		},
+		[]string{"outcome"}, // recovered or failed
+	)
+	WatermarkLoadsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_watermark_loads_total",
+			Help: "Total number of watermark image loads.",
+		},
+		[]string{"result"}, // success or failure
+	)
+	WatermarkLastLoadTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "quirm_watermark_last_load_timestamp_seconds",
+			Help: "Unix time of the last successful watermark load.",
+		},
+	)
+
+	// Video Metrics (ffmpeg)
+	VideoProcessDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "quirm_video_process_duration_seconds",
+			Help:    "Duration of ffmpeg video processing by operation.",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+		},
+		[]string{"operation"}, // thumbnail, animated, storyboard or clip
+	)
+	VideoProcessErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_video_process_errors_total",
+			Help: "Total number of failed ffmpeg video operations.",
+		},
+		[]string{"operation"},
+	)
+	FFmpegInvocationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_ffmpeg_invocations_total",
+			Help: "ffmpeg runs by operation and exit class.",
+		},
+		[]string{"operation", "exit"}, // ok, error, interrupted, other or not_started
+	)
+	FFmpegActiveJobs = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "quirm_ffmpeg_active_jobs",
+			Help: "ffmpeg processes holding one of the MAX_CONCURRENT_FFMPEG slots.",
+		},
+	)
+	FFmpegQueuedJobs = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "quirm_ffmpeg_queued_jobs",
+			Help: "ffmpeg runs waiting for a free slot.",
+		},
+	)
+	FFmpegQueueWait = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "quirm_ffmpeg_queue_wait_seconds",
+			Help:    "Time ffmpeg runs waited for a free slot.",
+			Buckets: []float64{0, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60},
+		},
+	)
+	FFmpegQueueTimeoutsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "quirm_ffmpeg_queue_timeouts_total",
+			Help: "ffmpeg runs given up after FFMPEG_QUEUE_TIMEOUT without a free slot.",
+		},
+	)
+	VideoInputTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_video_input_total",
+			Help: "Successful video renders by how ffmpeg read the source.",
+		},
+		[]string{"source"}, // presigned, download or download_fallback
+	)
+
+	GIFOptimizeTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_gif_optimize_total",
+			Help: "GIF optimizations (optimize=true) by result.",
+		},
+		[]string{"result"}, // optimized, unchanged or skipped
+	)
+	GIFOptimizeBytesSaved = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "quirm_gif_optimize_bytes_saved_total",
+			Help: "Bytes saved by optimized GIFs compared to their originals.",
+		},
+	)
 
 	// Storage Metrics
+	// Deprecated: use OriginFetchDuration. Kept for existing dashboards and
+	// removed in the next release.
 	S3FetchDuration = prometheus.NewHistogram(
 		prometheus.HistogramOpts{
 			Name:    "quirm_s3_fetch_duration_seconds",
-			Help:    "Duration of S3 fetch operations.",
+			Help:    "Duration of successful S3 fetch operations. Deprecated: use quirm_origin_fetch_duration_seconds.",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+
+	OriginFetchTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_origin_fetch_total",
+			Help: "Origin fetch attempts by outcome and bucket.",
+		},
+		[]string{"outcome", "bucket"},
+	)
+
+	OriginFetchDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "quirm_origin_fetch_duration_seconds",
+			Help:    "Duration of origin fetch attempts by outcome and bucket.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"outcome", "bucket"},
+	)
+
+	OriginOperationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "quirm_origin_operation_duration_seconds",
+			Help:    "Duration of storage operations, retries included, by operation, outcome and backend.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"operation", "outcome", "backend"},
+	)
+
+	OriginRetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_origin_retries_total",
+			Help: "Origin reads retried after throttling or a server or network error, by operation.",
+		},
+		[]string{"operation"},
+	)
+
+	OriginHedgesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "quirm_origin_hedges_total",
+			Help: "Second origin GETs fired because the first was slower than HEDGE_AFTER.",
+		},
+	)
+
+	OriginHedgeWinsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "quirm_origin_hedge_wins_total",
+			Help: "Hedged origin GETs that answered before the original request.",
+		},
+	)
+	OriginNotFoundCacheHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "quirm_origin_not_found_cache_hits_total",
+			Help: "Origin requests answered from remembered not found results.",
+		},
+	)
+
+	OriginConnectionsInUse = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "quirm_origin_connections_in_use",
+			Help: "Origin requests holding a connection, by pool.",
+		},
+		[]string{"pool"}, // interactive or background
+	)
+
+	ErrorWebhooksTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_error_webhooks_total",
+			Help: "Error webhook batches by result.",
+		},
+		[]string{"result"}, // sent, failed or suppressed
+	)
+
+	// Processed hook Metrics
+	ProcessedHookInvocationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_processed_hook_invocations_total",
+			Help: "Processed hook invocations by result.",
+		},
+		[]string{"result"}, // completed or panicked
+	)
+	ProcessedHookDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "quirm_processed_hook_duration_seconds",
+			Help:    "Duration of processed hook invocations.",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+	ProcessedHookDropsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "quirm_processed_hook_drops_total",
+			Help: "Processed variants whose hooks were not run because the queue was full.",
+		},
+	)
+	ProcessedWebhooksTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_processed_webhooks_total",
+			Help: "Processed webhook deliveries by result.",
+		},
+		[]string{"result"}, // sent or failed
+	)
+
+	// Warmup Metrics
+	WarmupJobs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "quirm_warmup_jobs",
+			Help: "Number of warmup jobs currently queued or in progress.",
+		},
+		[]string{"state"},
+	)
+	WarmupJobsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quirm_warmup_jobs_total",
+			Help: "Total number of finished warmup jobs.",
+		},
+		[]string{"state"}, // completed or failed
+	)
+	WarmupJobDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "quirm_warmup_job_duration_seconds",
+			Help:    "Duration of warmup jobs.",
 			Buckets: prometheus.DefBuckets,
 		},
 	)
 )
 
-// Init registers all metrics with Prometheus
+var initOnce sync.Once
+
+// Init registers all metrics with Prometheus. It is safe to call more than once.
 func Init() {
+	initOnce.Do(register)
+}
+
+func register() {
+	BuildInfo.Set(1)
+	prometheus.MustRegister(BuildInfo)
 	prometheus.MustRegister(HTTPRequestsTotal)
 	prometheus.MustRegister(HTTPRequestDuration)
+	prometheus.MustRegister(CanonicalRedirectsTotal)
 	prometheus.MustRegister(CacheOpsTotal)
+	prometheus.MustRegister(CacheLookupDuration)
+	prometheus.MustRegister(MemoryCacheHitRatio)
+	prometheus.MustRegister(MemoryCacheAdmissionRatio)
+	prometheus.MustRegister(RefreshLockTotal)
+	prometheus.MustRegister(RefreshTotal)
+	prometheus.MustRegister(BackgroundRefreshesTotal)
+	prometheus.MustRegister(RefreshQueueDepth)
+	prometheus.MustRegister(RefreshWorkersActive)
+	prometheus.MustRegister(RefreshDuration)
+	prometheus.MustRegister(DiskCacheDegraded)
+	prometheus.MustRegister(SelfTestOK)
+	prometheus.MustRegister(DiskCacheSizeBytes)
+	prometheus.MustRegister(DiskEvictionsTotal)
+	prometheus.MustRegister(ConditionalRefreshBytesSaved)
+	prometheus.MustRegister(OriginRangeBytesSaved)
+	prometheus.MustRegister(CacheDedupBytesSaved)
+	prometheus.MustRegister(VariantsPerObject)
+	prometheus.MustRegister(VariantEvictionsTotal)
+	prometheus.MustRegister(PrefixPurgesTotal)
+	prometheus.MustRegister(PrefixPurgeEntriesTotal)
+	prometheus.MustRegister(PeerRequestsTotal)
+	prometheus.MustRegister(PeerRingRebalances)
+	prometheus.MustRegister(PolicyViolations)
+	prometheus.MustRegister(FilteredRequestsTotal)
+	prometheus.MustRegister(PresetRequestsTotal)
 	prometheus.MustRegister(ImageProcessDuration)
+	prometheus.MustRegister(FaceDetectionFallbacksTotal)
 	prometheus.MustRegister(ImageProcessErrorsTotal)
+	prometheus.MustRegister(SkippedNoopProcessTotal)
+	prometheus.MustRegister(ProcessRetriesTotal)
+	prometheus.MustRegister(ProcessRetryOutcomesTotal)
+	prometheus.MustRegister(WatermarkLoadsTotal)
+	prometheus.MustRegister(WatermarkLastLoadTimestamp)
+	prometheus.MustRegister(VideoProcessDuration)
+	prometheus.MustRegister(VideoProcessErrorsTotal)
+	prometheus.MustRegister(FFmpegInvocationsTotal)
+	prometheus.MustRegister(FFmpegActiveJobs)
+	prometheus.MustRegister(FFmpegQueuedJobs)
+	prometheus.MustRegister(FFmpegQueueWait)
+	prometheus.MustRegister(FFmpegQueueTimeoutsTotal)
+	prometheus.MustRegister(VideoInputTotal)
+	prometheus.MustRegister(GIFOptimizeTotal)
+	prometheus.MustRegister(GIFOptimizeBytesSaved)
 	prometheus.MustRegister(S3FetchDuration)
+	prometheus.MustRegister(OriginFetchTotal)
+	prometheus.MustRegister(OriginFetchDuration)
+	prometheus.MustRegister(OriginOperationDuration)
+	prometheus.MustRegister(OriginRetriesTotal)
+	prometheus.MustRegister(OriginHedgesTotal)
+	prometheus.MustRegister(OriginHedgeWinsTotal)
+	prometheus.MustRegister(OriginNotFoundCacheHits)
+	prometheus.MustRegister(OriginConnectionsInUse)
+	prometheus.MustRegister(ErrorWebhooksTotal)
+	prometheus.MustRegister(ProcessedHookInvocationsTotal)
+	prometheus.MustRegister(ProcessedHookDuration)
+	prometheus.MustRegister(ProcessedHookDropsTotal)
+	prometheus.MustRegister(ProcessedWebhooksTotal)
+	prometheus.MustRegister(WarmupJobs)
+	prometheus.MustRegister(WarmupJobsTotal)
+	prometheus.MustRegister(WarmupJobDuration)
 }
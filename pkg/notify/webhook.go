@@ -0,0 +1,246 @@
+// Package notify posts summaries of repeated request failures to a webhook,
+// so broken references in production get noticed.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// Event kinds.
+const (
+	NotFound        = "not_found"
+	ProcessingError = "processing_error"
+)
+
+// Event summarizes the failures of one key within the window.
+type Event struct {
+	Kind         string    `json:"kind"`
+	Key          string    `json:"key"`
+	Count        int       `json:"count"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	SampleParams string    `json:"sample_params,omitempty"`
+	SampleError  string    `json:"sample_error,omitempty"`
+}
+
+// Options configures a Notifier.
+type Options struct {
+	URL string
+	// Threshold is how many failures of a key within Window trigger a
+	// notification.
+	Threshold int
+	Window    time.Duration
+	// Interval is the minimum time between two notifications for a key.
+	Interval time.Duration
+}
+
+const (
+	queueSize        = 1000
+	flushEvery       = time.Minute
+	maxTracked       = 10000
+	deliveryAttempts = 3
+	// After breakerThreshold failed deliveries in a row, nothing is posted
+	// for breakerCooldown.
+	breakerThreshold = 5
+	breakerCooldown  = 10 * time.Minute
+)
+
+type report struct {
+	kind, key, params, err string
+	at                     time.Time
+}
+
+type tracked struct {
+	Event
+	notified time.Time
+}
+
+// Notifier aggregates failures in the background. Report never blocks: when
+// the queue is full the failure is dropped.
+type Notifier struct {
+	opts   Options
+	client *http.Client
+	queue  chan report
+
+	// Owned by the run goroutine
+	events       map[string]*tracked
+	failures     int
+	breakerUntil time.Time
+}
+
+// New starts a notifier posting to opts.URL.
+func New(opts Options) *Notifier {
+	if opts.Threshold < 1 {
+		opts.Threshold = 1
+	}
+	n := &Notifier{
+		opts:   opts,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan report, queueSize),
+		events: make(map[string]*tracked),
+	}
+	go n.run()
+	return n
+}
+
+// Report records a failure of key. It is a no-op on a nil Notifier.
+func (n *Notifier) Report(kind, key, params string, err error) {
+	if n == nil {
+		return
+	}
+	r := report{kind: kind, key: key, params: params, at: time.Now()}
+	if err != nil {
+		r.err = err.Error()
+	}
+	select {
+	case n.queue <- r:
+	default:
+	}
+}
+
+func (n *Notifier) run() {
+	// Keys crossing the threshold are batched into one POST per tick
+	ticker := time.NewTicker(min(flushEvery, n.opts.Interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case r := <-n.queue:
+			n.add(r)
+		case now := <-ticker.C:
+			n.flush(now)
+		}
+	}
+}
+
+func (n *Notifier) add(r report) {
+	id := r.kind + "\x00" + r.key
+	ev, ok := n.events[id]
+	if ok && r.at.Sub(ev.FirstSeen) > n.opts.Window {
+		// The window restarts, the notification history is kept
+		ev.Event = Event{}
+	}
+	if !ok {
+		if len(n.events) >= maxTracked {
+			n.expire(r.at)
+			if len(n.events) >= maxTracked {
+				return
+			}
+		}
+		ev = &tracked{}
+		n.events[id] = ev
+	}
+	if ev.Count == 0 {
+		ev.Event = Event{Kind: r.kind, Key: r.key, FirstSeen: r.at}
+	}
+	ev.Count++
+	ev.LastSeen = r.at
+	ev.SampleParams = r.params
+	if r.err != "" {
+		ev.SampleError = r.err
+	}
+}
+
+// expire forgets keys whose window and notification interval have passed.
+func (n *Notifier) expire(now time.Time) {
+	for id, ev := range n.events {
+		if now.Sub(ev.LastSeen) > n.opts.Window && now.Sub(ev.notified) > n.opts.Interval {
+			delete(n.events, id)
+		}
+	}
+}
+
+func (n *Notifier) flush(now time.Time) {
+	var batch []Event
+	for _, ev := range n.events {
+		if ev.Count >= n.opts.Threshold && now.Sub(ev.notified) >= n.opts.Interval {
+			batch = append(batch, ev.Event)
+			ev.notified = now
+			ev.Event = Event{}
+		}
+	}
+	n.expire(now)
+	if len(batch) == 0 {
+		return
+	}
+
+	if now.Before(n.breakerUntil) {
+		metrics.ErrorWebhooksTotal.WithLabelValues("suppressed").Inc()
+		return
+	}
+	if err := n.deliver(batch); err != nil {
+		metrics.ErrorWebhooksTotal.WithLabelValues("failed").Inc()
+		n.failures++
+		if n.failures >= breakerThreshold {
+			n.breakerUntil = now.Add(breakerCooldown)
+			n.failures = 0
+			slog.Warn("Error webhook keeps failing, pausing notifications", "error", err, "for", breakerCooldown)
+		} else {
+			slog.Warn("Failed to deliver error webhook", "error", err)
+		}
+		return
+	}
+	metrics.ErrorWebhooksTotal.WithLabelValues("sent").Inc()
+	n.failures = 0
+}
+
+// deliver posts the batch, retrying with backoff on network errors and 5xx.
+func (n *Notifier) deliver(batch []Event) error {
+	body, err := json.Marshal(map[string]interface{}{"events": batch})
+	if err != nil {
+		return err
+	}
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err = n.post(body)
+		if err == nil || attempt == deliveryAttempts {
+			return err
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// permanentError is a delivery failure that a retry would not fix.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func (n *Notifier) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "quirm")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("webhook answered %d", resp.StatusCode)
+	case resp.StatusCode >= 300:
+		return &permanentError{fmt.Errorf("webhook answered %d", resp.StatusCode)}
+	}
+	return nil
+}
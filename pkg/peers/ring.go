@@ -0,0 +1,50 @@
+// Package peers routes processed variants to the replica that owns them, so
+// that replicas with their own disk caches do not each build every variant.
+package peers
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// virtualNodes is the number of points each peer gets on the ring. More
+// points spread the keys more evenly at the cost of a larger ring.
+const virtualNodes = 160
+
+// Ring is a consistent hash ring over the peer addresses. Adding or removing
+// a peer only moves the keys owned by that peer.
+type Ring struct {
+	points []uint32
+	owners map[uint32]string
+}
+
+// NewRing builds a ring over peers.
+func NewRing(peers []string) *Ring {
+	r := &Ring{owners: make(map[uint32]string, len(peers)*virtualNodes)}
+	for _, peer := range peers {
+		for i := 0; i < virtualNodes; i++ {
+			point := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "#" + peer))
+			if _, taken := r.owners[point]; taken {
+				continue
+			}
+			r.owners[point] = peer
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Owner returns the peer that owns key, or "" if the ring is empty.
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
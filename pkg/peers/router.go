@@ -0,0 +1,191 @@
+package peers
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// Header marks a request forwarded by a peer. Its value is the address of
+// the forwarding peer. Requests carrying it are always served locally, which
+// prevents forwarding loops while peers disagree about the ring.
+const Header = "X-Quirm-Peer"
+
+// peerAddrTTL is how long resolved peer addresses are used before the
+// host names are looked up again; replicas get new addresses on restart.
+const peerAddrTTL = 30 * time.Second
+
+// peerLookupTimeout bounds resolving the peer list.
+const peerLookupTimeout = 2 * time.Second
+
+// Forwarded reports whether r was forwarded by one of peers: it carries
+// Header and comes from the address of a peer. Any client can send the
+// header, so it is not trusted on its own. Peers given by host name are
+// resolved at most once per peerAddrTTL, not for every request.
+func (rt *Router) Forwarded(r *http.Request, peers []string) bool {
+	if r.Header.Get(Header) == "" || len(peers) == 0 {
+		return false
+	}
+	remote, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(rt.peerAddrs(peers), remote.Addr().Unmap().WithZone(""))
+}
+
+// peerAddrs returns the addresses of peers, resolving host names when the
+// list changed or its addresses are older than peerAddrTTL. A nil Router
+// resolves them every time.
+func (rt *Router) peerAddrs(peers []string) []netip.Addr {
+	if rt == nil {
+		return resolvePeers(net.DefaultResolver.LookupNetIP, peers)
+	}
+	rt.addrMu.Lock()
+	defer rt.addrMu.Unlock()
+	if !slices.Equal(rt.addrPeers, peers) || time.Since(rt.addrsAt) > peerAddrTTL {
+		rt.addrs = resolvePeers(rt.lookup, peers)
+		rt.addrPeers = slices.Clone(peers)
+		rt.addrsAt = time.Now()
+	}
+	return rt.addrs
+}
+
+// lookupFunc resolves a host name, as net.Resolver.LookupNetIP does.
+type lookupFunc func(ctx context.Context, network, host string) ([]netip.Addr, error)
+
+// resolvePeers returns the addresses of peers. Hosts that fail to resolve
+// are left out.
+func resolvePeers(lookup lookupFunc, peers []string) []netip.Addr {
+	ctx, cancel := context.WithTimeout(context.Background(), peerLookupTimeout)
+	defer cancel()
+
+	var addrs []netip.Addr
+	for _, peer := range peers {
+		host := peerHost(peer)
+		if ip, err := netip.ParseAddr(host); err == nil {
+			addrs = append(addrs, ip.Unmap().WithZone(""))
+			continue
+		}
+		ips, err := lookup(ctx, "ip", host)
+		if err != nil {
+			slog.Warn("Failed to resolve peer", "peer", peer, "error", err)
+			continue
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, ip.Unmap().WithZone(""))
+		}
+	}
+	return addrs
+}
+
+// peerHost returns the host of a PEERS entry, "host:port" or a URL.
+func peerHost(peer string) string {
+	if strings.Contains(peer, "://") {
+		if u, err := url.Parse(peer); err == nil {
+			return u.Hostname()
+		}
+	}
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		return host
+	}
+	return strings.Trim(peer, "[]")
+}
+
+// Router keeps the ring for the configured peer list and forwards requests
+// to their owner. The ring is rebuilt whenever the list changes, e.g. after a
+// configuration reload; keys that move just miss once on their new owner.
+// A nil Router serves everything locally.
+type Router struct {
+	mu    sync.Mutex
+	peers []string
+	ring  *Ring
+
+	// addrs are the resolved addresses of addrPeers, used by Forwarded
+	addrMu    sync.Mutex
+	addrPeers []string
+	addrs     []netip.Addr
+	addrsAt   time.Time
+	lookup    lookupFunc
+
+	transport http.RoundTripper
+}
+
+// NewRouter returns a Router with no peers.
+func NewRouter() *Router {
+	return &Router{
+		lookup: net.DefaultResolver.LookupNetIP,
+		transport: &http.Transport{
+			// Fail fast on a dead peer: the fallback is serving locally
+			DialContext:         (&net.Dialer{Timeout: 2 * time.Second}).DialContext,
+			MaxIdleConnsPerHost: 32,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// Owner returns the peer owning key among peers, and whether that is self.
+// Requests are local when no peers are configured or self is not part of
+// the list.
+func (rt *Router) Owner(peers []string, self, key string) (string, bool) {
+	if rt == nil || len(peers) == 0 || !slices.Contains(peers, self) {
+		return self, true
+	}
+
+	rt.mu.Lock()
+	if !slices.Equal(rt.peers, peers) {
+		if rt.ring != nil {
+			slog.Info("Peer list changed, rebuilding ring", "peers", strings.Join(peers, ","))
+			metrics.PeerRingRebalances.Inc()
+		}
+		rt.peers = slices.Clone(peers)
+		rt.ring = NewRing(rt.peers)
+	}
+	owner := rt.ring.Owner(key)
+	rt.mu.Unlock()
+
+	return owner, owner == self
+}
+
+// Forward proxies r to owner. If the owner cannot be reached, fallback
+// serves the request locally instead.
+func (rt *Router) Forward(w http.ResponseWriter, r *http.Request, owner, self string, fallback http.HandlerFunc) {
+	target := &url.URL{Scheme: "http", Host: owner}
+	if strings.Contains(owner, "://") {
+		if u, err := url.Parse(owner); err == nil {
+			target = u
+		}
+	}
+
+	failed := false
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			pr.Out.Host = pr.In.Host
+			pr.Out.Header.Set(Header, self)
+		},
+		Transport: rt.transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			slog.Warn("Peer unavailable, serving locally", "peer", owner, "error", err)
+			failed = true
+			fallback(w, r)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+
+	if failed {
+		metrics.PeerRequestsTotal.WithLabelValues("fallback").Inc()
+	} else {
+		metrics.PeerRequestsTotal.WithLabelValues("proxied").Inc()
+	}
+}
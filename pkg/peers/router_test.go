@@ -0,0 +1,63 @@
+package peers
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestForwarded(t *testing.T) {
+	lookups := 0
+	rt := NewRouter()
+	rt.lookup = func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		lookups++
+		return map[string][]netip.Addr{
+			"quirm-0": {netip.MustParseAddr("10.0.0.10")},
+			"quirm-1": {netip.MustParseAddr("10.0.0.11"), netip.MustParseAddr("fd00::11")},
+		}[host], nil
+	}
+	peers := []string{"quirm-0:8080", "http://quirm-1:8080", "10.0.0.12:8080"}
+
+	tests := []struct {
+		name   string
+		remote string
+		header bool
+		want   bool
+	}{
+		{name: "peer by name", remote: "10.0.0.10:41000", header: true, want: true},
+		{name: "peer with several addresses", remote: "[fd00::11]:41000", header: true, want: true},
+		{name: "peer by address", remote: "10.0.0.12:41000", header: true, want: true},
+		{name: "mapped IPv4", remote: "[::ffff:10.0.0.11]:41000", header: true, want: true},
+		{name: "without the header", remote: "10.0.0.10:41000", want: false},
+		{name: "header from a client", remote: "192.0.2.1:41000", header: true, want: false},
+		{name: "malformed remote", remote: "10.0.0.10", header: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/a.jpg", nil)
+			r.RemoteAddr = tt.remote
+			if tt.header {
+				r.Header.Set(Header, "quirm-0:8080")
+			}
+			if got := rt.Forwarded(r, peers); got != tt.want {
+				t.Errorf("Forwarded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	// Two host names, resolved once for all requests
+	if lookups != 2 {
+		t.Errorf("%d lookups, want 2", lookups)
+	}
+
+	// A new peer list is resolved again
+	r := httptest.NewRequest("GET", "/a.jpg", nil)
+	r.RemoteAddr = "10.0.0.10:41000"
+	r.Header.Set(Header, "quirm-1:8080")
+	if !rt.Forwarded(r, peers[:1]) {
+		t.Error("peer not recognized after the list changed")
+	}
+	if lookups != 3 {
+		t.Errorf("%d lookups after the list changed, want 3", lookups)
+	}
+}
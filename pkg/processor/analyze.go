@@ -0,0 +1,210 @@
+package processor
+
+import (
+	"fmt"
+	"image"
+	"sort"
+
+	"github.com/buckket/go-blurhash"
+	"github.com/davidbyttow/govips/v2/vips"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// Analysis is what Analyze learns about an image.
+type Analysis struct {
+	Format string
+	Width  int // as displayed, like ReadHeader
+	Height int
+	Pages  int
+	// Colors are the dominant colors, as returned by ExtractPalette
+	Colors   []string
+	Blurhash string
+	// Brightness is the mean luma, from 0 (black) to 1 (white)
+	Brightness float64
+}
+
+// Analyze reports the dimensions, format, dominant colors, blurhash and
+// average brightness of an image from a single decode.
+func Analyze(data []byte) (Analysis, error) {
+	img, err := vips.LoadImageFromBuffer(data, vips.NewImportParams())
+	if err != nil {
+		metrics.ImageProcessErrorsTotal.Inc()
+		return Analysis{}, fmt.Errorf("decode error: %w", err)
+	}
+	defer img.Close()
+	if err := autoRotate(img); err != nil {
+		metrics.ImageProcessErrorsTotal.Inc()
+		return Analysis{}, fmt.Errorf("auto-rotate error: %w", err)
+	}
+
+	analysis := Analysis{
+		Format: vips.ImageTypes[img.OriginalFormat()],
+		Width:  img.Width(),
+		Height: img.Height(),
+		Pages:  img.Pages(),
+	}
+	if analysis.Colors, analysis.Brightness, err = dominantColors(img); err != nil {
+		metrics.ImageProcessErrorsTotal.Inc()
+		return Analysis{}, err
+	}
+
+	// Like Process, transparent PDFs are flattened to white before hashing
+	if img.OriginalFormat() == vips.ImageTypePDF && img.HasAlpha() {
+		if err := img.Flatten(&vips.Color{R: 255, G: 255, B: 255}); err != nil {
+			metrics.ImageProcessErrorsTotal.Inc()
+			return Analysis{}, err
+		}
+	}
+	if analysis.Blurhash, err = encodeBlurhash(img); err != nil {
+		metrics.ImageProcessErrorsTotal.Inc()
+		return Analysis{}, err
+	}
+	return analysis, nil
+}
+
+// encodeBlurhash returns the blurhash of img, computed on a 32x32 copy.
+func encodeBlurhash(img *vips.ImageRef) (string, error) {
+	thumb, err := img.Copy()
+	if err != nil {
+		return "", err
+	}
+	if err := thumb.ThumbnailWithSize(32, 32, vips.InterestingCentre, vips.SizeForce); err != nil {
+		thumb.Close()
+		return "", err
+	}
+
+	if err := thumb.ToColorSpace(vips.InterpretationSRGB); err != nil {
+		thumb.Close()
+		return "", err
+	}
+
+	pixels, err := thumb.ToBytes()
+	if err != nil {
+		thumb.Close()
+		return "", err
+	}
+	w := thumb.Width()
+	h := thumb.Height()
+	bands := thumb.Bands()
+	thumb.Close()
+
+	var imgObj image.Image
+	if bands == 4 {
+		imgObj = &image.RGBA{
+			Pix:    pixels,
+			Stride: w * 4,
+			Rect:   image.Rect(0, 0, w, h),
+		}
+	} else if bands == 3 {
+		rgbaPixels := make([]uint8, w*h*4)
+		for i := 0; i < w*h; i++ {
+			rgbaPixels[i*4] = pixels[i*3]
+			rgbaPixels[i*4+1] = pixels[i*3+1]
+			rgbaPixels[i*4+2] = pixels[i*3+2]
+			rgbaPixels[i*4+3] = 255
+		}
+		imgObj = &image.RGBA{Pix: rgbaPixels, Stride: w * 4, Rect: image.Rect(0, 0, w, h)}
+	} else {
+		return "", fmt.Errorf("unsupported bands for blurhash: %d", bands)
+	}
+
+	return blurhash.Encode(4, 3, imgObj)
+}
+
+// dominantColors returns the five most frequent colors of img and its mean
+// Rec. 709 luma (0-1), both measured on a 100x100 copy. img is unchanged.
+func dominantColors(img *vips.ImageRef) ([]string, float64, error) {
+	thumb, err := img.Copy()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer thumb.Close()
+
+	// Resize to small size (100x100) to find dominant colors faster and group them
+	if err := thumb.ThumbnailWithSize(100, 100, vips.InterestingCentre, vips.SizeForce); err != nil {
+		return nil, 0, err
+	}
+
+	// Ensure sRGB
+	if err := thumb.ToColorSpace(vips.InterpretationSRGB); err != nil {
+		return nil, 0, err
+	}
+
+	pixels, err := thumb.ToBytes()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	bands := thumb.Bands()
+	w := thumb.Width()
+	h := thumb.Height()
+
+	colorCounts := make(map[string]int)
+	var lumaSum float64
+	var counted int
+
+	toHex := func(r, g, b uint8) string {
+		return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+	}
+
+	for i := 0; i < w*h; i++ {
+		offset := i * bands
+		if offset+bands > len(pixels) {
+			break
+		}
+
+		var rVal, gVal, bVal uint8
+
+		if bands >= 3 {
+			rVal = pixels[offset]
+			gVal = pixels[offset+1]
+			bVal = pixels[offset+2]
+		} else if bands == 1 {
+			// Grayscale
+			val := pixels[offset]
+			rVal, gVal, bVal = val, val, val
+		} else if bands == 2 {
+			// Grayscale + Alpha?
+			val := pixels[offset]
+			rVal, gVal, bVal = val, val, val
+		} else {
+			// Fallback (shouldn't happen with sRGB/BW)
+			continue
+		}
+
+		hex := toHex(rVal, gVal, bVal)
+		colorCounts[hex]++
+		lumaSum += 0.2126*float64(rVal) + 0.7152*float64(gVal) + 0.0722*float64(bVal)
+		counted++
+	}
+
+	type colorFreq struct {
+		Hex   string
+		Count int
+	}
+	var freqs []colorFreq
+	for k, v := range colorCounts {
+		freqs = append(freqs, colorFreq{k, v})
+	}
+
+	sort.Slice(freqs, func(i, j int) bool {
+		return freqs[i].Count > freqs[j].Count
+	})
+
+	limit := 5
+	if len(freqs) < limit {
+		limit = len(freqs)
+	}
+
+	result := make([]string, limit)
+	for i := 0; i < limit; i++ {
+		result[i] = freqs[i].Hex
+	}
+
+	var brightness float64
+	if counted > 0 {
+		brightness = lumaSum / float64(counted) / 255
+	}
+	return result, brightness, nil
+}
@@ -0,0 +1,36 @@
+package processor
+
+import (
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// parseBackground parses the color (see ParseColor) transparent images are
+// flattened onto. Its alpha is ignored, as the result is opaque. An empty or
+// invalid color yields white.
+func parseBackground(s string) *vips.Color {
+	c, err := ParseColor(s)
+	if s == "" || err != nil {
+		return &vips.Color{R: 255, G: 255, B: 255}
+	}
+	return &vips.Color{R: c.R, G: c.G, B: c.B}
+}
+
+// formatHasAlpha reports whether format can carry transparency. Unknown
+// formats are exported as JPEG and have none.
+func formatHasAlpha(format string) bool {
+	switch format {
+	case "png", "webp", "avif", "gif", "jxl":
+		return true
+	}
+	return false
+}
+
+// flattenForFormat composites a transparent image onto its background color
+// when format cannot store alpha, instead of leaving the encoder to drop the
+// alpha channel (which turns transparent areas black).
+func flattenForFormat(img *vips.ImageRef, format, background string) error {
+	if formatHasAlpha(format) || !img.HasAlpha() {
+		return nil
+	}
+	return img.Flatten(parseBackground(background))
+}
@@ -0,0 +1,70 @@
+package processor
+
+import (
+	"bytes"
+	"os/exec"
+	"sync"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// Capabilities describes the optional features available in this build and
+// environment.
+type Capabilities struct {
+	// Formats lists the output formats libvips can encode
+	Formats       []string `json:"formats"`
+	FFmpeg        bool     `json:"ffmpeg"`
+	FaceDetection bool     `json:"face_detection"`
+}
+
+// outputFormats maps the format query values to their libvips image type.
+var outputFormats = []struct {
+	name      string
+	imageType vips.ImageType
+}{
+	{"jpeg", vips.ImageTypeJPEG},
+	{"png", vips.ImageTypePNG},
+	{"webp", vips.ImageTypeWEBP},
+	{"avif", vips.ImageTypeAVIF},
+	{"jxl", vips.ImageTypeJXL},
+	{"gif", vips.ImageTypeGIF},
+}
+
+var (
+	ffmpegVersionOnce sync.Once
+	ffmpegVersion     string
+)
+
+// LibvipsVersion returns the version of the libvips library in use.
+func LibvipsVersion() string {
+	return vips.Version
+}
+
+// FFmpegVersion returns the first line of "ffmpeg -version", or "" when
+// ffmpeg is not available. The binary is only run on the first call.
+func FFmpegVersion() string {
+	ffmpegVersionOnce.Do(func() {
+		out, err := exec.Command("ffmpeg", "-version").Output()
+		if err != nil {
+			return
+		}
+		line, _, _ := bytes.Cut(out, []byte("\n"))
+		ffmpegVersion = string(bytes.TrimSpace(line))
+	})
+	return ffmpegVersion
+}
+
+// DetectCapabilities probes libvips, the ffmpeg binary and the face detection
+// cascade.
+func DetectCapabilities() Capabilities {
+	caps := Capabilities{Formats: []string{}}
+	for _, f := range outputFormats {
+		if vips.IsTypeSupported(f.imageType) {
+			caps.Formats = append(caps.Formats, f.name)
+		}
+	}
+	_, err := exec.LookPath("ffmpeg")
+	caps.FFmpeg = err == nil
+	caps.FaceDetection = faceClassifier != nil
+	return caps
+}
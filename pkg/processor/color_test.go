@@ -0,0 +1,144 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+func TestParseColor(t *testing.T) {
+	rgba := func(r, g, b, a uint8) vips.ColorRGBA { return vips.ColorRGBA{R: r, G: g, B: b, A: a} }
+	tests := []struct {
+		in      string
+		want    vips.ColorRGBA
+		wantErr bool
+	}{
+		// Hex
+		{in: "#f00", want: rgba(255, 0, 0, 255)},
+		{in: "f00", want: rgba(255, 0, 0, 255)},
+		{in: "#F0a", want: rgba(255, 0, 170, 255)},
+		{in: "#f008", want: rgba(255, 0, 0, 136)},
+		{in: "#ff8000", want: rgba(255, 128, 0, 255)},
+		{in: "FF8000", want: rgba(255, 128, 0, 255)},
+		{in: "#ff800080", want: rgba(255, 128, 0, 128)},
+		{in: "#00000000", want: rgba(0, 0, 0, 0)},
+		{in: "  #fff  ", want: rgba(255, 255, 255, 255)},
+		{in: "#", wantErr: true},
+		{in: "#f", wantErr: true},
+		{in: "#ff", wantErr: true},
+		{in: "#fffff", wantErr: true},
+		{in: "#fffffff", wantErr: true},
+		{in: "#fffffffff", wantErr: true},
+		{in: "#ggg", wantErr: true},
+		{in: "#ff00zz", wantErr: true},
+		{in: "##fff", wantErr: true},
+		{in: "#+ff", wantErr: true},
+		{in: "0x0f0", wantErr: true},
+
+		// Names
+		{in: "red", want: rgba(255, 0, 0, 255)},
+		{in: "RebeccaPurple", want: rgba(102, 51, 153, 255)},
+		{in: " white ", want: rgba(255, 255, 255, 255)},
+		{in: "transparent", want: rgba(0, 0, 0, 0)},
+		{in: "grey", want: rgba(128, 128, 128, 255)},
+		{in: "gray", want: rgba(128, 128, 128, 255)},
+		{in: "redd", wantErr: true},
+		{in: "light blue", wantErr: true},
+		{in: "currentcolor", wantErr: true},
+
+		// rgb() and rgba()
+		{in: "rgb(255, 128, 0)", want: rgba(255, 128, 0, 255)},
+		{in: "rgb(255,128,0)", want: rgba(255, 128, 0, 255)},
+		{in: "RGB(255 128 0)", want: rgba(255, 128, 0, 255)},
+		{in: "rgb(100%, 50%, 0%)", want: rgba(255, 128, 0, 255)},
+		{in: "rgb(255 128 0 / 0.5)", want: rgba(255, 128, 0, 128)},
+		{in: "rgb(255 128 0 / 25%)", want: rgba(255, 128, 0, 64)},
+		{in: "rgba(255, 128, 0, 0.5)", want: rgba(255, 128, 0, 128)},
+		{in: "rgba(255, 128, 0, 0)", want: rgba(255, 128, 0, 0)},
+		{in: "rgba(255, 128, 0, 1)", want: rgba(255, 128, 0, 255)},
+		{in: "rgba(255, 128, 0)", want: rgba(255, 128, 0, 255)},
+		{in: "rgb(12.6, 0, 0)", want: rgba(13, 0, 0, 255)},
+		{in: "rgb(256, 0, 0)", wantErr: true},
+		{in: "rgb(-1, 0, 0)", wantErr: true},
+		{in: "rgb(101%, 0, 0)", wantErr: true},
+		{in: "rgba(0, 0, 0, 1.5)", wantErr: true},
+		{in: "rgba(0, 0, 0, 101%)", wantErr: true},
+		{in: "rgb(0, 0)", wantErr: true},
+		{in: "rgb(0, 0, 0, 0, 0)", wantErr: true},
+		{in: "rgb()", wantErr: true},
+		{in: "rgb(a, b, c)", wantErr: true},
+		{in: "rgb(NaN, 0, 0)", wantErr: true},
+		{in: "rgb(0, 0, 0", wantErr: true},
+		{in: "rgb 0, 0, 0)", wantErr: true},
+		{in: "hsl(0, 100%, 50%)", wantErr: true},
+
+		{in: "", wantErr: true},
+		{in: "   ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseColor(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseColor(%q) error %v, want error %v", tt.in, err, tt.wantErr)
+			}
+			if err != nil {
+				if !strings.Contains(err.Error(), tt.in) {
+					t.Errorf("error %q does not quote the input", err)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseColor(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNamedColors parses every CSS named color, in any case, and checks
+// that its SVG paint parses back to the same color.
+func TestNamedColors(t *testing.T) {
+	// The 148 CSS Color Module Level 4 names and transparent
+	if len(namedColors) != 149 {
+		t.Errorf("%d named colors, want 149", len(namedColors))
+	}
+	for name, want := range namedColors {
+		for _, in := range []string{name, strings.ToUpper(name)} {
+			if got, err := ParseColor(in); err != nil || got != want {
+				t.Errorf("ParseColor(%q) = %+v, %v, want %+v", in, got, err, want)
+			}
+		}
+		fill, opacity := SVGColor(want)
+		hex, err := ParseColor(fill)
+		if err != nil {
+			t.Fatalf("ParseColor(%q): %v", fill, err)
+		}
+		if hex.R != want.R || hex.G != want.G || hex.B != want.B {
+			t.Errorf("%s: %s parses to %+v", name, fill, hex)
+		}
+		if wantOpacity := float64(want.A) / 255; opacity != wantOpacity {
+			t.Errorf("%s: opacity %v, want %v", name, opacity, wantOpacity)
+		}
+	}
+}
+
+func TestSVGColor(t *testing.T) {
+	tests := []struct {
+		in      string
+		fill    string
+		opacity float64
+	}{
+		{in: "red", fill: "#ff0000", opacity: 1},
+		{in: "#0000ff80", fill: "#0000ff", opacity: 128.0 / 255},
+		{in: "transparent", fill: "#000000", opacity: 0},
+	}
+	for _, tt := range tests {
+		c, err := ParseColor(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fill, opacity := SVGColor(c); fill != tt.fill || opacity != tt.opacity {
+			t.Errorf("SVGColor(%s) = %s, %v, want %s, %v", tt.in, fill, opacity, tt.fill, tt.opacity)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// VideoConversionOptions configures ConvertAnimatedToVideo.
+type VideoConversionOptions struct {
+	Format    string // "mp4" (H.264) or "webm" (VP9)
+	Width     int
+	Height    int
+	MaxFrames int // frames beyond this are dropped (0 = no cap)
+	// MaxWidth and MaxHeight bound the output, keeping the aspect ratio
+	// (0 = no bound)
+	MaxWidth  int
+	MaxHeight int
+}
+
+// IsVideoFormat reports whether format names a video container that
+// ConvertAnimatedToVideo can produce.
+func IsVideoFormat(format string) bool {
+	return format == "mp4" || format == "webm"
+}
+
+// ConvertAnimatedToVideoArgs returns the ffmpeg arguments transcoding the
+// animated image at inputPath to outputPath.
+func ConvertAnimatedToVideoArgs(inputPath, outputPath string, o VideoConversionOptions) []string {
+	filter := ""
+	if o.Width > 0 || o.Height > 0 {
+		// -2 keeps the aspect ratio with an even size
+		w, h := "-2", "-2"
+		if o.Width > 0 {
+			w = strconv.Itoa(o.Width)
+		}
+		if o.Height > 0 {
+			h = strconv.Itoa(o.Height)
+		}
+		filter = fmt.Sprintf("scale=%s:%s:flags=lanczos,", w, h)
+	}
+	if bound := boundFilter(o.MaxWidth, o.MaxHeight); bound != "" {
+		filter += bound + ","
+	}
+	// H.264 with 4:2:0 chroma needs even dimensions: drop the odd row or
+	// column rather than rescaling the whole frame
+	filter += "crop=trunc(iw/2)*2:trunc(ih/2)*2,format=yuv420p"
+
+	args := []string{"-y", "-i", inputPath, "-vf", filter, "-an"}
+	if o.MaxFrames > 0 {
+		args = append(args, "-frames:v", strconv.Itoa(o.MaxFrames))
+	}
+	if o.Format == "webm" {
+		return append(args,
+			"-c:v", "libvpx-vp9",
+			"-b:v", "0",
+			"-crf", "35",
+			"-row-mt", "1",
+			"-f", "webm",
+			outputPath,
+		)
+	}
+	return append(args,
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "23",
+		// The index goes first so playback starts before the download ends
+		"-movflags", "+faststart",
+		"-f", "mp4",
+		outputPath,
+	)
+}
+
+// ConvertAnimatedToVideo transcodes an animated image (e.g. a GIF) to an
+// MP4 or WebM video using ffmpeg. vips cannot write video, and a video is
+// usually a fraction of the size of the equivalent GIF.
+func ConvertAnimatedToVideo(ctx context.Context, inputPath string, o VideoConversionOptions) (*bytes.Buffer, error) {
+	if !IsVideoFormat(o.Format) {
+		return nil, fmt.Errorf("unsupported video format %q", o.Format)
+	}
+	_, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg not found: %w", err)
+	}
+
+	// MP4 needs a seekable output to move the index to the front
+	out, err := os.CreateTemp("", "quirm-convert-*."+o.Format)
+	if err != nil {
+		return nil, err
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	run := ffmpegRun{operation: VideoClip, input: inputPath, width: o.Width, height: o.Height}
+	if stderr, err := runFFmpeg(ctx, run, ConvertAnimatedToVideoArgs(inputPath, out.Name(), o), nil); err != nil {
+		return nil, fmt.Errorf("ffmpeg convert error: %w, stderr: %s", err, stderr)
+	}
+
+	data, err := os.ReadFile(out.Name())
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewBuffer(data), nil
+}
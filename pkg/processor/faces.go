@@ -0,0 +1,207 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/davidbyttow/govips/v2/vips"
+	pigo "github.com/esimov/pigo/core"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+const (
+	// defaultFacePad is the margin kept around faces when face_pad is not
+	// set, as a fraction of the face box size.
+	defaultFacePad = 0.4
+	// minFaceQuality is the detection score below which focus=faces leaves
+	// a detection out of the group; low scores are mostly false positives.
+	minFaceQuality = 5.0
+)
+
+var (
+	// faceClassifier is the unpacked face cascade, nil while face detection
+	// is unavailable
+	faceClassifier *pigo.Pigo
+	// cascadeErr is why the configured cascade could not be loaded
+	cascadeErr error
+)
+
+// LoadCascade loads the pigo cascade file from the given path and unpacks
+// it, so a file that is not a cascade (say, an ONNX model) is refused at
+// startup instead of failing every face crop. On error face detection stays
+// unavailable, and FaceDetectionError reports why.
+func LoadCascade(path string) error {
+	faceClassifier = nil
+	classifier, err := unpackCascade(path)
+	if err != nil {
+		cascadeErr = fmt.Errorf("face cascade %s: %w", path, err)
+		return cascadeErr
+	}
+	faceClassifier, cascadeErr = classifier, nil
+	return nil
+}
+
+func unpackCascade(path string) (classifier *pigo.Pigo, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// pigo indexes into the file without bounds checks, so a short or
+	// foreign file panics rather than failing
+	defer func() {
+		if r := recover(); r != nil {
+			classifier, err = nil, fmt.Errorf("not a pigo cascade: %v", r)
+		}
+	}()
+	classifier, err = pigo.NewPigo().Unpack(b)
+	if err != nil {
+		return nil, fmt.Errorf("not a pigo cascade: %w", err)
+	}
+	return classifier, nil
+}
+
+// FaceDetectionError returns why the face cascade passed to LoadCascade
+// could not be loaded, or nil.
+func FaceDetectionError() error {
+	return cascadeErr
+}
+
+// faceBox is a rectangle in source pixels, [x0,x1) x [y0,y1).
+type faceBox struct {
+	x0, y0, x1, y1 int
+}
+
+func detectionBox(det pigo.Detection) faceBox {
+	half := det.Scale / 2
+	return faceBox{det.Col - half, det.Row - half, det.Col + half, det.Row + half}
+}
+
+func (b faceBox) union(o faceBox) faceBox {
+	return faceBox{min(b.x0, o.x0), min(b.y0, o.y0), max(b.x1, o.x1), max(b.y1, o.y1)}
+}
+
+// detectFaces runs the face cascade over a grayscale copy of img and returns
+// every clustered detection. Without a loaded cascade nothing is detected.
+func detectFaces(img *vips.ImageRef) ([]pigo.Detection, error) {
+	classifier := faceClassifier
+	if classifier == nil {
+		return nil, nil
+	}
+	detImg, err := img.Copy()
+	if err != nil {
+		return nil, err
+	}
+	defer detImg.Close()
+	if err := detImg.ToColorSpace(vips.InterpretationBW); err != nil {
+		return nil, err
+	}
+	pixels, err := detImg.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+	cols, rows := detImg.Width(), detImg.Height()
+
+	cascade := pigo.CascadeParams{
+		MinSize:     20,
+		MaxSize:     1000,
+		ShiftFactor: 0.1,
+		ScaleFactor: 1.1,
+		ImageParams: pigo.ImageParams{
+			Pixels: pixels,
+			Rows:   rows,
+			Cols:   cols,
+			Dim:    cols,
+		},
+	}
+	dets := classifier.RunCascade(cascade, 0.0)
+	return classifier.ClusterDetections(dets, 0.2), nil
+}
+
+// faceBounds is the box to keep in the crop: the largest face, or with all
+// set the union of the largest face and every confident detection.
+func faceBounds(dets []pigo.Detection, all bool) (faceBox, bool) {
+	if len(dets) == 0 {
+		return faceBox{}, false
+	}
+	largest := dets[0]
+	for _, det := range dets[1:] {
+		if det.Scale > largest.Scale {
+			largest = det
+		}
+	}
+	box := detectionBox(largest)
+	if all {
+		for _, det := range dets {
+			if det.Q >= minFaceQuality {
+				box = box.union(detectionBox(det))
+			}
+		}
+	}
+	return box, true
+}
+
+// faceWindow picks the crop of the target ratio for box in a cols x rows
+// image. Without zoom it is the largest such window; with zoom it is the box
+// grown by pad on every side and widened to the ratio, but never smaller
+// than the output nor larger than the largest window. The window is centered
+// on the box and then shifted inside the image, so faces near an edge stay in
+// the crop at the expense of centering.
+func faceWindow(box faceBox, cols, rows, width, height int, pad float64, zoom bool) (x0, y0, cropW, cropH int) {
+	ratio := float64(width) / float64(height)
+	maxW, maxH := cols, rows
+	if float64(cols)/float64(rows) > ratio {
+		maxW = int(float64(rows) * ratio)
+	} else {
+		maxH = int(float64(cols) / ratio)
+	}
+	cropW, cropH = maxW, maxH
+
+	if zoom {
+		boxW, boxH := float64(box.x1-box.x0), float64(box.y1-box.y0)
+		w := max(boxW*(1+2*pad), boxH*(1+2*pad)*ratio, float64(width))
+		if w < float64(maxW) {
+			cropW = max(int(w), 1)
+			cropH = max(min(int(w/ratio), maxH), 1)
+		}
+	}
+
+	x0 = (box.x0+box.x1)/2 - cropW/2
+	y0 = (box.y0+box.y1)/2 - cropH/2
+	x0 = max(0, min(x0, cols-cropW))
+	y0 = max(0, min(y0, rows-cropH))
+	return x0, y0, cropW, cropH
+}
+
+// faceCrop covers width x height around the detected faces: the largest one
+// for focus=face, all of them for focus=faces. focus=face keeps the largest
+// window unless face_pad is set; focus=faces always frames the group. Images
+// without faces are center-cropped.
+func faceCrop(img *vips.ImageRef, opts ImageOptions) error {
+	dets, err := detectFaces(img)
+	if err != nil {
+		return err
+	}
+	cols, rows := img.Width(), img.Height()
+	width, height := coverSize(opts.Width, opts.Height, cols, rows)
+	box, ok := faceBounds(dets, opts.Focus == "faces")
+	if !ok {
+		reason := "no_faces"
+		if faceClassifier == nil {
+			reason = "unavailable"
+		}
+		metrics.FaceDetectionFallbacksTotal.WithLabelValues(reason).Inc()
+		return img.ThumbnailWithSize(width, height, vips.InterestingCentre, vips.SizeForce)
+	}
+	pad := opts.FacePad
+	zoom := pad > 0 || opts.Focus == "faces"
+	if pad <= 0 {
+		pad = defaultFacePad
+	}
+
+	x0, y0, cropW, cropH := faceWindow(box, cols, rows, width, height, pad, zoom)
+	if err := img.ExtractArea(x0, y0, cropW, cropH); err != nil {
+		return err
+	}
+	return img.ResizeWithVScale(float64(width)/float64(cropW), float64(height)/float64(cropH), vips.KernelLanczos3)
+}
@@ -0,0 +1,142 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// Video operations, the "operation" label of the video metrics.
+const (
+	VideoThumbnail  = "thumbnail"
+	VideoAnimated   = "animated"
+	VideoStoryboard = "storyboard"
+	VideoClip       = "clip" // animated image transcoded to MP4/WebM
+)
+
+// ErrInputRejected is wrapped by the errors of ffmpeg runs whose input URL
+// answered with an HTTP 4xx, e.g. a presigned URL that expired through clock
+// skew or that a KMS key policy refuses.
+var ErrInputRejected = errors.New("ffmpeg input URL rejected")
+
+// inputHTTPError matches ffmpeg's report of a 4xx from its HTTP input.
+var inputHTTPError = regexp.MustCompile(`(?:Server returned|HTTP error) 4(?:\d\d|XX)`)
+
+// ffmpegRun describes an ffmpeg invocation for metrics and tracing.
+type ffmpegRun struct {
+	operation string
+	input     string  // URL or path of the source
+	duration  float64 // seconds of input read, 0 when the whole input is
+	width     int     // output scale, 0 when not resized
+	height    int
+}
+
+// runFFmpeg runs ffmpeg with args, writing its output to stdout, once one of
+// the MAX_CONCURRENT_FFMPEG slots is free. The run is recorded in the video
+// metrics and in a span carrying a summary of the arguments. On failure the
+// returned string holds ffmpeg's stderr, and the error wraps
+// ErrInputRejected when the input URL was refused, or is ErrFFmpegBusy when
+// no slot freed up in time.
+func runFFmpeg(ctx context.Context, run ffmpegRun, args []string, stdout io.Writer) (string, error) {
+	_, span := otel.Tracer("quirm/processor").Start(ctx, "ffmpeg")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("ffmpeg.operation", run.operation),
+		attribute.String("ffmpeg.input", inputType(run.input)),
+	)
+	if run.duration > 0 {
+		span.SetAttributes(attribute.Float64("ffmpeg.duration", run.duration))
+	}
+	if run.width > 0 || run.height > 0 {
+		span.SetAttributes(attribute.String("ffmpeg.scale", fmt.Sprintf("%dx%d", run.width, run.height)))
+	}
+
+	release, err := acquireFFmpeg(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+	defer release()
+
+	cmd := exec.Command("ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err = cmd.Run()
+	metrics.VideoProcessDuration.WithLabelValues(run.operation).Observe(time.Since(start).Seconds())
+
+	class := exitClass(err)
+	metrics.FFmpegInvocationsTotal.WithLabelValues(run.operation, class).Inc()
+	span.SetAttributes(attribute.String("ffmpeg.exit", class))
+	if err != nil {
+		metrics.VideoProcessErrorsTotal.WithLabelValues(run.operation).Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if inputType(run.input) == "url" && inputHTTPError.MatchString(stderr.String()) {
+			err = fmt.Errorf("%w: %v", ErrInputRejected, err)
+		}
+		return stderr.String(), err
+	}
+	return "", nil
+}
+
+// boundFilter returns a scale filter that shrinks frames larger than maxWidth x maxHeight to fit, keeping the aspect
+// ratio. It returns "" when neither bound is set.
+func boundFilter(maxWidth, maxHeight int) string {
+	if maxWidth <= 0 && maxHeight <= 0 {
+		return ""
+	}
+	w, h := "iw", "ih"
+	if maxWidth > 0 {
+		w = fmt.Sprintf("min(iw\\,%d)", maxWidth)
+	}
+	if maxHeight > 0 {
+		h = fmt.Sprintf("min(ih\\,%d)", maxHeight)
+	}
+	return fmt.Sprintf("scale=%s:%s:force_original_aspect_ratio=decrease:flags=lanczos", w, h)
+}
+
+// inputType tells remote inputs (presigned URLs) from local copies.
+func inputType(input string) string {
+	if strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://") {
+		return "url"
+	}
+	return "file"
+}
+
+// exitClass buckets the outcome of an ffmpeg run: "ok", "error" (exit code
+// 1, e.g. an unreadable input), "interrupted" (killed by a signal, or exit
+// code 255), "other" for any other exit code and "not_started" when ffmpeg
+// could not be run at all.
+func exitClass(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return "not_started"
+	}
+	switch code := exitErr.ExitCode(); code {
+	case 1:
+		return "error"
+	case -1, 255:
+		return "interrupted"
+	default:
+		return "other"
+	}
+}
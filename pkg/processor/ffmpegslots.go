@@ -0,0 +1,74 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// ErrFFmpegBusy is wrapped by the errors of video operations that found no
+// free ffmpeg slot within the queue timeout.
+var ErrFFmpegBusy = errors.New("no ffmpeg slot available")
+
+// ffmpegPool bounds concurrent ffmpeg runs, apart from image processing so
+// a pile-up of videos cannot hold up resizes.
+type ffmpegPool struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+// ffmpegSlots is nil while ffmpeg runs are not limited.
+var ffmpegSlots atomic.Pointer[ffmpegPool]
+
+// SetFFmpegLimit lets at most n ffmpeg processes run at once; further runs
+// wait up to wait for a slot and then fail with ErrFFmpegBusy. n <= 0 lifts
+// the limit. Runs already holding a slot of an earlier limit keep it.
+func SetFFmpegLimit(n int, wait time.Duration) {
+	if n <= 0 {
+		ffmpegSlots.Store(nil)
+		return
+	}
+	ffmpegSlots.Store(&ffmpegPool{slots: make(chan struct{}, n), wait: wait})
+}
+
+// acquireFFmpeg waits for an ffmpeg slot and returns the function giving it
+// back. It fails with ErrFFmpegBusy after the queue timeout, or with the
+// error of ctx when ctx ends first.
+func acquireFFmpeg(ctx context.Context) (release func(), err error) {
+	pool := ffmpegSlots.Load()
+	if pool == nil {
+		return func() {}, nil
+	}
+	release = func() {
+		<-pool.slots
+		metrics.FFmpegActiveJobs.Dec()
+	}
+	select {
+	case pool.slots <- struct{}{}:
+		metrics.FFmpegActiveJobs.Inc()
+		metrics.FFmpegQueueWait.Observe(0)
+		return release, nil
+	default:
+	}
+
+	metrics.FFmpegQueuedJobs.Inc()
+	defer metrics.FFmpegQueuedJobs.Dec()
+	start := time.Now()
+	timer := time.NewTimer(pool.wait)
+	defer timer.Stop()
+	select {
+	case pool.slots <- struct{}{}:
+		metrics.FFmpegActiveJobs.Inc()
+		metrics.FFmpegQueueWait.Observe(time.Since(start).Seconds())
+		return release, nil
+	case <-timer.C:
+		metrics.FFmpegQueueWait.Observe(time.Since(start).Seconds())
+		metrics.FFmpegQueueTimeoutsTotal.Inc()
+		return nil, ErrFFmpegBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,68 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestCoverSize(t *testing.T) {
+	tests := []struct {
+		name                  string
+		width, height         int
+		cols, rows            int
+		wantWidth, wantHeight int
+	}{
+		{name: "both dimensions", width: 200, height: 100, cols: 400, rows: 400, wantWidth: 200, wantHeight: 100},
+		{name: "width only", width: 200, cols: 400, rows: 300, wantWidth: 200, wantHeight: 150},
+		{name: "height only", height: 150, cols: 400, rows: 300, wantWidth: 200, wantHeight: 150},
+		{name: "larger than the source", width: 800, cols: 400, rows: 300, wantWidth: 800, wantHeight: 600},
+		{name: "never below 1", width: 1, cols: 4000, rows: 10, wantWidth: 1, wantHeight: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h := coverSize(tt.width, tt.height, tt.cols, tt.rows)
+			if w != tt.wantWidth || h != tt.wantHeight {
+				t.Errorf("coverSize(%d, %d, %d, %d) = %dx%d, want %dx%d", tt.width, tt.height, tt.cols, tt.rows, w, h, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+// TestFitDimensions fits sources smaller than, larger than and equal to a
+// 200x100 box with each fit mode. It needs libvips.
+func TestFitDimensions(t *testing.T) {
+	type size struct{ width, height int }
+	sources := map[string]size{
+		"smaller": {50, 50},
+		"larger":  {400, 400},
+		"equal":   {200, 100},
+	}
+	tests := []struct {
+		fit  string
+		want map[string]size
+	}{
+		// Bare w and h keep the aspect ratio, like contain
+		{fit: "", want: map[string]size{"smaller": {100, 100}, "larger": {100, 100}, "equal": {200, 100}}},
+		{fit: "contain", want: map[string]size{"smaller": {100, 100}, "larger": {100, 100}, "equal": {200, 100}}},
+		{fit: "scale-down", want: map[string]size{"smaller": {50, 50}, "larger": {100, 100}, "equal": {200, 100}}},
+		{fit: "cover", want: map[string]size{"smaller": {200, 100}, "larger": {200, 100}, "equal": {200, 100}}},
+		{fit: "fill", want: map[string]size{"smaller": {200, 100}, "larger": {200, 100}, "equal": {200, 100}}},
+		{fit: "none", want: map[string]size{"smaller": {50, 50}, "larger": {200, 100}, "equal": {200, 100}}},
+	}
+	for _, tt := range tests {
+		for name, src := range sources {
+			t.Run(tt.fit+"/"+name, func(t *testing.T) {
+				img := render(t, marked(t, src.width, src.height), ImageOptions{Width: 200, Height: 100, Fit: tt.fit})
+				want := tt.want[name]
+				if b := img.Bounds(); b.Dx() != want.width || b.Dy() != want.height {
+					t.Errorf("%dx%d source: size %dx%d, want %dx%d", src.width, src.height, b.Dx(), b.Dy(), want.width, want.height)
+				}
+			})
+		}
+	}
+
+	if _, err := Process(context.Background(), bytes.NewReader(marked(t, 50, 50)), ImageOptions{Width: 200, Fit: "stretch"}, nil, 0, "test.png"); err == nil {
+		t.Error("unknown fit accepted")
+	}
+}
@@ -0,0 +1,193 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/davidbyttow/govips/v2/vips"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// GIFOptimizeOptions bounds the GIFs OptimizeGIF re-encodes. Zero caps are
+// unlimited.
+type GIFOptimizeOptions struct {
+	MaxFrames    int
+	MaxDimension int // largest frame width or height
+	Quality      int // below 80 the palette loses bits; 0 keeps all 8
+}
+
+// OptimizeGIF re-encodes a GIF without resizing it: runs of identical
+// consecutive frames are merged into one frame showing for their combined
+// delay, and the palette is rebuilt at maximum effort with light dithering.
+// Every remaining frame keeps its delay, so the animation plays with the
+// same timing. Sources that are not GIFs or exceed the caps, and re-encodes
+// that come out larger, return data unchanged.
+func OptimizeGIF(data []byte, opts GIFOptimizeOptions) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte("GIF8")) {
+		metrics.GIFOptimizeTotal.WithLabelValues("skipped").Inc()
+		return data, nil
+	}
+
+	importParams := vips.NewImportParams()
+	importParams.NumPages.Set(-1)
+	img, err := vips.LoadImageFromBuffer(data, importParams)
+	if err != nil {
+		metrics.ImageProcessErrorsTotal.Inc()
+		return nil, fmt.Errorf("decode error: %w", err)
+	}
+	defer img.Close()
+
+	frameH := img.PageHeight()
+	if frameH <= 0 || img.Height()%frameH != 0 {
+		frameH = img.Height()
+	}
+	frames := img.Height() / frameH
+	if (opts.MaxFrames > 0 && frames > opts.MaxFrames) ||
+		(opts.MaxDimension > 0 && (img.Width() > opts.MaxDimension || frameH > opts.MaxDimension)) {
+		metrics.GIFOptimizeTotal.WithLabelValues("skipped").Inc()
+		return data, nil
+	}
+
+	if frames > 1 {
+		merged, err := mergeDuplicateFrames(img, frames, frameH)
+		if err != nil {
+			metrics.ImageProcessErrorsTotal.Inc()
+			return nil, err
+		}
+		if merged != nil {
+			defer merged.Close()
+			img = merged
+		}
+	}
+
+	ep := vips.NewGifExportParams()
+	ep.StripMetadata = true
+	ep.Effort = 10
+	ep.Dither = 0.5
+	ep.Bitdepth = gifBitdepth(opts.Quality)
+	out, _, err := img.ExportGIF(ep)
+	if err != nil {
+		metrics.ImageProcessErrorsTotal.Inc()
+		return nil, fmt.Errorf("encode error: %w", err)
+	}
+
+	if len(out) >= len(data) {
+		metrics.GIFOptimizeTotal.WithLabelValues("unchanged").Inc()
+		return data, nil
+	}
+	metrics.GIFOptimizeTotal.WithLabelValues("optimized").Inc()
+	metrics.GIFOptimizeBytesSaved.Add(float64(len(data) - len(out)))
+	return out, nil
+}
+
+// mergeDuplicateFrames drops frames identical to the one before them and
+// adds their delay to the frame that stays on screen instead. img holds the
+// frames stacked vertically, frameH rows each. It returns nil when there is
+// nothing to merge.
+func mergeDuplicateFrames(img *vips.ImageRef, frames, frameH int) (*vips.ImageRef, error) {
+	delays, err := img.PageDelay()
+	if err != nil || len(delays) != frames {
+		// Without per-frame delays, merging would change the timing
+		return nil, nil
+	}
+
+	keep := []int{0}
+	merged := []int{delays[0]}
+	var prev uint64
+	for i := 0; i < frames; i++ {
+		sum, err := frameHash(img, i, frameH)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			if sum == prev {
+				merged[len(merged)-1] += delays[i]
+			} else {
+				keep = append(keep, i)
+				merged = append(merged, delays[i])
+			}
+		}
+		prev = sum
+	}
+	if len(keep) == frames {
+		return nil, nil
+	}
+
+	parts := make([]*vips.ImageRef, 0, len(keep)-1)
+	defer func() {
+		for _, p := range parts {
+			p.Close()
+		}
+	}()
+	// The joined image keeps the first frame's metadata, such as the loop count
+	out, err := extractFrame(img, keep[0], frameH)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range keep[1:] {
+		part, err := extractFrame(img, i, frameH)
+		if err != nil {
+			out.Close()
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) > 0 {
+		err = out.ArrayJoin(parts, 1)
+	}
+	if err == nil {
+		err = out.SetPageHeight(frameH)
+	}
+	if err == nil {
+		err = out.SetPageDelay(merged)
+	}
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	return out, nil
+}
+
+func extractFrame(img *vips.ImageRef, i, frameH int) (*vips.ImageRef, error) {
+	frame, err := img.Copy()
+	if err != nil {
+		return nil, err
+	}
+	if err := frame.ExtractArea(0, i*frameH, img.Width(), frameH); err != nil {
+		frame.Close()
+		return nil, err
+	}
+	return frame, nil
+}
+
+// frameHash hashes the decoded pixels of frame i, so only one frame is held
+// in memory at a time.
+func frameHash(img *vips.ImageRef, i, frameH int) (uint64, error) {
+	frame, err := extractFrame(img, i, frameH)
+	if err != nil {
+		return 0, err
+	}
+	defer frame.Close()
+	pixels, err := frame.ToBytes()
+	if err != nil {
+		return 0, err
+	}
+	h := fnv.New64a()
+	h.Write(pixels)
+	return h.Sum64(), nil
+}
+
+// gifBitdepth maps quality to palette bits: 256 colors from q=80 up (and by
+// default), 128 from 50, 64 below.
+func gifBitdepth(quality int) int {
+	switch {
+	case quality == 0 || quality >= 80:
+		return 8
+	case quality >= 50:
+		return 7
+	default:
+		return 6
+	}
+}
@@ -0,0 +1,70 @@
+package processor
+
+import (
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// grayTolerance is the largest spread between the channels of a sampled
+// pixel (0-255) still taken for gray. JPEG chroma noise on a scan stays
+// below it; faint sepia tones and the subtle colors of a photo do not.
+const grayTolerance = 6
+
+// graySamples is the side of the grid of pixels isGrayscale looks at.
+const graySamples = 48
+
+// grayscaleFormats are the output formats encoded single-band by libvips.
+// WebP, AVIF and HEIF are always encoded as color.
+var grayscaleFormats = map[string]bool{"jpeg": true, "jpg": true, "png": true}
+
+// autoGrayscale converts img to a single band (plus alpha) when every pixel
+// of a sample grid is gray, so a scan or line art saved as RGB is encoded at
+// a fraction of the size. Images that are not sRGB, e.g. CMYK, are left as
+// they are.
+func autoGrayscale(img *vips.ImageRef, format string) error {
+	if !grayscaleFormats[format] || img.Bands() < 3 || img.Interpretation() != vips.InterpretationSRGB {
+		return nil
+	}
+	gray, err := isGrayscale(img)
+	if err != nil || !gray {
+		return err
+	}
+	return img.ToColorSpace(vips.InterpretationBW)
+}
+
+// isGrayscale samples img on a graySamples x graySamples grid, picking
+// pixels rather than averaging them so a thin colored stroke is not blended
+// away, and reports whether no sampled pixel has channels further apart
+// than grayTolerance.
+func isGrayscale(img *vips.ImageRef) (bool, error) {
+	sample, err := img.Copy()
+	if err != nil {
+		return false, err
+	}
+	defer sample.Close()
+	if side := max(sample.Width(), sample.Height()); side > graySamples {
+		if err := sample.Resize(float64(graySamples)/float64(side), vips.KernelNearest); err != nil {
+			return false, err
+		}
+	}
+	if sample.BandFormat() != vips.BandFormatUchar {
+		return false, nil
+	}
+	pixels, err := sample.ToBytes()
+	if err != nil {
+		return false, err
+	}
+	return grayPixels(pixels, sample.Bands()), nil
+}
+
+// grayPixels reports whether no pixel of an 8-bit buffer with the given
+// number of bands (RGB first, then e.g. alpha) has channels further apart
+// than grayTolerance.
+func grayPixels(pixels []byte, bands int) bool {
+	for i := 0; i+2 < len(pixels); i += bands {
+		r, g, b := int(pixels[i]), int(pixels[i+1]), int(pixels[i+2])
+		if max(r, g, b)-min(r, g, b) > grayTolerance {
+			return false
+		}
+	}
+	return true
+}
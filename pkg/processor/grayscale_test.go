@@ -0,0 +1,112 @@
+package processor
+
+import "testing"
+
+// fixture fills a graySamples x graySamples buffer with the color pixel
+// returns for each position, plus an opaque alpha band when alpha is set.
+func fixture(alpha bool, pixel func(x, y int) (r, g, b int)) ([]byte, int) {
+	bands := 3
+	if alpha {
+		bands = 4
+	}
+	buf := make([]byte, 0, graySamples*graySamples*bands)
+	for y := 0; y < graySamples; y++ {
+		for x := 0; x < graySamples; x++ {
+			r, g, b := pixel(x, y)
+			buf = append(buf, clampByte(r), clampByte(g), clampByte(b))
+			if alpha {
+				buf = append(buf, 255)
+			}
+		}
+	}
+	return buf, bands
+}
+
+func clampByte(v int) byte {
+	return byte(min(max(v, 0), 255))
+}
+
+// noise is a deterministic stand-in for JPEG chroma noise in [-n, n].
+func noise(x, y, channel, n int) int {
+	h := uint32(x*73856093) ^ uint32(y*19349663) ^ uint32(channel*83492791)
+	h ^= h >> 13
+	h *= 0x5bd1e995
+	h ^= h >> 15
+	return int(h%uint32(2*n+1)) - n
+}
+
+// scan is paper with dark lines of text, saved as RGB with slight noise.
+func scan(x, y int) (int, int, int) {
+	v := 235
+	if y%8 < 2 && x > 4 && x < graySamples-4 {
+		v = 40
+	}
+	return v + noise(x, y, 0, 2), v + noise(x, y, 1, 2), v + noise(x, y, 2, 2)
+}
+
+// sepia tones a gray gradient with the usual sepia matrix, blended with the
+// gray by strength.
+func sepia(strength float64) func(x, y int) (int, int, int) {
+	return func(x, y int) (int, int, int) {
+		v := float64(60 + 150*y/graySamples)
+		blend := func(toned float64) int { return int(v + (toned-v)*strength) }
+		return blend(v * 1.351), blend(v * 1.203), blend(v * 0.937)
+	}
+}
+
+func TestGrayPixels(t *testing.T) {
+	tests := []struct {
+		name  string
+		alpha bool
+		pixel func(x, y int) (int, int, int)
+		want  bool
+	}{
+		{
+			name: "color photo",
+			pixel: func(x, y int) (int, int, int) {
+				// Sky fading into foliage
+				return 80 + x, 120 + y, 200 - 2*y
+			},
+			want: false,
+		},
+		{
+			name:  "grayscale scan",
+			pixel: scan,
+			want:  true,
+		},
+		{
+			name:  "grayscale scan with alpha",
+			alpha: true,
+			pixel: scan,
+			want:  true,
+		},
+		{
+			name:  "sepia",
+			pixel: sepia(1),
+			want:  false,
+		},
+		{
+			name:  "faint sepia",
+			pixel: sepia(0.25),
+			want:  false,
+		},
+		{
+			name: "gray with a thin colored stroke",
+			pixel: func(x, y int) (int, int, int) {
+				if x == graySamples/2 {
+					return 200, 30, 30
+				}
+				return 128, 128, 128
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pixels, bands := fixture(tt.alpha, tt.pixel)
+			if got := grayPixels(pixels, bands); got != tt.want {
+				t.Errorf("grayPixels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package processor
+
+import (
+	"fmt"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// ImageHeader is what ReadHeader learns about an image without decoding it.
+type ImageHeader struct {
+	Format string
+	Width  int
+	Height int
+	Pages  int
+	// Bands is 1 for grayscale images and 3 for color ones, plus alpha
+	Bands int
+}
+
+// ReadHeader reads the format and dimensions of the image in data, which may
+// be only the start of the file: libvips parses the header when an image is
+// opened and leaves the pixels until they are needed. It fails when the
+// header is not complete, e.g. a TIFF whose directory sits at the end.
+func ReadHeader(data []byte) (ImageHeader, error) {
+	params := vips.NewImportParams()
+	params.FailOnError.Set(false)
+	img, err := vips.LoadImageFromBuffer(data, params)
+	if err != nil {
+		return ImageHeader{}, fmt.Errorf("header error: %w", err)
+	}
+	defer img.Close()
+
+	// Dimensions are reported as displayed, like processed output
+	width, height := img.Width(), img.Height()
+	if swapsAxes(img.Orientation()) {
+		width, height = height, width
+	}
+	return ImageHeader{
+		Format: vips.ImageTypes[img.OriginalFormat()],
+		Width:  width,
+		Height: height,
+		Pages:  img.Pages(),
+		Bands:  img.Bands(),
+	}, nil
+}
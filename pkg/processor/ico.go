@@ -0,0 +1,131 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ICO limits: an entry stores its size in one byte (0 meaning 256), and a
+// handful of sizes covers every favicon use.
+const (
+	ICOMaxSize    = 256
+	ICOMaxEntries = 6
+)
+
+// icoDefaultSizes are rendered when a request names no sizes.
+var icoDefaultSizes = []int{16, 32, 48}
+
+// ParseICOSizes parses a comma-separated list of icon sizes such as
+// "16,32,48". An empty list selects the default sizes.
+func ParseICOSizes(s string) ([]int, error) {
+	if s == "" {
+		return icoDefaultSizes, nil
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) > ICOMaxEntries {
+		return nil, fmt.Errorf("at most %d sizes are allowed", ICOMaxEntries)
+	}
+	sizes := make([]int, 0, len(parts))
+	seen := make(map[int]bool, len(parts))
+	for _, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n < 1 || n > ICOMaxSize {
+			return nil, fmt.Errorf("size %q must be between 1 and %d", p, ICOMaxSize)
+		}
+		if seen[n] {
+			return nil, fmt.Errorf("size %d is listed twice", n)
+		}
+		seen[n] = true
+		sizes = append(sizes, n)
+	}
+	return sizes, nil
+}
+
+// processICO renders the source once per requested size through the regular
+// pipeline as PNG and packs the results into a multi-resolution ICO. Icons
+// are square: without an explicit fit they are cropped to cover.
+// Watermarks are not applied, they would be illegible at favicon sizes.
+func processICO(ctx context.Context, r io.Reader, opts ImageOptions, originalKey string) (*bytes.Buffer, error) {
+	sizes, err := ParseICOSizes(opts.Sizes)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read error: %w", err)
+	}
+
+	icons := make([][]byte, 0, len(sizes))
+	for _, size := range sizes {
+		sized := opts
+		sized.Format = "png"
+		sized.Width, sized.Height = size, size
+		if sized.Fit == "" {
+			sized.Fit = "cover"
+		}
+		buf, err := process(ctx, bytes.NewReader(data), sized, nil, 0, originalKey, nil)
+		if err != nil {
+			return nil, err
+		}
+		icons = append(icons, buf.Bytes())
+	}
+
+	ico, err := EncodeICO(icons)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewBuffer(ico), nil
+}
+
+// EncodeICO assembles PNG images into an ICO container: a 6-byte header,
+// one 16-byte directory entry per image, then the PNG data. Modern readers
+// accept PNG entries as is.
+func EncodeICO(pngs [][]byte) ([]byte, error) {
+	if len(pngs) == 0 || len(pngs) > ICOMaxEntries {
+		return nil, fmt.Errorf("an icon needs 1 to %d images", ICOMaxEntries)
+	}
+
+	const headerSize, entrySize = 6, 16
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, [3]uint16{0, 1, uint16(len(pngs))})
+
+	offset := headerSize + entrySize*len(pngs)
+	for _, p := range pngs {
+		w, h, err := pngSize(p)
+		if err != nil {
+			return nil, err
+		}
+		if w > ICOMaxSize || h > ICOMaxSize {
+			return nil, fmt.Errorf("icon image %dx%d exceeds %d pixels", w, h, ICOMaxSize)
+		}
+		buf.WriteByte(byte(w % ICOMaxSize)) // 256 is stored as 0
+		buf.WriteByte(byte(h % ICOMaxSize))
+		buf.WriteByte(0)                                    // no palette
+		buf.WriteByte(0)                                    // reserved
+		binary.Write(&buf, binary.LittleEndian, uint16(1))  // color planes
+		binary.Write(&buf, binary.LittleEndian, uint16(32)) // bits per pixel
+		binary.Write(&buf, binary.LittleEndian, uint32(len(p)))
+		binary.Write(&buf, binary.LittleEndian, uint32(offset))
+		offset += len(p)
+	}
+	for _, p := range pngs {
+		buf.Write(p)
+	}
+	return buf.Bytes(), nil
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngSize reads the dimensions from the IHDR chunk of a PNG.
+func pngSize(p []byte) (int, int, error) {
+	if len(p) < 24 || !bytes.HasPrefix(p, pngSignature) || string(p[12:16]) != "IHDR" {
+		return 0, 0, errors.New("icon image is not a PNG")
+	}
+	return int(binary.BigEndian.Uint32(p[16:20])), int(binary.BigEndian.Uint32(p[20:24])), nil
+}
@@ -0,0 +1,172 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image/png"
+	"slices"
+	"testing"
+)
+
+// icoEntry is a parsed ICO directory entry.
+type icoEntry struct {
+	width, height int // 0 in the directory means 256
+	planes, bpp   uint16
+	size, offset  uint32
+}
+
+// parseICO checks the structure of an ICO file and returns its directory
+// and the embedded images.
+func parseICO(t *testing.T, ico []byte) ([]icoEntry, [][]byte) {
+	t.Helper()
+	if len(ico) < 6 {
+		t.Fatalf("ICO of %d bytes has no header", len(ico))
+	}
+	if reserved, kind := binary.LittleEndian.Uint16(ico[0:]), binary.LittleEndian.Uint16(ico[2:]); reserved != 0 || kind != 1 {
+		t.Fatalf("header reserved %d, type %d, want 0 and 1 (icon)", reserved, kind)
+	}
+	count := int(binary.LittleEndian.Uint16(ico[4:]))
+	if len(ico) < 6+16*count {
+		t.Fatalf("ICO of %d bytes is too short for %d entries", len(ico), count)
+	}
+
+	entries := make([]icoEntry, count)
+	images := make([][]byte, count)
+	next := uint32(6 + 16*count)
+	for i := range entries {
+		d := ico[6+16*i:]
+		e := icoEntry{
+			width:  int(d[0]),
+			height: int(d[1]),
+			planes: binary.LittleEndian.Uint16(d[4:]),
+			bpp:    binary.LittleEndian.Uint16(d[6:]),
+			size:   binary.LittleEndian.Uint32(d[8:]),
+			offset: binary.LittleEndian.Uint32(d[12:]),
+		}
+		if e.width == 0 {
+			e.width = 256
+		}
+		if e.height == 0 {
+			e.height = 256
+		}
+		if d[2] != 0 || d[3] != 0 {
+			t.Errorf("entry %d: palette %d, reserved %d, want 0", i, d[2], d[3])
+		}
+		if e.offset != next {
+			t.Errorf("entry %d at offset %d, want %d (images packed in order)", i, e.offset, next)
+		}
+		if int(e.offset+e.size) > len(ico) {
+			t.Fatalf("entry %d ends at %d, past the end of the file (%d)", i, e.offset+e.size, len(ico))
+		}
+		next = e.offset + e.size
+		entries[i] = e
+		images[i] = ico[e.offset : e.offset+e.size]
+	}
+	if int(next) != len(ico) {
+		t.Errorf("%d trailing bytes after the last image", len(ico)-int(next))
+	}
+	return entries, images
+}
+
+// checkICO parses ico and checks that it holds square PNGs of the given sizes.
+func checkICO(t *testing.T, ico []byte, sizes []int) {
+	t.Helper()
+	entries, images := parseICO(t, ico)
+	if len(entries) != len(sizes) {
+		t.Fatalf("%d entries, want %d", len(entries), len(sizes))
+	}
+	for i, e := range entries {
+		if e.width != sizes[i] || e.height != sizes[i] {
+			t.Errorf("entry %d is %dx%d, want %dx%d", i, e.width, e.height, sizes[i], sizes[i])
+		}
+		if e.planes != 1 || e.bpp != 32 {
+			t.Errorf("entry %d: %d planes, %d bpp, want 1 and 32", i, e.planes, e.bpp)
+		}
+		cfg, err := png.DecodeConfig(bytes.NewReader(images[i]))
+		if err != nil {
+			t.Fatalf("entry %d is not a PNG: %v", i, err)
+		}
+		if cfg.Width != e.width || cfg.Height != e.height {
+			t.Errorf("entry %d: PNG is %dx%d, directory says %dx%d", i, cfg.Width, cfg.Height, e.width, e.height)
+		}
+	}
+}
+
+func TestEncodeICO(t *testing.T) {
+	sizes := []int{16, 32, 48, 256}
+	pngs := make([][]byte, len(sizes))
+	for i, size := range sizes {
+		pngs[i] = marked(t, size, size)
+	}
+	ico, err := EncodeICO(pngs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkICO(t, ico, sizes)
+
+	_, images := parseICO(t, ico)
+	for i := range images {
+		if !bytes.Equal(images[i], pngs[i]) {
+			t.Errorf("entry %d does not hold its PNG as is", i)
+		}
+	}
+}
+
+func TestEncodeICOErrors(t *testing.T) {
+	small := marked(t, 16, 16)
+	tests := []struct {
+		name string
+		pngs [][]byte
+	}{
+		{name: "no images"},
+		{name: "too many images", pngs: slices.Repeat([][]byte{small}, ICOMaxEntries+1)},
+		{name: "too large", pngs: [][]byte{marked(t, 257, 16)}},
+		{name: "not a PNG", pngs: [][]byte{[]byte("GIF89a not a png at all")}},
+		{name: "truncated PNG", pngs: [][]byte{small[:20]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := EncodeICO(tt.pngs); err == nil {
+				t.Error("EncodeICO succeeded")
+			}
+		})
+	}
+}
+
+func TestParseICOSizes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []int
+		ok   bool
+	}{
+		{in: "", want: []int{16, 32, 48}, ok: true},
+		{in: "32", want: []int{32}, ok: true},
+		{in: "16, 32,64", want: []int{16, 32, 64}, ok: true},
+		{in: "1,256", want: []int{1, 256}, ok: true},
+		{in: "16,24,32,48,64,128", want: []int{16, 24, 32, 48, 64, 128}, ok: true},
+		{in: "16,24,32,48,64,128,256"},
+		{in: "257"},
+		{in: "0"},
+		{in: "-16"},
+		{in: "16,16"},
+		{in: "16,,32"},
+		{in: "big"},
+	}
+	for _, tt := range tests {
+		got, err := ParseICOSizes(tt.in)
+		if (err == nil) != tt.ok || !slices.Equal(got, tt.want) {
+			t.Errorf("ParseICOSizes(%q) = %v, %v, want %v (ok %v)", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestICOOutput renders a favicon through the pipeline. It needs libvips.
+func TestICOOutput(t *testing.T) {
+	src := marked(t, 300, 200)
+	out, err := Process(context.Background(), bytes.NewReader(src), ImageOptions{Format: "ico", Sizes: "16,32,48"}, nil, 0, "logo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkICO(t, out.Bytes(), []int{16, 32, 48})
+}
@@ -0,0 +1,26 @@
+package processor
+
+import "github.com/davidbyttow/govips/v2/vips"
+
+// mimeTypes maps the image types libvips recognizes to their MIME type.
+var mimeTypes = map[vips.ImageType]string{
+	vips.ImageTypeJPEG: "image/jpeg",
+	vips.ImageTypePNG:  "image/png",
+	vips.ImageTypeGIF:  "image/gif",
+	vips.ImageTypeWEBP: "image/webp",
+	vips.ImageTypeAVIF: "image/avif",
+	vips.ImageTypeJXL:  "image/jxl",
+	vips.ImageTypeHEIF: "image/heif",
+	vips.ImageTypeTIFF: "image/tiff",
+	vips.ImageTypeBMP:  "image/bmp",
+	vips.ImageTypeSVG:  "image/svg+xml",
+	vips.ImageTypePDF:  "application/pdf",
+}
+
+// SniffContentType returns the MIME type of the image encoded in data, read
+// from its signature, so it names the format an encode actually produced
+// whatever was requested. It returns "" for data libvips does not recognize,
+// such as icons and videos.
+func SniffContentType(data []byte) string {
+	return mimeTypes[vips.DetermineImageType(data)]
+}
@@ -0,0 +1,47 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSniffContentType(t *testing.T) {
+	pad := strings.Repeat("\x00", 16)
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "jpeg", data: "\xFF\xD8\xFF\xE0" + pad, want: "image/jpeg"},
+		{name: "png", data: "\x89PNG\r\n\x1a\n" + pad, want: "image/png"},
+		{name: "gif", data: "GIF89a" + pad, want: "image/gif"},
+		{name: "webp", data: "RIFF\x24\x00\x00\x00WEBPVP8 " + pad, want: "image/webp"},
+		{name: "avif", data: "\x00\x00\x00\x1cftypavif" + pad, want: "image/avif"},
+		{name: "jxl", data: "\xff\x0a" + pad, want: "image/jxl"},
+		{name: "heif", data: "\x00\x00\x00\x18ftypheic" + pad, want: "image/heif"},
+		{name: "tiff", data: "II*\x00" + pad, want: "image/tiff"},
+		{name: "bmp", data: "BM" + pad, want: "image/bmp"},
+		{name: "svg", data: `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"/>`, want: "image/svg+xml"},
+		{name: "pdf", data: "%PDF-1.7\n" + pad, want: "application/pdf"},
+		// Outputs libvips does not recognize are named by the caller
+		{name: "ico", data: "\x00\x00\x01\x00\x01\x00\x10\x10" + pad, want: ""},
+		{name: "mp4", data: "\x00\x00\x00\x18ftypisom" + pad, want: ""},
+		{name: "text", data: "LEHV6nWB2yk8pyo0adR*.7kCMdnj", want: ""},
+		{name: "too short", data: "\xFF\xD8\xFF", want: ""},
+		{name: "empty", data: "", want: ""},
+	}
+	covered := map[string]bool{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SniffContentType([]byte(tt.data)); got != tt.want {
+				t.Errorf("SniffContentType() = %q, want %q", got, tt.want)
+			}
+		})
+		covered[tt.want] = true
+	}
+	for _, mimeType := range mimeTypes {
+		if !covered[mimeType] {
+			t.Errorf("no test for %s", mimeType)
+		}
+	}
+}
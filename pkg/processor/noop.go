@@ -0,0 +1,87 @@
+package processor
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// SkipsProcessing reports whether processing the image in data with opts
+// would only decode and re-encode it: the source is a single upright image,
+// already in the output format and within the requested size, and opts ask
+// for nothing else. Serving data as is then saves the work and a generation
+// of lossy compression. fit=contain, cover, fill and none still enlarge or
+// crop to the exact size, and watermark tells whether a watermark would be
+// applied to an output of the source's size.
+func SkipsProcessing(data []byte, opts ImageOptions, watermark bool, originalKey string) bool {
+	if opts.Force || (opts.Fit != "" && opts.Fit != "scale-down") || !onlyResizes(opts) {
+		return false
+	}
+
+	params := vips.NewImportParams()
+	params.FailOnError.Set(false)
+	img, err := vips.LoadImageFromBuffer(data, params)
+	if err != nil {
+		return false
+	}
+	defer img.Close()
+
+	return sourceMatches(sourceImage{
+		format:      vips.ImageTypes[img.OriginalFormat()],
+		pages:       img.Pages(),
+		orientation: img.Orientation(),
+		bands:       img.Bands(),
+		width:       img.Width(),
+		height:      img.Height(),
+	}, opts, watermark, originalKey)
+}
+
+// sourceImage is what SkipsProcessing needs to know about a decoded source.
+type sourceImage struct {
+	format                    string
+	pages, orientation, bands int
+	width, height             int
+}
+
+// sourceMatches is the decision of SkipsProcessing for a decoded source.
+func sourceMatches(src sourceImage, opts ImageOptions, watermark bool, originalKey string) bool {
+	format := strings.ToLower(opts.Format)
+	if format == "" {
+		format = DefaultFormat(originalKey)
+	} else if format == "jpg" {
+		format = "jpeg"
+	}
+	if src.format != format || src.pages > 1 || src.orientation > 1 {
+		return false
+	}
+	// A color-encoded source may still be converted to grayscale
+	if opts.AutoGray && grayscaleFormats[format] && src.bands >= 3 {
+		return false
+	}
+
+	fits := func(size, limit int) bool { return limit <= 0 || size <= limit }
+	if !fits(src.width, opts.Width) || !fits(src.height, opts.Height) || !fits(src.width, opts.MaxWidth) || !fits(src.height, opts.MaxHeight) {
+		return false
+	}
+	return !watermark || watermarkExempt(src.width, src.height, opts)
+}
+
+// onlyResizes reports whether opts ask for nothing but a size, a fit and an
+// output format.
+func onlyResizes(opts ImageOptions) bool {
+	return opts.Quality == 0 && opts.Text == "" && opts.Effect == "" && opts.Brightness == 0 && opts.Contrast == 0 &&
+		!opts.Blurhash && !opts.SmartCompression && !opts.Animated && !opts.Static && !opts.Optimize &&
+		opts.Page == 0 && opts.Rotate == 0 && opts.Flip == "" && !opts.hasCrop() && opts.Sizes == ""
+}
+
+// DefaultFormat is the output format of requests without one: the source's,
+// judged by the extension of originalKey, and JPEG for anything else.
+func DefaultFormat(originalKey string) string {
+	switch ext := strings.ToLower(filepath.Ext(originalKey)); ext {
+	case ".png", ".gif", ".webp", ".avif", ".jxl":
+		return strings.TrimPrefix(ext, ".")
+	default:
+		return "jpeg"
+	}
+}
@@ -0,0 +1,122 @@
+package processor
+
+import "testing"
+
+func TestSourceMatchesSize(t *testing.T) {
+	src := sourceImage{format: "jpeg", pages: 1, bands: 3, width: 800, height: 600}
+
+	tests := []struct {
+		name string
+		opts ImageOptions
+		want bool
+	}{
+		{"smaller than the target", ImageOptions{Width: 1200, Height: 900}, true},
+		{"equal to the target", ImageOptions{Width: 800, Height: 600}, true},
+		{"larger than the target width", ImageOptions{Width: 640}, false},
+		{"larger than the target height", ImageOptions{Height: 480}, false},
+		{"within the maximum size", ImageOptions{MaxWidth: 800, MaxHeight: 600}, true},
+		{"larger than the maximum width", ImageOptions{MaxWidth: 799}, false},
+		{"larger than the maximum height", ImageOptions{MaxHeight: 599}, false},
+		{"no size", ImageOptions{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sourceMatches(src, tt.opts, false, "photo.jpg"); got != tt.want {
+				t.Errorf("sourceMatches(%+v) = %v, want %v", tt.opts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceMatchesSource(t *testing.T) {
+	jpeg := sourceImage{format: "jpeg", pages: 1, bands: 3, width: 800, height: 600}
+
+	tests := []struct {
+		name      string
+		src       sourceImage
+		opts      ImageOptions
+		watermark bool
+		key       string
+		want      bool
+	}{
+		{"same format by extension", jpeg, ImageOptions{Width: 1000}, false, "photo.jpg", true},
+		{"jpg alias", jpeg, ImageOptions{Format: "jpg"}, false, "photo.jpg", true},
+		{"other format asked for", jpeg, ImageOptions{Format: "webp"}, false, "photo.jpg", false},
+		{"extension disagrees with the content", jpeg, ImageOptions{}, false, "photo.png", false},
+		{"multi-page source", sourceImage{format: "gif", pages: 12, width: 100, height: 100}, ImageOptions{}, false, "anim.gif", false},
+		{"rotated source", sourceImage{format: "jpeg", pages: 1, orientation: 6, width: 800, height: 600}, ImageOptions{}, false, "photo.jpg", false},
+		{"color source with auto_gray", jpeg, ImageOptions{AutoGray: true}, false, "photo.jpg", false},
+		{"gray source with auto_gray", sourceImage{format: "jpeg", pages: 1, bands: 1, width: 800, height: 600}, ImageOptions{AutoGray: true}, false, "scan.jpg", true},
+		{"watermarked", jpeg, ImageOptions{}, true, "photo.jpg", false},
+		{"below the watermark minimum", jpeg, ImageOptions{WatermarkMinWidth: 1024}, true, "photo.jpg", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sourceMatches(tt.src, tt.opts, tt.watermark, tt.key); got != tt.want {
+				t.Errorf("sourceMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkipsProcessingFit(t *testing.T) {
+	// These are decided before the source is decoded
+	for _, opts := range []ImageOptions{
+		{Width: 100, Fit: "cover"},
+		{Width: 100, Fit: "contain"},
+		{Width: 100, Fit: "fill"},
+		{Width: 100, Fit: "none"},
+		{Width: 100, Force: true},
+		{Width: 100, Quality: 50},
+	} {
+		if SkipsProcessing(nil, opts, false, "photo.jpg") {
+			t.Errorf("SkipsProcessing(%+v) = true, want false", opts)
+		}
+	}
+}
+
+func TestOnlyResizes(t *testing.T) {
+	tests := []struct {
+		name string
+		opts ImageOptions
+		want bool
+	}{
+		{"size, fit and format", ImageOptions{Width: 300, Height: 200, Fit: "scale-down", Format: "webp"}, true},
+		{"quality", ImageOptions{Width: 300, Quality: 80}, false},
+		{"text", ImageOptions{Text: "hello"}, false},
+		{"effect", ImageOptions{Effect: "sepia"}, false},
+		{"brightness", ImageOptions{Brightness: 1.2}, false},
+		{"blurhash", ImageOptions{Blurhash: true}, false},
+		{"rotate", ImageOptions{Rotate: 90}, false},
+		{"flip", ImageOptions{Flip: "h"}, false},
+		{"crop", ImageOptions{CropWidth: 100, CropHeight: 100}, false},
+		{"page", ImageOptions{Page: 2}, false},
+		{"static", ImageOptions{Static: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := onlyResizes(tt.opts); got != tt.want {
+				t.Errorf("onlyResizes(%+v) = %v, want %v", tt.opts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultFormat(t *testing.T) {
+	tests := map[string]string{
+		"photo.jpg":    "jpeg",
+		"photo.JPEG":   "jpeg",
+		"logo.png":     "png",
+		"anim.GIF":     "gif",
+		"pic.webp":     "webp",
+		"pic.avif":     "avif",
+		"pic.jxl":      "jxl",
+		"scan.tiff":    "jpeg",
+		"no-extension": "jpeg",
+	}
+	for key, want := range tests {
+		if got := DefaultFormat(key); got != want {
+			t.Errorf("DefaultFormat(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
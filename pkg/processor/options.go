@@ -0,0 +1,75 @@
+package processor
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// Canonical returns a compact, deterministic serialization of the effective
+// options, e.g. "format=webp&fit=cover&w=300". Zero values are left out, so
+// equivalent requests serialize identically.
+func (o ImageOptions) Canonical() string {
+	v := url.Values{}
+	setInt := func(k string, n int) {
+		if n != 0 {
+			v.Set(k, strconv.Itoa(n))
+		}
+	}
+	setFloat := func(k string, f float64) {
+		if f != 0 {
+			v.Set(k, strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	}
+	setString := func(k, s string) {
+		if s != "" {
+			v.Set(k, s)
+		}
+	}
+	setBool := func(k string, b bool) {
+		if b {
+			v.Set(k, "true")
+		}
+	}
+
+	setInt("w", o.Width)
+	setInt("h", o.Height)
+	setString("fit", o.Fit)
+	setInt("rot", o.Rotate)
+	setString("flip", o.Flip)
+	setInt("cx", o.CropX)
+	setInt("cy", o.CropY)
+	setInt("cw", o.CropWidth)
+	setInt("ch", o.CropHeight)
+	setString("format", o.Format)
+	setInt("q", o.Quality)
+	setString("focus", o.Focus)
+	setFloat("fp-x", o.FocalX)
+	setFloat("fp-y", o.FocalY)
+	setFloat("face_pad", o.FacePad)
+	setString("text", o.Text)
+	setBool("text_tiled", o.TextTiled)
+	setString("color", o.TextColor)
+	setFloat("ts", o.TextSize)
+	setFloat("text_opacity", o.TextOpacity)
+	setString("font", o.Font)
+	setString("effect", o.Effect)
+	setString("bg", o.Background)
+	setString("sizes", o.Sizes)
+	setFloat("brightness", o.Brightness)
+	setFloat("contrast", o.Contrast)
+	setBool("blurhash", o.Blurhash)
+	setBool("smart", o.SmartCompression)
+	setBool("animated", o.Animated)
+	setBool("static", o.Static)
+	setBool("optimize", o.Optimize)
+	setFloat("t", o.AnimStart)
+	setInt("fps", o.AnimFPS)
+	setBool("boomerang", o.Boomerang)
+	setInt("page", o.Page)
+	setBool("no_watermark", o.NoWatermark)
+	setBool("force", o.Force)
+	setBool("auto_gray", o.AutoGray)
+	setInt("max_w", o.MaxWidth)
+	setInt("max_h", o.MaxHeight)
+	return v.Encode()
+}
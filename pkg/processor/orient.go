@@ -0,0 +1,121 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// autoRotate turns img upright according to its EXIF orientation and drops
+// the tag, so everything after decoding (crops, face and saliency detection,
+// blurhash, palettes) sees the image as it is displayed, and encoders do not
+// rotate it a second time. Upright images are left untouched.
+func autoRotate(img *vips.ImageRef) error {
+	if img.Orientation() <= 1 {
+		return nil
+	}
+	return img.AutoRotate()
+}
+
+// rotations maps the rot parameter (clockwise degrees) to libvips angles.
+var rotations = map[int]vips.Angle{90: vips.Angle90, 180: vips.Angle180, 270: vips.Angle270}
+
+// RotateAngles are the accepted values of the rot parameter.
+var RotateAngles = []int{90, 180, 270}
+
+// FlipModes are the accepted values of the flip parameter: mirror
+// horizontally, vertically or both.
+var FlipModes = []string{"h", "v", "hv"}
+
+// rotateAndFlip turns img clockwise by opts.Rotate degrees, then mirrors it
+// as opts.Flip asks. It runs on the upright image, before any crop or
+// resize, so w and h apply to the result.
+func rotateAndFlip(img *vips.ImageRef, opts ImageOptions) error {
+	if angle, ok := rotations[opts.Rotate]; ok {
+		if err := img.Rotate(angle); err != nil {
+			return err
+		}
+	}
+	if strings.Contains(opts.Flip, "h") {
+		if err := img.Flip(vips.DirectionHorizontal); err != nil {
+			return err
+		}
+	}
+	if strings.Contains(opts.Flip, "v") {
+		if err := img.Flip(vips.DirectionVertical); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// swapsAxes reports whether an EXIF orientation turns the image by 90
+// degrees, so its displayed width is its stored height.
+func swapsAxes(orientation int) bool {
+	return orientation >= 5 && orientation <= 8
+}
+
+// jpegOrientation returns the EXIF orientation (1-8) of the JPEG in data, or
+// 0 when it has none. Only the marker segments before the image data are
+// read.
+func jpegOrientation(data []byte) int {
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+		return 0
+	}
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			return 0
+		}
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 { // start of scan, end of image
+			return 0
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return 0
+		}
+		if segment := data[pos+4 : end]; marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		pos = end
+	}
+	return 0
+}
+
+// exifOrientation reads the Orientation tag (0x0112) from the first IFD of
+// a TIFF structure, or returns 0.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if v := int(order.Uint16(tiff[entry+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 0
+		}
+	}
+	return 0
+}
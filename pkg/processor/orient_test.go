@@ -0,0 +1,166 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// marked returns a PNG of the given size, blue with a red square in the
+// top-left corner, so rotations and flips can be told apart.
+func marked(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{B: 255, A: 255}
+			if x < width/4 && y < height/4 {
+				c = color.RGBA{R: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// render runs src through the pipeline with opts and decodes the result.
+// It needs libvips.
+func render(t *testing.T, src []byte, opts ImageOptions) image.Image {
+	t.Helper()
+	out, err := Process(context.Background(), bytes.NewReader(src), opts, nil, 0, "test.png")
+	if err != nil {
+		t.Fatalf("Process(%+v): %v", opts, err)
+	}
+	img, _, err := image.Decode(out)
+	if err != nil {
+		t.Fatalf("decoding output of %+v: %v", opts, err)
+	}
+	return img
+}
+
+// corner returns which corner of img holds the red mark: "tl", "tr", "bl"
+// or "br", or "" when none does.
+func corner(img image.Image) string {
+	b := img.Bounds()
+	red := func(x, y int) bool {
+		r, _, bl, _ := img.At(x, y).RGBA()
+		return r > 0xC000 && bl < 0x4000
+	}
+	left, right := b.Min.X+1, b.Max.X-2
+	top, bottom := b.Min.Y+1, b.Max.Y-2
+	switch {
+	case red(left, top):
+		return "tl"
+	case red(right, top):
+		return "tr"
+	case red(left, bottom):
+		return "bl"
+	case red(right, bottom):
+		return "br"
+	}
+	return ""
+}
+
+// TestRotateAndFlip checks that rot and flip apply to the source before w
+// and h, so a quarter turn swaps the axes the size is fitted to. It needs
+// libvips.
+func TestRotateAndFlip(t *testing.T) {
+	src := marked(t, 300, 200)
+	tests := []struct {
+		name          string
+		opts          ImageOptions
+		width, height int
+		corner        string
+	}{
+		{name: "none", opts: ImageOptions{Width: 150}, width: 150, height: 100, corner: "tl"},
+		{name: "90", opts: ImageOptions{Rotate: 90, Width: 100}, width: 100, height: 150, corner: "tr"},
+		{name: "180", opts: ImageOptions{Rotate: 180, Width: 150}, width: 150, height: 100, corner: "br"},
+		{name: "270", opts: ImageOptions{Rotate: 270, Width: 100}, width: 100, height: 150, corner: "bl"},
+		{name: "90 by height", opts: ImageOptions{Rotate: 90, Height: 150}, width: 100, height: 150, corner: "tr"},
+		{name: "90 without resize", opts: ImageOptions{Rotate: 90}, width: 200, height: 300, corner: "tr"},
+		{name: "90 cover", opts: ImageOptions{Rotate: 90, Width: 100, Height: 150, Fit: "cover"}, width: 100, height: 150, corner: "tr"},
+		{name: "flip h", opts: ImageOptions{Flip: "h", Width: 150}, width: 150, height: 100, corner: "tr"},
+		{name: "flip v", opts: ImageOptions{Flip: "v", Width: 150}, width: 150, height: 100, corner: "bl"},
+		{name: "flip hv", opts: ImageOptions{Flip: "hv", Width: 150}, width: 150, height: 100, corner: "br"},
+		{name: "90 then flip h", opts: ImageOptions{Rotate: 90, Flip: "h", Width: 100}, width: 100, height: 150, corner: "tl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := render(t, src, tt.opts)
+			if b := img.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Errorf("size = %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.width, tt.height)
+			}
+			if got := corner(img); got != tt.corner {
+				t.Errorf("mark in corner %q, want %q", got, tt.corner)
+			}
+		})
+	}
+}
+
+// orientation6 is a 200x300 JPEG tagged with EXIF orientation 6 whose
+// upright view is the 300x200 marked image: the mark is stored in the
+// bottom-left corner.
+func orientation6(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "orientation-6.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestOrientationFixture(t *testing.T) {
+	data := orientation6(t)
+	if got := jpegOrientation(data); got != 6 {
+		t.Fatalf("jpegOrientation() = %d, want 6", got)
+	}
+	stored, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := stored.Bounds(); b.Dx() != 200 || b.Dy() != 300 {
+		t.Errorf("stored size %dx%d, want 200x300", b.Dx(), b.Dy())
+	}
+	if got := corner(stored); got != "bl" {
+		t.Errorf("stored mark in corner %q, want bl", got)
+	}
+}
+
+// TestAutoRotate turns the orientation 6 fixture upright before w, h, fit
+// and rot apply, so its width and height are swapped and the mark ends up
+// in the top-left corner. It needs libvips.
+func TestAutoRotate(t *testing.T) {
+	src := orientation6(t)
+	tests := []struct {
+		name          string
+		opts          ImageOptions
+		width, height int
+		corner        string
+	}{
+		{name: "no resize", opts: ImageOptions{Format: "png"}, width: 300, height: 200, corner: "tl"},
+		{name: "width", opts: ImageOptions{Format: "png", Width: 150}, width: 150, height: 100, corner: "tl"},
+		{name: "cover", opts: ImageOptions{Format: "png", Width: 100, Height: 100, Fit: "cover"}, width: 100, height: 100, corner: "tl"},
+		{name: "then rot 90", opts: ImageOptions{Format: "png", Rotate: 90}, width: 200, height: 300, corner: "tr"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := render(t, src, tt.opts)
+			if b := img.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Errorf("size = %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.width, tt.height)
+			}
+			if got := corner(img); got != tt.corner {
+				t.Errorf("mark in corner %q, want %q", got, tt.corner)
+			}
+		})
+	}
+}
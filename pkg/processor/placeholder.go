@@ -0,0 +1,125 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// Placeholder describes a generated placeholder image.
+type Placeholder struct {
+	Width  int
+	Height int
+	// Background is a color, or "gradient:top,bottom" for a vertical
+	// gradient between two colors
+	Background string
+	// Foreground is the color of Text, drawn centered
+	Foreground string
+	Text       string
+	TextSize   float64
+	Format     string
+	Quality    int
+}
+
+// Canonical returns a deterministic serialization of p for cache keys.
+func (p Placeholder) Canonical() string {
+	v := url.Values{}
+	v.Set("w", strconv.Itoa(p.Width))
+	v.Set("h", strconv.Itoa(p.Height))
+	v.Set("bg", p.Background)
+	v.Set("fg", p.Foreground)
+	v.Set("text", p.Text)
+	v.Set("ts", strconv.FormatFloat(p.TextSize, 'g', -1, 64))
+	v.Set("format", p.Format)
+	v.Set("q", strconv.Itoa(p.Quality))
+	return v.Encode()
+}
+
+// IsOutputFormat reports whether format (e.g. "webp") can be encoded.
+func IsOutputFormat(format string) bool {
+	for _, f := range outputFormats {
+		if f.name == format {
+			return vips.IsTypeSupported(f.imageType)
+		}
+	}
+	return false
+}
+
+// RenderPlaceholder draws the placeholder canvas and runs it through the
+// regular pipeline for the text overlay and the encoding.
+func RenderPlaceholder(ctx context.Context, p Placeholder) (*bytes.Buffer, error) {
+	canvas, err := placeholderSVG(p)
+	if err != nil {
+		return nil, err
+	}
+	opts := ImageOptions{
+		Text:      p.Text,
+		TextColor: p.Foreground,
+		TextSize:  p.TextSize,
+		Format:    p.Format,
+		Quality:   p.Quality,
+	}
+	return Process(ctx, strings.NewReader(canvas), opts, nil, 0, "_placeholder.svg")
+}
+
+// Validate checks the colors of p, naming the parameter at fault.
+func (p Placeholder) Validate() error {
+	if _, err := p.backgroundColors(); err != nil {
+		return fmt.Errorf("bg: %v", err)
+	}
+	if _, err := ParseColor(p.Foreground); err != nil {
+		return fmt.Errorf("fg: %v", err)
+	}
+	return nil
+}
+
+// backgroundColors returns the background color of p, or the top and
+// bottom colors of its gradient.
+func (p Placeholder) backgroundColors() ([]vips.ColorRGBA, error) {
+	specs := []string{p.Background}
+	if gradient, ok := strings.CutPrefix(p.Background, "gradient:"); ok {
+		top, bottom, ok := strings.Cut(gradient, ",")
+		if !ok {
+			return nil, fmt.Errorf("invalid gradient %q: expected two colors, e.g. gradient:ffffff,cccccc", p.Background)
+		}
+		specs = []string{top, bottom}
+	}
+	var colors []vips.ColorRGBA
+	for _, s := range specs {
+		c, err := ParseColor(s)
+		if err != nil {
+			return nil, err
+		}
+		colors = append(colors, c)
+	}
+	return colors, nil
+}
+
+// placeholderSVG returns the background of p as an SVG document.
+func placeholderSVG(p Placeholder) (string, error) {
+	colors, err := p.backgroundColors()
+	if err != nil {
+		return "", err
+	}
+	if len(colors) == 1 {
+		fill, opacity := SVGColor(colors[0])
+		return fmt.Sprintf(`<svg width="%d" height="%d" xmlns="http://www.w3.org/2000/svg">
+			<rect width="100%%" height="100%%" fill="%s" fill-opacity="%f"/>
+		</svg>`, p.Width, p.Height, fill, opacity), nil
+	}
+
+	var stops strings.Builder
+	for i, c := range colors {
+		fill, opacity := SVGColor(c)
+		fmt.Fprintf(&stops, `<stop offset="%d" stop-color="%s" stop-opacity="%f"/>`, i, fill, opacity)
+	}
+	return fmt.Sprintf(`<svg width="%d" height="%d" xmlns="http://www.w3.org/2000/svg">
+			<defs><linearGradient id="bg" x1="0" y1="0" x2="0" y2="1">%s</linearGradient></defs>
+			<rect width="100%%" height="100%%" fill="url(#bg)"/>
+		</svg>`, p.Width, p.Height, stops.String()), nil
+}
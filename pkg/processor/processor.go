@@ -4,43 +4,53 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"html"
 	"image"
 	"image/png"
 	"io"
-	"os"
-	"path/filepath"
-	"sort"
 	"strings"
 	"time"
 
-	"github.com/buckket/go-blurhash"
 	"github.com/davidbyttow/govips/v2/vips"
-	pigo "github.com/esimov/pigo/core"
 	"go.opentelemetry.io/otel"
 
 	"github.com/CodeTease/quirm/pkg/metrics"
 )
 
-var cascadeParams []byte
+// transientErrors are libvips messages of failures that depend on the
+// moment rather than on the image.
+var transientErrors = []string{"buffer error"}
 
-// LoadCascade loads the pigo cascade file from the given path.
-func LoadCascade(path string) error {
-	b, err := os.ReadFile(path)
-	if err != nil {
-		return err
+// IsTransient reports whether a processing error may not happen again on
+// the next try. Decode errors and invalid options are permanent.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
 	}
-	cascadeParams = b
-	return nil
+	msg := strings.ToLower(err.Error())
+	for _, s := range transientErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
 }
 
+// FitModes are the accepted Fit values, named after CSS object-fit.
+var FitModes = []string{"cover", "contain", "fill", "scale-down", "none"}
+
 type ImageOptions struct {
 	Width            int
 	Height           int
-	Fit              string // cover, contain, fill, inside
+	Fit              string // one of FitModes; "" fits within w x h like contain
 	Format           string // jpeg, png, webp, jxl
 	Quality          int
-	Focus            string // smart, face
+	Focus            string  // smart, face, faces, point
+	FocalX           float64 // focal point for Focus "point", 0-1 from the left
+	FocalY           float64 // focal point for Focus "point", 0-1 from the top
+	FacePad          float64 // margin around faces as a fraction of their size; 0 = default
 	Text             string
+	TextTiled        bool // repeat the text diagonally across the image
 	TextColor        string
 	TextSize         float64
 	TextOpacity      float64
@@ -51,7 +61,41 @@ type ImageOptions struct {
 	Blurhash         bool
 	SmartCompression bool
 	Animated         bool
+	Static           bool // render animated sources as their first frame
 	Page             int
+	NoWatermark      bool // skip the configured watermark
+	Force            bool // process even a source that already fits (see SkipsProcessing)
+	AutoGray         bool // encode monochrome outputs single-band (see autoGrayscale)
+	// MaxWidth and MaxHeight bound the output size (e.g. from a transform
+	// policy); they only ever shrink the image
+	MaxWidth  int
+	MaxHeight int
+	// Rotate turns the image clockwise (90, 180 or 270 degrees) and Flip
+	// mirrors it ("h", "v" or "hv"), before any crop or resize
+	Rotate int
+	Flip   string
+	// CropX, CropY, CropWidth and CropHeight cut a region out of the source,
+	// in source pixels, before it is resized; a zero size runs to the edge
+	CropX      int
+	CropY      int
+	CropWidth  int
+	CropHeight int
+	// AnimStart, AnimFPS and Boomerang shape animated video thumbnails
+	AnimStart float64
+	AnimFPS   int
+	Boomerang bool
+	// Sizes lists the icon sizes of format=ico, e.g. "16,32,48"
+	Sizes string
+	// Background is the hex color transparent images are flattened onto
+	// for formats without alpha (default white)
+	Background string
+	// Optimize re-encodes a GIF at its own size (see OptimizeGIF)
+	Optimize bool
+	// WatermarkMinWidth and WatermarkMinHeight exempt outputs smaller than
+	// them from the watermark and tiled text. They are configuration, so
+	// Canonical leaves them out.
+	WatermarkMinWidth  int
+	WatermarkMinHeight int
 }
 
 // Process decodes, transforms, watermarks, and encodes the image.
@@ -59,6 +103,25 @@ type ImageOptions struct {
 // which has a context. Ideally Process should take context. For now we use Background if we can't change signature,
 // BUT looking at where Process is called, it might be inside HandleRequest which likely has context.
 func Process(ctx context.Context, r io.Reader, opts ImageOptions, wmImg image.Image, wmOpacity float64, originalKey string) (*bytes.Buffer, error) {
+	return process(ctx, r, opts, wmImg, wmOpacity, originalKey, nil)
+}
+
+// ProcessBundle works like Process and also returns a low-quality WebP
+// placeholder (LQIP) of the processed image, generated from the same decode.
+func ProcessBundle(ctx context.Context, r io.Reader, opts ImageOptions, wmImg image.Image, wmOpacity float64, originalKey string) (*bytes.Buffer, *bytes.Buffer, error) {
+	lqip := &bytes.Buffer{}
+	buf, err := process(ctx, r, opts, wmImg, wmOpacity, originalKey, lqip)
+	if err != nil {
+		return nil, nil, err
+	}
+	return buf, lqip, nil
+}
+
+func process(ctx context.Context, r io.Reader, opts ImageOptions, wmImg image.Image, wmOpacity float64, originalKey string, lqip *bytes.Buffer) (*bytes.Buffer, error) {
+	if strings.ToLower(opts.Format) == "ico" {
+		return processICO(ctx, r, opts, originalKey)
+	}
+
 	tracer := otel.Tracer("quirm/processor")
 	ctx, span := tracer.Start(ctx, "Processor.Process")
 	defer span.End()
@@ -68,6 +131,9 @@ func Process(ctx context.Context, r io.Reader, opts ImageOptions, wmImg image.Im
 		metrics.ImageProcessDuration.Observe(time.Since(start).Seconds())
 	}()
 
+	stats := statsFrom(ctx)
+	mark := start
+
 	// 1. Decode
 	// We read the full stream into memory to support LoadImageFromBuffer with options (e.g. Page)
 	data, err := io.ReadAll(r)
@@ -80,6 +146,16 @@ func Process(ctx context.Context, r io.Reader, opts ImageOptions, wmImg image.Im
 	if opts.Page > 0 {
 		importParams.Page.Set(opts.Page - 1)
 	}
+	if opts.Static {
+		// Only decode a single frame of animated GIF/WebP sources
+		importParams.NumPages.Set(1)
+	}
+	// Extreme JPEG downscales decode at reduced size. The transforms below
+	// scale relative to the decoded image, so they need no adjustment.
+	shrink := jpegShrinkFactor(data, opts)
+	if shrink > 1 {
+		importParams.JpegShrinkFactor.Set(shrink)
+	}
 
 	img, err := vips.LoadImageFromBuffer(data, importParams)
 	if err != nil {
@@ -87,6 +163,23 @@ func Process(ctx context.Context, r io.Reader, opts ImageOptions, wmImg image.Im
 		return nil, fmt.Errorf("decode error: %w", err)
 	}
 	defer img.Close()
+	// Before any geometry-dependent work: crops, detectors and blurhash all
+	// expect the image as displayed
+	if err := autoRotate(img); err != nil {
+		metrics.ImageProcessErrorsTotal.Inc()
+		return nil, fmt.Errorf("auto-rotate error: %w", err)
+	}
+
+	if stats != nil {
+		stats.SourceBytes = len(data)
+		stats.SourceWidth = img.Width()
+		stats.SourceHeight = img.Height()
+		stats.SourceFrames = img.Pages()
+		if shrink > 1 {
+			stats.SourceWidth *= shrink
+			stats.SourceHeight *= shrink
+		}
+	}
 
 	// PDF Specific Logic
 	// If the image is a PDF, we might need to handle transparency (flatten to white)
@@ -106,149 +199,103 @@ func Process(ctx context.Context, r io.Reader, opts ImageOptions, wmImg image.Im
 		}
 	}
 
+	stats.stage("decode", &mark)
+
 	// 2. Transform
+	// Rotation and the explicit crop come first: w, h, fit and focus apply
+	// to their result
+	if err := rotateAndFlip(img, opts); err != nil {
+		metrics.ImageProcessErrorsTotal.Inc()
+		return nil, fmt.Errorf("rotate error: %w", err)
+	}
+	if err := cropRegion(img, opts); err != nil {
+		metrics.ImageProcessErrorsTotal.Inc()
+		return nil, fmt.Errorf("crop error: %w", err)
+	}
+	// Negative sizes count as unset rather than producing negative scales
+	opts.Width, opts.Height = max(opts.Width, 0), max(opts.Height, 0)
 	if opts.Width > 0 || opts.Height > 0 {
 		switch opts.Fit {
 		case "cover":
-			if opts.Focus == "smart" {
+			width, height := coverSize(opts.Width, opts.Height, img.Width(), img.Height())
+			if opts.Focus == "point" {
+				if err := cropToPoint(img, width, height, opts.FocalX, opts.FocalY); err != nil {
+					return nil, err
+				}
+			} else if opts.Focus == "smart" {
 				// Use AI Detector if configured/available, else fallback to Entropy
 				// For now we instantiate a detector. In a real app, this should be a singleton injected.
 				detector := &AiDetector{}
-				if err := SmartCrop(img, opts.Width, opts.Height, detector); err != nil {
+				if err := SmartCrop(img, width, height, detector); err != nil {
 					return nil, err
 				}
-			} else if opts.Focus == "face" {
-				if len(cascadeParams) > 0 {
-					detImg, err := img.Copy()
-					if err != nil {
-						return nil, err
-					}
-
-					if err := detImg.ToColorSpace(vips.InterpretationBW); err != nil {
-						detImg.Close()
-						return nil, err
-					}
-					pixels, err := detImg.ToBytes()
-					if err != nil {
-						detImg.Close()
-						return nil, err
-					}
-					cols := detImg.Width()
-					rows := detImg.Height()
-					detImg.Close()
-
-					cParams := pigo.NewPigo()
-					classifier, err := cParams.Unpack(cascadeParams)
-					if err == nil {
-						imgParams := pigo.ImageParams{
-							Pixels: pixels,
-							Rows:   rows,
-							Cols:   cols,
-							Dim:    cols,
-						}
-						cascade := pigo.CascadeParams{
-							MinSize:     20,
-							MaxSize:     1000,
-							ShiftFactor: 0.1,
-							ScaleFactor: 1.1,
-							ImageParams: imgParams,
-						}
-
-						dets := classifier.RunCascade(cascade, 0.0)
-						dets = classifier.ClusterDetections(dets, 0.2)
-
-						if len(dets) > 0 {
-							var maxDet pigo.Detection
-							maxSize := 0
-							for _, det := range dets {
-								if det.Scale > maxSize {
-									maxSize = det.Scale
-									maxDet = det
-								}
-							}
-
-							faceX := maxDet.Col
-							faceY := maxDet.Row
-
-							targetRatio := float64(opts.Width) / float64(opts.Height)
-							srcRatio := float64(cols) / float64(rows)
-
-							var cropW, cropH int
-							if srcRatio > targetRatio {
-								cropH = rows
-								cropW = int(float64(cropH) * targetRatio)
-							} else {
-								cropW = cols
-								cropH = int(float64(cropW) / targetRatio)
-							}
-
-							x0 := faceX - cropW/2
-							y0 := faceY - cropH/2
-
-							if x0 < 0 {
-								x0 = 0
-							}
-							if y0 < 0 {
-								y0 = 0
-							}
-							if x0+cropW > cols {
-								x0 = cols - cropW
-							}
-							if y0+cropH > rows {
-								y0 = rows - cropH
-							}
-							if err := img.ExtractArea(x0, y0, cropW, cropH); err != nil {
-								return nil, err
-							}
-							if err := img.Resize(float64(opts.Width)/float64(cropW), vips.KernelLanczos3); err != nil {
-								return nil, err
-							}
-
-						} else {
-							if err := img.ThumbnailWithSize(opts.Width, opts.Height, vips.InterestingCentre, vips.SizeForce); err != nil {
-								return nil, err
-							}
-						}
-					} else {
-						if err := img.ThumbnailWithSize(opts.Width, opts.Height, vips.InterestingCentre, vips.SizeForce); err != nil {
-							return nil, err
-						}
-					}
-
-				} else {
-					if err := img.ThumbnailWithSize(opts.Width, opts.Height, vips.InterestingCentre, vips.SizeForce); err != nil {
-						return nil, err
-					}
+			} else if opts.Focus == "face" || opts.Focus == "faces" {
+				if err := faceCrop(img, opts); err != nil {
+					return nil, err
 				}
 			} else {
-				if err := img.ThumbnailWithSize(opts.Width, opts.Height, vips.InterestingCentre, vips.SizeForce); err != nil {
+				if err := img.ThumbnailWithSize(width, height, vips.InterestingCentre, vips.SizeForce); err != nil {
 					return nil, err
 				}
 			}
-		case "contain":
+		case "contain", "scale-down", "":
 			scale := float64(opts.Width) / float64(img.Width())
 			scaleY := float64(opts.Height) / float64(img.Height())
-			if scaleY < scale {
+			if opts.Width == 0 || (opts.Height > 0 && scaleY < scale) {
 				scale = scaleY
 			}
+			// scale-down never enlarges
+			if opts.Fit == "scale-down" && scale >= 1 {
+				break
+			}
 			if err := img.Resize(scale, vips.KernelLanczos3); err != nil {
 				return nil, err
 			}
 
-		default:
-			if err := img.ResizeWithVScale(float64(opts.Width)/float64(img.Width()), float64(opts.Height)/float64(img.Height()), vips.KernelLanczos3); err != nil {
+		case "none":
+			fx, fy := 0.5, 0.5
+			if opts.Focus == "point" {
+				fx, fy = opts.FocalX, opts.FocalY
+			}
+			if err := cropToBox(img, opts.Width, opts.Height, fx, fy); err != nil {
 				return nil, err
 			}
+
+		case "fill":
+			scaleX := float64(opts.Width) / float64(img.Width())
+			scaleY := float64(opts.Height) / float64(img.Height())
+			// A single dimension keeps the aspect ratio
+			if opts.Width == 0 {
+				scaleX = scaleY
+			} else if opts.Height == 0 {
+				scaleY = scaleX
+			}
+			if err := img.ResizeWithVScale(scaleX, scaleY, vips.KernelLanczos3); err != nil {
+				return nil, err
+			}
+
+		default:
+			metrics.ImageProcessErrorsTotal.Inc()
+			return nil, fmt.Errorf("unknown fit %q", opts.Fit)
 		}
 	}
 
+	if err := applyMaxSize(img, opts.MaxWidth, opts.MaxHeight); err != nil {
+		return nil, err
+	}
+
+	stats.stage("transform", &mark)
+
 	// 2.5 Effects
 	if err := applyEffects(img, opts); err != nil {
 		return nil, err
 	}
+	stats.stage("effects", &mark)
 
-	// 3. Watermark (Image)
-	if wmImg != nil {
+	// 3. Watermark (Image). Outputs under the minimum size get neither the
+	// watermark nor tiled text, which would make a thumbnail unreadable.
+	exempt := watermarkExempt(img.Width(), img.Height(), opts)
+	if wmImg != nil && !exempt {
 		var wmBuf bytes.Buffer
 		if err := png.Encode(&wmBuf, wmImg); err == nil {
 			wmVips, err := vips.NewImageFromBuffer(wmBuf.Bytes())
@@ -277,39 +324,29 @@ func Process(ctx context.Context, r io.Reader, opts ImageOptions, wmImg image.Im
 	}
 
 	// 3.5 Text Overlay
-	if opts.Text != "" {
+	if opts.Text != "" && !(opts.TextTiled && exempt) {
 		if opts.TextSize == 0 {
 			opts.TextSize = 24
 		}
 		if opts.TextColor == "" {
 			opts.TextColor = "red"
 		}
-		fontFamily := opts.Font
-		if fontFamily == "" {
-			fontFamily = "sans-serif"
-		} else {
-			// Sanitize Font Name: Allow only alphanumeric, space, hyphens, and underscores
-			// to prevent SVG injection.
-			safe := true
-			for _, r := range fontFamily {
-				if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == ' ' || r == '-' || r == '_') {
-					safe = false
-					break
-				}
-			}
-			if !safe {
-				fontFamily = "sans-serif"
-			}
-		}
+		fontFamily := safeFontFamily(opts.Font)
 
 		textOpacity := opts.TextOpacity
 		if textOpacity == 0 {
 			textOpacity = 1.0
 		}
+		fill, textOpacity := textFill(opts.TextColor, textOpacity)
 
-		svg := fmt.Sprintf(`<svg width="%d" height="%d">
+		var svg string
+		if opts.TextTiled {
+			svg = tiledTextSVG(img.Width(), img.Height(), opts)
+		} else {
+			svg = fmt.Sprintf(`<svg width="%d" height="%d">
 			<text x="50%%" y="50%%" font-family="%s" font-size="%f" fill="%s" text-anchor="middle" dominant-baseline="middle" opacity="%f">%s</text>
-		</svg>`, img.Width(), img.Height(), fontFamily, opts.TextSize, opts.TextColor, textOpacity, opts.Text)
+		</svg>`, img.Width(), img.Height(), fontFamily, opts.TextSize, fill, textOpacity, html.EscapeString(opts.Text))
+		}
 
 		textImg, err := vips.NewImageFromBuffer([]byte(svg))
 		if err == nil {
@@ -320,89 +357,89 @@ func Process(ctx context.Context, r io.Reader, opts ImageOptions, wmImg image.Im
 		}
 	}
 
+	stats.stage("overlay", &mark)
+
 	// 4. Encode
 	// Handle Blurhash
 	if opts.Blurhash {
-		thumb, err := img.Copy()
-		if err != nil {
-			return nil, err
-		}
-		if err := thumb.ThumbnailWithSize(32, 32, vips.InterestingCentre, vips.SizeForce); err != nil {
-			thumb.Close()
-			return nil, err
-		}
-
-		if err := thumb.ToColorSpace(vips.InterpretationSRGB); err != nil {
-			thumb.Close()
-			return nil, err
-		}
-
-		pixels, err := thumb.ToBytes()
-		if err != nil {
-			thumb.Close()
-			return nil, err
-		}
-		w := thumb.Width()
-		h := thumb.Height()
-		bands := thumb.Bands()
-		thumb.Close()
-
-		var imgObj image.Image
-		if bands == 4 {
-			imgObj = &image.RGBA{
-				Pix:    pixels,
-				Stride: w * 4,
-				Rect:   image.Rect(0, 0, w, h),
-			}
-		} else if bands == 3 {
-			rgbaPixels := make([]uint8, w*h*4)
-			for i := 0; i < w*h; i++ {
-				rgbaPixels[i*4] = pixels[i*3]
-				rgbaPixels[i*4+1] = pixels[i*3+1]
-				rgbaPixels[i*4+2] = pixels[i*3+2]
-				rgbaPixels[i*4+3] = 255
-			}
-			imgObj = &image.RGBA{Pix: rgbaPixels, Stride: w * 4, Rect: image.Rect(0, 0, w, h)}
-		} else {
-			return nil, fmt.Errorf("unsupported bands for blurhash: %d", bands)
-		}
-
-		hash, err := blurhash.Encode(4, 3, imgObj)
+		hash, err := encodeBlurhash(img)
 		if err != nil {
 			metrics.ImageProcessErrorsTotal.Inc()
 			return nil, err
 		}
+		stats.stage("encode", &mark)
+		if stats != nil {
+			stats.OutputFormat = "blurhash"
+			stats.OutputBytes = len(hash)
+		}
 		return bytes.NewBufferString(hash), nil
 	}
 
 	// Actual Encode
 	formatStr := strings.ToLower(opts.Format)
 	if formatStr == "" {
-		ext := strings.ToLower(filepath.Ext(originalKey))
-		if ext == ".png" {
-			formatStr = "png"
-		} else if ext == ".gif" {
-			formatStr = "gif"
-		} else if ext == ".webp" {
-			formatStr = "webp"
-		} else if ext == ".avif" {
-			formatStr = "avif"
-		} else if ext == ".jxl" {
-			formatStr = "jxl"
-		} else {
-			formatStr = "jpeg"
+		formatStr = DefaultFormat(originalKey)
+	}
+
+	// WebP, AVIF and PNG keep the alpha channel as is
+	if err := flattenForFormat(img, formatStr, opts.Background); err != nil {
+		metrics.ImageProcessErrorsTotal.Inc()
+		return nil, err
+	}
+	// After everything that could add color, the background included
+	if opts.AutoGray {
+		if err := autoGrayscale(img, formatStr); err != nil {
+			metrics.ImageProcessErrorsTotal.Inc()
+			return nil, fmt.Errorf("grayscale error: %w", err)
 		}
 	}
 
-	exportBytes, _, err := exportImage(img, formatStr, opts.Quality, opts.SmartCompression)
+	exportBytes, exported, err := exportImage(img, formatStr, opts.Quality, opts.SmartCompression)
 	if err != nil {
 		metrics.ImageProcessErrorsTotal.Inc()
 		return nil, err
 	}
+	stats.stage("encode", &mark)
+	if stats != nil {
+		// The format actually encoded, e.g. jpeg for format=jpg
+		stats.OutputFormat = vips.ImageTypes[exported.Format]
+		stats.OutputBytes = len(exportBytes)
+	}
+
+	if lqip != nil {
+		if err := encodePlaceholder(img, lqip); err != nil {
+			metrics.ImageProcessErrorsTotal.Inc()
+			return nil, err
+		}
+	}
 
 	return bytes.NewBuffer(exportBytes), nil
 }
 
+// placeholderWidth is the width of the low-quality placeholders.
+const placeholderWidth = 32
+
+// encodePlaceholder writes a tiny, low-quality WebP rendition of img to buf.
+func encodePlaceholder(img *vips.ImageRef, buf *bytes.Buffer) error {
+	thumb, err := img.Copy()
+	if err != nil {
+		return err
+	}
+	defer thumb.Close()
+
+	if thumb.Width() > placeholderWidth {
+		if err := thumb.Resize(float64(placeholderWidth)/float64(thumb.Width()), vips.KernelLanczos3); err != nil {
+			return err
+		}
+	}
+	data, _, err := exportImage(thumb, "webp", 30, false)
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
+
 func exportImage(img *vips.ImageRef, format string, quality int, smart bool) ([]byte, *vips.ImageMetadata, error) {
 	if quality == 0 {
 		quality = 80
@@ -478,85 +515,124 @@ func ExtractPalette(r io.Reader) ([]string, error) {
 		return nil, fmt.Errorf("decode error: %w", err)
 	}
 	defer img.Close()
-
-	// Resize to small size (100x100) to find dominant colors faster and group them
-	if err := img.ThumbnailWithSize(100, 100, vips.InterestingCentre, vips.SizeForce); err != nil {
-		return nil, err
+	if err := autoRotate(img); err != nil {
+		return nil, fmt.Errorf("auto-rotate error: %w", err)
 	}
 
-	// Ensure sRGB
-	if err := img.ToColorSpace(vips.InterpretationSRGB); err != nil {
-		return nil, err
-	}
+	colors, _, err := dominantColors(img)
+	return colors, err
+}
 
-	pixels, err := img.ToBytes()
-	if err != nil {
-		return nil, err
+// cropToPoint crops img to the aspect ratio of width x height around the
+// focal point (fx, fy), given as fractions of the image size, and resizes it
+// to width x height.
+func cropToPoint(img *vips.ImageRef, width, height int, fx, fy float64) error {
+	cols, rows := img.Width(), img.Height()
+	width, height = coverSize(width, height, cols, rows)
+
+	targetRatio := float64(width) / float64(height)
+	cropW, cropH := cols, rows
+	if float64(cols)/float64(rows) > targetRatio {
+		cropW = int(float64(rows) * targetRatio)
+	} else {
+		cropH = int(float64(cols) / targetRatio)
 	}
 
-	bands := img.Bands()
-	w := img.Width()
-	h := img.Height()
-
-	colorCounts := make(map[string]int)
+	x0 := int(fx*float64(cols)) - cropW/2
+	y0 := int(fy*float64(rows)) - cropH/2
+	x0 = max(0, min(x0, cols-cropW))
+	y0 = max(0, min(y0, rows-cropH))
 
-	toHex := func(r, g, b uint8) string {
-		return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+	if err := img.ExtractArea(x0, y0, cropW, cropH); err != nil {
+		return err
 	}
+	return img.ResizeWithVScale(float64(width)/float64(cropW), float64(height)/float64(cropH), vips.KernelLanczos3)
+}
 
-	for i := 0; i < w*h; i++ {
-		offset := i * bands
-		if offset+bands > len(pixels) {
-			break
-		}
-
-		var rVal, gVal, bVal uint8
-
-		if bands >= 3 {
-			rVal = pixels[offset]
-			gVal = pixels[offset+1]
-			bVal = pixels[offset+2]
-		} else if bands == 1 {
-			// Grayscale
-			val := pixels[offset]
-			rVal, gVal, bVal = val, val, val
-		} else if bands == 2 {
-			// Grayscale + Alpha?
-			val := pixels[offset]
-			rVal, gVal, bVal = val, val, val
-		} else {
-			// Fallback (shouldn't happen with sRGB/BW)
-			continue
-		}
+// hasCrop reports whether opts cut an explicit region out of the source.
+func (o ImageOptions) hasCrop() bool {
+	return o.CropX > 0 || o.CropY > 0 || o.CropWidth > 0 || o.CropHeight > 0
+}
 
-		hex := toHex(rVal, gVal, bVal)
-		colorCounts[hex]++
+// cropRegion cuts the region of opts (cx, cy, cw, ch) out of img. The region
+// is clamped to the image rather than rejected, so a crop chosen on a
+// differently sized copy of the source still yields an image.
+func cropRegion(img *vips.ImageRef, opts ImageOptions) error {
+	if !opts.hasCrop() {
+		return nil
+	}
+	cols, rows := img.Width(), img.Height()
+	x0 := min(max(opts.CropX, 0), cols-1)
+	y0 := min(max(opts.CropY, 0), rows-1)
+	width, height := cols-x0, rows-y0
+	if opts.CropWidth > 0 {
+		width = min(opts.CropWidth, width)
 	}
+	if opts.CropHeight > 0 {
+		height = min(opts.CropHeight, height)
+	}
+	if width == cols && height == rows {
+		return nil
+	}
+	return img.ExtractArea(x0, y0, width, height)
+}
 
-	type colorFreq struct {
-		Hex   string
-		Count int
+// cropToBox cuts a width x height window out of img without resizing it,
+// centered on (fx, fy) as fractions of the image size and shifted to stay
+// inside the image. Missing or larger dimensions keep the image's own.
+func cropToBox(img *vips.ImageRef, width, height int, fx, fy float64) error {
+	cols, rows := img.Width(), img.Height()
+	if width <= 0 || width > cols {
+		width = cols
+	}
+	if height <= 0 || height > rows {
+		height = rows
 	}
-	var freqs []colorFreq
-	for k, v := range colorCounts {
-		freqs = append(freqs, colorFreq{k, v})
+	if width == cols && height == rows {
+		return nil
 	}
+	x0 := max(0, min(int(fx*float64(cols))-width/2, cols-width))
+	y0 := max(0, min(int(fy*float64(rows))-height/2, rows-height))
+	return img.ExtractArea(x0, y0, width, height)
+}
 
-	sort.Slice(freqs, func(i, j int) bool {
-		return freqs[i].Count > freqs[j].Count
-	})
+// watermarkExempt reports whether a width x height output is below the
+// watermark minimums of opts in either dimension.
+func watermarkExempt(width, height int, opts ImageOptions) bool {
+	return (opts.WatermarkMinWidth > 0 && width < opts.WatermarkMinWidth) ||
+		(opts.WatermarkMinHeight > 0 && height < opts.WatermarkMinHeight)
+}
 
-	limit := 5
-	if len(freqs) < limit {
-		limit = len(freqs)
+// coverSize returns the crop size of fit=cover for a width x height request
+// on a cols x rows image: a missing dimension follows the image's aspect
+// ratio. Neither is below 1, as w=1 on a wide image would otherwise give a
+// zero height and divide by zero in the crop ratios.
+func coverSize(width, height, cols, rows int) (int, int) {
+	if width <= 0 {
+		width = height * cols / rows
 	}
-
-	result := make([]string, limit)
-	for i := 0; i < limit; i++ {
-		result[i] = freqs[i].Hex
+	if height <= 0 {
+		height = width * rows / cols
 	}
+	return max(width, 1), max(height, 1)
+}
 
-	return result, nil
+// applyMaxSize shrinks img to fit within maxWidth x maxHeight, keeping the
+// aspect ratio. Zero bounds are ignored.
+func applyMaxSize(img *vips.ImageRef, maxWidth, maxHeight int) error {
+	scale := 1.0
+	if maxWidth > 0 && img.Width() > maxWidth {
+		scale = float64(maxWidth) / float64(img.Width())
+	}
+	if maxHeight > 0 && img.Height() > maxHeight {
+		if s := float64(maxHeight) / float64(img.Height()); s < scale {
+			scale = s
+		}
+	}
+	if scale >= 1 {
+		return nil
+	}
+	return img.Resize(scale, vips.KernelLanczos3)
 }
 
 func applyEffects(img *vips.ImageRef, opts ImageOptions) error {
@@ -0,0 +1,47 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os/exec"
+	"strings"
+)
+
+// SelfTestImage returns a small generated PNG (a 64x48 gradient) for
+// exercising the pipeline without an origin.
+func SelfTestImage() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 5), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// ProbeFFmpeg decodes and encodes one generated frame with ffmpeg, which
+// fails when the binary is missing or cannot run. It is not recorded in the
+// video metrics.
+func ProbeFFmpeg(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-f", "lavfi", "-i", "testsrc=size=32x32:rate=1",
+		"-frames:v", "1", "-f", "image2", "-c:v", "mjpeg", "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	if len(out) == 0 {
+		return fmt.Errorf("ffmpeg produced no output")
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package processor
+
+import (
+	"bytes"
+	"image/jpeg"
+)
+
+// shrinkOnLoadRatio is how much smaller than the source the target must be
+// before a JPEG is decoded at reduced size.
+const shrinkOnLoadRatio = 4
+
+// jpegShrinkFactor returns the factor (2, 4 or 8) by which libjpeg can
+// downscale data while decoding, or 0 when the full-resolution decode is
+// needed. Decoding a 200-megapixel panorama at 1/8 scale skips most of the
+// work a later Resize would throw away.
+//
+// The shrink is only used when every requested dimension is at most a
+// quarter of the source, and the decoded image keeps at least twice the
+// target size so the Lanczos resize that follows still has pixels to work
+// with. Smart and face crops analyse the full image and are left alone.
+func jpegShrinkFactor(data []byte, opts ImageOptions) int {
+	if opts.Width <= 0 && opts.Height <= 0 {
+		return 0
+	}
+	if opts.Fit == "cover" && (opts.Focus == "smart" || opts.Focus == "face" || opts.Focus == "faces") {
+		return 0
+	}
+	// fit=none and explicit crops work at the source resolution
+	if opts.Fit == "none" || opts.hasCrop() {
+		return 0
+	}
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}) {
+		return 0
+	}
+
+	// Only the header is parsed
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0
+	}
+	// The requested size applies to the image turned upright
+	if swapsAxes(jpegOrientation(data)) != (opts.Rotate == 90 || opts.Rotate == 270) {
+		cfg.Width, cfg.Height = cfg.Height, cfg.Width
+	}
+	fits := func(factor int) bool {
+		if opts.Width > 0 && cfg.Width < opts.Width*factor {
+			return false
+		}
+		if opts.Height > 0 && cfg.Height < opts.Height*factor {
+			return false
+		}
+		return true
+	}
+	if !fits(shrinkOnLoadRatio) {
+		return 0
+	}
+	for _, factor := range []int{8, 4, 2} {
+		if fits(factor * 2) {
+			return factor
+		}
+	}
+	return 0
+}
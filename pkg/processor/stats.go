@@ -0,0 +1,49 @@
+package processor
+
+import (
+	"context"
+	"time"
+)
+
+// Stats collects what a single Process call did and how long each stage
+// took. It is only filled in when attached to the context with WithStats, so
+// callers that don't ask for it pay nothing beyond a context lookup.
+type Stats struct {
+	SourceBytes  int
+	SourceWidth  int
+	SourceHeight int
+	// SourceFrames is the number of frames (pages) of the source, even when
+	// only the first was decoded
+	SourceFrames int
+	OutputFormat string
+	OutputBytes  int
+	Stages       []StageTiming
+}
+
+// StageTiming is the duration of one processing stage (decode, resize, ...).
+type StageTiming struct {
+	Name     string
+	Duration time.Duration
+}
+
+type statsKey struct{}
+
+// WithStats returns a context that makes Process record into stats.
+func WithStats(ctx context.Context, stats *Stats) context.Context {
+	return context.WithValue(ctx, statsKey{}, stats)
+}
+
+func statsFrom(ctx context.Context) *Stats {
+	stats, _ := ctx.Value(statsKey{}).(*Stats)
+	return stats
+}
+
+// stage records the time elapsed since *mark under name and moves the mark.
+func (s *Stats) stage(name string, mark *time.Time) {
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	s.Stages = append(s.Stages, StageTiming{Name: name, Duration: now.Sub(*mark)})
+	*mark = now
+}
@@ -0,0 +1,63 @@
+package processor
+
+import (
+	"fmt"
+	"html"
+	"unicode/utf8"
+)
+
+// tiledTextOpacity is the default opacity of tiled text, low enough to keep
+// the image readable underneath.
+const tiledTextOpacity = 0.2
+
+// safeFontFamily returns font if it only contains letters, digits, spaces,
+// hyphens and underscores, and "sans-serif" otherwise, so the name cannot
+// break out of the SVG attribute.
+func safeFontFamily(font string) string {
+	if font == "" {
+		return "sans-serif"
+	}
+	for _, r := range font {
+		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == ' ' || r == '-' || r == '_') {
+			return "sans-serif"
+		}
+	}
+	return font
+}
+
+// textFill returns the SVG fill and opacity of text in color, drawn at
+// opacity. The alpha of the color is folded into the opacity. Colors the
+// handlers did not validate fall back to red, the default text color.
+func textFill(color string, opacity float64) (string, float64) {
+	c, err := ParseColor(color)
+	if err != nil {
+		c = namedColors["red"]
+	}
+	fill, alpha := SVGColor(c)
+	return fill, opacity * alpha
+}
+
+// tiledTextSVG renders opts.Text repeated diagonally over a width x height
+// canvas, as used for leak-tracing watermarks. opts.TextSize and
+// opts.TextColor must already have their defaults applied.
+func tiledTextSVG(width, height int, opts ImageOptions) string {
+	opacity := opts.TextOpacity
+	if opacity == 0 {
+		opacity = tiledTextOpacity
+	}
+	fill, opacity := textFill(opts.TextColor, opacity)
+
+	// Approximate the text width from the average glyph width, leaving a gap
+	// of a few characters between repetitions
+	tileWidth := float64(utf8.RuneCountInString(opts.Text)+4) * opts.TextSize * 0.6
+	tileHeight := opts.TextSize * 4
+
+	return fmt.Sprintf(`<svg width="%d" height="%d" xmlns="http://www.w3.org/2000/svg">
+			<defs>
+				<pattern id="tile" width="%f" height="%f" patternUnits="userSpaceOnUse" patternTransform="rotate(-30)">
+					<text x="0" y="%f" font-family="%s" font-size="%f" fill="%s" opacity="%f">%s</text>
+				</pattern>
+			</defs>
+			<rect width="100%%" height="100%%" fill="url(#tile)"/>
+		</svg>`, width, height, tileWidth, tileHeight, opts.TextSize, safeFontFamily(opts.Font), opts.TextSize, fill, opacity, html.EscapeString(opts.Text))
+}
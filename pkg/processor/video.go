@@ -2,21 +2,16 @@ package processor
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os/exec"
-	"time"
-
-	"github.com/CodeTease/quirm/pkg/metrics"
+	"strconv"
+	"strings"
 )
 
 // GenerateThumbnail generates a thumbnail for a video file using ffmpeg.
 // It returns a buffer containing the image data (JPEG).
-func GenerateThumbnail(videoURL string, timestamp string) (*bytes.Buffer, error) {
-	start := time.Now()
-	defer func() {
-		metrics.ImageProcessDuration.Observe(time.Since(start).Seconds())
-	}()
-
+func GenerateThumbnail(ctx context.Context, videoURL string, timestamp string) (*bytes.Buffer, error) {
 	// Check if ffmpeg is available (should be done at startup, but for safety)
 	_, err := exec.LookPath("ffmpeg")
 	if err != nil {
@@ -28,13 +23,34 @@ func GenerateThumbnail(videoURL string, timestamp string) (*bytes.Buffer, error)
 	}
 
 	// Command: ffmpeg -i <videoURL> -ss <timestamp> -vframes 1 -f image2 -
-	cmd := exec.Command("ffmpeg",
+	var stdout bytes.Buffer
+	stderr, err := runFFmpeg(ctx, ffmpegRun{operation: VideoThumbnail, input: videoURL}, []string{
 		"-i", videoURL,
 		"-ss", timestamp,
 		"-vframes", "1",
 		"-f", "image2",
 		"-c:v", "mjpeg",
 		"-",
+	}, &stdout)
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg error: %w, stderr: %s", err, stderr)
+	}
+
+	return &stdout, nil
+}
+
+// ProbeDuration returns the duration of a video in seconds using ffprobe.
+func ProbeDuration(videoURL string) (float64, error) {
+	_, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe not found: %w", err)
+	}
+
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		videoURL,
 	)
 
 	var stdout bytes.Buffer
@@ -42,24 +58,20 @@ func GenerateThumbnail(videoURL string, timestamp string) (*bytes.Buffer, error)
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	err = cmd.Run()
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("ffprobe error: %v, stderr: %s", err, stderr.String())
+	}
+	duration, err := strconv.ParseFloat(strings.TrimSpace(stdout.String()), 64)
 	if err != nil {
-		metrics.ImageProcessErrorsTotal.Inc()
-		return nil, fmt.Errorf("ffmpeg error: %v, stderr: %s", err, stderr.String())
+		return 0, fmt.Errorf("ffprobe returned no duration: %w", err)
 	}
-
-	return &stdout, nil
+	return duration, nil
 }
 
 // GenerateStoryboard generates a storyboard image (grid of frames) for the video.
 // interval: timestamp interval between frames (default "1")
 // cols, rows: grid dimensions
-func GenerateStoryboard(videoURL string, interval string, cols, rows int, width int) (*bytes.Buffer, error) {
-	start := time.Now()
-	defer func() {
-		metrics.ImageProcessDuration.Observe(time.Since(start).Seconds())
-	}()
-
+func GenerateStoryboard(ctx context.Context, videoURL string, interval string, cols, rows int, width int) (*bytes.Buffer, error) {
 	_, err := exec.LookPath("ffmpeg")
 	if err != nil {
 		return nil, fmt.Errorf("ffmpeg not found: %w", err)
@@ -92,68 +104,107 @@ func GenerateStoryboard(videoURL string, interval string, cols, rows int, width
 	// ffmpeg -i input -vf "fps=1/10,scale=160:-1,tile=5x5" -frames:v 1 output.jpg
 	vf := fmt.Sprintf("%s,%s,%s", fpsFilter, scaleFilter, tileFilter)
 
-	cmd := exec.Command("ffmpeg",
+	var stdout bytes.Buffer
+	stderr, err := runFFmpeg(ctx, ffmpegRun{operation: VideoStoryboard, input: videoURL, width: width}, []string{
 		"-i", videoURL,
 		"-vf", vf,
 		"-frames:v", "1",
 		"-f", "image2",
 		"-c:v", "mjpeg",
 		"-",
-	)
-
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	err = cmd.Run()
+	}, &stdout)
 	if err != nil {
-		metrics.ImageProcessErrorsTotal.Inc()
-		return nil, fmt.Errorf("ffmpeg storyboard error: %v, stderr: %s", err, stderr.String())
+		return nil, fmt.Errorf("ffmpeg storyboard error: %w, stderr: %s", err, stderr)
 	}
 
 	return &stdout, nil
 }
 
-// GenerateAnimatedThumbnail generates a 3-second animated thumbnail for a video file using ffmpeg.
-// It extracts 3 seconds from the beginning (or timestamp).
-func GenerateAnimatedThumbnail(videoURL string, duration string, width int, height int, format string) (*bytes.Buffer, error) {
-	start := time.Now()
-	defer func() {
-		metrics.ImageProcessDuration.Observe(time.Since(start).Seconds())
-	}()
+// Animated thumbnail limits. Frames are counted after the boomerang copy,
+// so output size stays bounded whatever the combination of options.
+const (
+	AnimatedMaxFPS    = 30
+	AnimatedMaxFrames = 120
 
-	_, err := exec.LookPath("ffmpeg")
-	if err != nil {
-		return nil, fmt.Errorf("ffmpeg not found: %w", err)
+	animatedDefaultFPS      = 10
+	animatedDefaultDuration = 3
+)
+
+// AnimatedThumbnailOptions selects the clip rendered by
+// GenerateAnimatedThumbnail.
+type AnimatedThumbnailOptions struct {
+	Start     float64 // offset into the video, in seconds
+	Duration  float64 // clip length in seconds (default 3)
+	FPS       int     // frames per second (default 10)
+	Boomerang bool    // append the clip reversed for a ping-pong loop
+	Width     int
+	Height    int
+	Format    string // "webp" or "gif" (default)
+	// MaxWidth and MaxHeight bound the output, keeping the aspect ratio
+	// (0 = no bound)
+	MaxWidth  int
+	MaxHeight int
+}
+
+func (o AnimatedThumbnailOptions) withDefaults() AnimatedThumbnailOptions {
+	if o.Duration <= 0 {
+		o.Duration = animatedDefaultDuration
 	}
+	if o.FPS <= 0 {
+		o.FPS = animatedDefaultFPS
+	}
+	return o
+}
 
-	// Default 3 seconds
-	if duration == "" {
-		duration = "3"
+// Validate checks the frame rate, start offset and total frame count.
+func (o AnimatedThumbnailOptions) Validate() error {
+	if o.Start < 0 {
+		return fmt.Errorf("start offset must not be negative")
 	}
+	if o.FPS < 0 || o.FPS > AnimatedMaxFPS {
+		return fmt.Errorf("fps must be between 1 and %d", AnimatedMaxFPS)
+	}
+	o = o.withDefaults()
+	frames := float64(o.FPS) * o.Duration
+	if o.Boomerang {
+		frames *= 2
+	}
+	if frames > AnimatedMaxFrames {
+		return fmt.Errorf("animation exceeds %d frames (fps x duration, doubled for boomerang)", AnimatedMaxFrames)
+	}
+	return nil
+}
+
+// AnimatedThumbnailArgs returns the ffmpeg arguments rendering the clip
+// described by o from videoURL to stdout.
+func AnimatedThumbnailArgs(videoURL string, o AnimatedThumbnailOptions) []string {
+	o = o.withDefaults()
 
-	// Determine dimensions
 	w := "320"
 	h := "-1"
-	if width > 0 {
-		w = fmt.Sprintf("%d", width)
+	if o.Width > 0 {
+		w = strconv.Itoa(o.Width)
 	}
-	if height > 0 {
-		h = fmt.Sprintf("%d", height)
+	if o.Height > 0 {
+		h = strconv.Itoa(o.Height)
+	}
+	filter := fmt.Sprintf("fps=%d,scale=%s:%s:flags=lanczos", o.FPS, w, h)
+	if bound := boundFilter(o.MaxWidth, o.MaxHeight); bound != "" {
+		filter += "," + bound
+	}
+	if o.Boomerang {
+		filter += ",split[fwd][rev];[rev]reverse[r];[fwd][r]concat=n=2:v=1:a=0"
 	}
-	scaleFilter := fmt.Sprintf("scale=%s:%s:flags=lanczos", w, h)
-
-	var cmd *exec.Cmd
 
-	if format == "webp" {
+	args := []string{
+		"-ss", strconv.FormatFloat(o.Start, 'f', -1, 64),
+		"-t", strconv.FormatFloat(o.Duration, 'f', -1, 64),
+		"-i", videoURL,
+	}
+	if o.Format == "webp" {
 		// Animated WebP
-		// ffmpeg -ss 00:00:00 -t 3 -i input -vf "fps=10,scale=..." -vcodec libwebp -lossless 0 -compression_level 4 -q:v 75 -loop 0 -preset default -an -vsync 0 -f webp -
-		cmd = exec.Command("ffmpeg",
-			"-ss", "00:00:00",
-			"-t", duration,
-			"-i", videoURL,
-			"-vf", "fps=10,"+scaleFilter,
+		return append(args,
+			"-vf", filter,
 			"-vcodec", "libwebp",
 			"-lossless", "0",
 			"-compression_level", "4",
@@ -164,28 +215,35 @@ func GenerateAnimatedThumbnail(videoURL string, duration string, width int, heig
 			"-f", "webp",
 			"-",
 		)
-	} else {
-		// GIF (Default)
-		// Use palettegen/paletteuse for better GIF quality
-		cmd = exec.Command("ffmpeg",
-			"-ss", "00:00:00",
-			"-t", duration,
-			"-i", videoURL,
-			"-vf", fmt.Sprintf("fps=10,%s,split[s0][s1];[s0]palettegen[p];[s1][p]paletteuse", scaleFilter),
-			"-f", "gif",
-			"-",
-		)
 	}
+	// GIF (Default)
+	// Use palettegen/paletteuse for better GIF quality
+	return append(args,
+		"-vf", filter+",split[s0][s1];[s0]palettegen[p];[s1][p]paletteuse",
+		"-f", "gif",
+		"-",
+	)
+}
 
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+// GenerateAnimatedThumbnail renders a short animated GIF or WebP clip of a
+// video file using ffmpeg.
+func GenerateAnimatedThumbnail(ctx context.Context, videoURL string, o AnimatedThumbnailOptions) (*bytes.Buffer, error) {
+	_, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg not found: %w", err)
+	}
 
-	err = cmd.Run()
+	run := ffmpegRun{
+		operation: VideoAnimated,
+		input:     videoURL,
+		duration:  o.withDefaults().Duration,
+		width:     o.Width,
+		height:    o.Height,
+	}
+	var stdout bytes.Buffer
+	stderr, err := runFFmpeg(ctx, run, AnimatedThumbnailArgs(videoURL, o), &stdout)
 	if err != nil {
-		metrics.ImageProcessErrorsTotal.Inc()
-		return nil, fmt.Errorf("ffmpeg animated error: %v, stderr: %s", err, stderr.String())
+		return nil, fmt.Errorf("ffmpeg animated error: %w, stderr: %s", err, stderr)
 	}
 
 	return &stdout, nil
@@ -0,0 +1,101 @@
+package processor
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAnimatedThumbnailValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    AnimatedThumbnailOptions
+		wantErr bool
+	}{
+		{name: "defaults", opts: AnimatedThumbnailOptions{}},
+		{name: "max fps", opts: AnimatedThumbnailOptions{FPS: 30, Duration: 4}},
+		{name: "fps over the cap", opts: AnimatedThumbnailOptions{FPS: 31, Duration: 1}, wantErr: true},
+		{name: "negative fps", opts: AnimatedThumbnailOptions{FPS: -1}, wantErr: true},
+		{name: "negative start", opts: AnimatedThumbnailOptions{Start: -1}, wantErr: true},
+		{name: "max frames", opts: AnimatedThumbnailOptions{FPS: 20, Duration: 6}},
+		{name: "frames over the cap", opts: AnimatedThumbnailOptions{FPS: 20, Duration: 6.5}, wantErr: true},
+		{name: "default fps over the cap", opts: AnimatedThumbnailOptions{Duration: 13}, wantErr: true},
+		{name: "boomerang at the cap", opts: AnimatedThumbnailOptions{FPS: 20, Duration: 3, Boomerang: true}},
+		{name: "boomerang doubles frames", opts: AnimatedThumbnailOptions{FPS: 20, Duration: 4, Boomerang: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAnimatedThumbnailArgs(t *testing.T) {
+	const input = "https://bucket.example.com/clip.mp4"
+	tests := []struct {
+		name   string
+		opts   AnimatedThumbnailOptions
+		seek   []string // -ss and -t values
+		filter string   // -vf value
+		format string   // -f value
+	}{
+		{
+			name:   "defaults",
+			seek:   []string{"0", "3"},
+			filter: "fps=10,scale=320:-1:flags=lanczos,split[s0][s1];[s0]palettegen[p];[s1][p]paletteuse",
+			format: "gif",
+		},
+		{
+			name:   "webp sized",
+			opts:   AnimatedThumbnailOptions{Start: 1.5, Duration: 2, FPS: 15, Width: 480, Height: 270, Format: "webp"},
+			seek:   []string{"1.5", "2"},
+			filter: "fps=15,scale=480:270:flags=lanczos",
+			format: "webp",
+		},
+		{
+			name:   "bounded",
+			opts:   AnimatedThumbnailOptions{Width: 1920, MaxWidth: 800, Format: "webp"},
+			seek:   []string{"0", "3"},
+			filter: `fps=10,scale=1920:-1:flags=lanczos,scale=min(iw\,800):ih:force_original_aspect_ratio=decrease:flags=lanczos`,
+			format: "webp",
+		},
+		{
+			name:   "boomerang",
+			opts:   AnimatedThumbnailOptions{FPS: 12, Boomerang: true, Format: "webp"},
+			seek:   []string{"0", "3"},
+			filter: "fps=12,scale=320:-1:flags=lanczos,split[fwd][rev];[rev]reverse[r];[fwd][r]concat=n=2:v=1:a=0",
+			format: "webp",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := AnimatedThumbnailArgs(input, tt.opts)
+			value := func(flag string) string {
+				if i := slices.Index(args, flag); i >= 0 && i+1 < len(args) {
+					return args[i+1]
+				}
+				return ""
+			}
+			if got := []string{value("-ss"), value("-t")}; !slices.Equal(got, tt.seek) {
+				t.Errorf("-ss, -t = %q, want %q", got, tt.seek)
+			}
+			if got := value("-i"); got != input {
+				t.Errorf("-i = %q, want %q", got, input)
+			}
+			if got := value("-vf"); got != tt.filter {
+				t.Errorf("-vf = %q, want %q", got, tt.filter)
+			}
+			if got := value("-f"); got != tt.format {
+				t.Errorf("-f = %q, want %q", got, tt.format)
+			}
+			if args[len(args)-1] != "-" {
+				t.Errorf("output %q, want stdout", args[len(args)-1])
+			}
+			if slices.Index(args, "-ss") > slices.Index(args, "-i") {
+				t.Errorf("-ss after -i seeks by decoding: %s", strings.Join(args, " "))
+			}
+		})
+	}
+}
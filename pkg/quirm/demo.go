@@ -0,0 +1,26 @@
+package quirm
+
+import (
+	"log/slog"
+
+	"github.com/CodeTease/quirm/pkg/demo"
+	"github.com/CodeTease/quirm/pkg/storage"
+)
+
+// applyDemo switches cfg to demo mode: the bundled sample images are served
+// from memory, and Redis and URL signatures are turned off so that quirm
+// runs without any external service.
+func applyDemo(cfg Config, o *options) (Config, error) {
+	if o.storage == nil {
+		provider, err := demo.Storage()
+		if err != nil {
+			return cfg, err
+		}
+		o.storage = storage.Instrumented(provider, "demo")
+	}
+	cfg.RedisAddr = ""
+	cfg.SecretKey = ""
+
+	slog.Warn("Demo mode: serving bundled sample images, Redis and URL signatures are disabled")
+	return cfg, nil
+}
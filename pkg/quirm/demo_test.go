@@ -0,0 +1,55 @@
+package quirm
+
+import (
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CodeTease/quirm/pkg/config"
+)
+
+// TestDemoMode boots a demo server and runs the bundled samples through the
+// real pipeline. It needs libvips.
+func TestDemoMode(t *testing.T) {
+	t.Setenv("DEMO_MODE", "true")
+	t.Setenv("CACHE_DIR", t.TempDir())
+	s, err := NewServer(config.LoadConfig(), WithoutTracerSetup())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		target string
+		accept string
+		want   string
+	}{
+		{"/", "", "text/html"},
+		{"/samples/landscape.jpg", "", "image/jpeg"},
+		{"/samples/landscape.jpg?w=400", "", "image/jpeg"},
+		{"/samples/landscape.jpg?w=400", "image/webp,*/*", "image/webp"},
+		{"/samples/portrait.jpg?w=400&format=webp", "", "image/webp"},
+		{"/samples/logo.png?w=100", "", "image/png"},
+		{"/samples/portrait.jpg?palette=true", "", "application/json"},
+		{"/samples/portrait.jpg?blurhash=true", "", "text/plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			got, _, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+			if err != nil || got != tt.want {
+				t.Errorf("Content-Type = %q, want %s", w.Header().Get("Content-Type"), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package quirm_test
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/handlers"
+	"github.com/CodeTease/quirm/pkg/quirm"
+	"github.com/CodeTease/quirm/pkg/storage"
+)
+
+// ExampleNewServer embeds quirm under /img/ of another service, with objects
+// served from memory instead of S3. It needs libvips.
+func ExampleNewServer() {
+	cacheDir, err := os.MkdirTemp("", "quirm-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	objects := storage.NewMemoryProvider()
+	objects.Put("css/site.css", []byte("body { margin: 0 }"), "text/css")
+
+	cfg := config.LoadConfig()
+	cfg.CacheDir = cacheDir
+	srv, err := quirm.NewServer(cfg,
+		quirm.WithStorage(objects),
+		quirm.WithoutStages(handlers.StageRateLimit),
+		quirm.WithoutTracerSetup(),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer srv.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/img/", http.StripPrefix("/img", srv))
+	host := httptest.NewServer(mux)
+	defer host.Close()
+
+	resp, err := http.Get(host.URL + "/img/css/site.css")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	fmt.Println(resp.StatusCode, resp.Header.Get("Content-Type"))
+	fmt.Println(string(body))
+	// Output:
+	// 200 text/css
+	// body { margin: 0 }
+}
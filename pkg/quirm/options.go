@@ -0,0 +1,77 @@
+package quirm
+
+import (
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/hooks"
+	"github.com/CodeTease/quirm/pkg/ratelimit"
+	"github.com/CodeTease/quirm/pkg/storage"
+)
+
+// Option customizes a Server built by NewServer.
+type Option func(*options)
+
+type options struct {
+	storage    storage.StorageProvider
+	cache      cache.CacheProvider
+	limiter    ratelimit.Limiter
+	skipStages []string
+	noTracing  bool
+	hooks      []hooks.ProcessedHook
+	envReload  bool
+}
+
+// WithStorage replaces the S3 backend built from the configuration.
+func WithStorage(p storage.StorageProvider) Option {
+	return func(o *options) {
+		o.storage = p
+	}
+}
+
+// WithCache replaces the memory (and Redis) cache built from the configuration.
+func WithCache(c cache.CacheProvider) Option {
+	return func(o *options) {
+		o.cache = c
+	}
+}
+
+// WithLimiter replaces the rate limiter built from the configuration.
+func WithLimiter(l ratelimit.Limiter) Option {
+	return func(o *options) {
+		o.limiter = l
+	}
+}
+
+// WithoutStages leaves the named request stages (handlers.StageSecurity,
+// handlers.StageRateLimit, ...) out of the asset handler chain, for hosts
+// that already handle them.
+func WithoutStages(stages ...string) Option {
+	return func(o *options) {
+		o.skipStages = append(o.skipStages, stages...)
+	}
+}
+
+// WithProcessedHook runs hook after every processed variant, in the
+// background (see hooks.ProcessedHook). Hooks run in the order they are
+// given, after the PROCESSED_WEBHOOK_URL webhook.
+func WithProcessedHook(hook hooks.ProcessedHook) Option {
+	return func(o *options) {
+		o.hooks = append(o.hooks, hook)
+	}
+}
+
+// WithoutTracerSetup keeps NewServer from installing the global OpenTelemetry
+// tracer provider, for hosts that configure tracing themselves.
+func WithoutTracerSetup() Option {
+	return func(o *options) {
+		o.noTracing = true
+	}
+}
+
+// WithEnvironmentReload lets Reload read the configuration again from the
+// environment (and .env), for a Config built with config.LoadConfig. Without
+// it the Config given to NewServer is all there is, and Reload fails.
+func WithEnvironmentReload() Option {
+	return func(o *options) {
+		o.envReload = true
+	}
+}
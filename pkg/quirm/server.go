@@ -0,0 +1,341 @@
+// Package quirm builds a complete quirm server that can be embedded in
+// another Go program as an http.Handler.
+package quirm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/davidbyttow/govips/v2/vips"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/CodeTease/quirm/pkg/audit"
+	"github.com/CodeTease/quirm/pkg/cache"
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/costlog"
+	"github.com/CodeTease/quirm/pkg/demo"
+	"github.com/CodeTease/quirm/pkg/handlers"
+	"github.com/CodeTease/quirm/pkg/hooks"
+	"github.com/CodeTease/quirm/pkg/metrics"
+	"github.com/CodeTease/quirm/pkg/notify"
+	"github.com/CodeTease/quirm/pkg/peers"
+	"github.com/CodeTease/quirm/pkg/processor"
+	"github.com/CodeTease/quirm/pkg/ratelimit"
+	"github.com/CodeTease/quirm/pkg/stats"
+	"github.com/CodeTease/quirm/pkg/storage"
+	"github.com/CodeTease/quirm/pkg/telemetry"
+	"github.com/CodeTease/quirm/pkg/token"
+	"github.com/CodeTease/quirm/pkg/warmup"
+	"github.com/CodeTease/quirm/pkg/watermark"
+)
+
+// Config is the server configuration, see config.LoadConfig.
+type Config = config.Config
+
+// Server is a fully wired quirm instance.
+type Server struct {
+	// Handler gives access to the underlying handlers, e.g. to mount
+	// individual endpoints elsewhere.
+	Handler *handlers.Handler
+
+	cfgManager     *config.Manager
+	mux            *http.ServeMux
+	root           http.Handler
+	shutdownTracer func(context.Context) error
+	demo           bool
+}
+
+// NewServer builds the storage backend, caches, rate limiter and handlers
+// for cfg and starts libvips. Call Close when the server is no longer needed.
+func NewServer(cfg Config, opts ...Option) (*Server, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	if cfg.DemoMode {
+		var err error
+		if cfg, err = applyDemo(cfg, &o); err != nil {
+			return nil, fmt.Errorf("failed to start demo mode: %w", err)
+		}
+	}
+
+	// Setup fonts
+	if err := config.SetupFonts(); err != nil {
+		slog.Warn("Failed to setup fonts", "error", err)
+	}
+
+	// Initialize libvips
+	vips.Startup(nil)
+
+	s := &Server{
+		cfgManager: config.NewManagerWithConfig(cfg),
+		mux:        http.NewServeMux(),
+		demo:       cfg.DemoMode,
+	}
+	if o.envReload {
+		s.cfgManager = config.NewManagerFromEnvironment(cfg)
+	}
+
+	// Initialize Tracing
+	if !o.noTracing {
+		shutdownTracer, err := telemetry.InitTracer(context.Background(), "quirm")
+		if err != nil {
+			slog.Warn("Failed to initialize tracer", "error", err)
+		} else {
+			s.shutdownTracer = shutdownTracer
+			slog.Info("Tracing initialized")
+		}
+	}
+
+	if err := s.build(cfg, o); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Server) build(cfg Config, o options) error {
+	if _, err := os.Stat(cfg.CacheDir); os.IsNotExist(err) {
+		os.MkdirAll(cfg.CacheDir, 0755)
+	}
+
+	// Initialize components
+	if cfg.FaceFinderPath != "" {
+		if err := processor.LoadCascade(cfg.FaceFinderPath); err != nil {
+			slog.Error("Face detection disabled: FACE_FINDER_PATH is not a usable pigo cascade; focus=face and focus=faces fall back to center crops", "error", err)
+		}
+	}
+
+	processor.SetFFmpegLimit(cfg.MaxConcurrentFFmpeg, cfg.FFmpegQueueTimeout)
+
+	if cfg.AIModelPath != "" {
+		if _, err := os.Stat(cfg.AIModelPath); err != nil {
+			return fmt.Errorf("AI model configured but file not found at %s: %w", cfg.AIModelPath, err)
+		}
+	}
+
+	wmManager := watermark.NewManager(cfg.WatermarkPath, cfg.WatermarkOpacity, cfg.Debug)
+
+	// Access counts rank disk entries for LFU eviction
+	var access *cache.AccessStats
+	if cfg.CacheEvictionPolicy == cache.EvictLFU {
+		access = cache.NewAccessStats()
+		statsPath := filepath.Join(cfg.CacheDir, cache.AccessStatsFile)
+		if err := access.Load(statsPath); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to load access stats, evicting by LRU until new ones are recorded", "path", statsPath, "error", err)
+		}
+	}
+	go cache.StartCleaner(cfg.CacheDir, cache.CleanerOptions{
+		HardTTL:  cfg.CacheHardTTL,
+		Interval: cfg.CleanupInterval,
+		MaxSize:  cfg.CacheMaxSizeMB * 1024 * 1024,
+		Policy:   cfg.CacheEvictionPolicy,
+		Access:   access,
+	})
+
+	storageProvider := o.storage
+	if storageProvider == nil {
+		startupCtx, cancelStartup := context.WithTimeout(context.Background(), 10*time.Second)
+		var err error
+		storageProvider, err = storage.New(startupCtx, cfg)
+		cancelStartup()
+		if err != nil {
+			return fmt.Errorf("failed to initialize storage: %w", err)
+		}
+	}
+
+	// Initialize caches
+	cacheProvider := o.cache
+	if cacheProvider == nil {
+		memoryCache := cache.NewMemoryCacheWithOptions(cache.MemoryCacheOptions{
+			Size:         cfg.MemoryCacheSize,
+			LimitBytes:   cfg.MemoryCacheLimitBytes,
+			DefaultTTL:   cfg.MemoryCacheTTL,
+			AvgItemBytes: cfg.MemoryCacheAvgItemBytes,
+			Metrics:      cfg.MemoryCacheMetrics,
+		})
+		if cfg.RedisAddr != "" {
+			redisAddrs := strings.Split(cfg.RedisAddr, ",")
+			redisCache := cache.NewRedisCache(redisAddrs, cfg.RedisPassword, cfg.RedisDB, cfg.RedisCacheTTL)
+			cacheProvider = cache.NewTieredCache(memoryCache, redisCache)
+			slog.Info("Initialized Tiered Cache (Memory + Redis)")
+		} else {
+			cacheProvider = memoryCache
+			slog.Info("Initialized Memory Cache")
+		}
+	}
+
+	// Initialize Rate Limiter
+	limiter := o.limiter
+	if limiter == nil && cfg.RateLimit > 0 {
+		if cfg.RedisAddr != "" {
+			redisAddrs := strings.Split(cfg.RedisAddr, ",")
+			limiter = ratelimit.NewRedisLimiter(redisAddrs, cfg.RedisPassword, cfg.RedisDB, cfg.RateLimit)
+			slog.Info("Initialized Redis Rate Limiter")
+		} else {
+			limiter = ratelimit.NewMemoryLimiter(cfg.RateLimit, 10000, time.Hour)
+			slog.Info("Initialized Memory Rate Limiter")
+		}
+	}
+
+	// Compile AllowedDomains Regex
+	var allowedDomainsRegex []*regexp.Regexp
+	for _, d := range cfg.AllowedDomains {
+		if strings.HasPrefix(d, "^") {
+			re, err := regexp.Compile(d)
+			if err != nil {
+				slog.Error("Invalid regex in allowed domains", "regex", d, "error", err)
+				continue
+			}
+			allowedDomainsRegex = append(allowedDomainsRegex, re)
+		}
+	}
+
+	h := &handlers.Handler{
+		ConfigManager:       s.cfgManager,
+		S3:                  storageProvider,
+		WM:                  wmManager,
+		Group:               &singleflight.Group{},
+		CacheDir:            cfg.CacheDir,
+		Cache:               cacheProvider,
+		Limiter:             limiter,
+		AllowedDomainsRegex: allowedDomainsRegex,
+	}
+
+	if cfg.RedisAddr != "" && cfg.RefreshLock {
+		h.RefreshLock = cache.NewRedisLocker(strings.Split(cfg.RedisAddr, ","), cfg.RedisPassword, cfg.RedisDB)
+		slog.Info("Initialized Redis Refresh Lock")
+	}
+
+	if cfg.CostLogSampleRate > 0 {
+		h.Costs = costlog.New(cfg.CostLogSampleRate, cfg.CostLogSize, cfg.CostLogAnonymize, cfg.CostLogSlog)
+		slog.Info("Cost log enabled", "sample_rate", cfg.CostLogSampleRate)
+	}
+
+	switch cfg.StatsBackend {
+	case "redis":
+		h.Stats = stats.NewRedis(strings.Split(cfg.RedisAddr, ","), cfg.RedisPassword, cfg.RedisDB, cfg.StatsMaxKeys, 10*time.Second)
+		slog.Info("Request stats enabled", "backend", "redis")
+	case "memory":
+		h.Stats = stats.NewMemory(cfg.StatsMaxKeys)
+		slog.Info("Request stats enabled", "backend", "memory")
+	}
+
+	if cfg.ErrorWebhookURL != "" {
+		h.Notifier = notify.New(notify.Options{
+			URL:       cfg.ErrorWebhookURL,
+			Threshold: cfg.ErrorWebhookThreshold,
+			Window:    cfg.ErrorWebhookWindow,
+			Interval:  cfg.ErrorWebhookInterval,
+		})
+		slog.Info("Error webhook enabled", "threshold", cfg.ErrorWebhookThreshold, "window", cfg.ErrorWebhookWindow)
+	}
+
+	processedHooks := o.hooks
+	if cfg.ProcessedWebhookURL != "" {
+		processedHooks = append([]hooks.ProcessedHook{hooks.NewWebhook(cfg.ProcessedWebhookURL, cfg.ProcessedWebhookTimeout)}, processedHooks...)
+		slog.Info("Processed webhook enabled")
+	}
+	if len(processedHooks) > 0 {
+		h.Hooks = hooks.NewDispatcher(processedHooks, cfg.ProcessedHookWorkers, cfg.ProcessedHookQueueSize)
+	}
+
+	if cfg.AuditLog || cfg.AuditLogPath != "" {
+		auditLogger := slog.Default()
+		if cfg.AuditLogPath != "" {
+			f, err := os.OpenFile(cfg.AuditLogPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+			if err != nil {
+				return fmt.Errorf("failed to open audit log: %w", err)
+			}
+			auditLogger = slog.New(slog.NewJSONHandler(f, nil))
+		}
+		h.Audit = audit.New(auditLogger, cfg.AuditLogSize)
+		slog.Info("Audit log enabled", "path", cfg.AuditLogPath)
+	}
+
+	h.Disk = cache.NewDiskMonitor(cfg.CacheDir)
+	h.Disk.Probe()
+	h.Peers = peers.NewRouter()
+	h.Variants = cache.NewVariants()
+	h.Access = access
+	h.Tokens = token.NewVerifier(cfg.AuthTokenCacheTTL)
+	go h.Disk.Run(cfg.DiskProbeInterval)
+
+	h.Warmup = warmup.NewQueue(h.Warm, cfg.WarmupConcurrency, cfg.WarmupQueueSize, cfg.WarmupHistorySize, cfg.WarmupRetention, 5*time.Minute)
+	s.Handler = h
+
+	if cfg.EnableMetrics {
+		metrics.Init()
+		s.mux.Handle("/metrics", promhttp.Handler())
+		slog.Info("Metrics enabled at /metrics")
+	}
+
+	s.mux.Handle("/", h.Chain(o.skipStages...))
+	if cfg.DemoMode {
+		s.mux.HandleFunc("/{$}", demo.HandleIndex)
+	}
+	s.mux.HandleFunc("/warmup", h.HandleWarmup)
+	s.mux.HandleFunc("/warmup/status", h.HandleWarmupStatus)
+	s.mux.HandleFunc("/_debug/costs", h.HandleCosts)
+	s.mux.HandleFunc("/_stats/top", h.HandleStatsTop)
+	s.mux.HandleFunc("/_stats/presets", h.HandleStatsPresets)
+	s.mux.HandleFunc("/_audit/recent", h.HandleAuditRecent)
+	s.mux.HandleFunc("/_variants/", h.HandleVariants)
+	s.mux.HandleFunc("/_capabilities", h.HandleCapabilities)
+	s.mux.HandleFunc("/_admin/cache/flush", h.HandleCacheFlush)
+	s.mux.HandleFunc("/_admin/ratelimit/reset", h.HandleRateLimitReset)
+	s.mux.HandleFunc("/_admin/watermark/reload", h.HandleWatermarkReload)
+	s.mux.HandleFunc("/_info/", h.HandleInfo)
+	s.mux.HandleFunc("/_analyze", h.HandleAnalyze)
+	s.mux.HandleFunc("/_playground", h.HandlePlayground)
+	s.mux.HandleFunc("/_playground/sign", h.HandlePlaygroundSign)
+	s.mux.Handle("/_placeholder/", h.PlaceholderChain(o.skipStages...))
+	s.mux.HandleFunc("/health", h.HandleHealth)
+	s.mux.HandleFunc("/version", h.HandleVersion)
+	s.mux.HandleFunc("/_selftest", h.HandleSelfTest)
+	s.root = h.WithLimits(s.mux)
+
+	return nil
+}
+
+// ServeHTTP serves assets and the operational endpoints (/health, /warmup, ...).
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.root.ServeHTTP(w, r)
+}
+
+// Reload re-reads the configuration from the environment (and .env). Only
+// servers built with WithEnvironmentReload can: a Config given
+// programmatically is kept, and Reload returns config.ErrNoReloadSource.
+func (s *Server) Reload() error {
+	if s.demo {
+		return errors.New("configuration reload is not supported in demo mode")
+	}
+	return s.cfgManager.Reload()
+}
+
+// Close flushes traces and shuts libvips down. libvips cannot be restarted
+// afterwards, so Close should only be called when the process is done
+// serving images.
+func (s *Server) Close() error {
+	var err error
+	if s.shutdownTracer != nil {
+		err = s.shutdownTracer(context.Background())
+		s.shutdownTracer = nil
+	}
+	vips.Shutdown()
+	return err
+}
@@ -10,6 +10,9 @@ import (
 
 type Limiter interface {
 	Allow(key string) bool
+	// Reset forgets the requests counted for key, so it starts over with
+	// a full allowance
+	Reset(key string) error
 }
 
 type MemoryLimiter struct {
@@ -45,3 +48,8 @@ func (m *MemoryLimiter) Allow(key string) bool {
 	}
 	return limiter.Allow()
 }
+
+func (m *MemoryLimiter) Reset(key string) error {
+	m.limiters.Remove(key)
+	return nil
+}
@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis answers the commands RedisLimiter sends, as a client hook, so no
+// server is needed. The window never slides: a key counts every request
+// since it was last deleted.
+type fakeRedis struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (f *fakeRedis) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (f *fakeRedis) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		args := cmd.Args()
+		switch strings.ToLower(cmd.Name()) {
+		case "eval":
+			// EVAL script numkeys key limit now window expire
+			key := args[3].(string)
+			limit, _ := args[4].(int)
+			if f.counts[key] >= int64(limit) {
+				cmd.(*redis.Cmd).SetVal(int64(0))
+				break
+			}
+			f.counts[key]++
+			cmd.(*redis.Cmd).SetVal(int64(1))
+		case "del":
+			var n int64
+			for _, arg := range args[1:] {
+				if _, ok := f.counts[arg.(string)]; ok {
+					delete(f.counts, arg.(string))
+					n++
+				}
+			}
+			cmd.(*redis.IntCmd).SetVal(n)
+		default:
+			cmd.SetErr(fmt.Errorf("unexpected command %s", cmd.Name()))
+		}
+		return cmd.Err()
+	}
+}
+
+func (f *fakeRedis) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestReset(t *testing.T) {
+	const limit = 3
+	tests := []struct {
+		name    string
+		limiter func(t *testing.T) Limiter
+	}{
+		{
+			name: "memory",
+			limiter: func(t *testing.T) Limiter {
+				// A rate this low refills nothing while the test runs
+				l := NewMemoryLimiter(limit, 100, time.Hour)
+				l.r = 0.001
+				return l
+			},
+		},
+		{
+			name: "redis",
+			limiter: func(t *testing.T) Limiter {
+				l := NewRedisLimiter([]string{"127.0.0.1:0"}, "", 0, limit)
+				l.client.AddHook(&fakeRedis{counts: map[string]int64{}})
+				return l
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := tt.limiter(t)
+			exhaust := func(key string) {
+				t.Helper()
+				for i := range limit {
+					if !l.Allow(key) {
+						t.Fatalf("%s: request %d refused within the limit", key, i+1)
+					}
+				}
+				if l.Allow(key) {
+					t.Fatalf("%s: request beyond the limit allowed", key)
+				}
+			}
+			exhaust("192.0.2.1")
+			exhaust("192.0.2.2")
+
+			if err := l.Reset("192.0.2.1"); err != nil {
+				t.Fatalf("Reset() = %v", err)
+			}
+			// The reset key starts over with a full allowance, the other
+			// stays limited
+			exhaust("192.0.2.1")
+			if l.Allow("192.0.2.2") {
+				t.Error("Reset() cleared another key")
+			}
+			if err := l.Reset("192.0.2.3"); err != nil {
+				t.Errorf("Reset() of an unknown key = %v", err)
+			}
+		})
+	}
+}
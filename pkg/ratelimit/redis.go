@@ -76,3 +76,7 @@ func (r *RedisLimiter) Allow(key string) bool {
 
 	return val == 1
 }
+
+func (r *RedisLimiter) Reset(key string) error {
+	return r.client.Del(context.Background(), "ratelimit:"+key).Err()
+}
@@ -0,0 +1,145 @@
+// Package sign generates the URL signatures quirm verifies when SECRET_KEY
+// is set. Clients written in Go can import it instead of reimplementing the
+// canonicalization.
+package sign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature returns the hex HMAC-SHA256 over path and the params sorted by
+// key, e.g. "/images/a.jpg?h=100&w=200". path is unescaped ("/a b.jpg",
+// not "/a%20b.jpg") and cleaned as by path.Clean; non-ASCII characters are
+// used as they are. Values are used unescaped, only the first value of a
+// repeated key is covered, and the "s" parameter itself is left out.
+func Signature(secret, path string, params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k == "s" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(path)
+	if len(keys) > 0 {
+		b.WriteString("?")
+	}
+	for i, k := range keys {
+		b.WriteString(k)
+		b.WriteString("=")
+		b.WriteString(params.Get(k))
+		if i < len(keys)-1 {
+			b.WriteString("&")
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(b.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// versionSegment matches the content hash segment of an immutable URL.
+var versionSegment = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// SplitVersion separates the leading content hash segment of an immutable
+// URL ("/<hash>/path/to/img.jpg") from the rest of the path. It returns an
+// empty version when the path has none.
+func SplitVersion(urlPath string) (string, string) {
+	segment, rest, found := strings.Cut(strings.TrimPrefix(urlPath, "/"), "/")
+	if !found || rest == "" || !versionSegment.MatchString(segment) {
+		return "", urlPath
+	}
+	return segment, "/" + rest
+}
+
+// Path returns the path a signature covers for the decoded request path
+// urlPath: cleaned as by path.Clean and, with IMMUTABLE_URLS enabled
+// (immutable), without the content hash segment, so a signed URL stays
+// valid when the object changes.
+func Path(urlPath string, immutable bool) string {
+	if immutable {
+		_, urlPath = SplitVersion(urlPath)
+	}
+	return path.Clean("/" + urlPath)
+}
+
+// SignURL returns path with params in canonical form, the expiry (unless
+// expires is zero) and the signature as an escaped, relative URL ready to be
+// appended to the server's origin. params is not modified.
+func SignURL(secret, path string, params url.Values, expires time.Time) string {
+	query := Canonical(params)
+	if !expires.IsZero() {
+		query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	}
+	query.Set("s", Signature(secret, path, query))
+
+	u := url.URL{Path: path, RawQuery: query.Encode()}
+	return u.String()
+}
+
+// lowercaseParams hold keywords whose case carries no meaning.
+var lowercaseParams = map[string]bool{
+	"fit": true, "format": true, "focus": true, "effect": true, "neg": true,
+	"bg": true,
+}
+
+// flagParams are enabled by "true" or "1"; any other value is the default.
+var flagParams = map[string]bool{
+	"blurhash": true, "animated": true, "boomerang": true, "static": true,
+	"optimize": true, "force": true, "auto_gray": true,
+}
+
+// trueOnlyParams are enabled by "true" only.
+var trueOnlyParams = map[string]bool{
+	"palette": true, "videocard": true, "original": true,
+}
+
+// sizeParams are unset when zero or negative.
+var sizeParams = map[string]bool{
+	"w": true, "h": true, "q": true, "page": true,
+}
+
+// Canonical returns params in the canonical form quirm redirects to with
+// CANONICALIZE_URLS=redirect: one value per key, keywords lowercased, flags
+// spelled "true", and parameters that select the default dropped. The "s"
+// parameter is dropped as well, since normalizing may change what it
+// covers. Encode the result to get the sorted, canonical query string.
+func Canonical(params url.Values) url.Values {
+	canonical := make(url.Values, len(params))
+	for k := range params {
+		v := params.Get(k)
+		switch {
+		case k == "s" || v == "":
+			continue
+		case lowercaseParams[k]:
+			v = strings.ToLower(v)
+		case flagParams[k]:
+			if v != "true" && v != "1" {
+				continue
+			}
+			v = "true"
+		case trueOnlyParams[k]:
+			if v != "true" {
+				continue
+			}
+		case sizeParams[k]:
+			if n, err := strconv.Atoi(v); err == nil && n <= 0 {
+				continue
+			}
+		}
+		canonical.Set(k, v)
+	}
+	return canonical
+}
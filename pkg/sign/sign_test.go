@@ -0,0 +1,24 @@
+package sign
+
+import "testing"
+
+func TestPath(t *testing.T) {
+	tests := []struct {
+		urlPath   string
+		immutable bool
+		want      string
+	}{
+		{"/photos/a.jpg", false, "/photos/a.jpg"},
+		{"photos//x/../a.jpg", false, "/photos/a.jpg"},
+		{"/0123456789abcdef/photos/a.jpg", false, "/0123456789abcdef/photos/a.jpg"},
+		{"/0123456789abcdef/photos/a.jpg", true, "/photos/a.jpg"},
+		{"/0123456789abcdef", true, "/0123456789abcdef"},
+		{"/0123456789ABCDEF/photos/a.jpg", true, "/0123456789ABCDEF/photos/a.jpg"},
+		{"/photos/a.jpg", true, "/photos/a.jpg"},
+	}
+	for _, tt := range tests {
+		if got := Path(tt.urlPath, tt.immutable); got != tt.want {
+			t.Errorf("Path(%q, %v) = %q, want %q", tt.urlPath, tt.immutable, got, tt.want)
+		}
+	}
+}
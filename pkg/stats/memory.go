@@ -0,0 +1,75 @@
+package stats
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Ensure Memory implements Recorder
+var _ Recorder = (*Memory)(nil)
+
+// Memory keeps the daily buckets in process. Each instance only sees the
+// requests it served itself.
+type Memory struct {
+	maxKeys int
+
+	mu      sync.Mutex
+	buckets map[string]*table
+}
+
+// NewMemory returns a recorder tracking at most maxKeys keys per day.
+func NewMemory(maxKeys int) *Memory {
+	return &Memory{maxKeys: maxKeys, buckets: make(map[string]*table)}
+}
+
+func (m *Memory) Record(key string, c Counters) {
+	now := time.Now()
+	today := day(now)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[today]
+	if !ok {
+		b = newTable(m.maxKeys)
+		m.buckets[today] = b
+		m.expire(now)
+	}
+	b.add(key, c)
+}
+
+// expire drops the buckets that fell out of the retention window.
+func (m *Memory) expire(now time.Time) {
+	keep := make(map[string]bool, Days)
+	for _, d := range lastDays(now, Days) {
+		keep[d] = true
+	}
+	for d := range m.buckets {
+		if !keep[d] {
+			delete(m.buckets, d)
+		}
+	}
+}
+
+func (m *Memory) Top(_ context.Context, by string, limit, days int) ([]Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	merged := make(map[string]*Counters)
+	for _, d := range lastDays(time.Now(), days) {
+		b, ok := m.buckets[d]
+		if !ok {
+			continue
+		}
+		for k, c := range b.counts {
+			if cur, ok := merged[k]; ok {
+				cur.add(*c)
+			} else {
+				sum := *c
+				merged[k] = &sum
+			}
+		}
+	}
+	return top(merged, by, limit), nil
+}
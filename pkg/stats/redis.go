@@ -0,0 +1,143 @@
+package stats
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Ensure Redis implements Recorder
+var _ Recorder = (*Redis)(nil)
+
+const redisKeyPrefix = "quirm:stats:"
+
+// Redis aggregates requests locally and flushes them periodically into one
+// hash per day and metric ("quirm:stats:2024-05-01:requests", field = object
+// key), shared by every instance using the same Redis.
+type Redis struct {
+	client  redis.UniversalClient
+	maxKeys int
+
+	mu      sync.Mutex
+	pending *table
+}
+
+// NewRedis returns a recorder flushing every interval. maxKeys bounds both
+// the local buffer and, loosely, the keys stored per day: once the day's hash
+// holds maxKeys keys, keys requested only once within an interval are no
+// longer added.
+func NewRedis(addrs []string, password string, db int, maxKeys int, interval time.Duration) *Redis {
+	r := &Redis{
+		client: redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs:    addrs,
+			Password: password,
+			DB:       db,
+		}),
+		maxKeys: maxKeys,
+		pending: newTable(maxKeys),
+	}
+	go r.run(interval)
+	return r
+}
+
+func (r *Redis) Record(key string, c Counters) {
+	r.mu.Lock()
+	r.pending.add(key, c)
+	r.mu.Unlock()
+}
+
+func (r *Redis) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if err := r.Flush(ctx); err != nil {
+			slog.Warn("Failed to flush request stats", "error", err)
+		}
+		cancel()
+	}
+}
+
+// Flush writes the locally aggregated counters to Redis with pipelined
+// HINCRBYs. Counters that fail to write are dropped, stats are best effort.
+func (r *Redis) Flush(ctx context.Context) error {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = newTable(r.maxKeys)
+	r.mu.Unlock()
+
+	if len(pending.counts) == 0 {
+		return nil
+	}
+
+	today := day(time.Now())
+	hashKey := func(metric string) string { return redisKeyPrefix + today + ":" + metric }
+
+	full := false
+	if n, err := r.client.HLen(ctx, hashKey(Requests)).Result(); err == nil && n >= int64(r.maxKeys) {
+		full = true
+	}
+
+	// A bucket is kept one day longer than it can be queried
+	ttl := time.Duration(Days+1) * 24 * time.Hour
+	pipe := r.client.Pipeline()
+	for key, c := range pending.counts {
+		if full && c.Requests < 2 {
+			continue
+		}
+		for _, metric := range allMetrics {
+			if v := c.get(metric); v != 0 {
+				pipe.HIncrBy(ctx, hashKey(metric), key, v)
+			}
+		}
+	}
+	for _, metric := range allMetrics {
+		pipe.Expire(ctx, hashKey(metric), ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *Redis) Top(ctx context.Context, by string, limit, days int) ([]Entry, error) {
+	pipe := r.client.Pipeline()
+	var cmds []*redis.MapStringStringCmd
+	for _, d := range lastDays(time.Now(), days) {
+		for _, metric := range allMetrics {
+			cmds = append(cmds, pipe.HGetAll(ctx, redisKeyPrefix+d+":"+metric))
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	merged := make(map[string]*Counters)
+	for i, cmd := range cmds {
+		metric := allMetrics[i%len(allMetrics)]
+		for key, raw := range cmd.Val() {
+			v, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				continue
+			}
+			c, ok := merged[key]
+			if !ok {
+				c = &Counters{}
+				merged[key] = c
+			}
+			switch metric {
+			case Requests:
+				c.Requests += v
+			case Misses:
+				c.Misses += v
+			case ProcessingMS:
+				c.ProcessingMS += v
+			case Bytes:
+				c.Bytes += v
+			}
+		}
+	}
+	return top(merged, by, limit), nil
+}
@@ -0,0 +1,158 @@
+// Package stats counts requests per object key in daily buckets, so the most
+// requested and most expensive keys of the last days can be listed for
+// capacity planning.
+package stats
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Days is how many daily buckets are kept and can be queried.
+const Days = 7
+
+// Metrics a top list can be ranked by.
+const (
+	Requests     = "requests"
+	Misses       = "misses"
+	ProcessingMS = "processing_ms"
+	Bytes        = "bytes"
+)
+
+var allMetrics = []string{Requests, Misses, ProcessingMS, Bytes}
+
+// ValidMetric reports whether by names a counter Top can rank by.
+func ValidMetric(by string) bool {
+	switch by {
+	case Requests, Misses, ProcessingMS, Bytes:
+		return true
+	}
+	return false
+}
+
+// Counters are the per-key totals of a bucket.
+type Counters struct {
+	Requests     int64 `json:"requests"`
+	Misses       int64 `json:"misses"`
+	ProcessingMS int64 `json:"processing_ms"`
+	Bytes        int64 `json:"bytes"`
+}
+
+func (c *Counters) add(o Counters) {
+	c.Requests += o.Requests
+	c.Misses += o.Misses
+	c.ProcessingMS += o.ProcessingMS
+	c.Bytes += o.Bytes
+}
+
+func (c Counters) get(by string) int64 {
+	switch by {
+	case Misses:
+		return c.Misses
+	case ProcessingMS:
+		return c.ProcessingMS
+	case Bytes:
+		return c.Bytes
+	}
+	return c.Requests
+}
+
+// Entry is one key of a top list.
+type Entry struct {
+	Key string `json:"key"`
+	Counters
+}
+
+// Recorder records served requests and lists the top keys.
+type Recorder interface {
+	// Record adds c to the counters of key in today's bucket.
+	Record(key string, c Counters)
+	// Top returns up to limit keys of the last days buckets (today
+	// included), ranked by the given metric.
+	Top(ctx context.Context, by string, limit, days int) ([]Entry, error)
+}
+
+// day names the bucket t falls in.
+func day(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// lastDays returns the bucket names of the last n days, today first.
+func lastDays(now time.Time, n int) []string {
+	if n < 1 || n > Days {
+		n = Days
+	}
+	out := make([]string, n)
+	for i := range out {
+		out[i] = day(now.AddDate(0, 0, -i))
+	}
+	return out
+}
+
+// table holds the counters of at most maxKeys keys. When it overflows, the
+// half with the fewest requests is dropped: popular keys stay exact while the
+// long tail of one-off keys cannot grow it without bound.
+type table struct {
+	maxKeys int
+	counts  map[string]*Counters
+}
+
+func newTable(maxKeys int) *table {
+	if maxKeys < 2 {
+		maxKeys = 2
+	}
+	return &table{maxKeys: maxKeys, counts: make(map[string]*Counters)}
+}
+
+func (t *table) add(key string, c Counters) {
+	if cur, ok := t.counts[key]; ok {
+		cur.add(c)
+		return
+	}
+	if len(t.counts) >= t.maxKeys {
+		t.prune()
+	}
+	t.counts[key] = &c
+}
+
+func (t *table) prune() {
+	entries := t.entries()
+	sortEntries(entries, Requests)
+	for _, e := range entries[t.maxKeys/2:] {
+		delete(t.counts, e.Key)
+	}
+}
+
+func (t *table) entries() []Entry {
+	out := make([]Entry, 0, len(t.counts))
+	for k, c := range t.counts {
+		out = append(out, Entry{Key: k, Counters: *c})
+	}
+	return out
+}
+
+// sortEntries orders entries by the given metric, highest first, with the
+// key breaking ties so the order is stable across calls.
+func sortEntries(entries []Entry, by string) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].get(by), entries[j].get(by)
+		if a != b {
+			return a > b
+		}
+		return entries[i].Key < entries[j].Key
+	})
+}
+
+// top merges per-day totals and returns the first limit entries by metric.
+func top(merged map[string]*Counters, by string, limit int) []Entry {
+	entries := make([]Entry, 0, len(merged))
+	for k, c := range merged {
+		entries = append(entries, Entry{Key: k, Counters: *c})
+	}
+	sortEntries(entries, by)
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// customDomainMiddleware rewrites path-style requests ("/<bucket>/<key>")
+// into requests for a custom domain that serves the bucket at its root
+// ("/<key>"). It runs in the finalize step right after endpoint resolution,
+// which is what adds the bucket segment, and before signing, so that both
+// regular and presigned requests are signed for the rewritten path.
+func customDomainMiddleware(buckets ...string) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("QuirmCustomDomain",
+			func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (
+				middleware.FinalizeOutput, middleware.Metadata, error,
+			) {
+				if req, ok := in.Request.(*smithyhttp.Request); ok {
+					for _, bucket := range buckets {
+						if bucket == "" {
+							continue
+						}
+						if path, ok := stripBucketSegment(req.URL.Path, bucket); ok {
+							req.URL.Path = path
+							if req.URL.RawPath != "" {
+								req.URL.RawPath, _ = stripBucketSegment(req.URL.RawPath, bucket)
+							}
+							break
+						}
+					}
+				}
+				return next.HandleFinalize(ctx, in)
+			}),
+			"ResolveEndpointV2", middleware.After,
+		)
+	}
+}
+
+// stripBucketSegment removes a leading "/<bucket>" path segment. Only a whole
+// segment matches, so keys that merely start with the bucket name (e.g.
+// "/media/media-2024.jpg" for bucket "media") keep their own first segment.
+func stripBucketSegment(path, bucket string) (string, bool) {
+	prefix := "/" + bucket
+	if !strings.HasPrefix(path, prefix) {
+		return path, false
+	}
+	rest := path[len(prefix):]
+	if rest == "" {
+		return "/", true
+	}
+	if rest[0] != '/' {
+		return path, false
+	}
+	return rest, true
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	appConfig "github.com/CodeTease/quirm/pkg/config"
+)
+
+// captureClient records the requests the SDK sends and answers them with an
+// empty 200.
+type captureClient struct {
+	requests []*http.Request
+}
+
+func (c *captureClient) Do(req *http.Request) (*http.Response, error) {
+	c.requests = append(c.requests, req)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+func testS3Client(cfg appConfig.Config, httpClient s3.HTTPClient) *s3.Client {
+	return s3.New(s3.Options{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		HTTPClient:  httpClient,
+	}, func(o *s3.Options) {
+		configureS3Options(o, cfg)
+	})
+}
+
+func TestS3RequestAddressing(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      appConfig.Config
+		bucket   string
+		key      string
+		wantHost string
+		wantPath string
+	}{
+		{
+			name:     "virtual-hosted",
+			cfg:      appConfig.Config{S3Bucket: "media"},
+			bucket:   "media",
+			key:      "photos/a.jpg",
+			wantHost: "media.s3.us-east-1.amazonaws.com",
+			wantPath: "/photos/a.jpg",
+		},
+		{
+			name:     "path-style endpoint",
+			cfg:      appConfig.Config{S3Bucket: "media", S3Endpoint: "http://minio:9000", S3ForcePathStyle: true},
+			bucket:   "media",
+			key:      "photos/a.jpg",
+			wantHost: "minio:9000",
+			wantPath: "/media/photos/a.jpg",
+		},
+		{
+			name:     "custom domain",
+			cfg:      appConfig.Config{S3Bucket: "media", S3Endpoint: "https://cdn.example.com", S3UseCustomDomain: true},
+			bucket:   "media",
+			key:      "photos/a.jpg",
+			wantHost: "cdn.example.com",
+			wantPath: "/photos/a.jpg",
+		},
+		{
+			name:     "custom domain, key starting with the bucket name",
+			cfg:      appConfig.Config{S3Bucket: "media", S3Endpoint: "https://cdn.example.com", S3UseCustomDomain: true},
+			bucket:   "media",
+			key:      "media-2024.jpg",
+			wantHost: "cdn.example.com",
+			wantPath: "/media-2024.jpg",
+		},
+		{
+			name:     "custom domain, key with the bucket as first segment",
+			cfg:      appConfig.Config{S3Bucket: "media", S3Endpoint: "https://cdn.example.com", S3UseCustomDomain: true},
+			bucket:   "media",
+			key:      "media/a.jpg",
+			wantHost: "cdn.example.com",
+			wantPath: "/media/a.jpg",
+		},
+		{
+			name:     "custom domain, escaped key",
+			cfg:      appConfig.Config{S3Bucket: "media", S3Endpoint: "https://cdn.example.com", S3UseCustomDomain: true},
+			bucket:   "media",
+			key:      "photos/summer 2024/café+1.jpg",
+			wantHost: "cdn.example.com",
+			wantPath: "/photos/summer%202024/caf%C3%A9%2B1.jpg",
+		},
+		{
+			name:     "custom domain, backup bucket",
+			cfg:      appConfig.Config{S3Bucket: "media", S3BackupBucket: "media-backup", S3Endpoint: "https://cdn.example.com", S3UseCustomDomain: true},
+			bucket:   "media-backup",
+			key:      "photos/a.jpg",
+			wantHost: "cdn.example.com",
+			wantPath: "/photos/a.jpg",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			capture := &captureClient{}
+			client := testS3Client(tt.cfg, capture)
+			input := &s3.GetObjectInput{Bucket: aws.String(tt.bucket), Key: aws.String(tt.key)}
+
+			if _, err := client.GetObject(context.Background(), input); err != nil {
+				t.Fatalf("GetObject: %v", err)
+			}
+			if len(capture.requests) != 1 {
+				t.Fatalf("%d requests sent, want 1", len(capture.requests))
+			}
+			req := capture.requests[0]
+			if req.URL.Host != tt.wantHost || req.URL.EscapedPath() != tt.wantPath {
+				t.Errorf("request to %s%s, want %s%s", req.URL.Host, req.URL.EscapedPath(), tt.wantHost, tt.wantPath)
+			}
+
+			presigned, err := s3.NewPresignClient(client).PresignGetObject(context.Background(), input, func(o *s3.PresignOptions) {
+				o.Expires = time.Hour
+			})
+			if err != nil {
+				t.Fatalf("PresignGetObject: %v", err)
+			}
+			u, err := url.Parse(presigned.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Host != tt.wantHost || u.EscapedPath() != tt.wantPath {
+				t.Errorf("presigned URL for %s%s, want %s%s", u.Host, u.EscapedPath(), tt.wantHost, tt.wantPath)
+			}
+			if u.Query().Get("X-Amz-Signature") == "" {
+				t.Errorf("presigned URL %s is not signed", presigned.URL)
+			}
+		})
+	}
+}
+
+func TestStripBucketSegment(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{path: "/media/a.jpg", want: "/a.jpg", ok: true},
+		{path: "/media", want: "/", ok: true},
+		{path: "/media/media/a.jpg", want: "/media/a.jpg", ok: true},
+		{path: "/media-2024.jpg", want: "/media-2024.jpg"},
+		{path: "/mediafiles/a.jpg", want: "/mediafiles/a.jpg"},
+		{path: "/other/a.jpg", want: "/other/a.jpg"},
+	}
+	for _, tt := range tests {
+		got, ok := stripBucketSegment(tt.path, "media")
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("stripBucketSegment(%q) = %q, %v, want %q, %v", tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+}
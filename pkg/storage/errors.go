@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// ErrNotModified is returned by GetObjectIfNoneMatch when the object still
+// has the given ETag.
+var ErrNotModified = errors.New("object not modified")
+
+// KMSAccessError is returned when the origin denies access to an object
+// because the caller is not allowed to use its SSE-KMS key. It usually points
+// at a key policy problem rather than a missing object or bucket permission.
+type KMSAccessError struct {
+	Key string
+	Err error
+}
+
+func (e *KMSAccessError) Error() string {
+	return fmt.Sprintf("kms access denied for %q: %v", e.Key, e.Err)
+}
+
+func (e *KMSAccessError) Unwrap() error {
+	return e.Err
+}
+
+// IsTransient reports whether err is an origin failure that may not happen
+// again on the next try: throttling, a server error, or a network error such
+// as a connection reset, including while reading a body. Not found, other
+// client errors and cancellations are not transient, nor is any error this
+// package cannot classify.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var respErr *smithyhttp.ResponseError
+	var apiErr smithy.APIError
+	if errors.As(err, &respErr) || errors.As(err, &apiErr) {
+		return retryable(fetchOutcome(err))
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+}
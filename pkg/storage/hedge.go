@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// hedger races a second identical GET against an origin request that has not
+// answered within after. At most maxPercent of the requests it sees are
+// hedged, so a slow origin never doubles its own load.
+type hedger struct {
+	after      time.Duration
+	maxPercent int64
+	requests   atomic.Int64
+	hedges     atomic.Int64
+}
+
+type hedgeAttempt struct {
+	resp   *s3.GetObjectOutput
+	err    error
+	cancel context.CancelFunc
+	hedge  bool
+}
+
+// newHedger returns nil when hedging is disabled.
+func newHedger(after time.Duration, maxPercent int) *hedger {
+	if after <= 0 || maxPercent <= 0 {
+		return nil
+	}
+	return &hedger{after: after, maxPercent: int64(maxPercent)}
+}
+
+// allow reports whether one more hedge stays within the configured share.
+func (h *hedger) allow() bool {
+	for {
+		hedges := h.hedges.Load()
+		if (hedges+1)*100 > h.maxPercent*h.requests.Load() {
+			return false
+		}
+		if h.hedges.CompareAndSwap(hedges, hedges+1) {
+			return true
+		}
+	}
+}
+
+// do runs get, and again in parallel if the first call is still pending after
+// the hedge delay. The first successful response wins; the loser's context is
+// cancelled and its body closed. A nil hedger simply calls get.
+func (h *hedger) do(ctx context.Context, get func(ctx context.Context) (*s3.GetObjectOutput, error)) (*s3.GetObjectOutput, error) {
+	if h == nil {
+		return get(ctx)
+	}
+	h.requests.Add(1)
+
+	results := make(chan hedgeAttempt, 2)
+	// cancels holds the original's cancel func, then the hedge's
+	var cancels []context.CancelFunc
+	launch := func(hedge bool) {
+		// Each attempt owns its context: the body of the winner is read after
+		// do returns, so it is only cancelled once that body is closed.
+		attemptCtx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, err := get(attemptCtx)
+			results <- hedgeAttempt{resp: resp, err: err, cancel: cancel, hedge: hedge}
+		}()
+	}
+
+	launch(false)
+	timer := time.NewTimer(h.after)
+	defer timer.Stop()
+
+	select {
+	case first := <-results:
+		return first.finish()
+	case <-timer.C:
+	}
+	if !h.allow() {
+		first := <-results
+		return first.finish()
+	}
+
+	metrics.OriginHedgesTotal.Inc()
+	launch(true)
+
+	winner := <-results
+	if winner.err != nil {
+		// Give the other attempt its chance before reporting the failure
+		winner.discard()
+		winner = <-results
+	} else {
+		// Stop the loser now instead of when it answers, which frees its
+		// connection; its result is drained in the background
+		loser := cancels[0]
+		if !winner.hedge {
+			loser = cancels[1]
+		}
+		loser()
+		go func() {
+			loser := <-results
+			loser.discard()
+		}()
+	}
+	if winner.err == nil && winner.hedge {
+		metrics.OriginHedgeWinsTotal.Inc()
+	}
+	return winner.finish()
+}
+
+// finish hands the response to the caller, tying the attempt's context to
+// the lifetime of the body.
+func (a hedgeAttempt) finish() (*s3.GetObjectOutput, error) {
+	if a.err != nil {
+		a.cancel()
+		return nil, a.err
+	}
+	a.resp.Body = &cancelOnClose{ReadCloser: a.resp.Body, cancel: a.cancel}
+	return a.resp, nil
+}
+
+// discard releases everything held by an attempt that lost the race.
+func (a hedgeAttempt) discard() {
+	a.cancel()
+	if a.err == nil && a.resp != nil && a.resp.Body != nil {
+		a.resp.Body.Close()
+	}
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	appConfig "github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// slowOrigin is an httptest S3 stub that holds the n-th GET for delays[n]
+// before answering with "attempt n", and counts the requests whose client
+// went away first.
+type slowOrigin struct {
+	delays    []time.Duration
+	requests  atomic.Int32
+	cancelled atomic.Int32
+}
+
+func (o *slowOrigin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := int(o.requests.Add(1)) - 1
+	var delay time.Duration
+	if n < len(o.delays) {
+		delay = o.delays[n]
+	}
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		o.cancelled.Add(1)
+		return
+	}
+	body := fmt.Sprintf("attempt %d", n)
+	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	w.Header().Set("ETag", `"etag"`)
+	io.WriteString(w, body)
+}
+
+func newHedgedClient(t *testing.T, origin *slowOrigin, after time.Duration, maxPercent int) *S3Client {
+	t.Helper()
+	srv := httptest.NewServer(origin)
+	t.Cleanup(srv.Close)
+	cfg := appConfig.Config{S3Bucket: "media", S3Endpoint: srv.URL, S3ForcePathStyle: true}
+	client := testS3Client(cfg, srv.Client())
+	return &S3Client{client: client, background: client, bucket: "media", hedge: newHedger(after, maxPercent)}
+}
+
+func readObject(t *testing.T, s *S3Client) string {
+	t.Helper()
+	body, _, err := s.GetObject(context.Background(), "a.jpg")
+	if err != nil {
+		t.Fatalf("GetObject: %v", err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// waitFor polls cond for up to a second.
+func waitFor(cond func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+func TestHedgeWins(t *testing.T) {
+	origin := &slowOrigin{delays: []time.Duration{5 * time.Second, 0}}
+	s := newHedgedClient(t, origin, 50*time.Millisecond, 100)
+	hedges, wins := testutil.ToFloat64(metrics.OriginHedgesTotal), testutil.ToFloat64(metrics.OriginHedgeWinsTotal)
+
+	start := time.Now()
+	if got := readObject(t, s); got != "attempt 1" {
+		t.Errorf("body %q, want the hedge's", got)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("hedged GET took %v", elapsed)
+	}
+	if got := testutil.ToFloat64(metrics.OriginHedgesTotal) - hedges; got != 1 {
+		t.Errorf("%v hedges counted, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.OriginHedgeWinsTotal) - wins; got != 1 {
+		t.Errorf("%v hedge wins counted, want 1", got)
+	}
+	// The slow attempt is cancelled rather than left holding a connection
+	if !waitFor(func() bool { return origin.cancelled.Load() == 1 }) {
+		t.Errorf("%d attempts cancelled, want the losing one", origin.cancelled.Load())
+	}
+}
+
+func TestHedgeNotNeeded(t *testing.T) {
+	origin := &slowOrigin{}
+	s := newHedgedClient(t, origin, time.Second, 100)
+	hedges := testutil.ToFloat64(metrics.OriginHedgesTotal)
+
+	if got := readObject(t, s); got != "attempt 0" {
+		t.Errorf("body %q, want the first attempt's", got)
+	}
+	if n := origin.requests.Load(); n != 1 {
+		t.Errorf("%d origin requests, want 1", n)
+	}
+	if got := testutil.ToFloat64(metrics.OriginHedgesTotal) - hedges; got != 0 {
+		t.Errorf("%v hedges counted, want 0", got)
+	}
+}
+
+func TestHedgeOriginalWins(t *testing.T) {
+	// The hedge fires but the original answers first
+	origin := &slowOrigin{delays: []time.Duration{100 * time.Millisecond, 5 * time.Second}}
+	s := newHedgedClient(t, origin, 20*time.Millisecond, 100)
+	wins := testutil.ToFloat64(metrics.OriginHedgeWinsTotal)
+
+	if got := readObject(t, s); got != "attempt 0" {
+		t.Errorf("body %q, want the original's", got)
+	}
+	if got := testutil.ToFloat64(metrics.OriginHedgeWinsTotal) - wins; got != 0 {
+		t.Errorf("%v hedge wins counted, want 0", got)
+	}
+	if !waitFor(func() bool { return origin.cancelled.Load() == 1 }) {
+		t.Errorf("%d attempts cancelled, want the hedge", origin.cancelled.Load())
+	}
+}
+
+func TestHedgeBudget(t *testing.T) {
+	h := newHedger(time.Millisecond, 10)
+	allowed := 0
+	for range 100 {
+		h.requests.Add(1)
+		if h.allow() {
+			allowed++
+		}
+	}
+	if allowed != 10 {
+		t.Errorf("%d hedges allowed for 100 requests at 10%%, want 10", allowed)
+	}
+	if newHedger(0, 10) != nil || newHedger(time.Second, 0) != nil {
+		t.Error("hedging enabled without HEDGE_AFTER or HEDGE_MAX_PERCENT")
+	}
+}
@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// Fetch outcomes recorded in quirm_origin_fetch_total and
+// quirm_origin_fetch_duration_seconds.
+const (
+	outcomeOK           = "ok"
+	outcomeNotModified  = "not_modified"
+	outcomeNotFound     = "not_found"
+	outcomeThrottled    = "throttled"
+	outcomeClientError  = "client_error"
+	outcomeServerError  = "server_error"
+	outcomeNetwork      = "network"
+	outcomeBackupOK     = "backup_ok"
+	outcomeBackupFailed = "backup_failed"
+)
+
+// fetchOutcome classifies the result of an origin fetch.
+func fetchOutcome(err error) string {
+	switch {
+	case err == nil:
+		return outcomeOK
+	case errors.Is(err, ErrNotModified) || isNotModified(err):
+		return outcomeNotModified
+	case isNotFound(err):
+		return outcomeNotFound
+	}
+
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		switch status := respErr.Response.StatusCode; {
+		case status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable:
+			return outcomeThrottled
+		case status >= 500:
+			return outcomeServerError
+		case status >= 400:
+			return outcomeClientError
+		}
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded":
+			return outcomeThrottled
+		}
+		if apiErr.ErrorFault() == smithy.FaultServer {
+			return outcomeServerError
+		}
+		return outcomeClientError
+	}
+	return outcomeNetwork
+}
+
+// observeFetch records a fetch attempt against bucket that took d.
+func observeFetch(bucket, outcome string, d time.Duration) {
+	metrics.OriginFetchTotal.WithLabelValues(outcome, bucket).Inc()
+	metrics.OriginFetchDuration.WithLabelValues(outcome, bucket).Observe(d.Seconds())
+}
+
+// operation describes a StorageProvider method for Instrumented.
+type operation struct {
+	method string // span name suffix
+	label  string // "operation" label of the metrics
+	fetch  bool   // transfers an object body, recorded as a fetch
+	retry  bool   // idempotent read that may be retried
+}
+
+var (
+	opGetObject            = operation{"GetObject", "get_object", true, true}
+	opGetObjectIfNoneMatch = operation{"GetObjectIfNoneMatch", "get_object_if_none_match", true, true}
+	opGetObjectRange       = operation{"GetObjectRange", "get_object_range", true, true}
+	opStatObject           = operation{"StatObject", "stat_object", false, true}
+	// ListObjects is not retried: fn may already have seen part of the listing
+	opListObjects = operation{"ListObjects", "list_objects", false, false}
+)
+
+// failoverKey carries the *failover of a fetch through the provider.
+type failoverKey struct{}
+
+// failover is what a provider reports, through reportFailover, about a
+// fetch it retried against a secondary location (S3_BACKUP_BUCKET), so
+// that Instrumented records both attempts.
+type failover struct {
+	primaryErr error
+	backup     string // label of the secondary location
+	backupErr  error
+	duration   time.Duration // of the backup attempt
+}
+
+// reportFailover records fo for the Instrumented call ctx belongs to, if any.
+func reportFailover(ctx context.Context, fo failover) {
+	if report, ok := ctx.Value(failoverKey{}).(*failover); ok {
+		*report = fo
+	}
+}
+
+// InstrumentOption configures Instrumented.
+type InstrumentOption func(*instrumented)
+
+// WithSlowThreshold logs calls that take longer than d (0 disables).
+func WithSlowThreshold(d time.Duration) InstrumentOption {
+	return func(i *instrumented) {
+		i.slow = d
+	}
+}
+
+// WithRetries retries idempotent reads up to n times when the origin
+// throttles or fails with a server or network error, waiting backoff
+// before the first retry and twice as long before each next one, with
+// jitter. Not found and other client errors are never retried, nor are
+// errors while reading a body already returned.
+func WithRetries(n int, backoff time.Duration) InstrumentOption {
+	return func(i *instrumented) {
+		i.retries, i.backoff = n, backoff
+	}
+}
+
+type instrumented struct {
+	StorageProvider
+	name    string
+	slow    time.Duration
+	retries int
+	backoff time.Duration
+}
+
+// Ensure instrumented implements StorageProvider
+var _ StorageProvider = (*instrumented)(nil)
+
+// Instrumented wraps p with the instrumentation every backend shares: a
+// span per operation, fetch and operation metrics labeled with name,
+// slow call logging and optional retries (see InstrumentOption). Backends
+// then only implement their API. Failovers reported by p through the
+// context are recorded as two attempts, the primary one under name.
+func Instrumented(p StorageProvider, name string, opts ...InstrumentOption) StorageProvider {
+	i := &instrumented{StorageProvider: p, name: name}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+// call runs op on key through the instrumentation.
+func (i *instrumented) call(ctx context.Context, op operation, key string, do func(ctx context.Context) error) error {
+	ctx, span := otel.Tracer("quirm/storage").Start(ctx, "Storage."+op.method, trace.WithAttributes(
+		attribute.String("storage.backend", i.name),
+		attribute.String("storage.key", key),
+	))
+	defer span.End()
+
+	start := time.Now()
+	var err error
+attempts:
+	for attempt := 0; ; attempt++ {
+		attemptStart := time.Now()
+		var fo failover
+		err = do(context.WithValue(ctx, failoverKey{}, &fo))
+		outcome := fetchOutcome(err)
+		if op.fetch {
+			i.observeAttempt(outcome, time.Since(attemptStart), fo)
+		}
+		if err == nil || !op.retry || attempt >= i.retries || !retryable(outcome) || ctx.Err() != nil {
+			break
+		}
+
+		delay := i.backoff << attempt
+		delay += rand.N(delay/2 + 1)
+		metrics.OriginRetriesTotal.WithLabelValues(op.label).Inc()
+		span.AddEvent("Retry", trace.WithAttributes(attribute.String("outcome", outcome)))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			// The caller gave up: report the last failure
+			break attempts
+		}
+	}
+
+	d := time.Since(start)
+	outcome := fetchOutcome(err)
+	metrics.OriginOperationDuration.WithLabelValues(op.label, outcome, i.name).Observe(d.Seconds())
+	if op.fetch && err == nil {
+		metrics.S3FetchDuration.Observe(d.Seconds())
+	}
+	if i.slow > 0 && d > i.slow {
+		slog.Warn("Slow origin request", "backend", i.name, "operation", op.label, "key", key, "outcome", outcome, "duration", d)
+	}
+	if err != nil && !errors.Is(err, ErrNotModified) && !isNotFound(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, outcome)
+	}
+	return err
+}
+
+// observeAttempt records one fetch attempt. A failover counts as the
+// primary attempt with its own outcome, followed by a backup_* attempt.
+func (i *instrumented) observeAttempt(outcome string, d time.Duration, fo failover) {
+	if fo.backup == "" {
+		observeFetch(i.name, outcome, d)
+		return
+	}
+	observeFetch(i.name, fetchOutcome(fo.primaryErr), d-fo.duration)
+	switch {
+	case fo.backupErr == nil:
+		observeFetch(fo.backup, outcomeBackupOK, fo.duration)
+	case errors.Is(fo.backupErr, ErrNotModified) || isNotModified(fo.backupErr):
+		observeFetch(fo.backup, outcomeNotModified, fo.duration)
+	default:
+		observeFetch(fo.backup, outcomeBackupFailed, fo.duration)
+	}
+}
+
+// retryable reports whether a read that ended with outcome may succeed
+// when tried again.
+func retryable(outcome string) bool {
+	return outcome == outcomeThrottled || outcome == outcomeServerError || outcome == outcomeNetwork
+}
+
+func (i *instrumented) GetObject(ctx context.Context, key string) (body io.ReadCloser, size int64, err error) {
+	err = i.call(ctx, opGetObject, key, func(ctx context.Context) error {
+		body, size, err = i.StorageProvider.GetObject(ctx, key)
+		return err
+	})
+	return body, size, err
+}
+
+func (i *instrumented) GetObjectIfNoneMatch(ctx context.Context, key, etag string) (body io.ReadCloser, info ObjectInfo, err error) {
+	err = i.call(ctx, opGetObjectIfNoneMatch, key, func(ctx context.Context) error {
+		body, info, err = i.StorageProvider.GetObjectIfNoneMatch(ctx, key, etag)
+		return err
+	})
+	return body, info, err
+}
+
+func (i *instrumented) GetObjectRange(ctx context.Context, key string, offset, length int64) (body io.ReadCloser, err error) {
+	err = i.call(ctx, opGetObjectRange, key, func(ctx context.Context) error {
+		body, err = i.StorageProvider.GetObjectRange(ctx, key, offset, length)
+		return err
+	})
+	return body, err
+}
+
+func (i *instrumented) StatObject(ctx context.Context, key string) (info ObjectInfo, err error) {
+	err = i.call(ctx, opStatObject, key, func(ctx context.Context) error {
+		info, err = i.StorageProvider.StatObject(ctx, key)
+		return err
+	})
+	return info, err
+}
+
+func (i *instrumented) ListObjects(ctx context.Context, prefix, startAfter string, fn func(key string) error) error {
+	return i.call(ctx, opListObjects, prefix, func(ctx context.Context) error {
+		return i.StorageProvider.ListObjects(ctx, prefix, startAfter, fn)
+	})
+}
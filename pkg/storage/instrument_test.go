@@ -0,0 +1,269 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// scriptedProvider fails its calls with errs in turn and then serves the
+// memory provider. Each call may report a failover and take delay.
+type scriptedProvider struct {
+	*MemoryProvider
+	errs     []error
+	failover *failover
+	delay    time.Duration
+	calls    int
+}
+
+func newScriptedProvider(errs ...error) *scriptedProvider {
+	p := &scriptedProvider{MemoryProvider: NewMemoryProvider(), errs: errs}
+	p.Put("photos/a.jpg", []byte("image"), "image/jpeg")
+	return p
+}
+
+func (p *scriptedProvider) next(ctx context.Context) error {
+	n := p.calls
+	p.calls++
+	time.Sleep(p.delay)
+	if p.failover != nil {
+		reportFailover(ctx, *p.failover)
+	}
+	if n < len(p.errs) {
+		return p.errs[n]
+	}
+	return nil
+}
+
+func (p *scriptedProvider) GetObject(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	if err := p.next(ctx); err != nil {
+		return nil, 0, err
+	}
+	return p.MemoryProvider.GetObject(ctx, key)
+}
+
+func (p *scriptedProvider) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	if err := p.next(ctx); err != nil {
+		return ObjectInfo{}, err
+	}
+	return p.MemoryProvider.StatObject(ctx, key)
+}
+
+func (p *scriptedProvider) ListObjects(ctx context.Context, prefix, startAfter string, fn func(key string) error) error {
+	if err := p.next(ctx); err != nil {
+		return err
+	}
+	return p.MemoryProvider.ListObjects(ctx, prefix, startAfter, fn)
+}
+
+// responseError is the error the S3 SDK returns for an HTTP status.
+func responseError(status int) error {
+	return &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+		Err:      errors.New(http.StatusText(status)),
+	}
+}
+
+// fetchCount returns the origin fetches recorded for bucket with outcome.
+func fetchCount(outcome, bucket string) float64 {
+	return testutil.ToFloat64(metrics.OriginFetchTotal.WithLabelValues(outcome, bucket))
+}
+
+// callOperation runs one operation through p, by its metrics label.
+func callOperation(ctx context.Context, p StorageProvider, label string) error {
+	switch label {
+	case opGetObject.label:
+		body, _, err := p.GetObject(ctx, "photos/a.jpg")
+		if err == nil {
+			body.Close()
+		}
+		return err
+	case opStatObject.label:
+		_, err := p.StatObject(ctx, "photos/a.jpg")
+		return err
+	case opListObjects.label:
+		return p.ListObjects(ctx, "photos/", "", func(string) error { return nil })
+	}
+	panic("unknown operation " + label)
+}
+
+func TestInstrumentedRetries(t *testing.T) {
+	networkErr := errors.New("connection reset by peer")
+	outcomes := []string{outcomeOK, outcomeThrottled, outcomeNetwork, outcomeServerError, outcomeNotFound, outcomeClientError}
+	tests := []struct {
+		name        string
+		operation   string
+		errs        []error
+		retries     int
+		wantCalls   int
+		wantErr     bool
+		wantFetches map[string]float64 // by outcome
+	}{
+		{name: "success", operation: "get_object", retries: 2, wantCalls: 1, wantFetches: map[string]float64{outcomeOK: 1}},
+		{name: "throttled, then ok", operation: "get_object", errs: []error{responseError(503)}, retries: 2, wantCalls: 2, wantFetches: map[string]float64{outcomeThrottled: 1, outcomeOK: 1}},
+		{name: "network error, then ok", operation: "get_object", errs: []error{networkErr}, retries: 2, wantCalls: 2, wantFetches: map[string]float64{outcomeNetwork: 1, outcomeOK: 1}},
+		{name: "retries exhausted", operation: "get_object", errs: []error{responseError(500), responseError(500), responseError(500)}, retries: 2, wantCalls: 3, wantErr: true, wantFetches: map[string]float64{outcomeServerError: 3}},
+		{name: "retries disabled", operation: "get_object", errs: []error{responseError(503)}, wantCalls: 1, wantErr: true, wantFetches: map[string]float64{outcomeThrottled: 1}},
+		{name: "not found", operation: "get_object", errs: []error{ErrNotFound}, retries: 2, wantCalls: 1, wantErr: true, wantFetches: map[string]float64{outcomeNotFound: 1}},
+		{name: "client error", operation: "get_object", errs: []error{responseError(403)}, retries: 2, wantCalls: 1, wantErr: true, wantFetches: map[string]float64{outcomeClientError: 1}},
+		{name: "stat retried", operation: "stat_object", errs: []error{responseError(503)}, retries: 2, wantCalls: 2},
+		{name: "list not retried", operation: "list_objects", errs: []error{responseError(503)}, retries: 2, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A backend name per case keeps the metrics of the cases apart
+			backend := "scripted-" + strings.ReplaceAll(tt.name, " ", "-")
+			retried := metrics.OriginRetriesTotal.WithLabelValues(tt.operation)
+			retriesBefore := testutil.ToFloat64(retried)
+			fetchesBefore := map[string]float64{}
+			for _, outcome := range outcomes {
+				fetchesBefore[outcome] = fetchCount(outcome, backend)
+			}
+			p := newScriptedProvider(tt.errs...)
+
+			err := callOperation(context.Background(), Instrumented(p, backend, WithRetries(tt.retries, time.Millisecond)), tt.operation)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			if p.calls != tt.wantCalls {
+				t.Errorf("%d calls, want %d", p.calls, tt.wantCalls)
+			}
+			if got := testutil.ToFloat64(retried) - retriesBefore; got != float64(tt.wantCalls-1) {
+				t.Errorf("%v retries counted, want %d", got, tt.wantCalls-1)
+			}
+			for _, outcome := range outcomes {
+				if got := fetchCount(outcome, backend) - fetchesBefore[outcome]; got != tt.wantFetches[outcome] {
+					t.Errorf("%v %s fetches, want %v", got, outcome, tt.wantFetches[outcome])
+				}
+			}
+		})
+	}
+}
+
+// TestInstrumentedRetryCancel stops waiting for the next attempt when the
+// caller gives up.
+func TestInstrumentedRetryCancel(t *testing.T) {
+	p := newScriptedProvider(responseError(503), responseError(503))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := callOperation(ctx, Instrumented(p, "scripted-cancel", WithRetries(5, time.Hour)), "get_object"); err == nil {
+		t.Fatal("cancelled read succeeded")
+	}
+	if p.calls != 1 || time.Since(start) > time.Second {
+		t.Errorf("%d calls in %v, want 1 without waiting for the backoff", p.calls, time.Since(start))
+	}
+}
+
+func TestInstrumentedFailover(t *testing.T) {
+	const backend, backup = "scripted-primary", "scripted-backup"
+	p := newScriptedProvider()
+	p.failover = &failover{primaryErr: responseError(500), backup: backup, duration: time.Millisecond}
+	failed, ok, backupOK := fetchCount(outcomeServerError, backend), fetchCount(outcomeOK, backend), fetchCount(outcomeBackupOK, backup)
+	if err := callOperation(context.Background(), Instrumented(p, backend), "get_object"); err != nil {
+		t.Fatal(err)
+	}
+	failed, ok, backupOK = fetchCount(outcomeServerError, backend)-failed, fetchCount(outcomeOK, backend)-ok, fetchCount(outcomeBackupOK, backup)-backupOK
+	if failed != 1 || ok != 0 {
+		t.Errorf("primary fetches: %v server_error, %v ok, want 1 and 0", failed, ok)
+	}
+	if got := backupOK; got != 1 {
+		t.Errorf("%v backup_ok fetches, want 1", got)
+	}
+}
+
+func TestInstrumentedSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	tests := []struct {
+		name       string
+		operation  string
+		errs       []error
+		wantSpan   string
+		wantRetry  int
+		wantStatus codes.Code
+	}{
+		{name: "retried", operation: "get_object", errs: []error{responseError(503)}, wantSpan: "Storage.GetObject", wantRetry: 1, wantStatus: codes.Unset},
+		{name: "not found", operation: "stat_object", errs: []error{ErrNotFound}, wantSpan: "Storage.StatObject", wantStatus: codes.Unset},
+		{name: "failed", operation: "list_objects", errs: []error{responseError(500)}, wantSpan: "Storage.ListObjects", wantStatus: codes.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder.Reset()
+			callOperation(context.Background(), Instrumented(newScriptedProvider(tt.errs...), "scripted", WithRetries(1, time.Millisecond)), tt.operation)
+
+			spans := recorder.Ended()
+			if len(spans) != 1 || spans[0].Name() != tt.wantSpan {
+				t.Fatalf("ended spans %v, want one %s span", spans, tt.wantSpan)
+			}
+			span := spans[0]
+			if span.Status().Code != tt.wantStatus {
+				t.Errorf("status %v, want %v", span.Status().Code, tt.wantStatus)
+			}
+			retries := 0
+			for _, event := range span.Events() {
+				if event.Name == "Retry" {
+					retries++
+				}
+			}
+			if retries != tt.wantRetry {
+				t.Errorf("%d retry events, want %d", retries, tt.wantRetry)
+			}
+			var backend string
+			for _, attr := range span.Attributes() {
+				if attr.Key == "storage.backend" {
+					backend = attr.Value.AsString()
+				}
+			}
+			if backend != "scripted" {
+				t.Errorf("storage.backend %q, want scripted", backend)
+			}
+		})
+	}
+}
+
+func TestInstrumentedSlowLog(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		want      bool
+	}{
+		{name: "over the threshold", threshold: time.Millisecond, want: true},
+		{name: "under the threshold", threshold: time.Hour},
+		{name: "disabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			p := newScriptedProvider()
+			p.delay = 5 * time.Millisecond
+			callOperation(context.Background(), Instrumented(p, "scripted", WithSlowThreshold(tt.threshold)), "stat_object")
+			if got := strings.Contains(logs.String(), "Slow origin request"); got != tt.want {
+				t.Errorf("slow request logged %v, want %v: %s", got, tt.want, logs.String())
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 )
 
 func AtomicWrite(destPath string, r io.Reader, encodingType string, tempDir string) error {
@@ -30,6 +31,13 @@ func AtomicWrite(destPath string, r io.Reader, encodingType string, tempDir stri
 		gzWriter := gzip.NewWriter(tempFile)
 		_, err = io.Copy(gzWriter, r)
 		gzWriter.Close()
+	case "zstd":
+		var zstdWriter *zstd.Encoder
+		zstdWriter, err = zstd.NewWriter(tempFile, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+		if err == nil {
+			_, err = io.Copy(zstdWriter, r)
+			zstdWriter.Close()
+		}
 	default:
 		_, err = io.Copy(tempFile, r)
 	}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by providers that are not backed by S3 when an
+// object does not exist.
+var ErrNotFound = errors.New("NotFound: object does not exist")
+
+type memoryObject struct {
+	data []byte
+	info ObjectInfo
+}
+
+// MemoryProvider is a StorageProvider that keeps objects in memory. It backs
+// demo mode and is handy when embedding quirm in tests.
+type MemoryProvider struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+// Ensure MemoryProvider implements StorageProvider
+var _ StorageProvider = (*MemoryProvider)(nil)
+
+func NewMemoryProvider() *MemoryProvider {
+	return &MemoryProvider{objects: make(map[string]memoryObject)}
+}
+
+// Put stores data under key, replacing any existing object.
+func (p *MemoryProvider) Put(key string, data []byte, contentType string) {
+	sum := md5.Sum(data)
+	obj := memoryObject{
+		data: data,
+		info: ObjectInfo{
+			Size:         int64(len(data)),
+			ETag:         `"` + hex.EncodeToString(sum[:]) + `"`,
+			ContentType:  contentType,
+			LastModified: time.Now(),
+		},
+	}
+
+	p.mu.Lock()
+	p.objects[key] = obj
+	p.mu.Unlock()
+}
+
+// SetMetadata replaces the user-defined metadata of an existing object.
+func (p *MemoryProvider) SetMetadata(key string, metadata map[string]string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	obj, ok := p.objects[key]
+	if !ok {
+		return fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	obj.info.Metadata = metadata
+	p.objects[key] = obj
+	return nil
+}
+
+func (p *MemoryProvider) GetObject(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	p.mu.RLock()
+	obj, ok := p.objects[key]
+	p.mu.RUnlock()
+	if !ok {
+		return nil, 0, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), obj.info.Size, nil
+}
+
+func (p *MemoryProvider) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	p.mu.RLock()
+	obj, ok := p.objects[key]
+	p.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	data := obj.data[min(offset, int64(len(obj.data))):]
+	if length > 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (p *MemoryProvider) ListObjects(ctx context.Context, prefix, startAfter string, fn func(key string) error) error {
+	p.mu.RLock()
+	var keys []string
+	for key := range p.objects {
+		if strings.HasPrefix(key, prefix) && key > startAfter {
+			keys = append(keys, key)
+		}
+	}
+	p.mu.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *MemoryProvider) GetObjectIfNoneMatch(ctx context.Context, key, etag string) (io.ReadCloser, ObjectInfo, error) {
+	p.mu.RLock()
+	obj, ok := p.objects[key]
+	p.mu.RUnlock()
+	if !ok {
+		return nil, ObjectInfo{}, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	if etag != "" && etag == obj.info.ETag {
+		return nil, ObjectInfo{}, ErrNotModified
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), obj.info, nil
+}
+
+func (p *MemoryProvider) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	p.mu.RLock()
+	obj, ok := p.objects[key]
+	p.mu.RUnlock()
+	if !ok {
+		return ObjectInfo{}, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return obj.info, nil
+}
+
+// GetPresignedURL is not supported; callers fall back to GetObject.
+func (p *MemoryProvider) GetPresignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return "", errors.New("presigned URLs are not supported by the memory provider")
+}
+
+func (p *MemoryProvider) Health(ctx context.Context) error {
+	return nil
+}
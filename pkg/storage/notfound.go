@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// NotFoundCache wraps a StorageProvider and remembers the keys the origin
+// reported missing, answering further requests for them with the same error
+// until the TTL expires. Scanners and broken links then cost one origin
+// request per key and TTL. An object uploaded meanwhile is served once the
+// entry expires.
+type NotFoundCache struct {
+	StorageProvider
+	missing *expirable.LRU[string, error]
+}
+
+// Ensure NotFoundCache implements StorageProvider
+var _ StorageProvider = (*NotFoundCache)(nil)
+
+func NewNotFoundCache(provider StorageProvider, size int, ttl time.Duration) *NotFoundCache {
+	if size <= 0 {
+		size = 10000
+	}
+	return &NotFoundCache{
+		StorageProvider: provider,
+		missing:         expirable.NewLRU[string, error](size, nil, ttl),
+	}
+}
+
+// known returns the remembered not found error of key, if any.
+func (c *NotFoundCache) known(key string) error {
+	err, ok := c.missing.Get(key)
+	if ok {
+		metrics.OriginNotFoundCacheHits.Inc()
+	}
+	return err
+}
+
+// remember records err for key when it says the object does not exist.
+func (c *NotFoundCache) remember(key string, err error) {
+	if isNotFound(err) {
+		c.missing.Add(key, err)
+	}
+}
+
+func (c *NotFoundCache) GetObject(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	if err := c.known(key); err != nil {
+		return nil, 0, err
+	}
+	reader, size, err := c.StorageProvider.GetObject(ctx, key)
+	c.remember(key, err)
+	return reader, size, err
+}
+
+func (c *NotFoundCache) GetObjectIfNoneMatch(ctx context.Context, key, etag string) (io.ReadCloser, ObjectInfo, error) {
+	if err := c.known(key); err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	reader, info, err := c.StorageProvider.GetObjectIfNoneMatch(ctx, key, etag)
+	c.remember(key, err)
+	return reader, info, err
+}
+
+func (c *NotFoundCache) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if err := c.known(key); err != nil {
+		return nil, err
+	}
+	reader, err := c.StorageProvider.GetObjectRange(ctx, key, offset, length)
+	c.remember(key, err)
+	return reader, err
+}
+
+func (c *NotFoundCache) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	if err := c.known(key); err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := c.StorageProvider.StatObject(ctx, key)
+	c.remember(key, err)
+	return info, err
+}
@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,20 +15,22 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
-	"github.com/aws/smithy-go/middleware"
 	smithyhttp "github.com/aws/smithy-go/transport/http"
-	"go.opentelemetry.io/otel"
 
 	appConfig "github.com/CodeTease/quirm/pkg/config"
-	"github.com/CodeTease/quirm/pkg/metrics"
 )
 
 type S3Client struct {
 	client        *s3.Client
+	background    *s3.Client // same origin, smaller connection pool for WithBackground contexts
 	presignClient *s3.PresignClient
 	bucket        string
 	backupBucket  string
+	requestPayer  types.RequestPayer
+	expectedOwner string
+	hedge         *hedger
 }
 
 // Ensure S3Client implements StorageProvider
@@ -38,103 +42,112 @@ func NewS3Client(cfg appConfig.Config) (*S3Client, error) {
 		clientLogMode = aws.ClientLogMode(0)
 	}
 
-	awsCfg, err := config.LoadDefaultConfig(context.TODO(),
+	loadOpts := []func(*config.LoadOptions) error{
 		config.WithRegion(cfg.S3Region),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(cfg.S3AccessKey, cfg.S3SecretKey, "")),
 		config.WithClientLogMode(clientLogMode),
-	)
+	}
+	switch {
+	case cfg.S3AccessKey != "" && cfg.S3SecretKey != "":
+		loadOpts = append(loadOpts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(cfg.S3AccessKey, cfg.S3SecretKey, "")))
+	case cfg.S3AccessKey != "" || cfg.S3SecretKey != "":
+		return nil, errors.New("S3_ACCESS_KEY and S3_SECRET_KEY must be set together")
+	}
+	// Without static keys the default chain is used (environment, shared
+	// config, web identity / IRSA, ECS task role, EC2 instance profile).
+
+	awsCfg, err := config.LoadDefaultConfig(context.TODO(), loadOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
-	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
-		if cfg.S3Endpoint != "" {
-			o.BaseEndpoint = aws.String(cfg.S3Endpoint)
-		}
-		o.UsePathStyle = cfg.S3ForcePathStyle
-		if cfg.S3UseCustomDomain {
-			o.EndpointResolver = s3.EndpointResolverFunc(func(region string, options s3.EndpointResolverOptions) (aws.Endpoint, error) {
-				return aws.Endpoint{
-					URL:               cfg.S3Endpoint,
-					HostnameImmutable: true,
-					SigningRegion:     cfg.S3Region,
-					Source:            aws.EndpointSourceCustom,
-				}, nil
-			})
-			o.APIOptions = []func(*middleware.Stack) error{
-				func(stack *middleware.Stack) error {
-					return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("StripBucketFromPath",
-						func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (
-							middleware.FinalizeOutput, middleware.Metadata, error,
-						) {
-							req, ok := in.Request.(*smithyhttp.Request)
-							if !ok {
-								return next.HandleFinalize(ctx, in)
-							}
-							prefix := "/" + cfg.S3Bucket
-							if strings.HasPrefix(req.URL.Path, prefix) {
-								req.URL.Path = strings.TrimPrefix(req.URL.Path, prefix)
-							}
-							return next.HandleFinalize(ctx, in)
-						}),
-						middleware.Before,
-					)
-				},
-			}
-		}
-	})
+	newClient := func(httpClient *http.Client) *s3.Client {
+		return s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+			o.HTTPClient = httpClient
+			configureS3Options(o, cfg)
+		})
+	}
+	client := newClient(newPoolClient(poolInteractive, poolOptions{
+		maxIdleConns:    cfg.S3MaxIdleConns,
+		maxConnsPerHost: cfg.S3MaxConnsPerHost,
+		idleConnTimeout: cfg.S3IdleConnTimeout,
+	}))
+	background := newClient(newPoolClient(poolBackground, poolOptions{
+		maxIdleConns:    cfg.S3BackgroundMaxConns,
+		maxConnsPerHost: cfg.S3BackgroundMaxConns,
+		idleConnTimeout: cfg.S3IdleConnTimeout,
+	}))
 
 	presignClient := s3.NewPresignClient(client)
 
+	if awsCfg.Credentials == nil {
+		return nil, errors.New("no AWS credentials found: set S3_ACCESS_KEY/S3_SECRET_KEY or configure the default credential chain")
+	}
+	creds, err := awsCfg.Credentials.Retrieve(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("no usable AWS credentials: set S3_ACCESS_KEY/S3_SECRET_KEY or configure the default credential chain: %w", err)
+	}
+	slog.Info("Loaded S3 credentials", "source", creds.Source)
+
 	return &S3Client{
 		client:        client,
+		background:    background,
 		presignClient: presignClient,
 		bucket:        cfg.S3Bucket,
 		backupBucket:  cfg.S3BackupBucket,
+		requestPayer:  types.RequestPayer(cfg.S3RequestPayer),
+		expectedOwner: cfg.S3ExpectedBucketOwner,
+		hedge:         newHedger(cfg.HedgeAfter, cfg.HedgeMaxPercent),
 	}, nil
 }
 
-func (s *S3Client) GetObject(ctx context.Context, key string) (io.ReadCloser, int64, error) {
-	tracer := otel.Tracer("quirm/storage")
-	ctx, span := tracer.Start(ctx, "S3.GetObject")
-	defer span.End()
+// configureS3Options applies the endpoint and addressing settings of cfg.
+func configureS3Options(o *s3.Options, cfg appConfig.Config) {
+	if cfg.S3Endpoint != "" {
+		o.BaseEndpoint = aws.String(cfg.S3Endpoint)
+	}
+	o.UsePathStyle = cfg.S3ForcePathStyle
+	if cfg.S3UseCustomDomain {
+		// A custom domain already maps to the bucket. Path-style keeps the
+		// SDK from prefixing the bucket to the host, and the middleware
+		// drops the bucket segment from the path before signing.
+		o.UsePathStyle = true
+		o.APIOptions = append(o.APIOptions, customDomainMiddleware(cfg.S3Bucket, cfg.S3BackupBucket))
+	}
+}
 
-	start := time.Now()
-	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(key),
+// api returns the client whose connection pool serves ctx.
+func (s *S3Client) api(ctx context.Context) *s3.Client {
+	if isBackground(ctx) {
+		return s.background
+	}
+	return s.client
+}
+
+// getObjectInput builds a GetObject request carrying the requester-pays and
+// expected-owner options, so fetches and presigned URLs behave the same.
+func (s *S3Client) getObjectInput(bucket, key string) *s3.GetObjectInput {
+	input := &s3.GetObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		RequestPayer: s.requestPayer,
+	}
+	if s.expectedOwner != "" {
+		input.ExpectedBucketOwner = aws.String(s.expectedOwner)
+	}
+	return input
+}
+
+func (s *S3Client) GetObject(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	resp, err := s.fetch(ctx, func(bucket string) *s3.GetObjectInput {
+		return s.getObjectInput(bucket, key)
 	})
 	if err != nil {
-		// Failover Logic
-		if s.backupBucket != "" && shouldFailover(err) {
-			respBackup, errBackup := s.client.GetObject(ctx, &s3.GetObjectInput{
-				Bucket: aws.String(s.backupBucket),
-				Key:    aws.String(key),
-			})
-			if errBackup == nil {
-				metrics.S3FetchDuration.Observe(time.Since(start).Seconds())
-				var contentLength int64
-				if respBackup.ContentLength != nil {
-					contentLength = *respBackup.ContentLength
-				}
-				return respBackup.Body, contentLength, nil
-			}
+		if isKMSAccessDenied(err) {
+			return nil, 0, &KMSAccessError{Key: key, Err: err}
 		}
-
 		return nil, 0, err
 	}
 
-	// Only record metric if configured (implicit check: if metrics initialized)
-	// We can check appConfig, but here we don't have it easily accessible unless stored.
-	// However, prometheus metrics are global and safe to call even if not scraped,
-	// unless we want to avoid the overhead.
-	// Given the instructions, we should just record it.
-	// But wait, the plan said "Optional".
-	// The metrics variables are global. If we record them, they just update in memory.
-	// If /metrics is not exposed, no one sees them. That's fine.
-	// The overhead is minimal.
-	metrics.S3FetchDuration.Observe(time.Since(start).Seconds())
-
 	var contentLength int64
 	if resp.ContentLength != nil {
 		contentLength = *resp.ContentLength
@@ -142,11 +155,158 @@ func (s *S3Client) GetObject(ctx context.Context, key string) (io.ReadCloser, in
 	return resp.Body, contentLength, nil
 }
 
+func (s *S3Client) GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange += strconv.FormatInt(offset+length-1, 10)
+	}
+	resp, err := s.fetch(ctx, func(bucket string) *s3.GetObjectInput {
+		in := s.getObjectInput(bucket, key)
+		in.Range = aws.String(byteRange)
+		return in
+	})
+	if err != nil {
+		if isKMSAccessDenied(err) {
+			return nil, &KMSAccessError{Key: key, Err: err}
+		}
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Client) ListObjects(ctx context.Context, prefix, startAfter string, fn func(key string) error) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket:       aws.String(s.bucket),
+		Prefix:       aws.String(prefix),
+		RequestPayer: s.requestPayer,
+	}
+	if startAfter != "" {
+		input.StartAfter = aws.String(startAfter)
+	}
+	if s.expectedOwner != "" {
+		input.ExpectedBucketOwner = aws.String(s.expectedOwner)
+	}
+
+	pages := s3.NewListObjectsV2Paginator(s.api(ctx), input)
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Contents {
+			if err := fn(aws.ToString(obj.Key)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *S3Client) GetObjectIfNoneMatch(ctx context.Context, key, etag string) (io.ReadCloser, ObjectInfo, error) {
+	resp, err := s.fetch(ctx, func(bucket string) *s3.GetObjectInput {
+		in := s.getObjectInput(bucket, key)
+		if etag != "" {
+			in.IfNoneMatch = aws.String(etag)
+		}
+		return in
+	})
+	if err != nil {
+		if isNotModified(err) {
+			return nil, ObjectInfo{}, ErrNotModified
+		}
+		if isKMSAccessDenied(err) {
+			return nil, ObjectInfo{}, &KMSAccessError{Key: key, Err: err}
+		}
+		return nil, ObjectInfo{}, err
+	}
+
+	info := ObjectInfo{
+		ETag:        aws.ToString(resp.ETag),
+		ContentType: aws.ToString(resp.ContentType),
+		Metadata:    resp.Metadata,
+	}
+	if resp.ContentLength != nil {
+		info.Size = *resp.ContentLength
+	}
+	if resp.LastModified != nil {
+		info.LastModified = *resp.LastModified
+	}
+	return resp.Body, info, nil
+}
+
+// fetch runs GetObject against the primary bucket and, for errors that
+// warrant it, against the backup bucket, reporting the failover to
+// Instrumented. When the backup fails too, the primary error is returned.
+func (s *S3Client) fetch(ctx context.Context, input func(bucket string) *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	resp, err := s.getObject(ctx, input(s.bucket))
+	if err == nil {
+		return resp, nil
+	}
+	if s.backupBucket == "" || !shouldFailover(err) {
+		return nil, err
+	}
+
+	backupStart := time.Now()
+	respBackup, errBackup := s.getObject(ctx, input(s.backupBucket))
+	reportFailover(ctx, failover{primaryErr: err, backup: s.backupBucket, backupErr: errBackup, duration: time.Since(backupStart)})
+	switch {
+	case errBackup == nil:
+		return respBackup, nil
+	case isNotModified(errBackup):
+		return nil, errBackup
+	default:
+		return nil, err
+	}
+}
+
+// getObject issues a GetObject call, hedged when HEDGE_AFTER is set.
+func (s *S3Client) getObject(ctx context.Context, input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return s.hedge.do(ctx, func(ctx context.Context) (*s3.GetObjectOutput, error) {
+		// Concurrent attempts each get their own copy of the input
+		in := *input
+		return s.api(ctx).GetObject(ctx, &in)
+	})
+}
+
+func (s *S3Client) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	resp, err := s.api(ctx).HeadObject(ctx, s.headObjectInput(s.bucket, key))
+	if err != nil && s.backupBucket != "" && shouldFailover(err) {
+		if respBackup, errBackup := s.api(ctx).HeadObject(ctx, s.headObjectInput(s.backupBucket, key)); errBackup == nil {
+			resp, err = respBackup, nil
+		}
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info := ObjectInfo{
+		ETag:        aws.ToString(resp.ETag),
+		ContentType: aws.ToString(resp.ContentType),
+		Metadata:    resp.Metadata,
+	}
+	if resp.ContentLength != nil {
+		info.Size = *resp.ContentLength
+	}
+	if resp.LastModified != nil {
+		info.LastModified = *resp.LastModified
+	}
+	return info, nil
+}
+
+func (s *S3Client) headObjectInput(bucket, key string) *s3.HeadObjectInput {
+	input := &s3.HeadObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		RequestPayer: s.requestPayer,
+	}
+	if s.expectedOwner != "" {
+		input.ExpectedBucketOwner = aws.String(s.expectedOwner)
+	}
+	return input
+}
+
 func (s *S3Client) GetPresignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
-	request, err := s.presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(key),
-	}, func(o *s3.PresignOptions) {
+	request, err := s.presignClient.PresignGetObject(ctx, s.getObjectInput(s.bucket, key), func(o *s3.PresignOptions) {
 		o.Expires = expiry
 	})
 	if err != nil {
@@ -156,12 +316,57 @@ func (s *S3Client) GetPresignedURL(ctx context.Context, key string, expiry time.
 }
 
 func (s *S3Client) Health(ctx context.Context) error {
-	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{
+	input := &s3.HeadBucketInput{
 		Bucket: aws.String(s.bucket),
-	})
+	}
+	if s.expectedOwner != "" {
+		input.ExpectedBucketOwner = aws.String(s.expectedOwner)
+	}
+	_, err := s.client.HeadBucket(ctx, input)
 	return err
 }
 
+// isKMSAccessDenied reports whether err is S3 refusing access because the
+// caller may not use the KMS key protecting the object (SSE-KMS).
+func isKMSAccessDenied(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	code := apiErr.ErrorCode()
+	if strings.HasPrefix(code, "KMS.") {
+		return true
+	}
+	return code == "AccessDenied" && strings.Contains(strings.ToLower(apiErr.ErrorMessage()), "kms")
+}
+
+// isNotFound reports whether err means the object does not exist.
+func isNotFound(err error) bool {
+	if errors.Is(err, ErrNotFound) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code := apiErr.ErrorCode()
+		if code == "NoSuchKey" || code == "NotFound" {
+			return true
+		}
+	}
+	var respErr *smithyhttp.ResponseError
+	return errors.As(err, &respErr) && respErr.Response.StatusCode == http.StatusNotFound
+}
+
+// isNotModified reports whether err is S3's answer to a conditional request
+// for an unchanged object (304 Not Modified).
+func isNotModified(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotModified" {
+		return true
+	}
+	var respErr *smithyhttp.ResponseError
+	return errors.As(err, &respErr) && respErr.Response.StatusCode == http.StatusNotModified
+}
+
 func shouldFailover(err error) bool {
 	// 1. Check specific API error codes (e.g. "NoSuchKey")
 	var apiErr smithy.APIError
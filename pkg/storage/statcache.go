@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+type statResult struct {
+	info ObjectInfo
+	err  error
+}
+
+// StatCache wraps a StorageProvider and remembers StatObject results for a
+// short time, so that checks made before every fetch (size limits, hot
+// misses) do not double the number of requests sent to the origin.
+// Only successful lookups and "not found" answers are cached; transient
+// errors always go back to the origin.
+type StatCache struct {
+	StorageProvider
+	entries *expirable.LRU[string, statResult]
+}
+
+// Ensure StatCache implements StorageProvider
+var _ StorageProvider = (*StatCache)(nil)
+
+func NewStatCache(provider StorageProvider, size int, ttl time.Duration) *StatCache {
+	if size <= 0 {
+		size = 10000
+	}
+	return &StatCache{
+		StorageProvider: provider,
+		entries:         expirable.NewLRU[string, statResult](size, nil, ttl),
+	}
+}
+
+func (c *StatCache) StatObject(ctx context.Context, key string) (ObjectInfo, error) {
+	if res, ok := c.entries.Get(key); ok {
+		return res.info, res.err
+	}
+
+	info, err := c.StorageProvider.StatObject(ctx, key)
+	if err == nil || isNotFound(err) {
+		c.entries.Add(key, statResult{info: info, err: err})
+	}
+	return info, err
+}
@@ -2,12 +2,74 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"io"
+	"log/slog"
 	"time"
+
+	appConfig "github.com/CodeTease/quirm/pkg/config"
 )
 
+// ObjectInfo describes an object without its content.
+type ObjectInfo struct {
+	Size         int64
+	ETag         string
+	ContentType  string
+	LastModified time.Time
+	// Metadata holds the user-defined metadata (x-amz-meta-*), keyed by
+	// lowercase name without the prefix
+	Metadata map[string]string
+}
+
 type StorageProvider interface {
 	GetObject(ctx context.Context, key string) (io.ReadCloser, int64, error)
+	// GetObjectIfNoneMatch fetches the object unless its ETag equals etag, in
+	// which case ErrNotModified is returned. An empty etag fetches
+	// unconditionally. The returned info describes the fetched object.
+	GetObjectIfNoneMatch(ctx context.Context, key, etag string) (io.ReadCloser, ObjectInfo, error)
+	// GetObjectRange fetches length bytes of the object starting at offset,
+	// or everything from offset on when length is 0. A range past the end of
+	// the object is cut short rather than rejected.
+	GetObjectRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// ListObjects calls fn for every key under prefix in lexicographic
+	// order, starting after startAfter when it is not empty. An error from fn
+	// stops the listing and is returned.
+	ListObjects(ctx context.Context, prefix, startAfter string, fn func(key string) error) error
+	// StatObject returns the object's metadata without transferring its body
+	StatObject(ctx context.Context, key string) (ObjectInfo, error)
 	GetPresignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
 	Health(ctx context.Context) error
 }
+
+// New builds the configured storage provider and checks that it can reach
+// the origin, so misconfiguration is reported once at startup.
+func New(ctx context.Context, cfg appConfig.Config) (StorageProvider, error) {
+	if cfg.S3Bucket == "" {
+		return nil, errors.New("missing required S3 configuration: S3_BUCKET")
+	}
+
+	client, err := NewS3Client(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Cache hits below never reach the instrumentation, which only sees
+	// origin requests
+	provider := Instrumented(client, cfg.S3Bucket,
+		WithSlowThreshold(cfg.OriginSlowThreshold),
+		WithRetries(cfg.OriginReadRetries, cfg.OriginRetryBackoff))
+	if cfg.StatCacheTTL > 0 {
+		provider = NewStatCache(provider, cfg.StatCacheSize, cfg.StatCacheTTL)
+	}
+	if cfg.NotFoundCacheTTL > 0 {
+		provider = NewNotFoundCache(provider, cfg.NotFoundCacheSize, cfg.NotFoundCacheTTL)
+	}
+
+	// HeadBucket is the cheapest call that exercises the credentials
+	// against the bucket. The origin may be briefly unavailable while we
+	// start, so a failure is reported but not fatal; /health keeps checking.
+	if err := client.Health(ctx); err != nil {
+		slog.Warn("S3 bucket check failed at startup", "bucket", cfg.S3Bucket, "error", err)
+	}
+
+	return provider, nil
+}
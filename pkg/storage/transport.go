@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// Connection pools, the "pool" label of quirm_origin_connections_in_use.
+const (
+	poolInteractive = "interactive"
+	poolBackground  = "background"
+)
+
+type backgroundKey struct{}
+
+// WithBackground marks ctx as background work (warmup). Origin requests made
+// with it use a separate, smaller connection pool, so background jobs cannot
+// starve interactive requests of connections.
+func WithBackground(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundKey{}, true)
+}
+
+func isBackground(ctx context.Context) bool {
+	background, _ := ctx.Value(backgroundKey{}).(bool)
+	return background
+}
+
+// poolOptions sizes the connection pool of an origin HTTP client.
+type poolOptions struct {
+	maxIdleConns    int
+	maxConnsPerHost int // 0 for no limit
+	idleConnTimeout time.Duration
+}
+
+// newPoolClient returns an HTTP client for the S3 SDK with its own
+// connection pool sized by opts, reporting connections in use under pool.
+// It keeps the SDK's default dialer and TLS settings and, like the SDK, does
+// not follow redirects.
+func newPoolClient(pool string, opts poolOptions) *http.Client {
+	transport := awshttp.NewBuildableClient().GetTransport()
+	transport.MaxIdleConns = opts.maxIdleConns
+	// Every request goes to the same one or two hosts, so the per-host idle
+	// limit (10 by default) is what causes connection churn under load
+	transport.MaxIdleConnsPerHost = opts.maxIdleConns
+	transport.MaxConnsPerHost = opts.maxConnsPerHost
+	transport.IdleConnTimeout = opts.idleConnTimeout
+
+	return &http.Client{
+		Transport: &countingTransport{base: transport, inUse: metrics.OriginConnectionsInUse.WithLabelValues(pool)},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// countingTransport counts the requests holding a connection, from the
+// start of the round trip until the response body is closed.
+type countingTransport struct {
+	base  http.RoundTripper
+	inUse prometheus.Gauge
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.inUse.Inc()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.inUse.Dec()
+		return nil, err
+	}
+	resp.Body = &countedBody{ReadCloser: resp.Body, done: t.inUse.Dec}
+	return resp, nil
+}
+
+type countedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *countedBody) Close() error {
+	b.once.Do(b.done)
+	return b.ReadCloser.Close()
+}
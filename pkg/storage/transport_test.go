@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	appConfig "github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// poolTransport returns the transport behind the origin client serving ctx.
+func poolTransport(t *testing.T, s *S3Client, ctx context.Context) *http.Transport {
+	t.Helper()
+	httpClient, ok := s.api(ctx).Options().HTTPClient.(*http.Client)
+	if !ok {
+		t.Fatalf("HTTP client %T, want *http.Client", s.api(ctx).Options().HTTPClient)
+	}
+	counting, ok := httpClient.Transport.(*countingTransport)
+	if !ok {
+		t.Fatalf("transport %T, want *countingTransport", httpClient.Transport)
+	}
+	transport, ok := counting.base.(*http.Transport)
+	if !ok {
+		t.Fatalf("base transport %T, want *http.Transport", counting.base)
+	}
+	return transport
+}
+
+func TestConnectionPoolLimits(t *testing.T) {
+	s, err := NewS3Client(appConfig.Config{
+		S3Region:             "us-east-1",
+		S3Bucket:             "media",
+		S3AccessKey:          "key",
+		S3SecretKey:          "secret",
+		S3MaxIdleConns:       50,
+		S3MaxConnsPerHost:    20,
+		S3IdleConnTimeout:    30 * time.Second,
+		S3BackgroundMaxConns: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		ctx             context.Context
+		maxIdleConns    int
+		maxConnsPerHost int
+	}{
+		{name: "interactive", ctx: context.Background(), maxIdleConns: 50, maxConnsPerHost: 20},
+		{name: "background", ctx: WithBackground(context.Background()), maxIdleConns: 4, maxConnsPerHost: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := poolTransport(t, s, tt.ctx)
+			if transport.MaxIdleConns != tt.maxIdleConns || transport.MaxIdleConnsPerHost != tt.maxIdleConns {
+				t.Errorf("idle connections %d, %d per host, want %d", transport.MaxIdleConns, transport.MaxIdleConnsPerHost, tt.maxIdleConns)
+			}
+			if transport.MaxConnsPerHost != tt.maxConnsPerHost {
+				t.Errorf("MaxConnsPerHost = %d, want %d", transport.MaxConnsPerHost, tt.maxConnsPerHost)
+			}
+			if transport.IdleConnTimeout != 30*time.Second {
+				t.Errorf("IdleConnTimeout = %v, want 30s", transport.IdleConnTimeout)
+			}
+		})
+	}
+	if poolTransport(t, s, context.Background()) == poolTransport(t, s, WithBackground(context.Background())) {
+		t.Error("background work shares the interactive connection pool")
+	}
+}
+
+func TestConnectionsInUse(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "image")
+	}))
+	defer origin.Close()
+
+	const pool = "test"
+	gauge := metrics.OriginConnectionsInUse.WithLabelValues(pool)
+	client := newPoolClient(pool, poolOptions{maxIdleConns: 1})
+
+	resp, err := client.Get(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(gauge); got != 1 {
+		t.Errorf("%v connections in use while reading the body, want 1", got)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	resp.Body.Close()
+	if got := testutil.ToFloat64(gauge); got != 0 {
+		t.Errorf("%v connections in use after closing the body, want 0", got)
+	}
+
+	// A request that fails gives its connection back at once
+	origin.Close()
+	if _, err := client.Get(origin.URL); err == nil {
+		t.Fatal("request to a closed origin succeeded")
+	}
+	if got := testutil.ToFloat64(gauge); got != 0 {
+		t.Errorf("%v connections in use after a failed request, want 0", got)
+	}
+}
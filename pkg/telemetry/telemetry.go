@@ -7,11 +7,14 @@ import (
 	"os"
 
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+
+	"github.com/CodeTease/quirm/pkg/buildinfo"
 )
 
 var (
@@ -33,6 +36,10 @@ func InitTracer(ctx context.Context, serviceName string) (func(context.Context)
 	res, err := resource.New(ctx,
 		resource.WithAttributes(
 			semconv.ServiceNameKey.String(serviceName),
+			semconv.ServiceVersion(buildinfo.Version),
+			semconv.ProcessRuntimeVersion(buildinfo.GoVersion()),
+			attribute.String("quirm.commit", buildinfo.Commit),
+			attribute.String("quirm.build_date", buildinfo.BuildDate),
 		),
 	)
 	if err != nil {
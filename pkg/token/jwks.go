@@ -0,0 +1,104 @@
+package token
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksTTL is how long fetched keys are trusted before a refetch.
+	jwksTTL = 10 * time.Minute
+	// jwksMinRefresh spaces out refetches triggered by unknown key IDs.
+	jwksMinRefresh = time.Minute
+)
+
+// jwksCache holds the RSA keys of one JWKS URL.
+type jwksCache struct {
+	client *http.Client
+
+	mu      sync.Mutex
+	url     string
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+func newJWKSCache() *jwksCache {
+	return &jwksCache{client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// key returns the key with the given ID, fetching the set when it is stale,
+// was fetched for another URL, or does not know kid yet.
+func (c *jwksCache) key(ctx context.Context, jwksURL, kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	age := time.Since(c.fetched)
+	key, known := c.keys[kid]
+	if c.url != jwksURL || age > jwksTTL || (!known && age > jwksMinRefresh) {
+		keys, err := c.fetch(ctx, jwksURL)
+		if err != nil {
+			return nil, err
+		}
+		c.url, c.keys, c.fetched = jwksURL, keys, time.Now()
+		key, known = c.keys[kid]
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+func (c *jwksCache) fetch(ctx context.Context, jwksURL string) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no usable RSA keys")
+	}
+	return keys, nil
+}
@@ -0,0 +1,247 @@
+// Package token verifies the JWTs quirm accepts in place of a URL signature.
+// Tokens are signed with HS256 (AUTH_JWT_SECRET) or RS256 (keys from
+// AUTH_JWKS_URL) and their claims limit which requests they authorize.
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMalformed = errors.New("malformed token")
+	ErrSignature = errors.New("invalid token signature")
+	ErrExpired   = errors.New("token expired")
+	ErrScope     = errors.New("token does not allow this request")
+)
+
+// maxMemo bounds the number of memoized verification results.
+const maxMemo = 10000
+
+// Claims are the token claims quirm understands.
+type Claims struct {
+	// Path is the exact path allowed, or a prefix when it ends with "*"
+	Path string `json:"path"`
+	// MaxW caps the requested width (0 = no cap)
+	MaxW int `json:"max_w,omitempty"`
+	// Params, when set, must equal ParamsHash of the request parameters
+	Params string `json:"params,omitempty"`
+	// Exp is the expiry as a Unix timestamp and is required
+	Exp int64 `json:"exp"`
+}
+
+// Keys are the verification keys configured at the time of a request.
+type Keys struct {
+	Secret  string
+	JWKSURL string
+}
+
+// Enabled reports whether any key is configured.
+func (k Keys) Enabled() bool {
+	return k.Secret != "" || k.JWKSURL != ""
+}
+
+type memoEntry struct {
+	claims Claims
+	err    error
+	until  time.Time
+}
+
+// Verifier checks token signatures and memoizes the result for a short time,
+// keyed by a hash of the token, so a page full of images carrying the same
+// token is verified once. Expiry is still checked on every request.
+type Verifier struct {
+	ttl  time.Duration
+	jwks *jwksCache
+
+	mu   sync.Mutex
+	memo map[[sha256.Size]byte]memoEntry
+}
+
+// NewVerifier returns a Verifier memoizing results for ttl (0 disables
+// memoization).
+func NewVerifier(ttl time.Duration) *Verifier {
+	return &Verifier{
+		ttl:  ttl,
+		jwks: newJWKSCache(),
+		memo: make(map[[sha256.Size]byte]memoEntry),
+	}
+}
+
+// Verify checks the signature and expiry of raw and returns its claims.
+// A nil Verifier verifies every call without memoizing.
+func (v *Verifier) Verify(ctx context.Context, keys Keys, raw string) (Claims, error) {
+	if v == nil {
+		v = NewVerifier(0)
+	}
+	// The keys are part of the memo key so a reload that changes them
+	// never reuses an earlier verdict.
+	id := sha256.Sum256([]byte(keys.Secret + "\x00" + keys.JWKSURL + "\x00" + raw))
+	now := time.Now()
+
+	v.mu.Lock()
+	entry, ok := v.memo[id]
+	v.mu.Unlock()
+	if !ok || now.After(entry.until) {
+		entry.claims, entry.err = v.verify(ctx, keys, raw)
+		entry.until = now.Add(v.ttl)
+		if entry.err == nil && entry.claims.Exp > 0 {
+			if exp := time.Unix(entry.claims.Exp, 0); exp.Before(entry.until) {
+				entry.until = exp
+			}
+		}
+		if v.ttl > 0 {
+			v.remember(id, entry, now)
+		}
+	}
+
+	if entry.err != nil {
+		return Claims{}, entry.err
+	}
+	if now.Unix() >= entry.claims.Exp {
+		return Claims{}, ErrExpired
+	}
+	return entry.claims, nil
+}
+
+func (v *Verifier) remember(id [sha256.Size]byte, entry memoEntry, now time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.memo) >= maxMemo {
+		for k, e := range v.memo {
+			if now.After(e.until) {
+				delete(v.memo, k)
+			}
+		}
+		if len(v.memo) >= maxMemo {
+			v.memo = make(map[[sha256.Size]byte]memoEntry)
+		}
+	}
+	v.memo[id] = entry
+}
+
+func (v *Verifier) verify(ctx context.Context, keys Keys, raw string) (Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrMalformed
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	switch header.Alg {
+	case "HS256":
+		if keys.Secret == "" {
+			return Claims{}, fmt.Errorf("%w: HS256 tokens are not accepted", ErrSignature)
+		}
+		mac := hmac.New(sha256.New, []byte(keys.Secret))
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return Claims{}, ErrSignature
+		}
+	case "RS256":
+		if keys.JWKSURL == "" {
+			return Claims{}, fmt.Errorf("%w: RS256 tokens are not accepted", ErrSignature)
+		}
+		key, err := v.jwks.key(ctx, keys.JWKSURL, header.Kid)
+		if err != nil {
+			return Claims{}, fmt.Errorf("%w: %v", ErrSignature, err)
+		}
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+			return Claims{}, ErrSignature
+		}
+	default:
+		return Claims{}, fmt.Errorf("%w: unsupported alg %q", ErrMalformed, header.Alg)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, err
+	}
+	if claims.Exp == 0 {
+		return Claims{}, fmt.Errorf("%w: missing exp claim", ErrMalformed)
+	}
+	if claims.Path == "" {
+		return Claims{}, fmt.Errorf("%w: missing path claim", ErrMalformed)
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrMalformed
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// Allows checks path (e.g. "/images/a.jpg"), the request parameters and
+// the requested width, after presets are applied, against the claims.
+func (c Claims) Allows(path string, params url.Values, width int) error {
+	if prefix, ok := strings.CutSuffix(c.Path, "*"); ok {
+		if !strings.HasPrefix(path, prefix) {
+			return fmt.Errorf("%w: path %q is outside %q", ErrScope, path, c.Path)
+		}
+	} else if path != c.Path {
+		return fmt.Errorf("%w: path %q does not match %q", ErrScope, path, c.Path)
+	}
+
+	if c.MaxW > 0 {
+		if width <= 0 || width > c.MaxW {
+			return fmt.Errorf("%w: width must be between 1 and %d", ErrScope, c.MaxW)
+		}
+	}
+
+	if c.Params != "" && !hmac.Equal([]byte(c.Params), []byte(ParamsHash(params))) {
+		return fmt.Errorf("%w: parameters differ from the params claim", ErrScope)
+	}
+	return nil
+}
+
+// ParamsHash returns the hex SHA-256 of the parameters sorted by key, e.g.
+// "h=100&w=200", for use as the params claim. Only the first value of a key
+// counts, and the "s" and "token" parameters are left out.
+func ParamsHash(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k == "s" || k == "token" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + params.Get(k)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(pairs, "&")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,237 @@
+package warmup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
+)
+
+// State is the lifecycle state of a warmup job.
+type State string
+
+const (
+	StateQueued     State = "queued"
+	StateInProgress State = "in_progress"
+	StateCompleted  State = "completed"
+	StateFailed     State = "failed"
+)
+
+// ErrQueueFull is returned when the queue cannot accept more jobs.
+var ErrQueueFull = errors.New("warmup queue is full")
+
+// WarmFunc populates the cache for a single request target
+// (path plus query string, e.g. "/images/a.jpg?w=300").
+type WarmFunc func(ctx context.Context, target string, header http.Header) error
+
+// Job is a snapshot of a single warmup job.
+type Job struct {
+	ID         string     `json:"id"`
+	Key        string     `json:"key"`
+	Params     string     `json:"params,omitempty"`
+	State      State      `json:"state"`
+	Error      string     `json:"error,omitempty"`
+	QueuedAt   time.Time  `json:"queued_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	DurationMS int64      `json:"duration_ms,omitempty"`
+
+	target string
+	header http.Header
+}
+
+// Status summarizes the queue since process start.
+type Status struct {
+	Counts map[State]int64 `json:"counts"`
+	Jobs   []Job           `json:"jobs"`
+}
+
+// Queue runs warmup jobs on a fixed pool of workers and keeps a bounded
+// history of recent jobs for the status endpoint.
+type Queue struct {
+	warm       WarmFunc
+	jobs       chan *Job
+	timeout    time.Duration
+	retention  time.Duration
+	mu         sync.Mutex
+	history    []*Job
+	next       int
+	seq        uint64
+	queued     int64
+	inProgress int64
+	completed  int64
+	failed     int64
+}
+
+// NewQueue starts workers goroutines that run warm for every enqueued job.
+// historySize bounds the ring buffer of jobs reported by Status, and finished
+// jobs are dropped from the report once they are older than retention.
+func NewQueue(warm WarmFunc, workers, queueSize, historySize int, retention, timeout time.Duration) *Queue {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize <= 0 {
+		queueSize = 1
+	}
+	if historySize <= 0 {
+		historySize = 1
+	}
+	q := &Queue{
+		warm:      warm,
+		jobs:      make(chan *Job, queueSize),
+		timeout:   timeout,
+		retention: retention,
+		history:   make([]*Job, historySize),
+	}
+	for i := 0; i < workers; i++ {
+		go q.worker()
+	}
+	return q
+}
+
+// Enqueue schedules a warmup job for each target. When the queue is full the
+// rejected job is recorded as failed, the remaining targets are skipped and
+// ErrQueueFull is returned alongside the accepted jobs.
+func (q *Queue) Enqueue(targets []string, header http.Header) ([]Job, error) {
+	accepted := make([]Job, 0, len(targets))
+	for _, target := range targets {
+		job := q.newJob(target, header)
+		select {
+		case q.jobs <- job:
+			accepted = append(accepted, q.snapshot(job))
+		default:
+			finished := time.Now()
+			q.mu.Lock()
+			q.queued--
+			q.failed++
+			job.State = StateFailed
+			job.Error = ErrQueueFull.Error()
+			job.FinishedAt = &finished
+			q.mu.Unlock()
+			metrics.WarmupJobs.WithLabelValues(string(StateQueued)).Dec()
+			metrics.WarmupJobsTotal.WithLabelValues(string(StateFailed)).Inc()
+			return accepted, ErrQueueFull
+		}
+	}
+	return accepted, nil
+}
+
+// Status returns the counters and the retained jobs, optionally filtered by state.
+func (q *Queue) Status(state State) Status {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	status := Status{
+		Counts: map[State]int64{
+			StateQueued:     q.queued,
+			StateInProgress: q.inProgress,
+			StateCompleted:  q.completed,
+			StateFailed:     q.failed,
+		},
+		Jobs: []Job{},
+	}
+
+	now := time.Now()
+	n := len(q.history)
+	for i := 0; i < n; i++ {
+		// Oldest first
+		job := q.history[(q.next+i)%n]
+		if job == nil {
+			continue
+		}
+		if state != "" && job.State != state {
+			continue
+		}
+		if job.FinishedAt != nil && q.retention > 0 && now.Sub(*job.FinishedAt) > q.retention {
+			continue
+		}
+		status.Jobs = append(status.Jobs, *job)
+	}
+	return status
+}
+
+func (q *Queue) newJob(target string, header http.Header) *Job {
+	job := &Job{
+		State:    StateQueued,
+		QueuedAt: time.Now(),
+		target:   target,
+		header:   header,
+	}
+	if u, err := url.Parse(target); err == nil {
+		job.Key = strings.TrimPrefix(u.Path, "/")
+		job.Params = u.RawQuery
+	} else {
+		job.Key = target
+	}
+
+	q.mu.Lock()
+	q.seq++
+	job.ID = strconv.FormatUint(q.seq, 10)
+	q.history[q.next] = job
+	q.next = (q.next + 1) % len(q.history)
+	q.queued++
+	q.mu.Unlock()
+
+	metrics.WarmupJobs.WithLabelValues(string(StateQueued)).Inc()
+	return job
+}
+
+func (q *Queue) snapshot(job *Job) Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return *job
+}
+
+func (q *Queue) worker() {
+	for job := range q.jobs {
+		q.run(job)
+	}
+}
+
+func (q *Queue) run(job *Job) {
+	started := time.Now()
+	q.mu.Lock()
+	job.State = StateInProgress
+	job.StartedAt = &started
+	q.queued--
+	q.inProgress++
+	q.mu.Unlock()
+	metrics.WarmupJobs.WithLabelValues(string(StateQueued)).Dec()
+	metrics.WarmupJobs.WithLabelValues(string(StateInProgress)).Inc()
+
+	ctx := context.Background()
+	if q.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.timeout)
+		defer cancel()
+	}
+	err := q.warm(ctx, job.target, job.header)
+
+	finished := time.Now()
+	duration := finished.Sub(started)
+
+	q.mu.Lock()
+	job.FinishedAt = &finished
+	job.DurationMS = duration.Milliseconds()
+	q.inProgress--
+	if err != nil {
+		job.State = StateFailed
+		job.Error = err.Error()
+		q.failed++
+	} else {
+		job.State = StateCompleted
+		q.completed++
+	}
+	state := job.State
+	q.mu.Unlock()
+
+	metrics.WarmupJobs.WithLabelValues(string(StateInProgress)).Dec()
+	metrics.WarmupJobsTotal.WithLabelValues(string(state)).Inc()
+	metrics.WarmupJobDuration.Observe(duration.Seconds())
+}
@@ -1,6 +1,7 @@
 package watermark
 
 import (
+	"errors"
 	"image"
 	"log/slog"
 	"os"
@@ -8,8 +9,13 @@ import (
 	"time"
 
 	"github.com/disintegration/imaging"
+
+	"github.com/CodeTease/quirm/pkg/metrics"
 )
 
+// ErrNotConfigured is returned by Reload when no watermark path is set.
+var ErrNotConfigured = errors.New("watermark is not configured")
+
 type Manager struct {
 	path        string
 	opacity     float64
@@ -17,6 +23,22 @@ type Manager struct {
 	lastModTime time.Time
 	mu          sync.RWMutex
 	debug       bool
+	// lastLoad is the time of the last successful load; lastErr the error
+	// of the latest load if it failed, and failedModTime the modification
+	// time of the file it failed on, which is not retried until it changes
+	lastLoad      time.Time
+	lastErr       error
+	failedModTime time.Time
+}
+
+// Status describes the watermark, for health checks and the reload endpoint.
+type Status struct {
+	Path string `json:"path"`
+	// Loaded is true while an image is held; after a failed reload it is the
+	// previous one
+	Loaded    bool      `json:"loaded"`
+	LastLoad  time.Time `json:"last_load,omitzero"`
+	LastError string    `json:"last_error,omitempty"`
 }
 
 func NewManager(path string, opacity float64, debug bool) *Manager {
@@ -27,6 +49,9 @@ func NewManager(path string, opacity float64, debug bool) *Manager {
 	}
 }
 
+// Get returns the watermark image, reloading it when the file has changed.
+// When a reload fails the last good image keeps being returned; an error is
+// only returned while no image could be loaded at all.
 func (m *Manager) Get() (image.Image, float64, error) {
 	if m.path == "" {
 		return nil, 0, nil
@@ -34,7 +59,9 @@ func (m *Manager) Get() (image.Image, float64, error) {
 
 	info, err := os.Stat(m.path)
 	if err != nil {
-		return nil, 0, err
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.current(m.fail(time.Time{}, err))
 	}
 
 	m.mu.RLock()
@@ -43,6 +70,11 @@ func (m *Manager) Get() (image.Image, float64, error) {
 		defer m.mu.RUnlock()
 		return m.currentImg, m.opacity, nil
 	}
+	// A broken file is not decoded again on every request
+	if m.lastErr != nil && info.ModTime().Equal(m.failedModTime) {
+		defer m.mu.RUnlock()
+		return m.current(m.lastErr)
+	}
 	m.mu.RUnlock()
 
 	// Upgrade lock to write
@@ -53,16 +85,87 @@ func (m *Manager) Get() (image.Image, float64, error) {
 	if !info.ModTime().After(m.lastModTime) && m.currentImg != nil {
 		return m.currentImg, m.opacity, nil
 	}
+	if m.lastErr != nil && info.ModTime().Equal(m.failedModTime) {
+		return m.current(m.lastErr)
+	}
 
+	return m.current(m.load(info.ModTime()))
+}
+
+// Reload loads the watermark file even if it looks unchanged, and reports
+// the outcome. On failure the previous image stays in use.
+func (m *Manager) Reload() (Status, error) {
+	if m.path == "" {
+		return Status{}, ErrNotConfigured
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// A forced reload is always attempted and counted, even on a file that
+	// already failed
+	m.lastErr = nil
+	info, err := os.Stat(m.path)
+	if err != nil {
+		err = m.fail(time.Time{}, err)
+	} else {
+		err = m.load(info.ModTime())
+	}
+	return m.status(), err
+}
+
+// Status reports the state of the watermark as of the latest load.
+func (m *Manager) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status()
+}
+
+func (m *Manager) status() Status {
+	s := Status{Path: m.path, Loaded: m.currentImg != nil, LastLoad: m.lastLoad}
+	if m.lastErr != nil {
+		s.LastError = m.lastErr.Error()
+	}
+	return s
+}
+
+// load decodes the watermark file, modified at modTime. m.mu must be held.
+func (m *Manager) load(modTime time.Time) error {
 	slog.Debug("Loading watermark", "path", m.path)
 
 	img, err := imaging.Open(m.path)
 	if err != nil {
-		return nil, 0, err
+		return m.fail(modTime, err)
 	}
 
 	m.currentImg = img
-	m.lastModTime = info.ModTime()
+	m.lastModTime = modTime
+	m.lastLoad = time.Now()
+	m.lastErr = nil
+	metrics.WatermarkLoadsTotal.WithLabelValues("success").Inc()
+	metrics.WatermarkLastLoadTimestamp.Set(float64(m.lastLoad.Unix()))
+	return nil
+}
+
+// fail records a failed load of the file modified at modTime. m.mu must be
+// held.
+func (m *Manager) fail(modTime time.Time, err error) error {
+	if m.lastErr == nil || !modTime.Equal(m.failedModTime) {
+		metrics.WatermarkLoadsTotal.WithLabelValues("failure").Inc()
+		if m.currentImg != nil {
+			slog.Warn("Failed to load watermark, keeping the previous image", "path", m.path, "error", err)
+		} else {
+			slog.Warn("Failed to load watermark", "path", m.path, "error", err)
+		}
+	}
+	m.lastErr, m.failedModTime = err, modTime
+	return err
+}
 
+// current returns the image in use, or err when there is none. m.mu must be
+// held.
+func (m *Manager) current(err error) (image.Image, float64, error) {
+	if m.currentImg == nil {
+		return nil, 0, err
+	}
 	return m.currentImg, m.opacity, nil
 }
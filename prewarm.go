@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/quirm"
+	"github.com/CodeTease/quirm/pkg/sign"
+	"github.com/CodeTease/quirm/pkg/storage"
+)
+
+// runPrewarm implements "quirm prewarm -prefix products/ -presets thumb,card",
+// building every preset of every object under the prefix, either in process
+// against the local cache directory or through a running instance (-url).
+// It resumes after the key recorded in the checkpoint file and fails when
+// more than -max-failure-rate of the objects could not be warmed.
+func runPrewarm(cfg config.Config, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("prewarm", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	prefix := fs.String("prefix", "", "key prefix to walk, e.g. products/")
+	presetList := fs.String("presets", "", "comma-separated presets to build for every object")
+	concurrency := fs.Int("concurrency", 4, "objects warmed in parallel")
+	base := fs.String("url", "", "warm through the instance at this origin (default: in process)")
+	checkpoint := fs.String("checkpoint", "", "file recording progress, to resume after a restart")
+	maxFailureRate := fs.Float64("max-failure-rate", 0.01, "fraction of failed objects above which the run fails")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: quirm prewarm [-prefix products/] -presets a,b [-concurrency 4] [-url URL] [-checkpoint file]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	presets := splitList(*presetList)
+	if fs.NArg() != 0 || len(presets) == 0 || *concurrency < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx := context.Background()
+	provider, err := storage.New(ctx, cfg)
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to initialize storage:", err)
+		return 1
+	}
+
+	var warm func(ctx context.Context, key, preset string) error
+	if *base != "" {
+		warm = httpWarmer(cfg, strings.TrimSuffix(*base, "/"))
+	} else {
+		for _, p := range presets {
+			if _, ok := cfg.Presets[p]; !ok {
+				fmt.Fprintf(stderr, "Unknown preset %q\n", p)
+				return 2
+			}
+		}
+		srv, err := quirm.NewServer(cfg, quirm.WithStorage(provider))
+		if err != nil {
+			fmt.Fprintln(stderr, "Failed to start:", err)
+			return 1
+		}
+		defer srv.Close()
+		warm = func(ctx context.Context, key, preset string) error {
+			return srv.Handler.Warm(ctx, warmTarget(key, preset), nil)
+		}
+	}
+
+	startAfter := ""
+	if *checkpoint != "" {
+		if data, err := os.ReadFile(*checkpoint); err == nil {
+			startAfter = strings.TrimSpace(string(data))
+		} else if !os.IsNotExist(err) {
+			fmt.Fprintln(stderr, "Failed to read checkpoint:", err)
+			return 1
+		}
+	}
+
+	var keys []string
+	err = provider.ListObjects(ctx, *prefix, startAfter, func(key string) error {
+		if !strings.HasSuffix(key, "/") {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to list objects:", err)
+		return 1
+	}
+	if startAfter != "" {
+		fmt.Fprintf(stdout, "Resuming after %s\n", startAfter)
+	}
+	fmt.Fprintf(stdout, "Warming %d objects with presets %s\n", len(keys), strings.Join(presets, ","))
+
+	run := prewarmRun{keys: keys, done: make([]bool, len(keys))}
+	results := make(chan prewarmResult)
+	jobs := make(chan int)
+	for i := 0; i < *concurrency; i++ {
+		go func() {
+			for idx := range jobs {
+				var err error
+				for _, p := range presets {
+					if err = warm(ctx, keys[idx], p); err != nil {
+						err = fmt.Errorf("preset %s: %w", p, err)
+						break
+					}
+				}
+				results <- prewarmResult{idx, err}
+			}
+		}()
+	}
+	go func() {
+		for i := range keys {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	start := time.Now()
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for run.finished < len(keys) {
+		select {
+		case res := <-results:
+			run.record(res)
+			if res.err != nil {
+				fmt.Fprintf(stderr, "FAIL %s: %v\n", keys[res.idx], res.err)
+			}
+		case <-ticker.C:
+			fmt.Fprintln(stdout, run.progress(time.Since(start)))
+			saveCheckpoint(stderr, *checkpoint, run.resumeAfter(startAfter))
+		}
+	}
+	saveCheckpoint(stderr, *checkpoint, run.resumeAfter(startAfter))
+
+	fmt.Fprintf(stdout, "Done in %s: %d objects, %d failed\n", time.Since(start).Round(time.Second), len(keys), run.failed)
+	if len(keys) > 0 && float64(run.failed)/float64(len(keys)) > *maxFailureRate {
+		fmt.Fprintf(stderr, "Failure rate %.1f%% exceeds %.1f%%\n", 100*float64(run.failed)/float64(len(keys)), 100**maxFailureRate)
+		return 1
+	}
+	return 0
+}
+
+type prewarmResult struct {
+	idx int
+	err error
+}
+
+// prewarmRun tracks completion. Keys finish out of order, so the checkpoint
+// is the last key before which everything has finished.
+type prewarmRun struct {
+	keys     []string
+	done     []bool
+	finished int
+	failed   int
+	next     int // first unfinished index
+}
+
+func (p *prewarmRun) record(res prewarmResult) {
+	p.done[res.idx] = true
+	p.finished++
+	if res.err != nil {
+		p.failed++
+	}
+	for p.next < len(p.done) && p.done[p.next] {
+		p.next++
+	}
+}
+
+func (p *prewarmRun) resumeAfter(startAfter string) string {
+	if p.next == 0 {
+		return startAfter
+	}
+	return p.keys[p.next-1]
+}
+
+func (p *prewarmRun) progress(elapsed time.Duration) string {
+	line := fmt.Sprintf("%d/%d objects (%.0f%%), %d failed", p.finished, len(p.keys), 100*float64(p.finished)/float64(max(len(p.keys), 1)), p.failed)
+	if p.finished > 0 {
+		rate := float64(p.finished) / elapsed.Seconds()
+		eta := time.Duration(float64(len(p.keys)-p.finished)/rate) * time.Second
+		line += fmt.Sprintf(", %.1f/s, ETA %s", rate, eta.Round(time.Second))
+	}
+	return line
+}
+
+// saveCheckpoint replaces the checkpoint file atomically, so an interrupted
+// write never loses the previous position.
+func saveCheckpoint(stderr io.Writer, path, key string) {
+	if path == "" || key == "" {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".prewarm-*")
+	if err == nil {
+		_, err = tmp.WriteString(key + "\n")
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "Failed to write checkpoint:", err)
+	}
+}
+
+// warmTarget is the request path building preset for key.
+func warmTarget(key, preset string) string {
+	u := url.URL{Path: "/" + key, RawQuery: url.Values{"preset": {preset}}.Encode()}
+	return u.String()
+}
+
+// httpWarmer requests each variant from the instance at base, signing the
+// URL when SECRET_KEY is set. The response body is discarded.
+func httpWarmer(cfg config.Config, base string) func(ctx context.Context, key, preset string) error {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	return func(ctx context.Context, key, preset string) error {
+		target := warmTarget(key, preset)
+		if cfg.SecretKey != "" {
+			target = sign.SignURL(cfg.SecretKey, "/"+key, url.Values{"preset": {preset}}, time.Time{})
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+target, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return errors.New(resp.Status)
+		}
+		return nil
+	}
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CodeTease/quirm/pkg/config"
+	"github.com/CodeTease/quirm/pkg/sign"
+)
+
+func TestRunSign(t *testing.T) {
+	const secret = "test-secret"
+	tests := []struct {
+		name      string
+		immutable bool
+		arg       string
+		path      string // path of the printed URL
+		signed    string // path its signature covers
+	}{
+		{name: "plain", arg: "/photos/a.jpg?w=300", path: "/photos/a.jpg", signed: "/photos/a.jpg"},
+		{name: "cleaned", arg: "photos//x/../a.jpg?w=300", path: "/photos/a.jpg", signed: "/photos/a.jpg"},
+		{name: "immutable", immutable: true, arg: "/0123456789abcdef/photos/a.jpg?w=300", path: "/0123456789abcdef/photos/a.jpg", signed: "/photos/a.jpg"},
+		{name: "hash without IMMUTABLE_URLS", arg: "/0123456789abcdef/photos/a.jpg?w=300", path: "/0123456789abcdef/photos/a.jpg", signed: "/0123456789abcdef/photos/a.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{SecretKey: secret, ImmutableURLs: tt.immutable}
+			var stdout, stderr bytes.Buffer
+			if code := runSign(cfg, []string{"-expires", "1h", tt.arg}, &stdout, &stderr); code != 0 {
+				t.Fatalf("exit code %d: %s", code, stderr.String())
+			}
+			u, err := url.Parse(strings.TrimSpace(stdout.String()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Path != tt.path {
+				t.Errorf("path = %q, want %q", u.Path, tt.path)
+			}
+			query := u.Query()
+			if query.Get("s") != sign.Signature(secret, tt.signed, query) {
+				t.Errorf("signature of %s does not cover %q", u, tt.signed)
+			}
+			if !query.Has("expires") {
+				t.Errorf("no expiry in %s", u)
+			}
+		})
+	}
+
+	cfg := config.Config{SecretKey: secret, MaxURLLifetime: time.Hour}
+	var stdout, stderr bytes.Buffer
+	if code := runSign(cfg, []string{"-expires", "2h", "/a.jpg"}, &stdout, &stderr); code != 2 {
+		t.Errorf("-expires beyond MAX_URL_LIFETIME: exit code %d, want 2", code)
+	}
+}